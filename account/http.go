@@ -0,0 +1,155 @@
+package account
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Handler serves the account API for the website backend. Every request
+// carries the API key in the X-Api-Key header, without a key configured
+// every request is refused.
+//
+//	POST   /accounts                   {username, mail, password} -> {id, verification_token}
+//	POST   /accounts/verification      {username}                 -> {verification_token}
+//	POST   /accounts/verify            {token}
+//	POST   /password-reset             {mail}                     -> {reset_token}
+//	POST   /password-reset/confirm     {token, password}
+//	PUT    /accounts/{id}/username     {password, username}
+//	POST   /accounts/{id}/deletion     {password}                 -> {delete_after}
+//	DELETE /accounts/{id}/deletion     {password}
+type Handler struct {
+	Service *Service
+	APIKey  string
+}
+
+type request struct {
+	Username string `json:"username"`
+	Mail     string `json:"mail"`
+	Password string `json:"password"`
+	Token    string `json:"token"`
+}
+
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if h.APIKey == "" || subtle.ConstantTimeCompare([]byte(r.Header.Get("X-Api-Key")), []byte(h.APIKey)) != 1 {
+		writeError(w, http.StatusUnauthorized, errors.New("bad api key"))
+		return
+	}
+
+	var req request
+	if r.Body != nil && r.ContentLength != 0 {
+		if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 4096)).Decode(&req); err != nil {
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
+	}
+
+	path := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+	svc := h.Service
+
+	switch {
+	case r.Method == http.MethodPost && r.URL.Path == "/accounts":
+		u, token, err := svc.Register(req.Username, req.Mail, req.Password)
+		if err != nil {
+			writeError(w, statusOf(err), err)
+			return
+		}
+		writeJSON(w, http.StatusCreated, map[string]string{"id": u.ID, "verification_token": token})
+
+	case r.Method == http.MethodPost && r.URL.Path == "/accounts/verification":
+		token, err := svc.ResendVerification(req.Username)
+		if err != nil {
+			writeError(w, statusOf(err), err)
+			return
+		}
+		writeJSON(w, http.StatusOK, map[string]string{"verification_token": token})
+
+	case r.Method == http.MethodPost && r.URL.Path == "/accounts/verify":
+		writeResult(w, svc.VerifyMail(req.Token))
+
+	case r.Method == http.MethodPost && r.URL.Path == "/password-reset":
+		token, err := svc.RequestPasswordReset(req.Mail)
+		if err != nil {
+			writeError(w, statusOf(err), err)
+			return
+		}
+		writeJSON(w, http.StatusOK, map[string]string{"reset_token": token})
+
+	case r.Method == http.MethodPost && r.URL.Path == "/password-reset/confirm":
+		writeResult(w, svc.ResetPassword(req.Token, req.Password))
+
+	case len(path) == 3 && path[0] == "accounts" && path[2] == "username" && r.Method == http.MethodPut:
+		writeResult(w, svc.ChangeUsername(path[1], req.Password, req.Username))
+
+	case len(path) == 3 && path[0] == "accounts" && path[2] == "deletion" && r.Method == http.MethodPost:
+		at, err := svc.RequestDeletion(path[1], req.Password)
+		if err != nil {
+			writeError(w, statusOf(err), err)
+			return
+		}
+		writeJSON(w, http.StatusOK, map[string]string{"delete_after": at.Format(time.RFC3339)})
+
+	case len(path) == 3 && path[0] == "accounts" && path[2] == "deletion" && r.Method == http.MethodDelete:
+		writeResult(w, svc.CancelDeletion(path[1], req.Password))
+
+	default:
+		writeError(w, http.StatusNotFound, errors.New("not found"))
+	}
+}
+
+func statusOf(err error) int {
+	switch {
+	case errors.Is(err, ErrNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, ErrWrongPassword):
+		return http.StatusForbidden
+	case errors.Is(err, ErrUsernameTaken), errors.Is(err, ErrMailTaken), errors.Is(err, ErrOnline),
+		errors.Is(err, ErrAlreadyDeleting), errors.Is(err, ErrNotDeleting):
+		return http.StatusConflict
+	case errors.Is(err, ErrBadUsername), errors.Is(err, ErrBadMail), errors.Is(err, ErrWeakPassword), errors.Is(err, ErrBadToken):
+		return http.StatusBadRequest
+	}
+	return http.StatusInternalServerError
+}
+
+func writeResult(w http.ResponseWriter, err error) {
+	if err != nil {
+		writeError(w, statusOf(err), err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func writeError(w http.ResponseWriter, status int, err error) {
+	if status == http.StatusInternalServerError {
+		log.Printf("account api: %s", err)
+		err = errors.New("internal error")
+	}
+	writeJSON(w, status, map[string]string{"error": err.Error()})
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+// ListenAndServe serves the API on addr with the accounts in hops.users and
+// purges the accounts due for deletion every hour.
+func ListenAndServe(addr, apiKey string) error {
+	svc := NewService(PostgresStore{})
+	svc.StartPurging(time.Hour)
+
+	srv := &http.Server{
+		Addr:         addr,
+		Handler:      &Handler{Service: svc, APIKey: apiKey},
+		ReadTimeout:  10 * time.Second,
+		WriteTimeout: 30 * time.Second,
+	}
+	log.Printf("Account API listening on %s", addr)
+	return srv.ListenAndServe()
+}
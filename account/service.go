@@ -0,0 +1,350 @@
+// Package account creates and manages player accounts for the website:
+// registration, mail verification, password reset, username change and
+// deletion after a grace period. See Handler for the HTTP API.
+package account
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"net/mail"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+
+	"github.com/twodragon/kore-server/config"
+	"github.com/twodragon/kore-server/database"
+	"github.com/twodragon/kore-server/passwd"
+	null "gopkg.in/guregu/null.v3"
+)
+
+const (
+	TOKEN_VERIFY_MAIL    = "verify_mail"
+	TOKEN_RESET_PASSWORD = "reset_password"
+
+	MAX_PASSWORD_LENGTH = 32 // what fits the login packet
+)
+
+var (
+	ErrBadUsername     = errors.New("username must be 4 to 16 letters, digits or underscores")
+	ErrBadMail         = errors.New("mail address is not valid")
+	ErrWeakPassword    = errors.New("password is too weak")
+	ErrUsernameTaken   = errors.New("username is taken")
+	ErrMailTaken       = errors.New("mail address is taken")
+	ErrNotFound        = errors.New("account not found")
+	ErrWrongPassword   = errors.New("wrong password")
+	ErrBadToken        = errors.New("token is not valid or expired")
+	ErrOnline          = errors.New("account is online")
+	ErrNotDeleting     = errors.New("account deletion was not requested")
+	ErrAlreadyDeleting = errors.New("account deletion was already requested")
+
+	usernamePattern = regexp.MustCompile(`^[A-Za-z0-9_]{4,16}$`)
+)
+
+// Service implements the account operations over a Store. Operations are
+// serialized, they are rare and mostly wait on the store anyway.
+type Service struct {
+	Store Store
+	mutex sync.Mutex
+}
+
+func NewService(store Store) *Service {
+	return &Service{Store: store}
+}
+
+// Register creates an account and returns it with its mail verification token.
+func (s *Service) Register(username, mailAddress, password string) (*database.User, string, error) {
+	password = passwd.Normalize(password)
+	if !usernamePattern.MatchString(username) {
+		return nil, "", ErrBadUsername
+	}
+	addr, err := mail.ParseAddress(mailAddress)
+	if err != nil || addr.Address != mailAddress {
+		return nil, "", ErrBadMail
+	}
+	if err := checkPassword(password, username); err != nil {
+		return nil, "", err
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if u, err := s.Store.UserByName(username); err != nil {
+		return nil, "", err
+	} else if u != nil {
+		return nil, "", ErrUsernameTaken
+	}
+	if u, err := s.Store.UserByMail(mailAddress); err != nil {
+		return nil, "", err
+	} else if u != nil {
+		return nil, "", ErrMailTaken
+	}
+
+	hash, err := passwd.Hash(password)
+	if err != nil {
+		return nil, "", err
+	}
+	u := &database.User{Username: username, Mail: mailAddress, Password: hash, UserType: 1}
+	if err := s.Store.CreateUser(u); err != nil {
+		return nil, "", err
+	}
+
+	token, err := s.newToken(TOKEN_VERIFY_MAIL, u.ID, hours(config.Default.Account.VerifyTokenTTL))
+	if err != nil {
+		return nil, "", err
+	}
+	log.Printf("Account %s(%s) registered", u.Username, u.ID)
+	return u, token, nil
+}
+
+// ResendVerification issues a new mail verification token.
+func (s *Service) ResendVerification(username string) (string, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	u, err := s.user(s.Store.UserByName(username))
+	if err != nil {
+		return "", err
+	}
+	return s.newToken(TOKEN_VERIFY_MAIL, u.ID, hours(config.Default.Account.VerifyTokenTTL))
+}
+
+func (s *Service) VerifyMail(token string) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	u, err := s.takeToken(TOKEN_VERIFY_MAIL, token)
+	if err != nil {
+		return err
+	}
+	u.MailVerified = true
+	return s.Store.UpdateUser(u)
+}
+
+// RequestPasswordReset returns a password reset token for the account with
+// the mail address.
+func (s *Service) RequestPasswordReset(mailAddress string) (string, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	u, err := s.user(s.Store.UserByMail(mailAddress))
+	if err != nil {
+		return "", err
+	}
+	ttl := time.Duration(config.Default.Account.ResetTokenTTL) * time.Minute
+	return s.newToken(TOKEN_RESET_PASSWORD, u.ID, ttl)
+}
+
+func (s *Service) ResetPassword(token, password string) error {
+	password = passwd.Normalize(password)
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	u, err := s.tokenUser(s.Store.Token(TOKEN_RESET_PASSWORD, hashToken(token)))
+	if err != nil {
+		return err
+	}
+	if err := checkPassword(password, u.Username); err != nil {
+		return err // the token stays for another try
+	}
+	hash, err := passwd.Hash(password)
+	if err != nil {
+		return err
+	}
+	if _, err := s.takeToken(TOKEN_RESET_PASSWORD, token); err != nil {
+		return err
+	}
+	u.Password = hash
+	u.MailVerified = true // the token was read from the mailbox
+	log.Printf("Password of account %s(%s) reset", u.Username, u.ID)
+	return s.Store.UpdateUser(u)
+}
+
+func (s *Service) ChangeUsername(id, password, username string) error {
+	if !usernamePattern.MatchString(username) {
+		return ErrBadUsername
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	u, err := s.authenticate(id, password)
+	if err != nil {
+		return err
+	}
+	if u.ConnectedIP != "" {
+		return ErrOnline
+	}
+	if other, err := s.Store.UserByName(username); err != nil {
+		return err
+	} else if other != nil && other.ID != u.ID {
+		return ErrUsernameTaken
+	}
+
+	log.Printf("Account %s(%s) renamed to %s", u.Username, u.ID, username)
+	u.Username = username
+	return s.Store.UpdateUser(u)
+}
+
+// RequestDeletion schedules the account for deletion once the grace period
+// is over and returns when that will be.
+func (s *Service) RequestDeletion(id, password string) (time.Time, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	u, err := s.authenticate(id, password)
+	if err != nil {
+		return time.Time{}, err
+	}
+	if u.DeleteAfter.Valid {
+		return time.Time{}, ErrAlreadyDeleting
+	}
+
+	u.DeleteAfter = null.TimeFrom(time.Now().Add(hours(24 * config.Default.Account.DeletionGrace)))
+	if err := s.Store.UpdateUser(u); err != nil {
+		return time.Time{}, err
+	}
+	log.Printf("Account %s(%s) will be deleted after %s", u.Username, u.ID, u.DeleteAfter.Time.Format("2006-01-02 15:04"))
+	return u.DeleteAfter.Time, nil
+}
+
+func (s *Service) CancelDeletion(id, password string) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	u, err := s.authenticate(id, password)
+	if err != nil {
+		return err
+	}
+	if !u.DeleteAfter.Valid {
+		return ErrNotDeleting
+	}
+	u.DeleteAfter = null.Time{}
+	return s.Store.UpdateUser(u)
+}
+
+// PurgeDeleted deletes the accounts whose grace period is over.
+func (s *Service) PurgeDeleted() {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	users, err := s.Store.UsersDueForDeletion(time.Now())
+	if err != nil {
+		log.Print(err)
+		return
+	}
+	for _, u := range users {
+		if u.ConnectedIP != "" {
+			continue // next time
+		}
+		if err := s.Store.DeleteUser(u); err != nil {
+			log.Printf("Account %s(%s) deletion: %s", u.Username, u.ID, err)
+			continue
+		}
+		log.Printf("Account %s(%s) deleted", u.Username, u.ID)
+	}
+}
+
+// StartPurging runs PurgeDeleted every interval.
+func (s *Service) StartPurging(interval time.Duration) {
+	go func() {
+		for range time.Tick(interval) {
+			s.PurgeDeleted()
+		}
+	}()
+}
+
+func (s *Service) user(u *database.User, err error) (*database.User, error) {
+	if err != nil {
+		return nil, err
+	}
+	if u == nil {
+		return nil, ErrNotFound
+	}
+	return u, nil
+}
+
+func (s *Service) authenticate(id, password string) (*database.User, error) {
+	password = passwd.Normalize(password)
+	u, err := s.user(s.Store.UserByID(id))
+	if err != nil {
+		return nil, err
+	}
+	ok, _, err := passwd.Verify(password, u.Password)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return nil, ErrWrongPassword
+	}
+	return u, nil
+}
+
+func (s *Service) newToken(kind, userID string, ttl time.Duration) (string, error) {
+	raw := make([]byte, 24)
+	if _, err := rand.Read(raw); err != nil {
+		return "", err
+	}
+	token := hex.EncodeToString(raw)
+
+	t := &database.AccountToken{Hash: hashToken(token), Kind: kind, UserID: userID, ExpiresAt: time.Now().Add(ttl)}
+	if err := s.Store.SaveToken(t); err != nil {
+		return "", fmt.Errorf("account: save token: %s", err)
+	}
+	return token, nil
+}
+
+func (s *Service) takeToken(kind, token string) (*database.User, error) {
+	return s.tokenUser(s.Store.TakeToken(kind, hashToken(token)))
+}
+
+// tokenUser returns the user of a token that has not expired.
+func (s *Service) tokenUser(t *database.AccountToken, err error) (*database.User, error) {
+	if err != nil {
+		return nil, err
+	}
+	if t == nil || time.Now().After(t.ExpiresAt) {
+		return nil, ErrBadToken
+	}
+	return s.user(s.Store.UserByID(t.UserID))
+}
+
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+func hours(n int) time.Duration {
+	return time.Duration(n) * time.Hour
+}
+
+// checkPassword asks for a minimum length, letters and digits, and something
+// else than the username.
+func checkPassword(password, username string) error {
+	min := config.Default.Account.MinPasswordLength
+	if len(password) < min || len(password) > MAX_PASSWORD_LENGTH {
+		return fmt.Errorf("%w: it must be %d to %d characters", ErrWeakPassword, min, MAX_PASSWORD_LENGTH)
+	}
+
+	var letter, digit bool
+	for _, r := range password {
+		switch {
+		case unicode.IsLetter(r):
+			letter = true
+		case unicode.IsDigit(r):
+			digit = true
+		}
+	}
+	if !letter || !digit {
+		return fmt.Errorf("%w: it needs letters and digits", ErrWeakPassword)
+	}
+	if strings.Contains(strings.ToLower(password), strings.ToLower(username)) {
+		return fmt.Errorf("%w: it can not contain the username", ErrWeakPassword)
+	}
+	return nil
+}
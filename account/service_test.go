@@ -0,0 +1,197 @@
+package account
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/twodragon/kore-server/config"
+)
+
+func TestMain(m *testing.M) {
+	config.Default.Auth.PasswordScheme = "bcrypt"
+	config.Default.Auth.BcryptCost = 4 // fast hashes
+	os.Exit(m.Run())
+}
+
+func newTestService(t *testing.T) (*Service, string, string) {
+	t.Helper()
+	svc := NewService(NewMemoryStore())
+	u, token, err := svc.Register("player_1", "player@example.com", "secret123")
+	if err != nil {
+		t.Fatalf("Register: %s", err)
+	}
+	return svc, u.ID, token
+}
+
+func TestRegister(t *testing.T) {
+	svc, id, token := newTestService(t)
+	if token == "" {
+		t.Fatal("no verification token")
+	}
+	u, _ := svc.Store.UserByID(id)
+	if u.Password == "secret123" || u.MailVerified {
+		t.Fatalf("user saved as %+v", u)
+	}
+
+	for _, c := range []struct {
+		username, mail, password string
+		err                      error
+	}{
+		{"ab", "other@example.com", "secret123", ErrBadUsername},
+		{"player_2", "not a mail", "secret123", ErrBadMail},
+		{"player_2", "other@example.com", "short1", ErrWeakPassword},
+		{"player_2", "other@example.com", "onlyletters", ErrWeakPassword},
+		{"player_2", "other@example.com", "xplayer_2x1", ErrWeakPassword},
+		{"PLAYER_1", "other@example.com", "secret123", ErrUsernameTaken},
+		{"player_2", "player@example.com", "secret123", ErrMailTaken},
+	} {
+		if _, _, err := svc.Register(c.username, c.mail, c.password); !errors.Is(err, c.err) {
+			t.Errorf("Register(%q, %q, %q) = %v, want %v", c.username, c.mail, c.password, err, c.err)
+		}
+	}
+}
+
+func TestVerifyMail(t *testing.T) {
+	svc, id, token := newTestService(t)
+	if err := svc.VerifyMail("bad"); !errors.Is(err, ErrBadToken) {
+		t.Fatalf("VerifyMail(bad) = %v", err)
+	}
+	if err := svc.VerifyMail(token); err != nil {
+		t.Fatalf("VerifyMail: %s", err)
+	}
+	if u, _ := svc.Store.UserByID(id); !u.MailVerified {
+		t.Fatal("mail not verified")
+	}
+	if err := svc.VerifyMail(token); !errors.Is(err, ErrBadToken) {
+		t.Fatalf("token used twice: %v", err)
+	}
+}
+
+func TestResetPassword(t *testing.T) {
+	svc, id, _ := newTestService(t)
+	if _, err := svc.RequestPasswordReset("nobody@example.com"); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("RequestPasswordReset(unknown) = %v", err)
+	}
+	token, err := svc.RequestPasswordReset("player@example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := svc.ResetPassword(token, "weak"); !errors.Is(err, ErrWeakPassword) {
+		t.Fatalf("ResetPassword(weak) = %v", err)
+	}
+	if err := svc.ResetPassword(token, "another456"); err != nil {
+		t.Fatalf("the weak password used the token up: %s", err)
+	}
+	if _, err := svc.authenticate(id, "another456"); err != nil {
+		t.Fatalf("new password refused: %s", err)
+	}
+	if _, err := svc.authenticate(id, "secret123"); !errors.Is(err, ErrWrongPassword) {
+		t.Fatalf("old password: %v", err)
+	}
+	if err := svc.ResetPassword(token, "third789"); !errors.Is(err, ErrBadToken) {
+		t.Fatalf("token used twice: %v", err)
+	}
+}
+
+func TestExpiredToken(t *testing.T) {
+	svc, _, _ := newTestService(t)
+	token, _ := svc.RequestPasswordReset("player@example.com")
+	for _, tok := range svc.Store.(*MemoryStore).tokens {
+		tok.ExpiresAt = time.Now().Add(-time.Minute)
+	}
+	if err := svc.ResetPassword(token, "another456"); !errors.Is(err, ErrBadToken) {
+		t.Fatalf("ResetPassword(expired) = %v", err)
+	}
+}
+
+func TestClientPassword(t *testing.T) {
+	svc, id, _ := newTestService(t)
+	field := "secret123" + strings.Repeat("\x00", 26) // as the login packet carries it
+	if _, err := svc.authenticate(id, field); err != nil {
+		t.Fatalf("padded password refused: %s", err)
+	}
+}
+
+func TestChangeUsername(t *testing.T) {
+	svc, id, _ := newTestService(t)
+	svc.Register("player_2", "two@example.com", "secret123")
+
+	if err := svc.ChangeUsername(id, "wrong123", "player_3"); !errors.Is(err, ErrWrongPassword) {
+		t.Fatalf("wrong password: %v", err)
+	}
+	if err := svc.ChangeUsername(id, "secret123", "player_2"); !errors.Is(err, ErrUsernameTaken) {
+		t.Fatalf("taken name: %v", err)
+	}
+	u, _ := svc.Store.UserByID(id)
+	u.ConnectedIP = "127.0.0.1"
+	if err := svc.ChangeUsername(id, "secret123", "player_3"); !errors.Is(err, ErrOnline) {
+		t.Fatalf("online: %v", err)
+	}
+	u.ConnectedIP = ""
+	if err := svc.ChangeUsername(id, "secret123", "player_3"); err != nil {
+		t.Fatal(err)
+	}
+	if u, _ := svc.Store.UserByName("player_3"); u == nil || u.ID != id {
+		t.Fatal("not renamed")
+	}
+}
+
+func TestDeletion(t *testing.T) {
+	svc, id, _ := newTestService(t)
+	if err := svc.CancelDeletion(id, "secret123"); !errors.Is(err, ErrNotDeleting) {
+		t.Fatalf("CancelDeletion before a request = %v", err)
+	}
+	at, err := svc.RequestDeletion(id, "secret123")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := svc.RequestDeletion(id, "secret123"); !errors.Is(err, ErrAlreadyDeleting) {
+		t.Fatalf("second request = %v", err)
+	}
+
+	svc.PurgeDeleted()
+	if u, _ := svc.Store.UserByID(id); u == nil {
+		t.Fatal("purged within the grace period")
+	}
+	if err := svc.CancelDeletion(id, "secret123"); err != nil {
+		t.Fatal(err)
+	}
+
+	svc.RequestDeletion(id, "secret123")
+	u, _ := svc.Store.UserByID(id)
+	u.DeleteAfter.Time = at.Add(-time.Hour * 24 * 365)
+	svc.PurgeDeleted()
+	if u, _ := svc.Store.UserByID(id); u != nil {
+		t.Fatal("not purged after the grace period")
+	}
+}
+
+func TestHandler(t *testing.T) {
+	svc, _, _ := newTestService(t)
+	body := `{"mail": "player@example.com"}`
+
+	for _, c := range []struct {
+		key, header string
+		status      int
+	}{
+		{"", "", http.StatusUnauthorized},
+		{"", "anything", http.StatusUnauthorized},
+		{"key", "wrong", http.StatusUnauthorized},
+		{"key", "key", http.StatusOK},
+	} {
+		h := &Handler{Service: svc, APIKey: c.key}
+		r := httptest.NewRequest(http.MethodPost, "/password-reset", strings.NewReader(body))
+		r.Header.Set("X-Api-Key", c.header)
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		if w.Code != c.status {
+			t.Errorf("key %q, header %q: status %d, want %d", c.key, c.header, w.Code, c.status)
+		}
+	}
+}
@@ -0,0 +1,175 @@
+package account
+
+import (
+	"database/sql"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/twodragon/kore-server/database"
+)
+
+// Store is where the service keeps accounts and tokens. Finders return nil
+// and no error when nothing matches, UserByName matches the name in any case.
+type Store interface {
+	UserByID(id string) (*database.User, error)
+	UserByName(name string) (*database.User, error)
+	UserByMail(mail string) (*database.User, error)
+	CreateUser(u *database.User) error
+	UpdateUser(u *database.User) error
+	DeleteUser(u *database.User) error
+	UsersDueForDeletion(now time.Time) ([]*database.User, error)
+
+	SaveToken(t *database.AccountToken) error
+	Token(kind, hash string) (*database.AccountToken, error)
+	TakeToken(kind, hash string) (*database.AccountToken, error)
+}
+
+// PostgresStore keeps accounts in hops.users and tokens in hops.account_tokens.
+type PostgresStore struct{}
+
+func notFound(u *database.User, err error) (*database.User, error) {
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	return u, err
+}
+
+func (PostgresStore) UserByID(id string) (*database.User, error) {
+	return notFound(database.FindUserByID(id))
+}
+
+func (PostgresStore) UserByName(name string) (*database.User, error) {
+	return notFound(database.FindUserByNameFold(name))
+}
+
+func (PostgresStore) UserByMail(mail string) (*database.User, error) {
+	return notFound(database.FindUserByMail(mail))
+}
+
+func (PostgresStore) CreateUser(u *database.User) error {
+	now := time.Now().Format("2006-01-02 15:04:05")
+	if u.DisabledUntil == "" { // timestamp columns
+		u.DisabledUntil = now
+	}
+	if u.LastLogin == "" {
+		u.LastLogin = now
+	}
+	return u.Create()
+}
+
+func (PostgresStore) UpdateUser(u *database.User) error {
+	return u.Update()
+}
+
+// DeleteUser deletes the account with all its characters.
+func (PostgresStore) DeleteUser(u *database.User) error {
+	characters, err := database.FindCharactersByUserID(u.ID)
+	if err != nil {
+		return err
+	}
+	for _, c := range characters {
+		if err := c.Purge(); err != nil {
+			return err
+		}
+	}
+
+	if err := u.Delete(); err != nil {
+		return err
+	}
+	database.DeleteUserFromCache(u.ID)
+	return nil
+}
+
+func (PostgresStore) UsersDueForDeletion(now time.Time) ([]*database.User, error) {
+	return database.FindUsersDueForDeletion(now)
+}
+
+func (PostgresStore) SaveToken(t *database.AccountToken) error {
+	database.DeleteExpiredAccountTokens()
+	return t.Create()
+}
+
+func (PostgresStore) Token(kind, hash string) (*database.AccountToken, error) {
+	return database.FindAccountToken(kind, hash)
+}
+
+func (PostgresStore) TakeToken(kind, hash string) (*database.AccountToken, error) {
+	return database.TakeAccountToken(kind, hash)
+}
+
+// MemoryStore keeps everything in memory, for tests and for trying the API
+// without a database.
+type MemoryStore struct {
+	users  map[string]*database.User
+	tokens map[string]*database.AccountToken
+	nextID int
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{users: make(map[string]*database.User), tokens: make(map[string]*database.AccountToken)}
+}
+
+func (m *MemoryStore) UserByID(id string) (*database.User, error) {
+	return m.users[id], nil
+}
+
+func (m *MemoryStore) UserByName(name string) (*database.User, error) {
+	for _, u := range m.users {
+		if strings.EqualFold(u.Username, name) {
+			return u, nil
+		}
+	}
+	return nil, nil
+}
+
+func (m *MemoryStore) UserByMail(mail string) (*database.User, error) {
+	for _, u := range m.users {
+		if strings.EqualFold(u.Mail, mail) {
+			return u, nil
+		}
+	}
+	return nil, nil
+}
+
+func (m *MemoryStore) CreateUser(u *database.User) error {
+	m.nextID++
+	u.ID = strconv.Itoa(m.nextID)
+	m.users[u.ID] = u
+	return nil
+}
+
+func (m *MemoryStore) UpdateUser(u *database.User) error {
+	m.users[u.ID] = u
+	return nil
+}
+
+func (m *MemoryStore) DeleteUser(u *database.User) error {
+	delete(m.users, u.ID)
+	return nil
+}
+
+func (m *MemoryStore) UsersDueForDeletion(now time.Time) ([]*database.User, error) {
+	var due []*database.User
+	for _, u := range m.users {
+		if u.DeleteAfter.Valid && u.DeleteAfter.Time.Before(now) {
+			due = append(due, u)
+		}
+	}
+	return due, nil
+}
+
+func (m *MemoryStore) SaveToken(t *database.AccountToken) error {
+	m.tokens[t.Kind+":"+t.Hash] = t
+	return nil
+}
+
+func (m *MemoryStore) Token(kind, hash string) (*database.AccountToken, error) {
+	return m.tokens[kind+":"+hash], nil
+}
+
+func (m *MemoryStore) TakeToken(kind, hash string) (*database.AccountToken, error) {
+	t := m.tokens[kind+":"+hash]
+	delete(m.tokens, kind+":"+hash)
+	return t, nil
+}
@@ -0,0 +1,129 @@
+package admin
+
+import (
+	"encoding/csv"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/twodragon/kore-server/database"
+)
+
+const (
+	SESSION_HISTORY_MAX = 200
+	AUDIT_EXPORT_MAX    = 10000
+)
+
+// findUser returns the account with id, a not_found error if there is none.
+func findUser(id string) (*database.User, error) {
+	u, err := database.FindUserByID(id)
+	if err != nil || u == nil {
+		return nil, newError(CODE_NOT_FOUND, "account %s not found", id)
+	}
+	return u, nil
+}
+
+// accountSanctions returns the sanction history of the account and its characters.
+func accountSanctions(id string) ([]*database.Sanction, error) {
+	u, err := findUser(id)
+	if err != nil {
+		return nil, err
+	}
+	sanctions, err := database.FindSanctions(u.ID)
+	if sanctions == nil {
+		sanctions = []*database.Sanction{}
+	}
+	return sanctions, err
+}
+
+// accountSessions returns the latest sessions of the account, at most limit of them
+// and SESSION_HISTORY_MAX.
+func accountSessions(id string, limit int) ([]*database.Session, error) {
+	u, err := findUser(id)
+	if err != nil {
+		return nil, err
+	}
+	if limit <= 0 || limit > SESSION_HISTORY_MAX {
+		limit = SESSION_HISTORY_MAX
+	}
+	sessions, err := database.FindSessions(u.ID, limit)
+	if sessions == nil {
+		sessions = []*database.Session{}
+	}
+	return sessions, err
+}
+
+// accountLinks returns the accounts that played from the IPs of the account.
+func accountLinks(id string) ([]*database.IPLink, error) {
+	u, err := findUser(id)
+	if err != nil {
+		return nil, err
+	}
+	links, err := database.FindIPLinks(u.ID)
+	if links == nil {
+		links = []*database.IPLink{}
+	}
+	return links, err
+}
+
+// audit returns the admin audit entries of the query, at most
+// AUDIT_EXPORT_MAX of them.
+func audit(q url.Values) ([]*database.AuditEntry, error) {
+	f, err := auditFilter(q)
+	if err != nil {
+		return nil, err
+	}
+	if f.Limit <= 0 || f.Limit > AUDIT_EXPORT_MAX {
+		f.Limit = AUDIT_EXPORT_MAX
+	}
+	entries, err := database.FindAudit(f)
+	if entries == nil {
+		entries = []*database.AuditEntry{}
+	}
+	return entries, err
+}
+
+// auditFilter reads the filter of an audit query, times in RFC 3339 or as
+// dates.
+func auditFilter(q url.Values) (database.AuditFilter, error) {
+	f := database.AuditFilter{Issuer: q.Get("issuer"), Target: q.Get("target"), Command: q.Get("command")}
+	for _, t := range []struct {
+		key string
+		to  *time.Time
+	}{{"from", &f.From}, {"to", &f.To}} {
+		value := q.Get(t.key)
+		if value == "" {
+			continue
+		}
+		at, err := time.Parse(time.RFC3339, value)
+		if err != nil {
+			if at, err = time.Parse("2006-01-02", value); err != nil {
+				return f, newError(CODE_BAD_REQUEST, "%s is not a time", t.key)
+			}
+		}
+		*t.to = at
+	}
+	if limit := q.Get("limit"); limit != "" {
+		n, err := strconv.Atoi(limit)
+		if err != nil {
+			return f, newError(CODE_BAD_REQUEST, "limit is not a number")
+		}
+		f.Limit = n
+	}
+	return f, nil
+}
+
+func writeAuditCSV(w http.ResponseWriter, entries []*database.AuditEntry) {
+	w.Header().Set("Content-Type", "text/csv")
+	w.Header().Set("Content-Disposition", `attachment; filename="admin_audit.csv"`)
+	w.WriteHeader(http.StatusOK)
+
+	out := csv.NewWriter(w)
+	out.Write([]string{"id", "created_at", "issuer", "issuer_id", "target", "target_user_id", "command", "arguments", "changes", "approved_by", "error"})
+	for _, e := range entries {
+		out.Write([]string{strconv.Itoa(e.ID), e.CreatedAt.Format(time.RFC3339), e.Issuer, e.IssuerID, e.Target, e.TargetUserID,
+			e.Command, e.Arguments, e.Changes.String(), e.ApprovedBy, e.Error})
+	}
+	out.Flush()
+}
@@ -0,0 +1,514 @@
+// Package admin serves the admin API for live server operations on a local
+// address of the game server, for the tools of the staff. Every change it
+// makes is recorded in the admin audit like the GM commands.
+package admin
+
+import (
+	"crypto/subtle"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/twodragon/kore-server/database"
+)
+
+const (
+	// error codes, see statuses
+	CODE_BAD_REQUEST  = "bad_request"
+	CODE_UNAUTHORIZED = "unauthorized"
+	CODE_NOT_FOUND    = "not_found"
+	CODE_CONFLICT     = "conflict"
+	CODE_INTERNAL     = "internal"
+
+	MAX_ANNOUNCEMENT = 250 // bytes, the packet holds the length in a byte
+	MAX_RATE         = 100
+	MAX_RATE_MINUTES = 60 * 24 * 30
+	MAX_COUNTDOWN    = 60 * 60 // seconds
+	GREAT_WAR_LEVEL  = 40
+)
+
+var statuses = map[string]int{
+	CODE_BAD_REQUEST:  http.StatusBadRequest,
+	CODE_UNAUTHORIZED: http.StatusUnauthorized,
+	CODE_NOT_FOUND:    http.StatusNotFound,
+	CODE_CONFLICT:     http.StatusConflict,
+	CODE_INTERNAL:     http.StatusInternalServerError,
+}
+
+// Error is an error of the API with its code.
+type Error struct {
+	Code    string `json:"code"`
+	Message string `json:"error"`
+}
+
+func (e *Error) Error() string {
+	return e.Message
+}
+
+func newError(code, format string, a ...interface{}) *Error {
+	return &Error{Code: code, Message: fmt.Sprintf(format, a...)}
+}
+
+// Handler serves the admin API. Every request carries the API key in the
+// X-Api-Key header, and may name the staff member in X-Admin-User for the
+// audit. Errors are {code, error}, the codes being bad_request,
+// unauthorized, not_found, conflict and internal.
+//
+//	GET    /characters                                          -> [online character]
+//	GET    /characters/{name}/inventory                         -> [item]
+//	POST   /characters/{name}/kick
+//	POST   /sanctions           {type, scope, target, duration, reason, map} -> sanction
+//	DELETE /sanctions/{id}                                      -> sanction
+//	POST   /announcements       {message}
+//	GET    /rates                                               -> {gold, exp, drop}
+//	PUT    /rates/{kind}        {rate, minutes}                 -> rate
+//	GET    /wars                                                -> {faction, flag_kingdom, great}
+//	PUT    /wars/{kind}/schedule                                 faction and flag_kingdom
+//	DELETE /wars/{kind}/schedule
+//	POST   /wars/{kind}         {countdown}                     seconds to the start
+//	DELETE /wars/great                                          cancels the countdown
+//	GET    /tables                                              -> [name]
+//	POST   /tables/{name}/reload                                 all for the usual ones
+//	GET    /accounts/{id}/sanctions                             -> [sanction]
+//	GET    /accounts/{id}/sessions?limit=                       -> [session]
+//	GET    /accounts/{id}/links                                 -> [ip link]
+//	GET    /audit?issuer=&target=&command=&from=&to=&limit=&format=csv -> [audit entry]
+type Handler struct {
+	APIKey string
+}
+
+type request struct {
+	Type      string  `json:"type"`
+	Scope     string  `json:"scope"`
+	Target    string  `json:"target"`
+	Duration  string  `json:"duration"`
+	Reason    string  `json:"reason"`
+	Map       int16   `json:"map"`
+	Message   string  `json:"message"`
+	Rate      float64 `json:"rate"`
+	Minutes   int     `json:"minutes"`
+	Countdown int     `json:"countdown"`
+}
+
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if h.APIKey == "" || subtle.ConstantTimeCompare([]byte(r.Header.Get("X-Api-Key")), []byte(h.APIKey)) != 1 {
+		writeError(w, newError(CODE_UNAUTHORIZED, "bad api key"))
+		return
+	}
+
+	var req request
+	if r.Body != nil && r.ContentLength != 0 {
+		if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 4096)).Decode(&req); err != nil {
+			writeError(w, newError(CODE_BAD_REQUEST, "bad request body: %s", err))
+			return
+		}
+	}
+
+	path := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+	op := &operation{issuer: issuer(r)}
+
+	var v interface{}
+	var err error
+	switch {
+	case r.Method == http.MethodGet && r.URL.Path == "/characters":
+		v, err = onlineCharacters()
+
+	case len(path) == 3 && path[0] == "characters" && path[2] == "inventory" && r.Method == http.MethodGet:
+		v, err = inventory(path[1])
+
+	case len(path) == 3 && path[0] == "characters" && path[2] == "kick" && r.Method == http.MethodPost:
+		err = op.kick(path[1])
+
+	case r.Method == http.MethodPost && r.URL.Path == "/sanctions":
+		v, err = op.sanction(req)
+		if err == nil {
+			writeJSON(w, http.StatusCreated, v)
+			return
+		}
+
+	case len(path) == 2 && path[0] == "sanctions" && r.Method == http.MethodDelete:
+		v, err = op.lift(path[1])
+
+	case r.Method == http.MethodPost && r.URL.Path == "/announcements":
+		err = op.announce(req.Message)
+
+	case r.Method == http.MethodGet && r.URL.Path == "/rates":
+		v = database.Rates()
+
+	case len(path) == 2 && path[0] == "rates" && r.Method == http.MethodPut:
+		v, err = op.setRate(path[1], req.Rate, req.Minutes)
+
+	case r.Method == http.MethodGet && r.URL.Path == "/wars":
+		v = database.WarStatuses()
+
+	case len(path) == 3 && path[0] == "wars" && path[2] == "schedule" && r.Method == http.MethodPut:
+		err = op.schedule(path[1], true)
+
+	case len(path) == 3 && path[0] == "wars" && path[2] == "schedule" && r.Method == http.MethodDelete:
+		err = op.schedule(path[1], false)
+
+	case len(path) == 2 && path[0] == "wars" && r.Method == http.MethodPost:
+		err = op.startWar(path[1], req.Countdown)
+
+	case len(path) == 2 && path[0] == "wars" && r.Method == http.MethodDelete:
+		err = op.stopWar(path[1])
+
+	case r.Method == http.MethodGet && r.URL.Path == "/tables":
+		v = append([]string{database.TABLES_ALL}, database.TableNames()...)
+
+	case len(path) == 3 && path[0] == "tables" && path[2] == "reload" && r.Method == http.MethodPost:
+		err = op.reload(path[1])
+
+	case len(path) == 3 && path[0] == "accounts" && path[2] == "sanctions" && r.Method == http.MethodGet:
+		v, err = accountSanctions(path[1])
+
+	case len(path) == 3 && path[0] == "accounts" && path[2] == "sessions" && r.Method == http.MethodGet:
+		limit, _ := strconv.Atoi(r.URL.Query().Get("limit"))
+		v, err = accountSessions(path[1], limit)
+
+	case len(path) == 3 && path[0] == "accounts" && path[2] == "links" && r.Method == http.MethodGet:
+		v, err = accountLinks(path[1])
+
+	case r.Method == http.MethodGet && r.URL.Path == "/audit":
+		var entries []*database.AuditEntry
+		entries, err = audit(r.URL.Query())
+		if err == nil && r.URL.Query().Get("format") == "csv" {
+			writeAuditCSV(w, entries)
+			return
+		}
+		v = entries
+
+	default:
+		err = newError(CODE_NOT_FOUND, "not found")
+	}
+
+	switch {
+	case err != nil:
+		writeError(w, err)
+	case v == nil:
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		writeJSON(w, http.StatusOK, v)
+	}
+}
+
+// issuer names the caller in the audit, api:<X-Admin-User>.
+func issuer(r *http.Request) string {
+	if user := strings.TrimSpace(r.Header.Get("X-Admin-User")); user != "" {
+		return "api:" + user
+	}
+	return "api"
+}
+
+// onlineCharacter is a character in game with where it is.
+type onlineCharacter struct {
+	ID         int    `json:"id"`
+	Name       string `json:"name"`
+	UserID     string `json:"user_id"`
+	Username   string `json:"username"`
+	Level      int    `json:"level"`
+	Map        int16  `json:"map"`
+	Coordinate string `json:"coordinate"`
+	Server     int    `json:"server"`
+	IP         string `json:"ip"`
+	Country    string `json:"country"`
+}
+
+func onlineCharacters() ([]*onlineCharacter, error) {
+	characters, err := database.FindOnlineCharacters()
+	if err != nil {
+		return nil, err
+	}
+
+	online := []*onlineCharacter{}
+	for _, c := range characters {
+		o := &onlineCharacter{ID: c.ID, Name: c.Name, UserID: c.UserID, Level: c.Level, Map: c.Map, Coordinate: c.Coordinate}
+		if u, err := database.FindUserByID(c.UserID); err == nil && u != nil {
+			o.Username, o.Server, o.IP = u.Username, u.ConnectedServer, u.ConnectedIP
+		}
+		if s := database.GetSocket(c.UserID); s != nil {
+			o.IP = s.ClientIP()
+			if s.IPInfo != nil {
+				o.Country = s.IPInfo.Country
+			}
+		}
+		online = append(online, o)
+	}
+	sort.Slice(online, func(i, j int) bool {
+		return online[i].Name < online[j].Name
+	})
+	return online, nil
+}
+
+// item is a slot of the inventory or the bank of a character.
+type item struct {
+	Slot     int    `json:"slot"`
+	ItemID   int64  `json:"item_id"`
+	Name     string `json:"name"`
+	Quantity uint   `json:"quantity"`
+	Plus     uint8  `json:"plus"`
+	Upgrades string `json:"upgrades"`
+	Sockets  string `json:"sockets"`
+	InUse    bool   `json:"in_use"`
+}
+
+func inventory(name string) ([]*item, error) {
+	c, err := findCharacter(name)
+	if err != nil {
+		return nil, err
+	}
+	slots, err := c.InventorySlots()
+	if err != nil {
+		return nil, err
+	}
+
+	items := []*item{}
+	for i, slot := range slots {
+		if slot == nil || slot.ItemID == 0 {
+			continue
+		}
+		it := &item{Slot: i, ItemID: slot.ItemID, Quantity: slot.Quantity, Plus: slot.Plus,
+			Upgrades: slot.UpgradeArr, Sockets: slot.SocketArr, InUse: slot.InUse}
+		if info, ok := database.GetItemInfo(slot.ItemID); ok {
+			it.Name = info.Name
+		}
+		items = append(items, it)
+	}
+	return items, nil
+}
+
+func findCharacter(name string) (*database.Character, error) {
+	c, err := database.FindCharacterByName(name)
+	if errors.Is(err, sql.ErrNoRows) || (err == nil && c == nil) {
+		return nil, newError(CODE_NOT_FOUND, "character %s not found", name)
+	}
+	return c, err
+}
+
+// operation is a change of one request, recorded in the admin audit.
+type operation struct {
+	issuer string
+}
+
+func (op *operation) audit(command, arguments string, c *database.Character, err error) {
+	e := &database.AuditEntry{Issuer: op.issuer, Command: command, Arguments: arguments, CreatedAt: time.Now()}
+	if c != nil {
+		e.Target, e.TargetUserID = c.Name, c.UserID
+	}
+	if err != nil {
+		e.Error = err.Error()
+	}
+	if err := database.RecordAudit(e); err != nil {
+		log.Println(err)
+	}
+}
+
+func (op *operation) kick(name string) error {
+	c, err := findCharacter(name)
+	if err != nil {
+		return err
+	}
+	s := database.GetSocket(c.UserID)
+	if s == nil || s.Character == nil || s.Character.ID != c.ID {
+		return newError(CODE_CONFLICT, "%s is not online", c.Name)
+	}
+
+	s.SetCloseReason(database.SESSION_KICKED)
+	s.Conn.Close()
+	op.audit("kick", "", c, nil)
+	return nil
+}
+
+func (op *operation) sanction(req request) (*database.Sanction, error) {
+	switch req.Type {
+	case database.SANCTION_BAN, database.SANCTION_MUTE, database.SANCTION_JAIL, database.SANCTION_TRADE_BLOCK:
+	default:
+		return nil, newError(CODE_BAD_REQUEST, "type %q is not ban, mute, jail or trade_block", req.Type)
+	}
+	switch req.Scope {
+	case "":
+		req.Scope = database.SCOPE_ACCOUNT
+	case database.SCOPE_ACCOUNT, database.SCOPE_CHARACTER, database.SCOPE_IP:
+	default:
+		return nil, newError(CODE_BAD_REQUEST, "scope %q is not account, character or ip", req.Scope)
+	}
+	if req.Type == database.SANCTION_JAIL && req.Scope != database.SCOPE_CHARACTER {
+		return nil, newError(CODE_BAD_REQUEST, "jails are of a character")
+	}
+	if req.Target == "" {
+		return nil, newError(CODE_BAD_REQUEST, "target is required")
+	}
+
+	d, err := database.ParseSanctionDuration(req.Duration)
+	if err != nil {
+		return nil, newError(CODE_BAD_REQUEST, "%s", err)
+	}
+
+	var c *database.Character
+	if req.Scope != database.SCOPE_IP || net.ParseIP(req.Target) == nil {
+		if c, err = findCharacter(req.Target); err != nil {
+			return nil, err
+		}
+	}
+	target, err := database.SanctionTarget(req.Scope, req.Target)
+	if err != nil {
+		return nil, newError(CODE_CONFLICT, "%s", err) // the character is not online for its IP
+	}
+	s, err := database.IssueSanction(req.Type, req.Scope, target, req.Map, d, op.issuer, req.Reason)
+	op.audit(req.Type, strings.TrimSpace(fmt.Sprintf("%s %s %s %s", req.Scope, req.Target, req.Duration, req.Reason)), c, err)
+	return s, err
+}
+
+func (op *operation) lift(id string) (*database.Sanction, error) {
+	n, err := strconv.Atoi(id)
+	if err != nil {
+		return nil, newError(CODE_NOT_FOUND, "not found")
+	}
+	s, err := database.LiftSanction(n, op.issuer)
+	if errors.Is(err, database.ErrSanctionNotActive) {
+		return nil, newError(CODE_NOT_FOUND, "%s", err)
+	}
+	op.audit("lift", id, nil, err)
+	return s, err
+}
+
+func (op *operation) announce(message string) error {
+	message = strings.TrimSpace(message)
+	if message == "" {
+		return newError(CODE_BAD_REQUEST, "message is required")
+	}
+	if len(message) > MAX_ANNOUNCEMENT {
+		return newError(CODE_BAD_REQUEST, "message is longer than %d bytes", MAX_ANNOUNCEMENT)
+	}
+
+	database.Announce(message)
+	op.audit("announce", message, nil, nil)
+	return nil
+}
+
+func (op *operation) setRate(kind string, rate float64, minutes int) (*database.Rate, error) {
+	if _, ok := database.Rates()[kind]; !ok {
+		return nil, newError(CODE_NOT_FOUND, "rate %s not found", kind)
+	}
+	if rate < 0 || rate > MAX_RATE {
+		return nil, newError(CODE_BAD_REQUEST, "rate must be between 0 and %d", MAX_RATE)
+	}
+	if minutes < 1 || minutes > MAX_RATE_MINUTES {
+		return nil, newError(CODE_BAD_REQUEST, "minutes must be between 1 and %d", MAX_RATE_MINUTES)
+	}
+
+	err := database.SetRate(kind, rate, time.Duration(minutes)*time.Minute)
+	op.audit(kind+"rate", fmt.Sprintf("%g %d", rate, minutes), nil, err)
+	if err != nil {
+		return nil, err
+	}
+	r := database.Rates()[kind]
+	return &r, nil
+}
+
+func (op *operation) schedule(kind string, start bool) error {
+	s, ok := database.WarSchedules[kind]
+	if !ok {
+		return newError(CODE_NOT_FOUND, "war %s has no schedule", kind)
+	}
+
+	if start {
+		if !s.Start() {
+			return newError(CODE_CONFLICT, "war %s is scheduled already", kind)
+		}
+		op.audit("warschedule", kind+" on", nil, nil)
+		return nil
+	}
+	if !s.Stop() {
+		return newError(CODE_CONFLICT, "war %s is not scheduled", kind)
+	}
+	op.audit("warschedule", kind+" off", nil, nil)
+	return nil
+}
+
+func (op *operation) startWar(kind string, countdown int) error {
+	status, ok := database.WarStatuses()[kind]
+	if !ok {
+		return newError(CODE_NOT_FOUND, "war %s not found", kind)
+	}
+	if countdown < 0 || countdown > MAX_COUNTDOWN {
+		return newError(CODE_BAD_REQUEST, "countdown must be between 0 and %d seconds", MAX_COUNTDOWN)
+	}
+	if status.EntranceOpen || status.Started {
+		return newError(CODE_CONFLICT, "war %s is on already", kind)
+	}
+
+	switch kind {
+	case database.WAR_FACTION:
+		database.PrepareFactionWar(countdown)
+	case database.WAR_FLAG_KINGDOM:
+		database.PrepareFlagKingdom(countdown)
+	case database.WAR_GREAT:
+		database.CanJoinWar = true
+		database.StartWarTimer(countdown, GREAT_WAR_LEVEL)
+	}
+	op.audit("war", fmt.Sprintf("%s %d", kind, countdown), nil, nil)
+	return nil
+}
+
+// stopWar cancels the countdown of the great war, the other wars can not be
+// stopped once prepared.
+func (op *operation) stopWar(kind string) error {
+	if kind != database.WAR_GREAT {
+		return newError(CODE_NOT_FOUND, "war %s can not be stopped", kind)
+	}
+	if !database.StopWarTimer() {
+		return newError(CODE_CONFLICT, "war %s is not counting down", kind)
+	}
+	op.audit("stopwar", kind, nil, nil)
+	return nil
+}
+
+func (op *operation) reload(table string) error {
+	found := table == database.TABLES_ALL
+	for _, name := range database.TableNames() {
+		found = found || name == table
+	}
+	if !found {
+		return newError(CODE_NOT_FOUND, "table %s not found", table)
+	}
+
+	err := database.ReloadTable(table)
+	op.audit("refresh", table, nil, err)
+	return err
+}
+
+func writeError(w http.ResponseWriter, err error) {
+	var e *Error
+	if !errors.As(err, &e) {
+		log.Printf("admin api: %s", err)
+		e = newError(CODE_INTERNAL, "internal error")
+	}
+	writeJSON(w, statuses[e.Code], e)
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+// ListenAndServe serves the API on addr.
+func ListenAndServe(addr, apiKey string) error {
+	srv := &http.Server{
+		Addr:         addr,
+		Handler:      &Handler{APIKey: apiKey},
+		ReadTimeout:  10 * time.Second,
+		WriteTimeout: 30 * time.Second,
+	}
+	log.Printf("Admin API listening on %s", addr)
+	return srv.ListenAndServe()
+}
@@ -21,15 +21,14 @@ func Init() error {
 	for s := 0; s <= database.SERVER_COUNT; s++ {
 		database.DungeonsAiByMap[s] = make(map[int16][]*database.AI)
 	}
-	func() {
+	err := func() error {
 		<-database.InitRegisters
 
 		var err error
 
 		err = database.GetAllNPCPos()
 		if err != nil {
-			log.Println(err)
-			return
+			return err
 		}
 
 		for _, pos := range database.GetNPCPostions() {
@@ -40,8 +39,7 @@ func Init() error {
 
 		err = database.GetAllAI()
 		if err != nil {
-			log.Println(err)
-			return
+			return err
 		}
 
 		for _, ai := range database.AIs {
@@ -98,8 +96,9 @@ func Init() error {
 			}
 			//log.Println(fmt.Sprintf("AI: %d", AI.ID))
 		}
+		return nil
 	}()
-	return nil
+	return err
 }
 
 func InitBabyPets() {
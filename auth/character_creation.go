@@ -5,6 +5,7 @@ import (
 	"sync"
 
 	"github.com/twodragon/kore-server/database"
+	"github.com/twodragon/kore-server/logging"
 	"github.com/twodragon/kore-server/messaging"
 	"github.com/twodragon/kore-server/nats"
 	"github.com/twodragon/kore-server/utils"
@@ -82,6 +83,10 @@ func (cch *CharacterCreationHandler) Handle(s *database.Socket, data []byte) ([]
 
 func (cch *CharacterCreationHandler) createCharacter(s *database.Socket) ([]byte, error) {
 
+	if resp, err := cch.restoreCharacter(s); resp != nil || err != nil {
+		return resp, err
+	}
+
 	ok, err := database.IsValidUsername(cch.name)
 	if err != nil {
 		return nil, err
@@ -181,15 +186,7 @@ func (cch *CharacterCreationHandler) createCharacter(s *database.Socket) ([]byte
 		return nil, err
 	}
 
-	resp := CHARACTER_CREATED
-	length := int16(len(cch.name)) + 10
-	resp.SetLength(length)
-
-	resp.Insert(utils.IntToBytes(uint64(character.ID), 4, true), 9) // character id
-
-	resp[13] = byte(len(cch.name)) // character name length
-
-	resp.Insert([]byte(cch.name), 14) // character name
+	resp := characterCreated(character)
 
 	lch := &ListCharactersHandler{}
 	data, err := lch.showCharacterMenu(s)
@@ -213,3 +210,51 @@ func (cch *CharacterCreationHandler) createCharacter(s *database.Socket) ([]byte
 	p.Cast()
 	return resp, nil
 }
+
+// restoreCharacter restores the character of the account pending deletion
+// with the name of the new one, creating a character with its name being the
+// way back from the character menu of the stock client. It returns nil if
+// the account has no such character.
+func (cch *CharacterCreationHandler) restoreCharacter(s *database.Socket) ([]byte, error) {
+
+	characters, err := database.FindCharactersByUserID(s.User.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, character := range characters {
+		if !character.PendingDeletion() || character.Name != cch.name {
+			continue
+		}
+		if !s.PINFresh() {
+			return pinNeeded(), nil
+		}
+		if err := character.Restore(); err != nil {
+			return messaging.InfoMessage(err.Error()), nil
+		}
+		logger.Log(logging.ACTION_DELETE_CHARACTER, character.ID, "Character restored from the character menu", s.User.ID)
+
+		resp := characterCreated(character)
+		lch := &ListCharactersHandler{}
+		data, err := lch.showCharacterMenu(s)
+		if err != nil {
+			return nil, err
+		}
+		resp.Concat(data)
+		return resp, nil
+	}
+	return nil, nil
+}
+
+func characterCreated(character *database.Character) utils.Packet {
+	resp := CHARACTER_CREATED
+	length := int16(len(character.Name)) + 10
+	resp.SetLength(length)
+
+	resp.Insert(utils.IntToBytes(uint64(character.ID), 4, true), 9) // character id
+
+	resp[13] = byte(len(character.Name)) // character name length
+
+	resp.Insert([]byte(character.Name), 14) // character name
+	return resp
+}
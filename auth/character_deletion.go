@@ -4,6 +4,7 @@ import (
 	"sort"
 	"sync"
 
+	"github.com/twodragon/kore-server/config"
 	"github.com/twodragon/kore-server/database"
 	"github.com/twodragon/kore-server/logging"
 	"github.com/twodragon/kore-server/utils"
@@ -28,6 +29,10 @@ func (cdh *CharacterDeletionHandler) Handle(s *database.Socket, data []byte) ([]
 }
 
 func (cdh *CharacterDeletionHandler) deleteCharacter(s *database.Socket) ([]byte, error) {
+	if !s.PINFresh() {
+		return pinNeeded(), nil
+	}
+
 	DeleteCharMutex.Lock()
 	defer DeleteCharMutex.Unlock()
 
@@ -35,6 +40,10 @@ func (cdh *CharacterDeletionHandler) deleteCharacter(s *database.Socket) ([]byte
 	if err != nil {
 		return nil, err
 	}
+	chars = database.WithoutPendingDeletion(chars)
+	if cdh.index >= len(chars) {
+		return nil, nil
+	}
 
 	sort.Slice(chars, func(i, j int) bool {
 		return chars[i].ID < chars[j].ID
@@ -43,55 +52,14 @@ func (cdh *CharacterDeletionHandler) deleteCharacter(s *database.Socket) ([]byte
 	resp := CHARACTER_DELETED
 	if character := chars[cdh.index]; character.Name == cdh.name {
 
-		if err = character.Delete(); err != nil {
-			return nil, err
-		}
-
-		if character.GuildID > 0 {
-			guild, err := database.FindGuildByID(character.GuildID)
-			if err != nil {
-				return nil, err
-			}
-
-			if guild != nil {
-				if guild.LeaderID == character.ID {
-					guild.Delete()
-				} else {
-					guild.RemoveMember(character.ID)
-					go guild.Update()
-				}
-			}
-		}
-
-		consItems, err := database.FindConsignmentItemsBySellerID(character.ID)
-		if err != nil {
-			return nil, err
-		}
-
-		for _, item := range consItems {
-			err = item.Delete()
-			if err != nil {
-				return nil, err
-			}
-			database.RemoveConsignmentData(item.ID)
+		if config.Default.Game.CharacterRestoreDays > 0 {
+			err = character.SoftDelete()
+		} else {
+			err = character.Purge()
 		}
-
-		stat, err := database.FindStatByID(character.ID)
-		if err != nil {
-			return nil, err
-		}
-		if stat != nil { //
-			err = stat.Delete()
-			if err != nil {
-				return nil, err
-			}
-		}
-
-		skills, err := database.FindSkillsByID(character.ID)
 		if err != nil {
 			return nil, err
 		}
-		skills.Delete()
 
 		length := int16(len(cdh.name)) + 6
 		resp.SetLength(length)
@@ -3,6 +3,7 @@ package auth
 import (
 	"encoding/binary"
 	"fmt"
+	"log"
 	"sort"
 
 	"github.com/twodragon/kore-server/database"
@@ -34,30 +35,33 @@ func (lch *ListCharactersHandler) Handle(s *database.Socket, data []byte) ([]byt
 
 func (lch *ListCharactersHandler) listCharacters(s *database.Socket) ([]byte, error) {
 
-	user := findUser(lch.username)
-	if user == nil || user.ConnectingIP == "" {
+	ticket, err := s.RedeemTicket(lch.username)
+	if err != nil {
+		log.Printf("IP %s refused for %s: %s", s.ClientAddr, lch.username, err)
 		return nil, nil
 	}
 
-	s.User = user
-	s.ClientAddr = s.User.ConnectingIP
 	s.User.ConnectedIP = s.ClientAddr
-	s.User.ConnectedServer = s.User.ConnectingTo
-	s.User.ConnectingTo = 0
-	s.User.ConnectingIP = ""
+	s.User.ConnectedServer = ticket.Server
 	s.Add(s.User.ID)
 	database.FindCharactersByUserID(s.User.ID)
 	go s.User.Update()
-	return lch.showCharacterMenu(s)
+
+	resp, err := lch.showCharacterMenu(s)
+	if err == nil && database.PINAtMenu() && s.User.HasPIN() {
+		resp = append(resp, pinResult(PIN_REQUIRED)...)
+	}
+	return resp, err
 }
 
 func (lch *ListCharactersHandler) showCharacterMenu(s *database.Socket) ([]byte, error) {
 
-	characters, err := database.FindCharactersByUserID(s.User.ID)
+	all, err := database.FindCharactersByUserID(s.User.ID)
 	if err != nil {
 		return nil, err
 	}
 
+	characters := database.WithoutPendingDeletion(all) // deleted ones come back, see restoreCharacter
 	if len(characters) == 0 {
 		return NO_CHARACTERS, nil
 	}
@@ -154,15 +158,3 @@ func (lch *ListCharactersHandler) showCharacterMenu(s *database.Socket) ([]byte,
 
 	return resp, nil
 }
-
-func findUser(username string) *database.User {
-
-	all := database.AllUsers()
-	for _, u := range all {
-		if u.Username == username {
-			return u
-		}
-	}
-
-	return nil
-}
@@ -2,9 +2,11 @@ package auth
 
 import (
 	"encoding/json"
+	"fmt"
 	"log"
 
 	"github.com/twodragon/kore-server/database"
+	"github.com/twodragon/kore-server/messaging"
 	"github.com/twodragon/kore-server/nats"
 	"github.com/twodragon/kore-server/utils"
 
@@ -37,16 +39,23 @@ func (csh *CharacterSelectionHandler) selectCharacter(s *database.Socket) ([]byt
 	if s.User == nil {
 		return nil, nil
 	}
-	if character.UserID != s.User.ID {
+	if character.UserID != s.User.ID || character.PendingDeletion() {
 		return nil, nil
 	}
-	if s.User.ConnectedIP == "" {
+	if s.GameServer() == 0 { // no ticket redeemed by this socket
 		return nil, nil
 	}
+	if !s.PINUnlocked() {
+		return pinResult(PIN_REQUIRED), nil
+	}
+	if ban := database.ActiveSanction(database.SANCTION_BAN, "", character.ID, ""); ban != nil {
+		return messaging.InfoMessage(fmt.Sprintf("This character is banned until %s.", ban.Until())), nil
+	}
 
 	character.IsOnline = false
 	character.Socket = s
 	s.Character = character
+	s.RecordCharacter(character.ID)
 	err = database.GenerateIDforCharacter(s.Character)
 	if err != nil {
 		return nil, err
@@ -153,8 +162,7 @@ func HoustonHandler(s *database.Socket, msg *NATS.Msg) error {
 	}
 
 	if (!packet.CastNear) || (packet.CastNear && ok) {
-		_, err = s.Conn.Write(resp)
-		return err
+		return s.Write(resp)
 	}
 
 	return nil
@@ -0,0 +1,102 @@
+package auth
+
+import (
+	"fmt"
+	"log"
+	"net"
+	"strings"
+
+	"github.com/thoas/go-funk"
+	"github.com/twodragon/kore-server/config"
+	"github.com/twodragon/kore-server/database"
+	"github.com/twodragon/kore-server/geoip"
+	"github.com/twodragon/kore-server/utils"
+)
+
+var ipInfo geoip.Provider // nil when no file is configured
+
+// InitGeoIP loads the files of the geoip section.
+func InitGeoIP() error {
+	cfg := config.Default.GeoIP
+
+	var chain geoip.Chain
+	for _, path := range []string{cfg.DatabaseFile, cfg.ProxyFile} {
+		if path == "" {
+			continue
+		}
+		p, err := geoip.Open(path)
+		if err != nil {
+			return err
+		}
+		chain = append(chain, p)
+		log.Printf("GeoIP screening with %s", path)
+	}
+	if len(chain) > 0 {
+		ipInfo = chain
+	}
+	return nil
+}
+
+// lookupIP returns what the files know about ip, nil for local addresses.
+func lookupIP(ip string) *geoip.Info {
+	if ipInfo == nil || localIP(ip) {
+		return nil
+	}
+	info, err := ipInfo.Lookup(net.ParseIP(ip))
+	if err != nil {
+		log.Printf("GeoIP lookup of %s: %s", ip, err)
+	}
+	return info
+}
+
+func localIP(ip string) bool {
+	addr := net.ParseIP(ip)
+	return addr == nil || addr.IsLoopback() || addr.IsPrivate()
+}
+
+// screenIP tells why user can not log in from info, an empty reason letting
+// them in. Proxies are flagged in logs/geoip.txt when they are not blocked.
+func screenIP(user *database.User, ip string, info *geoip.Info) string {
+	cfg := config.Default.GeoIP
+	if ipInfo == nil || (cfg.ExemptGMs && funk.Contains(database.Tuned().GMRanks, int16(user.UserType))) {
+		return ""
+	}
+
+	country := ""
+	if info != nil {
+		country = info.Country
+	}
+	listed := func(list []string) bool {
+		for _, code := range list {
+			if strings.EqualFold(code, country) {
+				return true
+			}
+		}
+		return false
+	}
+
+	switch {
+	case country != "" && listed(cfg.DenyCountries),
+		country != "" && len(cfg.AllowCountries) > 0 && !listed(cfg.AllowCountries):
+		return logScreening(user, ip, "refused", fmt.Sprintf("logins from %s are not allowed", country))
+	case country == "" && len(cfg.AllowCountries) > 0 && !cfg.AllowUnknown && !localIP(ip):
+		return logScreening(user, ip, "refused", "logins from your country are not allowed")
+	}
+
+	if info != nil && info.Proxy {
+		switch cfg.Proxies {
+		case "block":
+			return logScreening(user, ip, "refused", "logins through proxies and VPNs are not allowed")
+		case "flag":
+			logScreening(user, ip, "flagged", "proxy "+info.ProxyType)
+		}
+	}
+	return ""
+}
+
+func logScreening(user *database.User, ip, verdict, reason string) string {
+	text := fmt.Sprintf("Login of %s(%s) from %s %s: %s", user.Username, user.ID, ip, verdict, reason)
+	log.Print(text)
+	utils.NewLog("logs/geoip.txt", text)
+	return reason
+}
@@ -0,0 +1,88 @@
+package auth
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/twodragon/kore-server/codec"
+)
+
+// truncations are the packet cut inside its body, with and without a
+// trailer. Those shorter than a header and a trailer are bad frames, the
+// others short packets.
+func truncations(packet []byte) [][]byte {
+	var cuts [][]byte
+	for end := codec.HEADER_SIZE; end < len(packet)-codec.TRAILER_SIZE; end++ {
+		cut := append([]byte{}, packet[:end]...)
+		cuts = append(cuts, cut, append(cut[:end:end], 0x55, 0xAA))
+	}
+	return cuts
+}
+
+func short(err error) bool {
+	return errors.Is(err, codec.ErrShortPacket) || errors.Is(err, codec.ErrBadFrame)
+}
+
+func TestLoginRequest(t *testing.T) {
+	password := make([]byte, 35)
+	copy(password, "secret")
+	packet := LOGIN_REQUEST.MustEncode(codec.Values{"username": "player", "password": password})
+
+	req, err := LOGIN_REQUEST.Decode(packet)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if req.String("username") != "player" || string(req.Bytes("password")) != string(password) {
+		t.Errorf("got %q %q", req.String("username"), req.Bytes("password"))
+	}
+	if _, err := LOGIN_FINGERPRINT.Decode(packet); !errors.Is(err, codec.ErrShortPacket) {
+		t.Errorf("fingerprint of a stock login: err %v, want %v", err, codec.ErrShortPacket)
+	}
+
+	for _, cut := range truncations(packet) {
+		if _, err := LOGIN_REQUEST.Decode(cut); !short(err) {
+			t.Errorf("% X: err %v, want a short packet", cut, err)
+		}
+	}
+}
+
+func TestLoginFingerprint(t *testing.T) {
+	packet := LOGIN_FINGERPRINT.MustEncode(codec.Values{"username": "player", "fingerprint": "abc123"})
+
+	req, err := LOGIN_FINGERPRINT.Decode(packet)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if req.String("fingerprint") != "abc123" {
+		t.Errorf("fingerprint %q", req.String("fingerprint"))
+	}
+	if req, err := LOGIN_REQUEST.Decode(packet); err != nil || req.String("username") != "player" {
+		t.Errorf("login with a fingerprint: %v", err)
+	}
+}
+
+func TestSelectServerRequest(t *testing.T) {
+	packet := SELECT_SERVER_REQUEST.MustEncode(codec.Values{"server": 3})
+	if want := "AA 55 05 00 00 04 00 00 03 55 AA"; fmt.Sprintf("% X", packet) != want {
+		t.Errorf("encoded %s, want %s", fmt.Sprintf("% X", packet), want)
+	}
+
+	req, err := SELECT_SERVER_REQUEST.Decode(packet)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if req.Uint("server") != 3 {
+		t.Errorf("server %d", req.Uint("server"))
+	}
+	for _, cut := range truncations(packet) {
+		if _, err := SELECT_SERVER_REQUEST.Decode(cut); !short(err) {
+			t.Errorf("% X: err %v, want a short packet", cut, err)
+		}
+	}
+
+	packet[5] = 0x05 // another opcode
+	if _, err := SELECT_SERVER_REQUEST.Decode(packet); !errors.Is(err, codec.ErrBadConst) {
+		t.Errorf("opcode 5: err %v, want %v", err, codec.ErrBadConst)
+	}
+}
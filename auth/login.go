@@ -1,14 +1,15 @@
 package auth
 
 import (
-	"crypto/sha256"
 	"fmt"
 	"log"
-	"strings"
 	"time"
 
+	"github.com/twodragon/kore-server/codec"
+	"github.com/twodragon/kore-server/config"
 	"github.com/twodragon/kore-server/database"
 	"github.com/twodragon/kore-server/logging"
+	"github.com/twodragon/kore-server/passwd"
 	"github.com/twodragon/kore-server/utils"
 )
 
@@ -22,25 +23,25 @@ var (
 	LOGGED_IN      = utils.Packet{0xaa, 0x55, 0x57, 0x00, 0x00, 0x01, 0x01, 0x40, 0x30, 0x42, 0x41, 0x45, 0x35, 0x32, 0x46, 0x45, 0x34, 0x32, 0x30, 0x44, 0x41, 0x35, 0x39, 0x32, 0x30, 0x41, 0x30, 0x33, 0x39, 0x46, 0x31, 0x41, 0x39, 0x30, 0x38, 0x34, 0x46, 0x31, 0x38, 0x38, 0x34, 0x41, 0x39, 0x36, 0x33, 0x44, 0x34, 0x30, 0x45, 0x38, 0x41, 0x39, 0x45, 0x44, 0x37, 0x35, 0x44, 0x35, 0x43, 0x41, 0x45, 0x43, 0x31, 0x46, 0x43, 0x44, 0x39, 0x45, 0x44, 0x33, 0x31, 0x38, 0x00, 0x00, 0xdb, 0x89, 0x2d, 0x06, 0x55, 0xaa}
 	USER_BANNED    = utils.Packet{0xAA, 0x55, 0x36, 0x00, 0x00, 0x01, 0x00, 0x32, 0x59, 0x6F, 0x75, 0x72, 0x20, 0x61, 0x63, 0x63, 0x6F, 0x75, 0x6E, 0x74, 0x20, 0x68, 0x61, 0x73, 0x20, 0x62, 0x65, 0x65, 0x6E, 0x20, 0x64, 0x69, 0x73, 0x61, 0x62, 0x6C, 0x65, 0x64, 0x20, 0x75, 0x6E, 0x74, 0x69, 0x6C, 0x20, 0x5B, 0x5D, 0x2E, 0x55, 0xAA}
 
-	logger = logging.Logger
+	LOGIN_REQUEST = codec.NewLayout("login", codec.Opcode(0), codec.Str("username", 1), codec.Pad(1), codec.Raw("password", 35))
+	// launchers may append a hardware fingerprint for the session policy
+	LOGIN_FINGERPRINT = codec.NewLayout("login", codec.Opcode(0), codec.Str("username", 1), codec.Pad(1), codec.Raw("password", 35), codec.Str("fingerprint", 1))
 
-	ip2locationFile = "./IP2LOCATION-LITE-DB1.BIN"
+	logger = logging.Logger
 )
 
 func (lh *LoginHandler) Handle(s *database.Socket, data []byte) ([]byte, error) {
 
-	index := 6 //9
-	uNameLen := int(utils.BytesToInt(data[index:index+1], false))
-	lh.username = string(data[index+1 : index+uNameLen+1])
-	passworde := string(data[index+uNameLen+2 : index+uNameLen+37])
-	salt := "188.132.128.35"
-	test := fmt.Sprintf("%s%s", passworde, salt) //salt test --
-	data2 := []byte(test)
-	hashx2 := sha256.Sum256(data2)
-	hasp2 := string(hashx2[:])
-	lh.password = fmt.Sprintf("%X", hasp2) //--
-
-	log.Printf("lh.username= %s lh.password=     %s", lh.username, lh.password)
+	req, err := LOGIN_REQUEST.Decode(data)
+	if err != nil {
+		return nil, err
+	}
+
+	lh.username = req.String("username")
+	lh.password = passwd.Normalize(req.String("password"))
+	if req, err := LOGIN_FINGERPRINT.Decode(data); err == nil {
+		s.Fingerprint = req.String("fingerprint")
+	}
 	return lh.login(s)
 }
 
@@ -48,6 +49,21 @@ func (lh *LoginHandler) login(s *database.Socket) ([]byte, error) {
 	var user *database.User
 	var err error
 
+	if database.IsDraining() { // shutting down
+		s.OnClose()
+		return nil, nil
+	}
+
+	ip := s.ClientIP()
+	if !checkip(ip) { // banned, the account it names is left alone
+		s.Conn.Close()
+		return nil, nil
+	}
+	if attempts.wait(userKey(lh.username), ipKey(ip)) > 0 { // backing off, not even checked
+		time.Sleep(time.Second / 2)
+		return USER_NOT_FOUND, nil
+	}
+
 	user, err = database.FindUserByName(lh.username)
 	if err != nil {
 		log.Print(err)
@@ -55,51 +71,66 @@ func (lh *LoginHandler) login(s *database.Socket) ([]byte, error) {
 
 	if user == nil || err != nil {
 		time.Sleep(time.Second / 2)
-		return USER_NOT_FOUND, nil
+		return lh.failed(nil, ip), nil
 	}
 
-	parts := strings.Split(s.Conn.RemoteAddr().String(), ":")
-	ip := parts[0]
+	if disabledUntil(user.DisabledUntil).After(time.Now()) { // locked
+		return bannedPacket(user.DisabledUntil, LOCK_REASON), nil
+	}
 
-	if !checkip(ip) {
-		s.Conn.Close()
-		user.Logout()
-		if sock := database.GetSocket(user.ID); sock != nil {
-			if c := sock.Character; c != nil {
-				c.Logout()
-			}
-			sock.Conn.Close()
-		}
+	ok, rehash, err := passwd.Verify(lh.password, user.Password)
+	if err != nil {
+		log.Printf("Password hash of user %s: %s", user.ID, err)
 	}
 
 	var resp utils.Packet
-	if strings.Compare(lh.password, user.Password) == 0 { // login succeeded
+	if ok { // login succeeded
 
-		if user.UserType == 0 { // Banned
-			resp = USER_BANNED
-			resp.Insert([]byte(user.DisabledUntil), 0x2E) // ban duration
-			return resp, nil
+		if ban := database.ActiveSanction(database.SANCTION_BAN, user.ID, 0, ip); ban != nil {
+			return bannedPacket(ban.Until(), ban.Reason), nil
 		}
+		info := lookupIP(ip)
+		if reason := screenIP(user, ip, info); reason != "" {
+			logger.Log(logging.ACTION_LOGIN, 0, "Login refused: "+reason, user.ID)
+			return loginRefused(reason), nil
+		}
+		s.IPInfo = info
+		attempts.reset(userKey(lh.username))
 
-		if user.ConnectedIP != "" { // user already online
-			logger.Log(logging.ACTION_LOGIN, 0, "Multiple login", user.ID)
-
-			if sock := database.GetSocket(user.ID); sock != nil {
-				if c := sock.Character; c != nil {
-					c.Logout()
-				}
-				sock.Conn.Close()
+		decision := database.DecideLogin(user, s)
+		if !decision.Allow {
+			logger.Log(logging.ACTION_LOGIN, 0, "Login refused: "+decision.Reason, user.ID)
+			return loginRefused(decision.Reason), nil
+		}
+		for _, sock := range decision.Kick {
+			logger.Log(logging.ACTION_LOGIN, 0, "Kicked by the login of "+user.Username+": "+decision.Reason, sock.User.ID)
+			if sock.User.ID == user.ID {
+				sock.SetCloseReason(database.SESSION_DUPLICATE)
+			} else {
+				sock.SetCloseReason(database.SESSION_LIMIT)
 			}
+			if c := sock.Character; c != nil {
+				c.Logout()
+			}
+			sock.Conn.Close()
 		}
 		logger.Log(logging.ACTION_LOGIN, 0, "Login successful", user.ID)
 		resp = LOGGED_IN
 		s.User = user
 		s.User.ConnectedIP = s.ClientAddr
+		s.OpenSession()
 		log.Print(s.ClientAddr)
 		length := int16(len(lh.username) + 75)
 		namelength := len(lh.username)
 
 		s.User.LastLogin = time.Now().Format("2006-01-02 15:04:05")
+		if rehash {
+			if hash, err := passwd.Hash(lh.password); err != nil {
+				log.Printf("Password rehash of user %s: %s", user.ID, err)
+			} else {
+				s.User.Password = hash
+			}
+		}
 
 		resp.SetLength(length)
 		resp.Insert([]byte(utils.IntToBytes(uint64(namelength), 1, false)), 7)
@@ -113,29 +144,48 @@ func (lh *LoginHandler) login(s *database.Socket) ([]byte, error) {
 	} else { // login failed
 		logger.Log(logging.ACTION_LOGIN, 0, "Login failed.", user.ID)
 		time.Sleep(time.Second / 2)
-		resp = USER_NOT_FOUND
+		resp = lh.failed(user, ip)
 	}
 
 	return resp, nil
 }
 
-func checkip(ip string) bool {
-	// return true
-	// database.GetBannedBannedRegions()
-	// all, err := db.GetAll(ip)
-
-	// if err != nil {
-	// 	fmt.Print(err)
-	// 	return false
-	// }
-	for _, bi := range database.BannedIps {
-		if bi.BannedIp == ip {
-			return false
+// failed counts a failed login, locking the account and banning the IP when
+// they had too many, and returns the answer for the client.
+func (lh *LoginHandler) failed(user *database.User, ip string) utils.Packet {
+	cfg := config.Default.Auth
+	lock := time.Duration(cfg.LockDuration) * time.Minute
+
+	n := attempts.fail(userKey(lh.username))
+	if m := attempts.fail(ipKey(ip)); cfg.IPLockThreshold > 0 && m == cfg.IPLockThreshold {
+		if _, err := database.BanIP(ip, LOCK_REASON, lock); err != nil {
+			log.Print(err)
+		} else {
+			text := fmt.Sprintf("IP %s banned for %s after %d failed logins", ip, lock, m)
+			logger.Log(logging.ACTION_LOGIN, 0, text, "")
+			utils.NewLog("logs/login_locks.txt", text)
 		}
 	}
-	//fmt.Printf("isProxy: %s\n", all["isProxy"])
-	//fmt.Printf("CountryShort: %s\n", all["CountryShort"])
-	//fmt.Printf("CountryLong: %s\n", all["CountryLong"])
 
-	return true
+	if user == nil || cfg.LockThreshold <= 0 || n < cfg.LockThreshold {
+		return USER_NOT_FOUND
+	}
+
+	until := time.Now().Add(lock)
+	if disabledUntil(user.DisabledUntil).Before(until) {
+		user.DisabledUntil = until.Format("2006-01-02 15:04:05")
+		if err := user.Update(); err != nil {
+			log.Print(err)
+		}
+	}
+	attempts.reset(userKey(lh.username))
+
+	text := fmt.Sprintf("Account %s locked until %s after %d failed logins, last from %s", user.Username, user.DisabledUntil, n, ip)
+	logger.Log(logging.ACTION_LOGIN, 0, text, user.ID)
+	utils.NewLog("logs/login_locks.txt", text)
+	return bannedPacket(user.DisabledUntil, LOCK_REASON)
+}
+
+func checkip(ip string) bool {
+	return !database.IsIPBanned(ip)
 }
@@ -0,0 +1,66 @@
+package auth
+
+import (
+	"fmt"
+
+	"github.com/twodragon/kore-server/codec"
+	"github.com/twodragon/kore-server/config"
+	"github.com/twodragon/kore-server/database"
+	"github.com/twodragon/kore-server/messaging"
+	"github.com/twodragon/kore-server/utils"
+)
+
+type PINHandler struct {
+	code string
+}
+
+const (
+	PIN_OK byte = iota
+	PIN_REQUIRED
+	PIN_WRONG
+	PIN_LOCKED
+)
+
+var (
+	PIN_REQUEST = codec.NewLayout("pin", codec.Opcode(262), codec.Str("code", 1))
+	PIN_RESULT  = codec.NewLayout("pin_result", codec.Opcode(262), codec.U8("result"))
+)
+
+// Handle checks the secondary PIN, or authenticator code, entered in the
+// character menu. Characters can not be selected before it succeeded. The
+// stock client has no PIN entry and never sends PIN_REQUEST, the opcode is a
+// requirement of the clients and launchers run with auth.pin_client, it is
+// ignored without.
+func (ph *PINHandler) Handle(s *database.Socket, data []byte) ([]byte, error) {
+	if !database.PINAtMenu() {
+		return nil, nil
+	}
+
+	req, err := PIN_REQUEST.Decode(data)
+	if err != nil {
+		return nil, err
+	}
+
+	ph.code = req.String("code")
+	switch s.VerifyPIN(ph.code) {
+	case nil:
+		return pinResult(PIN_OK), nil
+	case database.ErrPINLocked:
+		return pinResult(PIN_LOCKED), nil
+	}
+	return pinResult(PIN_WRONG), nil
+}
+
+// pinNeeded refuses an action of the character menu until the PIN is entered,
+// in the menu with auth.pin_client, in game with /pin otherwise.
+func pinNeeded() []byte {
+	if database.PINAtMenu() {
+		return pinResult(PIN_REQUIRED)
+	}
+	grace := config.Default.Auth.PINGrace
+	return messaging.InfoMessage(fmt.Sprintf("Enter your PIN in game with /pin <PIN>, then come back to the character menu within %d seconds.", grace))
+}
+
+func pinResult(result byte) utils.Packet {
+	return PIN_RESULT.MustEncode(codec.Values{"result": result})
+}
@@ -3,10 +3,10 @@ package auth
 import (
 	"fmt"
 
+	"github.com/twodragon/kore-server/codec"
 	"github.com/twodragon/kore-server/config"
 	"github.com/twodragon/kore-server/database"
 	"github.com/twodragon/kore-server/logging"
-	"github.com/twodragon/kore-server/utils"
 )
 
 type SelectServerHandler struct {
@@ -15,35 +15,37 @@ type SelectServerHandler struct {
 }
 
 var (
-	SELECTED_SERVER = utils.Packet{0xAA, 0x55, 0x00, 0x00, 0x00, 0x05, 0x01, 0x00, 0x55, 0xAA}
+	SELECT_SERVER_REQUEST = codec.NewLayout("select_server", codec.Opcode(4), codec.Pad(2), codec.U8("server"))
+	SELECTED_SERVER       = codec.NewLayout("selected_server", codec.Opcode(5), codec.Const("ok", 0x01), codec.Str("ip", 1), codec.U32("port"))
 )
 
 func (ssh *SelectServerHandler) Handle(s *database.Socket, data []byte) ([]byte, error) {
 
+	req, err := SELECT_SERVER_REQUEST.Decode(data)
+	if err != nil {
+		return nil, err
+	}
+
 	ssh.ip = (*s).ClientAddr
-	ssh.server = int(data[8]) + 1
+	ssh.server = int(req.Uint("server")) + 1
 	return ssh.selectServer(s)
 }
 
 func (ssh *SelectServerHandler) selectServer(s *database.Socket) ([]byte, error) {
 
-	resp := SELECTED_SERVER
-
-	serverIP := config.Default.Server.IP
-	length := int16(len(serverIP) + 8)
-	resp.SetLength(length)
-
-	resp[7] = byte(len(serverIP)) // server ip length
-	resp.Insert([]byte(serverIP), 8)
-
-	index := len(serverIP) + 8
-	port := config.Default.Server.Port
-	resp.Insert(utils.IntToBytes(uint64(port), 4, true), index)
+	resp, err := SELECTED_SERVER.Encode(codec.Values{
+		"ip":   config.Default.Server.IP,
+		"port": config.Default.Server.Port,
+	})
+	if err != nil {
+		return nil, err
+	}
 
 	logger.Log(logging.ACTION_SELECT_SERVER, 0, fmt.Sprintf("Server selected: %d", ssh.server), s.User.ID)
 
-	s.User.ConnectingTo = ssh.server
-	s.User.ConnectingIP = s.ClientAddr
+	if err := s.IssueTicket(ssh.server); err != nil {
+		return nil, err
+	}
 	s.User.SelectedServerID = ssh.server
 
 	return resp, nil
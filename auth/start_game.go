@@ -4,7 +4,6 @@ import (
 	"encoding/binary"
 	"fmt"
 	"log"
-	"strings"
 	"sync"
 	"time"
 
@@ -136,6 +135,14 @@ func (csh *StartGameHandler) startGame(s *database.Socket) ([]byte, error) {
 			s.Character.Coordinate = database.ConvertPointToCoordinate(324, 189)
 		}
 	}
+	if jail := s.Sanction(database.SANCTION_JAIL); jail != nil && s.Character.Map != jail.MapID {
+		s.Character.Map = jail.MapID
+		if save := database.SavePoints[int(jail.MapID)]; save != nil {
+			s.Character.Coordinate = database.ConvertPointToCoordinate(save.X, save.Y)
+		} else {
+			s.Character.Coordinate = database.ConvertPointToCoordinate(100, 100)
+		}
+	}
 	s.Character.Socket.Stats.CalculateHonorIDs()
 	s.Character.IsDungeon = false
 	s.Character.PartyMode = 33
@@ -170,8 +177,7 @@ func (csh *StartGameHandler) startGame(s *database.Socket) ([]byte, error) {
 	if s.Character.Injury > database.MAX_INJURY {
 		s.Character.Injury = database.MAX_INJURY
 	}
-	ip := s.Conn.RemoteAddr().String()
-	ip = strings.Split(ip, ":")[0]
+	ip := s.ClientIP()
 	heartbeat := database.GetHeartBeatsByIp(ip)
 	if heartbeat == nil {
 		heartbeat = &database.HeartBeat{Ip: ip, Count: 0, Last: time.Now()}
@@ -667,7 +673,7 @@ func exploreMobs(s *database.Socket) {
 					resp.Insert(utils.FloatToBytes(coordinate.Y, 4, true), 67)      // coordinate-y
 					resp.Overwrite(utils.IntToBytes(uint64(database.WarStones[int(mob.PseudoID)].ConquereValue), 1, false), 37)
 					resp.Overwrite([]byte{0xc8}, 45)
-					s.Conn.Write(resp)
+					s.Write(resp)
 					continue
 				}
 			}
@@ -0,0 +1,159 @@
+package auth
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/twodragon/kore-server/config"
+	"github.com/twodragon/kore-server/utils"
+)
+
+const (
+	LOCK_REASON = "too many failed logins"
+)
+
+var (
+	attempts = &attemptTracker{keys: make(map[string]*attemptKey)}
+)
+
+// attemptTracker counts the failed logins per username and per source IP
+// over a sliding window. Each failure past the free ones doubles the time
+// the key has to wait before its next attempt is even checked.
+type attemptTracker struct {
+	mutex sync.Mutex
+	keys  map[string]*attemptKey
+}
+
+type attemptKey struct {
+	failures []time.Time
+	next     time.Time // no attempt is checked before
+}
+
+func init() {
+	go func() {
+		for range time.Tick(time.Minute) {
+			attempts.prune()
+		}
+	}()
+}
+
+func userKey(username string) string {
+	return "user:" + strings.ToLower(username)
+}
+
+func ipKey(ip string) string {
+	return "ip:" + ip
+}
+
+// wait returns how long the keys still have to wait before an attempt.
+func (t *attemptTracker) wait(keys ...string) time.Duration {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	var wait time.Duration
+	now := time.Now()
+	for _, key := range keys {
+		if k, ok := t.keys[key]; ok && k.next.After(now) && k.next.Sub(now) > wait {
+			wait = k.next.Sub(now)
+		}
+	}
+	return wait
+}
+
+// fail records a failure for key and returns the failures in the window.
+func (t *attemptTracker) fail(key string) int {
+	cfg := config.Default.Auth
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	now := time.Now()
+	k, ok := t.keys[key]
+	if !ok {
+		k = &attemptKey{}
+		t.keys[key] = k
+	}
+	k.failures = append(recentFailures(k.failures, now), now)
+
+	if over := len(k.failures) - cfg.LoginFreeAttempts; over > 0 {
+		backoff := time.Duration(cfg.LoginBackoffMax) * time.Second
+		if over < 32 {
+			if d := time.Duration(cfg.LoginBackoffBase) * time.Second << (over - 1); d < backoff {
+				backoff = d
+			}
+		}
+		k.next = now.Add(backoff)
+	}
+	return len(k.failures)
+}
+
+func (t *attemptTracker) reset(key string) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	delete(t.keys, key)
+}
+
+func (t *attemptTracker) prune() {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	now := time.Now()
+	for key, k := range t.keys {
+		k.failures = recentFailures(k.failures, now)
+		if len(k.failures) == 0 && now.After(k.next) {
+			delete(t.keys, key)
+		}
+	}
+}
+
+func recentFailures(failures []time.Time, now time.Time) []time.Time {
+	window := time.Duration(config.Default.Auth.LoginWindow) * time.Second
+	recent := failures[:0]
+	for _, f := range failures {
+		if now.Sub(f) < window {
+			recent = append(recent, f)
+		}
+	}
+	return recent
+}
+
+// disabledUntil parses User.DisabledUntil, the zero time if it is not set.
+func disabledUntil(date string) time.Time {
+	t, err := time.ParseInLocation("2006-01-02 15:04:05", date, time.Local)
+	if err != nil {
+		return time.Time{}
+	}
+	return t
+}
+
+// loginRefused is USER_NOT_FOUND with text in place of its message.
+func loginRefused(text string) utils.Packet {
+	if len(text) > 200 {
+		text = text[:200]
+	}
+
+	resp := utils.Packet{0xAA, 0x55, 0x00, 0x00, 0x00, 0x01, 0x00, byte(len(text))}
+	resp = append(resp, text...)
+	resp = append(resp, 0x55, 0xAA)
+	resp.SetLength(int16(len(resp) - 6))
+	return resp
+}
+
+// bannedPacket is USER_BANNED with the date and, if any, the reason in the
+// brackets of "Your account has been disabled until []."
+func bannedPacket(until, reason string) utils.Packet {
+	text := until
+	if reason != "" {
+		text = fmt.Sprintf("%s, %s", until, reason)
+	}
+	if len(text) > 200 {
+		text = text[:200]
+	}
+
+	resp := USER_BANNED
+	resp.Insert([]byte(text), 0x2E)
+	resp[7] += byte(len(text))
+	resp.SetLength(int16(len(resp) - 6))
+	return resp
+}
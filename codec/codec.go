@@ -0,0 +1,250 @@
+package codec
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+
+	"github.com/twodragon/kore-server/utils"
+)
+
+// Layouts describe everything between the length field and the trailer of a
+// packet: AA 55 <length:2> <fields...> 55 AA. The opcode is the first field,
+// usually a Const, so Decode can also tell a packet apart from another one.
+
+type Kind byte
+
+const (
+	KindUint   Kind = iota
+	KindInt         // signed integer
+	KindFloat       // IEEE 754, width 4 or 8
+	KindString      // length prefixed, Width is the width of the prefix
+	KindText        // fixed width, zero padded
+	KindBytes       // fixed width raw bytes
+	KindConst       // fixed bytes, checked on decode
+	KindPad         // skipped on decode, zeroed on encode
+)
+
+type Order byte
+
+const (
+	LittleEndian Order = iota
+	BigEndian
+)
+
+const (
+	HEADER_SIZE  = 4 // AA 55 + length
+	TRAILER_SIZE = 2 // 55 AA
+)
+
+var (
+	ErrShortPacket = errors.New("short packet")
+	ErrBadFrame    = errors.New("bad frame")
+	ErrBadConst    = errors.New("unexpected constant")
+	ErrBadValue    = errors.New("bad value")
+)
+
+type Field struct {
+	Name  string
+	Kind  Kind
+	Width int
+	Order Order
+	Value []byte // KindConst only
+}
+
+type Layout struct {
+	Name   string
+	Fields []Field
+}
+
+func NewLayout(name string, fields ...Field) *Layout {
+	return &Layout{Name: name, Fields: fields}
+}
+
+func Const(name string, value ...byte) Field {
+	return Field{Name: name, Kind: KindConst, Width: len(value), Value: value}
+}
+
+func Opcode(op uint16) Field {
+	return Const("opcode", byte(op>>8), byte(op))
+}
+
+func U8(name string) Field    { return Field{Name: name, Kind: KindUint, Width: 1} }
+func U16(name string) Field   { return Field{Name: name, Kind: KindUint, Width: 2} }
+func U32(name string) Field   { return Field{Name: name, Kind: KindUint, Width: 4} }
+func U64(name string) Field   { return Field{Name: name, Kind: KindUint, Width: 8} }
+func U16BE(name string) Field { return Field{Name: name, Kind: KindUint, Width: 2, Order: BigEndian} }
+func I32(name string) Field   { return Field{Name: name, Kind: KindInt, Width: 4} }
+func F32(name string) Field   { return Field{Name: name, Kind: KindFloat, Width: 4} }
+
+func Str(name string, prefix int) Field {
+	return Field{Name: name, Kind: KindString, Width: prefix}
+}
+
+func Text(name string, width int) Field {
+	return Field{Name: name, Kind: KindText, Width: width}
+}
+
+func Raw(name string, width int) Field {
+	return Field{Name: name, Kind: KindBytes, Width: width}
+}
+
+func Pad(width int) Field {
+	return Field{Kind: KindPad, Width: width}
+}
+
+// Decode reads the fields of a full frame. It never indexes past the end of
+// the packet, a short or truncated packet returns ErrShortPacket.
+func (l *Layout) Decode(packet []byte) (Values, error) {
+	if len(packet) < HEADER_SIZE+TRAILER_SIZE || packet[0] != 0xAA || packet[1] != 0x55 {
+		return nil, l.errorf("", ErrBadFrame)
+	}
+
+	body := packet[HEADER_SIZE:]
+	if bytes.HasSuffix(body, []byte{0x55, 0xAA}) {
+		body = body[:len(body)-TRAILER_SIZE]
+	}
+
+	values := Values{}
+	index := 0
+	for _, f := range l.Fields {
+		width := f.Width
+		if index+width > len(body) {
+			return nil, l.errorf(f.Name, ErrShortPacket)
+		}
+		raw := body[index : index+width]
+		index += width
+
+		switch f.Kind {
+		case KindUint:
+			values[f.Name] = readUint(raw, f.Order)
+		case KindInt:
+			u := readUint(raw, f.Order)
+			shift := uint(64 - 8*width)
+			values[f.Name] = int64(u<<shift) >> shift
+		case KindFloat:
+			u := readUint(raw, f.Order)
+			if width == 8 {
+				values[f.Name] = math.Float64frombits(u)
+			} else {
+				values[f.Name] = float64(math.Float32frombits(uint32(u)))
+			}
+		case KindString:
+			n := int(readUint(raw, f.Order))
+			if index+n > len(body) {
+				return nil, l.errorf(f.Name, ErrShortPacket)
+			}
+			values[f.Name] = string(body[index : index+n])
+			index += n
+		case KindText:
+			values[f.Name] = string(bytes.TrimRight(raw, "\x00"))
+		case KindBytes:
+			values[f.Name] = append([]byte{}, raw...)
+		case KindConst:
+			if !bytes.Equal(raw, f.Value) {
+				return nil, l.errorf(f.Name, ErrBadConst)
+			}
+		}
+	}
+
+	return values, nil
+}
+
+// Encode builds a full frame, header, length and trailer included. Missing
+// values are encoded as zero.
+func (l *Layout) Encode(values Values) (utils.Packet, error) {
+	body := make([]byte, 0, 64)
+	for _, f := range l.Fields {
+		v := values[f.Name]
+
+		switch f.Kind {
+		case KindUint, KindInt:
+			u, ok := toUint(v)
+			if !ok {
+				return nil, l.errorf(f.Name, ErrBadValue)
+			}
+			body = appendUint(body, u, f.Width, f.Order)
+		case KindFloat:
+			fv, ok := toFloat(v)
+			if !ok {
+				return nil, l.errorf(f.Name, ErrBadValue)
+			}
+			if f.Width == 8 {
+				body = appendUint(body, math.Float64bits(fv), 8, f.Order)
+			} else {
+				body = appendUint(body, uint64(math.Float32bits(float32(fv))), f.Width, f.Order)
+			}
+		case KindString:
+			s, ok := toBytes(v)
+			if !ok || (f.Width < 8 && len(s) >= 1<<(8*f.Width)) {
+				return nil, l.errorf(f.Name, ErrBadValue)
+			}
+			body = appendUint(body, uint64(len(s)), f.Width, f.Order)
+			body = append(body, s...)
+		case KindText, KindBytes:
+			s, ok := toBytes(v)
+			if !ok || len(s) > f.Width {
+				return nil, l.errorf(f.Name, ErrBadValue)
+			}
+			body = append(body, s...)
+			body = append(body, make([]byte, f.Width-len(s))...)
+		case KindConst:
+			body = append(body, f.Value...)
+		case KindPad:
+			body = append(body, make([]byte, f.Width)...)
+		}
+	}
+
+	if len(body) > math.MaxUint16 {
+		return nil, l.errorf("", ErrBadValue)
+	}
+
+	resp := make(utils.Packet, 0, len(body)+HEADER_SIZE+TRAILER_SIZE)
+	resp = append(resp, 0xAA, 0x55, 0x00, 0x00)
+	resp = append(resp, body...)
+	resp = append(resp, 0x55, 0xAA)
+	binary.LittleEndian.PutUint16(resp[2:4], uint16(len(body)))
+	return resp, nil
+}
+
+// MustEncode is Encode for layouts whose values can not be wrong, such as
+// replies built from server side state only.
+func (l *Layout) MustEncode(values Values) utils.Packet {
+	resp, err := l.Encode(values)
+	if err != nil {
+		panic(err)
+	}
+	return resp
+}
+
+func (l *Layout) errorf(field string, err error) error {
+	if field == "" {
+		return fmt.Errorf("%s: %w", l.Name, err)
+	}
+	return fmt.Errorf("%s.%s: %w", l.Name, field, err)
+}
+
+func readUint(raw []byte, order Order) uint64 {
+	var u uint64
+	for i := range raw {
+		b := raw[i]
+		if order == LittleEndian {
+			b = raw[len(raw)-1-i]
+		}
+		u = u<<8 | uint64(b)
+	}
+	return u
+}
+
+func appendUint(body []byte, u uint64, width int, order Order) []byte {
+	for i := 0; i < width; i++ {
+		shift := uint(8 * i)
+		if order == BigEndian {
+			shift = uint(8 * (width - 1 - i))
+		}
+		body = append(body, byte(u>>shift))
+	}
+	return body
+}
@@ -0,0 +1,112 @@
+package codec
+
+import (
+	"bytes"
+	"errors"
+	"math"
+	"testing"
+)
+
+var every = NewLayout("every",
+	Opcode(0x0102),
+	U8("u8"), U16("u16"), U16BE("u16be"), U32("u32"), U64("u64"),
+	I32("i32"), F32("f32"), Field{Name: "f64", Kind: KindFloat, Width: 8},
+	Str("str", 1), Text("text", 8), Raw("raw", 3), Pad(2), Const("end", 0x7F))
+
+func TestRoundTrip(t *testing.T) {
+	in := Values{
+		"u8": 0xFE, "u16": 0xBEEF, "u16be": 0x1234, "u32": uint32(0xDEADBEEF), "u64": uint64(math.MaxUint64),
+		"i32": -5, "f32": 1.5, "f64": -2.25,
+		"str": "hello", "text": "abc", "raw": []byte{1, 2, 3},
+	}
+	packet, err := every.Encode(in)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.HasPrefix(packet, []byte{0xAA, 0x55}) || !bytes.HasSuffix(packet, []byte{0x55, 0xAA}) {
+		t.Fatalf("bad frame % X", packet)
+	}
+	if n := int(packet[2]) | int(packet[3])<<8; n != len(packet)-HEADER_SIZE-TRAILER_SIZE {
+		t.Fatalf("length %d, body is %d bytes", n, len(packet)-HEADER_SIZE-TRAILER_SIZE)
+	}
+	if !bytes.Equal(packet[4:6], []byte{0x01, 0x02}) || !bytes.Equal(packet[9:11], []byte{0x12, 0x34}) {
+		t.Errorf("opcode or big endian field misplaced: % X", packet)
+	}
+
+	out, err := every.Decode(packet)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, check := range []struct {
+		name      string
+		got, want interface{}
+	}{
+		{"u8", out.Uint("u8"), uint64(0xFE)},
+		{"u16", out.Uint("u16"), uint64(0xBEEF)},
+		{"u16be", out.Uint("u16be"), uint64(0x1234)},
+		{"u32", out.Uint("u32"), uint64(0xDEADBEEF)},
+		{"u64", out.Uint("u64"), uint64(math.MaxUint64)},
+		{"i32", out.Int("i32"), int64(-5)},
+		{"f32", out.Float("f32"), 1.5},
+		{"f64", out.Float("f64"), -2.25},
+		{"str", out.String("str"), "hello"},
+		{"text", out.String("text"), "abc"},
+		{"raw", string(out.Bytes("raw")), "\x01\x02\x03"},
+	} {
+		if check.got != check.want {
+			t.Errorf("%s = %v, want %v", check.name, check.got, check.want)
+		}
+	}
+}
+
+func TestDecodeShort(t *testing.T) {
+	packet := every.MustEncode(Values{"str": "hello"})
+	body := packet[HEADER_SIZE : len(packet)-TRAILER_SIZE]
+
+	for n := 0; n < len(body); n++ {
+		for _, trailer := range [][]byte{nil, {0x55, 0xAA}} {
+			cut := append(append(append([]byte{}, packet[:HEADER_SIZE]...), body[:n]...), trailer...)
+			if _, err := every.Decode(cut); !errors.Is(err, ErrShortPacket) && !errors.Is(err, ErrBadFrame) {
+				t.Errorf("%d body bytes, trailer %v: err %v, want a short packet", n, trailer != nil, err)
+			}
+		}
+	}
+}
+
+func TestDecodeBad(t *testing.T) {
+	good := every.MustEncode(nil)
+	wrongOpcode := append([]byte{}, good...)
+	wrongOpcode[5] = 0x03
+	longString := every.MustEncode(nil)
+	longString[HEADER_SIZE+2+1+2+2+4+8+4+4+8] = 200 // the str prefix, past the end
+
+	for _, test := range []struct {
+		name   string
+		packet []byte
+		err    error
+	}{
+		{"empty", nil, ErrBadFrame},
+		{"header only", good[:HEADER_SIZE], ErrBadFrame},
+		{"bad header", append([]byte{0x55, 0xAA}, good[2:]...), ErrBadFrame},
+		{"wrong opcode", wrongOpcode, ErrBadConst},
+		{"string past the end", longString, ErrShortPacket},
+	} {
+		if _, err := every.Decode(test.packet); !errors.Is(err, test.err) {
+			t.Errorf("%s: err %v, want %v", test.name, err, test.err)
+		}
+	}
+}
+
+func TestEncodeBad(t *testing.T) {
+	for name, v := range map[string]Values{
+		"string for an integer":  {"u8": "x"},
+		"integer for a string":   {"str": 5},
+		"string over its prefix": {"str": string(make([]byte, 256))},
+		"text over its width":    {"text": "123456789"},
+		"raw over its width":     {"raw": []byte{1, 2, 3, 4}},
+	} {
+		if _, err := every.Encode(v); !errors.Is(err, ErrBadValue) {
+			t.Errorf("%s: err %v, want %v", name, err, ErrBadValue)
+		}
+	}
+}
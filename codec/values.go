@@ -0,0 +1,86 @@
+package codec
+
+// Values holds decoded fields by name. Getters return the zero value for
+// missing fields, Decode only succeeds once every field was read.
+type Values map[string]interface{}
+
+func (v Values) Uint(name string) uint64 {
+	u, _ := toUint(v[name])
+	return u
+}
+
+func (v Values) Int(name string) int64 {
+	u, _ := toUint(v[name])
+	return int64(u)
+}
+
+func (v Values) Float(name string) float64 {
+	f, _ := toFloat(v[name])
+	return f
+}
+
+func (v Values) String(name string) string {
+	b, _ := toBytes(v[name])
+	return string(b)
+}
+
+func (v Values) Bytes(name string) []byte {
+	b, _ := toBytes(v[name])
+	return b
+}
+
+func toUint(v interface{}) (uint64, bool) {
+	switch n := v.(type) {
+	case nil:
+		return 0, true
+	case int:
+		return uint64(n), true
+	case int8:
+		return uint64(n), true
+	case int16:
+		return uint64(n), true
+	case int32:
+		return uint64(n), true
+	case int64:
+		return uint64(n), true
+	case uint:
+		return uint64(n), true
+	case uint8:
+		return uint64(n), true
+	case uint16:
+		return uint64(n), true
+	case uint32:
+		return uint64(n), true
+	case uint64:
+		return n, true
+	case bool:
+		if n {
+			return 1, true
+		}
+		return 0, true
+	}
+	return 0, false
+}
+
+func toFloat(v interface{}) (float64, bool) {
+	switch n := v.(type) {
+	case float32:
+		return float64(n), true
+	case float64:
+		return n, true
+	}
+	u, ok := toUint(v)
+	return float64(u), ok
+}
+
+func toBytes(v interface{}) ([]byte, bool) {
+	switch s := v.(type) {
+	case nil:
+		return nil, true
+	case string:
+		return []byte(s), true
+	case []byte:
+		return s, true
+	}
+	return nil, false
+}
@@ -1,27 +1,166 @@
 package config
 
 type config struct {
-	Database Database
-	Server   Server
+	Database   Database   `yaml:"database"`
+	Server     Server     `yaml:"server"`
+	Admission  Admission  `yaml:"admission"`
+	Sessions   Sessions   `yaml:"sessions"`
+	GeoIP      GeoIP      `yaml:"geoip"`
+	Nats       Nats       `yaml:"nats"`
+	Debug      Debug      `yaml:"debug"`
+	Auth       Auth       `yaml:"auth"`
+	Game       Game       `yaml:"game"`
+	Account    Account    `yaml:"account"`
+	Audit      Audit      `yaml:"audit"`
+	Admin      Admin      `yaml:"admin"`
+	Monitoring Monitoring `yaml:"monitoring"`
 }
 
 type Database struct {
-	Driver          string
-	IP              string
-	Port            int
-	User            string
-	Password        string `json:"-"`
-	Name            string
-	ConnMaxIdle     int
-	ConnMaxOpen     int
-	ConnMaxLifetime int
-	Debug           bool
-	SSLMode         string
+	Driver          string `yaml:"driver"`
+	IP              string `yaml:"ip"`
+	Port            int    `yaml:"port"`
+	User            string `yaml:"user"`
+	Password        string `yaml:"password" json:"-" secret:"true"`
+	Name            string `yaml:"name"`
+	ConnMaxIdle     int    `yaml:"conn_max_idle"`
+	ConnMaxOpen     int    `yaml:"conn_max_open"`
+	ConnMaxLifetime int    `yaml:"conn_max_lifetime"`
+	Debug           bool   `yaml:"debug"`
+	SSLMode         string `yaml:"ssl_mode"`
 }
 
 type Server struct {
-	IP   string
-	Port int
+	IP        string `yaml:"ip"`
+	Port      int    `yaml:"port"`
+	ServerIDs []int  `yaml:"server_ids"` // game servers run by this process, empty for all
+
+	InboundQueueSize   int    `yaml:"inbound_queue_size"`
+	InboundQueuePolicy string `yaml:"inbound_queue_policy"` // drop, kick or stall
+
+	OutboundQueueSize  int `yaml:"outbound_queue_size"` // packets
+	OutboundQueueBytes int `yaml:"outbound_queue_bytes"`
+	WriteTimeout       int `yaml:"write_timeout"` // seconds
+
+	ShutdownCountdown int `yaml:"shutdown_countdown"` // seconds
+
+	ProxyProtocol      bool     `yaml:"proxy_protocol"`       // accept PROXY v1/v2 headers
+	ProxyTrustedCIDRs  []string `yaml:"proxy_trusted_cidrs"`  // only from these networks
+	ProxyHeaderTimeout int      `yaml:"proxy_header_timeout"` // seconds
+}
+
+// Admission limits the connections before any packet handler runs.
+type Admission struct {
+	MaxConnections   int `yaml:"max_connections"` // 0 for no limit, same for the other limits
+	MaxPerIP         int `yaml:"max_per_ip"`
+	MaxPerSubnet     int `yaml:"max_per_subnet"`
+	SubnetV4         int `yaml:"subnet_v4"`         // prefix length
+	SubnetV6         int `yaml:"subnet_v6"`         // prefix length
+	HandshakeTimeout int `yaml:"handshake_timeout"` // seconds to log in
+	BanThreshold     int `yaml:"ban_threshold"`     // strikes in ban_window before a ban, 0 to never ban
+	BanWindow        int `yaml:"ban_window"`        // seconds
+	BanDuration      int `yaml:"ban_duration"`      // minutes
+}
+
+// Sessions is the policy for accounts online together, see
+// database.DecideLogin and database.DecideEventEntry.
+type Sessions struct {
+	MaxPerIP          int    `yaml:"max_per_ip"`          // accounts online from one IP, 0 for no limit
+	MaxPerFingerprint int    `yaml:"max_per_fingerprint"` // accounts online from one hardware fingerprint, 0 for no limit, advisory
+	OnDuplicate       string `yaml:"on_duplicate"`        // kick or refuse the old session of an account logging in again
+	OnLimit           string `yaml:"on_limit"`            // kick the oldest session or refuse the new one over a limit
+	ExemptGMs         bool   `yaml:"exempt_gms"`
+
+	EventMaxPerIP          int `yaml:"event_max_per_ip"` // characters in a war event from one IP, 0 for no limit
+	EventMaxPerFingerprint int `yaml:"event_max_per_fingerprint"`
+}
+
+// GeoIP screens logins by the country of their IP and proxies, looked up in
+// local files, see package geoip.
+type GeoIP struct {
+	DatabaseFile   string   `yaml:"database_file"`   // .mmdb or IP2Location .BIN with countries, empty for none
+	ProxyFile      string   `yaml:"proxy_file"`      // Anonymous IP .mmdb or IP2Proxy .BIN, empty for none
+	AllowCountries []string `yaml:"allow_countries"` // ISO codes, only these can log in if set
+	DenyCountries  []string `yaml:"deny_countries"`
+	AllowUnknown   bool     `yaml:"allow_unknown"` // IPs of no known country, with an allow list
+	Proxies        string   `yaml:"proxies"`       // allow, flag or block
+	ExemptGMs      bool     `yaml:"exempt_gms"`
+}
+
+type Nats struct {
+	Host string `yaml:"host"`
+	Port int    `yaml:"port"`
+}
+
+type Debug struct {
+	PprofAddress string `yaml:"pprof_address"` // empty to disable
+}
+
+type Auth struct {
+	LoginSalt string `yaml:"login_salt" json:"-" secret:"true"` // of the legacy sha256 hashes
+
+	PasswordScheme string `yaml:"password_scheme"` // argon2id or bcrypt, for new and upgraded hashes
+	Argon2Memory   int    `yaml:"argon2_memory"`   // KiB
+	Argon2Time     int    `yaml:"argon2_time"`
+	Argon2Threads  int    `yaml:"argon2_threads"`
+	BcryptCost     int    `yaml:"bcrypt_cost"`
+
+	LoginWindow       int `yaml:"login_window"`        // seconds failed logins are counted for
+	LoginFreeAttempts int `yaml:"login_free_attempts"` // failures before the backoff starts
+	LoginBackoffBase  int `yaml:"login_backoff_base"`  // seconds, doubled with every failure
+	LoginBackoffMax   int `yaml:"login_backoff_max"`   // seconds
+	LockThreshold     int `yaml:"lock_threshold"`      // failures of an account before it is locked, 0 to never lock
+	LockDuration      int `yaml:"lock_duration"`       // minutes
+	IPLockThreshold   int `yaml:"ip_lock_threshold"`   // failures from an IP before it is banned, 0 to never ban
+
+	PINMaxFailures  int    `yaml:"pin_max_failures"`                // before the PIN is locked, 0 to never lock
+	PINLockDuration int    `yaml:"pin_lock_duration"`               // minutes
+	PINGrace        int    `yaml:"pin_grace"`                       // seconds a PIN entry covers deletion, bank and trade
+	PINClient       bool   `yaml:"pin_client"`                      // the client sends the PIN in the character menu
+	TOTPKey         string `yaml:"totp_key" json:"-" secret:"true"` // encrypts the authenticator secrets, empty to disable them
+
+	TicketSecret string `yaml:"ticket_secret" json:"-" secret:"true"` // shared by the login and game servers
+	TicketTTL    int    `yaml:"ticket_ttl"`                           // seconds to connect to the game server
+}
+
+// Account is the account API the website backend calls, see package account.
+type Account struct {
+	Address           string `yaml:"address"` // local address, empty to disable
+	APIKey            string `yaml:"api_key" json:"-" secret:"true"`
+	VerifyTokenTTL    int    `yaml:"verify_token_ttl"` // hours
+	ResetTokenTTL     int    `yaml:"reset_token_ttl"`  // minutes
+	DeletionGrace     int    `yaml:"deletion_grace"`   // days before a deleted account is purged
+	MinPasswordLength int    `yaml:"min_password_length"`
+}
+
+// Audit is the admin audit of GM commands, see database.AuditEntry.
+type Audit struct {
+	Approval        []string `yaml:"approval"`         // commands a second GM has to approve, empty for none
+	ApprovalTimeout int      `yaml:"approval_timeout"` // minutes a request waits for approval
+}
+
+// Admin is the admin API for live server operations, see package admin.
+type Admin struct {
+	Address string `yaml:"address"` // local address, empty to disable
+	APIKey  string `yaml:"api_key" json:"-" secret:"true"`
+}
+
+// Monitoring is the listener of Prometheus and the orchestrator, see packages
+// metrics and health.
+type Monitoring struct {
+	Address string `yaml:"address"` // local address of /metrics, /healthz and /readyz, empty to disable
+}
+
+type Game struct {
+	DropRate        float64 `yaml:"drop_rate"` // the rates seed a new tuning file, it wins once it exists
+	ExpRate         float64 `yaml:"exp_rate"`
+	GoldRate        float64 `yaml:"gold_rate"`
+	MaxGuildMembers int     `yaml:"max_guild_members"`
+	RunningSpeed    float64 `yaml:"running_speed"`
+	TuningFile      string  `yaml:"tuning_file"` // hot reloaded, see database.Tuning
+
+	CharacterRestoreDays int `yaml:"character_restore_days"` // deleted characters can be restored, 0 to delete at once
+	CharacterSlots       int `yaml:"character_slots"`        // of the character menu of the client
 }
 
 var Default = &config{
@@ -42,5 +181,94 @@ var Default = &config{
 
 		IP:   "127.0.0.1",
 		Port: 4515,
+
+		InboundQueueSize:   64,
+		InboundQueuePolicy: "stall",
+
+		OutboundQueueSize:  512,
+		OutboundQueueBytes: 512 * 1024,
+		WriteTimeout:       10,
+
+		ShutdownCountdown: 30,
+
+		ProxyProtocol:      false,
+		ProxyTrustedCIDRs:  []string{"127.0.0.1/32"},
+		ProxyHeaderTimeout: 5,
+	},
+	Admission: Admission{
+		MaxConnections:   3000,
+		MaxPerIP:         10,
+		MaxPerSubnet:     50,
+		SubnetV4:         24,
+		SubnetV6:         64,
+		HandshakeTimeout: 15,
+		BanThreshold:     20,
+		BanWindow:        60,
+		BanDuration:      30,
+	},
+	Sessions: Sessions{
+		MaxPerIP:          0,
+		MaxPerFingerprint: 0,
+		OnDuplicate:       "kick",
+		OnLimit:           "refuse",
+		ExemptGMs:         true,
+
+		EventMaxPerIP:          1,
+		EventMaxPerFingerprint: 1,
+	},
+	GeoIP: GeoIP{
+		AllowUnknown: true,
+		Proxies:      "flag",
+		ExemptGMs:    true,
+	},
+	Nats: Nats{
+		Host: "127.0.0.1",
+		Port: 4330,
+	},
+	Debug: Debug{
+		PprofAddress: "localhost:8080",
+	},
+	Auth: Auth{
+		LoginSalt: "188.132.128.35",
+
+		PasswordScheme: "argon2id",
+		Argon2Memory:   64 * 1024,
+		Argon2Time:     3,
+		Argon2Threads:  2,
+		BcryptCost:     12,
+
+		LoginWindow:       15 * 60,
+		LoginFreeAttempts: 3,
+		LoginBackoffBase:  1,
+		LoginBackoffMax:   60,
+		LockThreshold:     10,
+		LockDuration:      15,
+		IPLockThreshold:   50,
+
+		PINMaxFailures:  5,
+		PINLockDuration: 30,
+		PINGrace:        300,
+
+		TicketTTL: 60,
+	},
+	Game: Game{
+		DropRate:        1.0,
+		ExpRate:         1.0,
+		GoldRate:        1.0,
+		MaxGuildMembers: 22,
+		RunningSpeed:    0.9,
+		TuningFile:      "tuning.yaml",
+
+		CharacterRestoreDays: 7,
+		CharacterSlots:       3,
+	},
+	Account: Account{
+		VerifyTokenTTL:    48,
+		ResetTokenTTL:     60,
+		DeletionGrace:     14,
+		MinPasswordLength: 8,
+	},
+	Audit: Audit{
+		ApprovalTimeout: 10,
 	},
 }
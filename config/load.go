@@ -0,0 +1,265 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	ENV_PREFIX = "KORE_"
+	REDACTED   = "******"
+
+	MIN_TICKET_SECRET = 32 // bytes of auth.ticket_secret
+)
+
+// Load reads the YAML file at path over the defaults, applies the KORE_*
+// environment overrides and validates the result. An empty path or a missing
+// file leaves the defaults in place. Every field can be overridden by an env
+// var named after its section and key, e.g. KORE_DATABASE_PASSWORD.
+func Load(path string) error {
+	if path != "" {
+		data, err := os.ReadFile(path)
+		if err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("config: %s", err)
+		}
+		if err == nil {
+			if err := yaml.Unmarshal(data, Default); err != nil {
+				return fmt.Errorf("config: %s: %s", path, err)
+			}
+		}
+	}
+
+	if err := applyEnv(Default, os.LookupEnv); err != nil {
+		return err
+	}
+
+	return Default.Validate()
+}
+
+func applyEnv(c *config, lookup func(string) (string, bool)) error {
+	return walk(c, func(section, key string, v reflect.Value, secret bool) error {
+		name := ENV_PREFIX + strings.ToUpper(section+"_"+key)
+		value, ok := lookup(name)
+		if !ok {
+			return nil
+		}
+
+		switch v.Kind() {
+		case reflect.String:
+			v.SetString(value)
+		case reflect.Int:
+			i, err := strconv.Atoi(value)
+			if err != nil {
+				return fmt.Errorf("config: %s: %s", name, err)
+			}
+			v.SetInt(int64(i))
+		case reflect.Float64:
+			f, err := strconv.ParseFloat(value, 64)
+			if err != nil {
+				return fmt.Errorf("config: %s: %s", name, err)
+			}
+			v.SetFloat(f)
+		case reflect.Bool:
+			b, err := strconv.ParseBool(value)
+			if err != nil {
+				return fmt.Errorf("config: %s: %s", name, err)
+			}
+			v.SetBool(b)
+		case reflect.Slice: // comma separated
+			items := reflect.MakeSlice(v.Type(), 0, 0)
+			for _, item := range strings.Split(value, ",") {
+				if item = strings.TrimSpace(item); item == "" {
+					continue
+				}
+				switch v.Type().Elem().Kind() {
+				case reflect.String:
+					items = reflect.Append(items, reflect.ValueOf(item))
+				case reflect.Int:
+					i, err := strconv.Atoi(item)
+					if err != nil {
+						return fmt.Errorf("config: %s: %s", name, err)
+					}
+					items = reflect.Append(items, reflect.ValueOf(i))
+				}
+			}
+			v.Set(items)
+		}
+		return nil
+	})
+}
+
+// walk calls cb for every field of every section with its yaml names.
+func walk(c *config, cb func(section, key string, v reflect.Value, secret bool) error) error {
+	root := reflect.ValueOf(c).Elem()
+	for i := 0; i < root.NumField(); i++ {
+		section := root.Type().Field(i).Tag.Get("yaml")
+		s := root.Field(i)
+		for j := 0; j < s.NumField(); j++ {
+			f := s.Type().Field(j)
+			if err := cb(section, f.Tag.Get("yaml"), s.Field(j), f.Tag.Get("secret") == "true"); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (c *config) Validate() error {
+	var errs []string
+	check := func(ok bool, format string, args ...interface{}) {
+		if !ok {
+			errs = append(errs, fmt.Sprintf(format, args...))
+		}
+	}
+
+	check(c.Database.IP != "", "database.ip is empty")
+	check(validPort(c.Database.Port), "database.port %d is out of range", c.Database.Port)
+	check(c.Database.Name != "", "database.name is empty")
+	check(c.Database.ConnMaxOpen > 0, "database.conn_max_open must be positive")
+	check(c.Database.ConnMaxIdle >= 0, "database.conn_max_idle can not be negative")
+	check(c.Database.ConnMaxLifetime >= 0, "database.conn_max_lifetime can not be negative")
+
+	check(c.Server.IP != "", "server.ip is empty")
+	check(validPort(c.Server.Port), "server.port %d is out of range", c.Server.Port)
+	check(c.Server.InboundQueueSize > 0, "server.inbound_queue_size must be positive")
+	switch c.Server.InboundQueuePolicy {
+	case "drop", "kick", "stall":
+	default:
+		errs = append(errs, fmt.Sprintf("server.inbound_queue_policy %q is not one of drop, kick, stall", c.Server.InboundQueuePolicy))
+	}
+	check(c.Server.OutboundQueueSize > 0, "server.outbound_queue_size must be positive")
+	check(c.Server.OutboundQueueBytes >= 0, "server.outbound_queue_bytes can not be negative")
+	check(c.Server.WriteTimeout >= 0, "server.write_timeout can not be negative")
+	check(c.Server.ShutdownCountdown >= 0, "server.shutdown_countdown can not be negative")
+	check(!c.Server.ProxyProtocol || len(c.Server.ProxyTrustedCIDRs) > 0, "server.proxy_trusted_cidrs is empty, no proxy would be trusted")
+	for _, cidr := range c.Server.ProxyTrustedCIDRs {
+		_, _, err := net.ParseCIDR(cidr)
+		check(err == nil, "server.proxy_trusted_cidrs: %q is not a CIDR", cidr)
+	}
+	check(c.Server.ProxyHeaderTimeout >= 0, "server.proxy_header_timeout can not be negative")
+
+	check(c.Admission.MaxConnections >= 0, "admission.max_connections can not be negative")
+	check(c.Admission.MaxPerIP >= 0, "admission.max_per_ip can not be negative")
+	check(c.Admission.MaxPerSubnet >= 0, "admission.max_per_subnet can not be negative")
+	check(c.Admission.SubnetV4 >= 0 && c.Admission.SubnetV4 <= 32, "admission.subnet_v4 must be between 0 and 32")
+	check(c.Admission.SubnetV6 >= 0 && c.Admission.SubnetV6 <= 128, "admission.subnet_v6 must be between 0 and 128")
+	check(c.Admission.HandshakeTimeout >= 0, "admission.handshake_timeout can not be negative")
+	check(c.Admission.BanThreshold >= 0, "admission.ban_threshold can not be negative")
+	check(c.Admission.BanThreshold == 0 || c.Admission.BanWindow > 0, "admission.ban_window must be positive")
+	check(c.Admission.BanDuration >= 0, "admission.ban_duration can not be negative")
+
+	check(c.Sessions.MaxPerIP >= 0, "sessions.max_per_ip can not be negative")
+	check(c.Sessions.MaxPerFingerprint >= 0, "sessions.max_per_fingerprint can not be negative")
+	for key, v := range map[string]string{"on_duplicate": c.Sessions.OnDuplicate, "on_limit": c.Sessions.OnLimit} {
+		check(v == "kick" || v == "refuse", "sessions.%s %q is not one of kick, refuse", key, v)
+	}
+	check(c.Sessions.EventMaxPerIP >= 0, "sessions.event_max_per_ip can not be negative")
+	check(c.Sessions.EventMaxPerFingerprint >= 0, "sessions.event_max_per_fingerprint can not be negative")
+
+	switch c.GeoIP.Proxies {
+	case "allow", "flag", "block":
+	default:
+		errs = append(errs, fmt.Sprintf("geoip.proxies %q is not one of allow, flag, block", c.GeoIP.Proxies))
+	}
+	for _, code := range append(append([]string{}, c.GeoIP.AllowCountries...), c.GeoIP.DenyCountries...) {
+		check(len(code) == 2, "geoip: %q is not a 2 letter country code", code)
+	}
+	check(len(c.GeoIP.AllowCountries) == 0 || c.GeoIP.DatabaseFile != "", "geoip.allow_countries is set but geoip.database_file is empty")
+
+	check(c.Nats.Host != "", "nats.host is empty")
+	check(validPort(c.Nats.Port), "nats.port %d is out of range", c.Nats.Port)
+	check(c.Nats.Port != c.Server.Port, "nats.port and server.port are the same")
+
+	check(c.Auth.LoginSalt != "", "auth.login_salt is empty")
+	switch c.Auth.PasswordScheme {
+	case "argon2id", "bcrypt":
+	default:
+		errs = append(errs, fmt.Sprintf("auth.password_scheme %q is not one of argon2id, bcrypt", c.Auth.PasswordScheme))
+	}
+	check(c.Auth.Argon2Memory >= 8*c.Auth.Argon2Threads && c.Auth.Argon2Memory > 0, "auth.argon2_memory must be at least 8 KiB per thread")
+	check(c.Auth.Argon2Time > 0, "auth.argon2_time must be positive")
+	check(c.Auth.Argon2Threads > 0 && c.Auth.Argon2Threads <= 255, "auth.argon2_threads must be between 1 and 255")
+	check(c.Auth.BcryptCost >= 4 && c.Auth.BcryptCost <= 31, "auth.bcrypt_cost must be between 4 and 31")
+	check(c.Auth.LoginWindow > 0, "auth.login_window must be positive")
+	check(c.Auth.LoginFreeAttempts >= 0, "auth.login_free_attempts can not be negative")
+	check(c.Auth.LoginBackoffBase >= 0, "auth.login_backoff_base can not be negative")
+	check(c.Auth.LoginBackoffMax >= c.Auth.LoginBackoffBase, "auth.login_backoff_max is less than auth.login_backoff_base")
+	check(c.Auth.LockThreshold >= 0, "auth.lock_threshold can not be negative")
+	check(c.Auth.LockThreshold == 0 || c.Auth.LockDuration > 0, "auth.lock_duration must be positive")
+	check(c.Auth.IPLockThreshold >= 0, "auth.ip_lock_threshold can not be negative")
+	check(c.Auth.PINMaxFailures >= 0, "auth.pin_max_failures can not be negative")
+	check(c.Auth.PINMaxFailures == 0 || c.Auth.PINLockDuration > 0, "auth.pin_lock_duration must be positive")
+	check(c.Auth.PINGrace >= 0, "auth.pin_grace can not be negative")
+	check(len(c.Auth.TicketSecret) >= MIN_TICKET_SECRET, "auth.ticket_secret must be at least %d bytes, the same for every process sharing the database", MIN_TICKET_SECRET)
+	check(c.Auth.TicketTTL > 0, "auth.ticket_ttl must be positive")
+
+	check(c.Game.DropRate > 0, "game.drop_rate must be positive")
+	check(c.Game.ExpRate > 0, "game.exp_rate must be positive")
+	check(c.Game.GoldRate > 0, "game.gold_rate must be positive")
+	check(c.Game.MaxGuildMembers > 0 && c.Game.MaxGuildMembers <= 127, "game.max_guild_members must be between 1 and 127")
+	check(c.Game.RunningSpeed > 0, "game.running_speed must be positive")
+	check(c.Game.TuningFile != "", "game.tuning_file is empty")
+	check(c.Game.CharacterRestoreDays >= 0, "game.character_restore_days can not be negative")
+	check(c.Game.CharacterSlots > 0, "game.character_slots must be positive")
+
+	if c.Account.Address != "" {
+		_, _, err := net.SplitHostPort(c.Account.Address)
+		check(err == nil, "account.address %q is not host:port", c.Account.Address)
+		check(c.Account.APIKey != "", "account.api_key is required with account.address")
+	}
+	check(c.Account.VerifyTokenTTL > 0, "account.verify_token_ttl must be positive")
+	check(c.Account.ResetTokenTTL > 0, "account.reset_token_ttl must be positive")
+	check(c.Account.DeletionGrace >= 0, "account.deletion_grace can not be negative")
+	check(c.Account.MinPasswordLength >= 6 && c.Account.MinPasswordLength <= 64, "account.min_password_length must be between 6 and 64")
+
+	check(c.Audit.ApprovalTimeout > 0, "audit.approval_timeout must be positive")
+	for _, command := range c.Audit.Approval {
+		check(command != "" && !strings.HasPrefix(command, "/"), "audit.approval: %q is not a command name", command)
+	}
+
+	if c.Admin.Address != "" {
+		_, _, err := net.SplitHostPort(c.Admin.Address)
+		check(err == nil, "admin.address %q is not host:port", c.Admin.Address)
+		check(c.Admin.APIKey != "", "admin.api_key is required with admin.address")
+	}
+
+	if c.Monitoring.Address != "" {
+		_, _, err := net.SplitHostPort(c.Monitoring.Address)
+		check(err == nil, "monitoring.address %q is not host:port", c.Monitoring.Address)
+	}
+
+	if len(errs) > 0 {
+		return errors.New("config: " + strings.Join(errs, "; "))
+	}
+	return nil
+}
+
+func validPort(port int) bool {
+	return port > 0 && port <= 65535
+}
+
+// Dump writes the configuration as YAML with the secrets redacted.
+func (c *config) Dump(w io.Writer) error {
+	redacted := *c
+	walk(&redacted, func(section, key string, v reflect.Value, secret bool) error {
+		if secret && v.Kind() == reflect.String && v.String() != "" {
+			v.SetString(REDACTED)
+		}
+		return nil
+	})
+
+	enc := yaml.NewEncoder(w)
+	enc.SetIndent(2)
+	if err := enc.Encode(&redacted); err != nil {
+		return err
+	}
+	return enc.Close()
+}
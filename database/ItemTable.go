@@ -11,12 +11,9 @@ import (
 )
 
 var (
-	ItemsList        = make(map[int64]*Item)
-	ItemsMutex       = sync.RWMutex{}
-	STRRates         = []int{350, 350, 325, 225, 175, 150, 80, 110, 90, 75, 50, 40, 30, 20, 15}
-	BEASTstrRates    = []int{350, 350, 325, 225, 175, 150, 80, 110, 90, 75, 50, 40, 30, 20, 15}
-	STRHappyHourRate = 0.00
-	haxBoxes         = []int64{92000002, 92000003, 92000004, 92000005, 92000006, 92000007, 92000008, 92000009, 92000010,
+	ItemsList  = make(map[int64]*Item)
+	ItemsMutex = sync.RWMutex{}
+	haxBoxes   = []int64{92000002, 92000003, 92000004, 92000005, 92000006, 92000007, 92000008, 92000009, 92000010,
 		92000055, 92000056, 92000057, 92000058, 92000059, 92000060}
 	AidTonics = []int64{13000037, 13000011, 13000012, 13000013, 13000014, 13000015, 13000060, 13000074}
 )
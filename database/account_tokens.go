@@ -0,0 +1,63 @@
+package database
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+// AccountToken is a mail verification or password reset token. Only the
+// SHA-256 of the token is stored, the token itself goes to the player.
+type AccountToken struct {
+	Hash      string    `db:"hash"`
+	Kind      string    `db:"kind"`
+	UserID    string    `db:"user_id"`
+	ExpiresAt time.Time `db:"expires_at"`
+}
+
+func (t *AccountToken) Create() error {
+	return pgsql_DbMap.Insert(t)
+}
+
+// TakeAccountToken deletes and returns the token of kind with hash, nil if
+// there is none. Expired tokens are returned too, the caller checks.
+func TakeAccountToken(kind, hash string) (*AccountToken, error) {
+	var t AccountToken
+	query := `delete from hops.account_tokens where kind = $1 and hash = $2 returning *`
+	if err := pgsql_DbMap.SelectOne(&t, query, kind, hash); err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("TakeAccountToken: %s", err)
+	}
+	return &t, nil
+}
+
+// FindAccountToken returns the token of kind with hash without using it up,
+// nil if there is none.
+func FindAccountToken(kind, hash string) (*AccountToken, error) {
+	var t AccountToken
+	query := `select * from hops.account_tokens where kind = $1 and hash = $2`
+	if err := pgsql_DbMap.SelectOne(&t, query, kind, hash); err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("FindAccountToken: %s", err)
+	}
+	return &t, nil
+}
+
+func DeleteExpiredAccountTokens() error {
+	_, err := pgsql_DbMap.Exec(`delete from hops.account_tokens where expires_at < now()`)
+	return err
+}
+
+// FindUsersDueForDeletion returns the users whose deletion grace period is over.
+func FindUsersDueForDeletion(now time.Time) ([]*User, error) {
+	var users []*User
+	query := `select * from hops.users where delete_after is not null and delete_after < $1`
+	if _, err := pgsql_DbMap.Select(&users, query, now); err != nil {
+		return nil, fmt.Errorf("FindUsersDueForDeletion: %s", err)
+	}
+	return users, nil
+}
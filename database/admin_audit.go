@@ -0,0 +1,217 @@
+package database
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// AuditEntry is a privileged command a GM ran on a character, with what it
+// changed. Entries are only ever inserted, the table refuses updates and
+// deletes.
+type AuditEntry struct {
+	ID           int          `db:"id" json:"id"`
+	Issuer       string       `db:"issuer" json:"issuer"` // Name(user ID)
+	IssuerID     string       `db:"issuer_id" json:"issuer_id"`
+	Target       string       `db:"target" json:"target"` // character name, empty for none
+	TargetUserID string       `db:"target_user_id" json:"target_user_id"`
+	Command      string       `db:"command" json:"command"`
+	Arguments    string       `db:"arguments" json:"arguments"`
+	Changes      AuditChanges `db:"changes" json:"changes"`
+	ApprovedBy   string       `db:"approved_by" json:"approved_by"` // second GM of a command needing approval
+	Error        string       `db:"error" json:"error"`
+	CreatedAt    time.Time    `db:"created_at" json:"created_at"`
+}
+
+// AuditChange is a value a command changed. Fields are gold, ncash, level,
+// exp and item:<slot>, items reading as "<item ID> x<quantity> +<plus>".
+type AuditChange struct {
+	Field  string `json:"field"`
+	Before string `json:"before"`
+	After  string `json:"after"`
+}
+
+// AuditChanges are kept as JSON.
+type AuditChanges []AuditChange
+
+func (c AuditChanges) Value() (driver.Value, error) {
+	if c == nil {
+		c = AuditChanges{}
+	}
+	data, err := json.Marshal(c)
+	return string(data), err
+}
+
+func (c *AuditChanges) Scan(src interface{}) error {
+	switch src := src.(type) {
+	case nil:
+		*c = nil
+		return nil
+	case []byte:
+		return json.Unmarshal(src, c)
+	case string:
+		return json.Unmarshal([]byte(src), c)
+	}
+	return errors.New("AuditChanges: bad source")
+}
+
+func (c AuditChanges) String() string {
+	changes := make([]string, len(c))
+	for i, change := range c {
+		changes[i] = fmt.Sprintf("%s %s -> %s", change.Field, orNone(change.Before), orNone(change.After))
+	}
+	return strings.Join(changes, ", ")
+}
+
+func orNone(value string) string {
+	if value == "" {
+		return "none"
+	}
+	return value
+}
+
+func (e *AuditEntry) String() string {
+	text := fmt.Sprintf("#%d %s %s /%s %s", e.ID, e.CreatedAt.Format("2006-01-02 15:04"), e.Issuer, e.Command, e.Arguments)
+	if e.Target != "" {
+		text += " on " + e.Target
+	}
+	if e.ApprovedBy != "" {
+		text += ", approved by " + e.ApprovedBy
+	}
+	if len(e.Changes) > 0 {
+		text += ": " + e.Changes.String()
+	}
+	if e.Error != "" {
+		text += " (failed: " + e.Error + ")"
+	}
+	return text
+}
+
+// AuditSnapshot is what the audit compares of a character before and after
+// a command.
+type AuditSnapshot struct {
+	Gold  uint64
+	NCash uint64
+	Level int
+	Exp   int64
+	Items map[int16]string // by slot, in the inventory and the bank
+}
+
+// SnapshotCharacter takes the audited values of c and of its account.
+func SnapshotCharacter(c *Character) *AuditSnapshot {
+	s := &AuditSnapshot{Gold: c.Gold, Level: c.Level, Exp: c.Exp, Items: make(map[int16]string)}
+	if u, err := FindUserByID(c.UserID); err == nil && u != nil {
+		s.NCash = u.NCash
+	}
+
+	slots, err := c.InventorySlots()
+	if err != nil {
+		return s
+	}
+	for i, slot := range slots {
+		if slot == nil || slot.ItemID == 0 {
+			continue
+		}
+		s.Items[int16(i)] = fmt.Sprintf("%d x%d +%d", slot.ItemID, slot.Quantity, slot.Plus)
+	}
+	return s
+}
+
+// Diff lists the values that changed from s to after.
+func (s *AuditSnapshot) Diff(after *AuditSnapshot) AuditChanges {
+	var changes AuditChanges
+	add := func(field string, before, after interface{}) {
+		if before != after {
+			changes = append(changes, AuditChange{Field: field, Before: fmt.Sprint(before), After: fmt.Sprint(after)})
+		}
+	}
+	add("gold", s.Gold, after.Gold)
+	add("ncash", s.NCash, after.NCash)
+	add("level", s.Level, after.Level)
+	add("exp", s.Exp, after.Exp)
+
+	slots := make([]int, 0, len(s.Items)+len(after.Items))
+	for slot := range s.Items {
+		slots = append(slots, int(slot))
+	}
+	for slot := range after.Items {
+		if _, ok := s.Items[slot]; !ok {
+			slots = append(slots, int(slot))
+		}
+	}
+	sort.Ints(slots)
+	for _, slot := range slots {
+		add("item:"+strconv.Itoa(slot), s.Items[int16(slot)], after.Items[int16(slot)])
+	}
+	return changes
+}
+
+// RecordAudit appends an entry to the admin audit.
+func RecordAudit(e *AuditEntry) error {
+	if e.CreatedAt.IsZero() {
+		e.CreatedAt = time.Now()
+	}
+	if err := pgsql_DbMap.Insert(e); err != nil {
+		return fmt.Errorf("RecordAudit: %s", err)
+	}
+	return nil
+}
+
+// AuditFilter selects audit entries, empty fields select all. Issuer and
+// Target match a user ID or a name.
+type AuditFilter struct {
+	Issuer  string
+	Target  string
+	Command string
+	From    time.Time
+	To      time.Time
+	Limit   int
+}
+
+// FindAudit returns the entries of f, the most recent first.
+func FindAudit(f AuditFilter) ([]*AuditEntry, error) {
+	var where []string
+	var args []interface{}
+	arg := func(v interface{}) string {
+		args = append(args, v)
+		return "$" + strconv.Itoa(len(args))
+	}
+
+	if f.Issuer != "" {
+		p := arg(f.Issuer)
+		where = append(where, fmt.Sprintf("(issuer_id = %s or issuer like %s || '(%%')", p, p))
+	}
+	if f.Target != "" {
+		p := arg(f.Target)
+		where = append(where, fmt.Sprintf("(target_user_id = %s or target = %s)", p, p))
+	}
+	if f.Command != "" {
+		where = append(where, "command = "+arg(strings.TrimPrefix(f.Command, "/")))
+	}
+	if !f.From.IsZero() {
+		where = append(where, "created_at >= "+arg(f.From))
+	}
+	if !f.To.IsZero() {
+		where = append(where, "created_at < "+arg(f.To))
+	}
+
+	query := "select * from hops.admin_audit"
+	if len(where) > 0 {
+		query += " where " + strings.Join(where, " and ")
+	}
+	query += " order by id desc"
+	if f.Limit > 0 {
+		query += " limit " + arg(f.Limit)
+	}
+
+	var entries []*AuditEntry
+	if _, err := pgsql_DbMap.Select(&entries, query, args...); err != nil {
+		return nil, fmt.Errorf("FindAudit: %s", err)
+	}
+	return entries, nil
+}
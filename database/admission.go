@@ -0,0 +1,199 @@
+package database
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/twodragon/kore-server/utils"
+)
+
+var (
+	ErrIPBanned          = errors.New("ip is banned")
+	ErrServerFull        = errors.New("connection limit reached")
+	ErrTooManyFromIP     = errors.New("too many connections from ip")
+	ErrTooManyFromSubnet = errors.New("too many connections from subnet")
+
+	admission = &admissionTable{
+		ips:     make(map[string]int),
+		subnets: make(map[string]int),
+		strikes: make(map[string][]time.Time),
+	}
+)
+
+// admissionTable counts the open connections, per IP and per subnet, and the
+// strikes against each IP: refused connections and handshake timeouts. Too
+// many strikes in the ban window and the IP gets a temporary ban.
+type admissionTable struct {
+	mutex   sync.Mutex
+	total   int
+	ips     map[string]int
+	subnets map[string]int
+	strikes map[string][]time.Time
+}
+
+func init() {
+	go func() {
+		for range time.Tick(time.Minute) {
+			admission.forgetStrikes()
+			if pgsql_DbMap != nil {
+				DeleteExpiredBannedIps()
+			}
+		}
+	}()
+}
+
+// admit takes a connection slot for the socket, or tells why it can not have one.
+func (s *Socket) admit() error {
+	ip := s.ClientIP()
+	if IsIPBanned(ip) {
+		return ErrIPBanned
+	}
+
+	limits := cfg.Admission
+	subnet := subnetOf(ip)
+
+	a := admission
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	switch {
+	case limits.MaxConnections > 0 && a.total >= limits.MaxConnections:
+		return ErrServerFull
+	case limits.MaxPerIP > 0 && a.ips[ip] >= limits.MaxPerIP:
+		return ErrTooManyFromIP
+	case limits.MaxPerSubnet > 0 && a.subnets[subnet] >= limits.MaxPerSubnet:
+		return ErrTooManyFromSubnet
+	}
+
+	a.total++
+	a.ips[ip]++
+	a.subnets[subnet]++
+	s.admittedIP = ip
+	return nil
+}
+
+// releaseAdmission gives back the slot taken by admit.
+func (s *Socket) releaseAdmission() {
+	s.releaseOnce.Do(func() {
+		ip := s.admittedIP
+		if ip == "" {
+			return
+		}
+		subnet := subnetOf(ip)
+
+		a := admission
+		a.mutex.Lock()
+		defer a.mutex.Unlock()
+
+		a.total--
+		if a.ips[ip]--; a.ips[ip] <= 0 {
+			delete(a.ips, ip)
+		}
+		if a.subnets[subnet]--; a.subnets[subnet] <= 0 {
+			delete(a.subnets, subnet)
+		}
+	})
+}
+
+// refuse closes a socket that was not admitted.
+func (s *Socket) refuse(reason error) {
+	s.Conn.Close()
+	if reason == ErrIPBanned {
+		return
+	}
+	log.Printf("IP %s refused: %s", s.ClientAddr, reason)
+	if reason != ErrServerFull {
+		strike(s.ClientIP(), reason.Error())
+	}
+}
+
+// checkHandshake closes the socket if it did not log in in time.
+func (s *Socket) checkHandshake() {
+	if atomic.LoadInt32(&s.loggedIn) == 1 {
+		return
+	}
+	select {
+	case <-s.done:
+		return
+	default:
+	}
+
+	text := "IP " + s.ClientAddr + " disconnected from server for login handshake timeout."
+	log.Print(text)
+	utils.NewLog("logs/rate_kicks.txt", text)
+	s.OnClose()
+	strike(s.ClientIP(), "login handshake timeout")
+}
+
+// strike records a strike against ip and bans it when it had too many.
+func strike(ip, reason string) {
+	limits := cfg.Admission
+	if limits.BanThreshold <= 0 {
+		return
+	}
+
+	a := admission
+	a.mutex.Lock()
+	now := time.Now()
+	strikes := append(recentStrikes(a.strikes[ip], now), now)
+	a.strikes[ip] = strikes
+	ban := len(strikes) >= limits.BanThreshold
+	if ban {
+		delete(a.strikes, ip)
+	}
+	a.mutex.Unlock()
+
+	if !ban || IsIPBanned(ip) {
+		return
+	}
+
+	duration := time.Duration(limits.BanDuration) * time.Minute
+	if _, err := BanIP(ip, "auto: "+reason, duration); err != nil {
+		log.Print(err)
+		return
+	}
+	text := fmt.Sprintf("IP %s banned for %s after %d strikes, last one: %s", ip, duration, len(strikes), reason)
+	log.Print(text)
+	utils.NewLog("logs/ip_bans.txt", text)
+}
+
+func recentStrikes(strikes []time.Time, now time.Time) []time.Time {
+	window := time.Duration(cfg.Admission.BanWindow) * time.Second
+	recent := strikes[:0]
+	for _, t := range strikes {
+		if now.Sub(t) < window {
+			recent = append(recent, t)
+		}
+	}
+	return recent
+}
+
+func (a *admissionTable) forgetStrikes() {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	now := time.Now()
+	for ip, strikes := range a.strikes {
+		if strikes = recentStrikes(strikes, now); len(strikes) == 0 {
+			delete(a.strikes, ip)
+		} else {
+			a.strikes[ip] = strikes
+		}
+	}
+}
+
+// subnetOf returns the network ip is counted in, as configured for its family.
+func subnetOf(ip string) string {
+	parsed := net.ParseIP(ip)
+	if parsed == nil {
+		return ip
+	}
+	if v4 := parsed.To4(); v4 != nil {
+		return v4.Mask(net.CIDRMask(cfg.Admission.SubnetV4, 32)).String()
+	}
+	return parsed.Mask(net.CIDRMask(cfg.Admission.SubnetV6, 128)).String()
+}
@@ -229,6 +229,8 @@ func (ai *AI) FindTargetCharacterID() (int, error) {
 }
 
 func (ai *AI) AIHandler() {
+	AIGoroutines.With(AI_HANDLER).Inc()
+	defer AIGoroutines.With(AI_HANDLER).Dec()
 
 	npcPos := GetNPCPosByID(ai.PosID)
 	if npcPos == nil {
@@ -1328,6 +1330,8 @@ func (ai *AI) Kill() {
 }
 
 func (ai *AI) MovementHandler(token int64, start, end *utils.Location, speed float64) {
+	AIGoroutines.With(AI_MOVEMENT).Inc()
+	defer AIGoroutines.With(AI_MOVEMENT).Dec()
 
 	diff := utils.CalculateDistance(start, end)
 
@@ -1546,6 +1550,7 @@ BEGIN:
 			if ok && item != nil {
 				seed := int(utils.RandInt(0, 1000))
 				plus := byte(0)
+				plusRates := Tuned().PlusRates
 				for i := 0; i < len(plusRates) && !isRelic; i++ {
 					if seed > plusRates[i] {
 						plus++
@@ -1822,6 +1827,7 @@ func (ai *AI) RewardNcash() {
 			}
 			if char.IsActive && char.IsOnline {
 				char.Socket.User.NCash += reward
+				NCashCreated.Add(float64(reward))
 				char.Socket.User.Update()
 				text := fmt.Sprintf("%s earned %d by dealing %d to (%s)", char.Name, reward, totalDamageDealt, npc.Name)
 				char.Socket.Write(messaging.InfoMessage(fmt.Sprintf("%s earned %d by dealing %d to (%s)", char.Name, reward, totalDamageDealt, npc.Name)))
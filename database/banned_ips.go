@@ -3,15 +3,26 @@ package database
 import (
 	"database/sql"
 	"fmt"
+	"sync"
+	"time"
+
+	null "gopkg.in/guregu/null.v3"
 )
 
 var (
-	BannedIps = make(map[int]*BannedIp)
+	BannedIps      = make(map[int]*BannedIp)
+	bannedIpsMutex sync.RWMutex
 )
 
 type BannedIp struct {
-	Id       int    `db:"id"`
-	BannedIp string `db:"ip"`
+	Id        int       `db:"id"`
+	BannedIp  string    `db:"ip"`
+	Reason    string    `db:"reason"`
+	ExpiresAt null.Time `db:"expires_at"` // null for a permanent ban
+}
+
+func (b *BannedIp) Create() error {
+	return pgsql_DbMap.Insert(b)
 }
 
 func (b *BannedIp) Update() error {
@@ -19,6 +30,15 @@ func (b *BannedIp) Update() error {
 	return err
 }
 
+func (b *BannedIp) Delete() error {
+	_, err := pgsql_DbMap.Delete(b)
+	return err
+}
+
+func (b *BannedIp) Expired() bool {
+	return b.ExpiresAt.Valid && time.Now().After(b.ExpiresAt.Time)
+}
+
 func GetBannedIps() error {
 	var ips []*BannedIp
 	query := `select * from hops.banned_ips`
@@ -30,8 +50,57 @@ func GetBannedIps() error {
 		return fmt.Errorf("GetBannedIps: %s", err.Error())
 	}
 
+	bannedIpsMutex.Lock()
+	defer bannedIpsMutex.Unlock()
 	for _, cr := range ips {
 		BannedIps[cr.Id] = cr
 	}
 	return nil
 }
+
+// IsIPBanned reports whether ip has a ban that did not expire yet.
+func IsIPBanned(ip string) bool {
+	bannedIpsMutex.RLock()
+	defer bannedIpsMutex.RUnlock()
+	for _, bi := range BannedIps {
+		if bi.BannedIp == ip && !bi.Expired() {
+			return true
+		}
+	}
+	return false
+}
+
+// BanIP bans ip for d, or for ever if d is 0.
+func BanIP(ip, reason string, d time.Duration) (*BannedIp, error) {
+	b := &BannedIp{BannedIp: ip, Reason: reason}
+	if d > 0 {
+		b.ExpiresAt = null.TimeFrom(time.Now().Add(d))
+	}
+	if err := b.Create(); err != nil {
+		return nil, fmt.Errorf("BanIP: %s", err)
+	}
+
+	bannedIpsMutex.Lock()
+	defer bannedIpsMutex.Unlock()
+	BannedIps[b.Id] = b
+	return b, nil
+}
+
+// DeleteExpiredBannedIps lifts the temporary bans that are over.
+func DeleteExpiredBannedIps() {
+	bannedIpsMutex.Lock()
+	var expired []*BannedIp
+	for id, bi := range BannedIps {
+		if bi.Expired() {
+			expired = append(expired, bi)
+			delete(BannedIps, id)
+		}
+	}
+	bannedIpsMutex.Unlock()
+
+	for _, bi := range expired {
+		if err := bi.Delete(); err != nil {
+			fmt.Printf("DeleteExpiredBannedIps: %s\n", err)
+		}
+	}
+}
@@ -0,0 +1,116 @@
+package database
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/twodragon/kore-server/utils"
+	null "gopkg.in/guregu/null.v3"
+)
+
+var (
+	CHARACTER_PURGE_POLL = time.Hour
+
+	ErrNoCharacterSlot = errors.New("all character slots of the account are taken")
+
+	characterPurgeMutex sync.Mutex // between restoring and purging
+)
+
+// restoreWindow is how long deleted characters can be restored.
+func restoreWindow() time.Duration {
+	return time.Duration(cfg.Game.CharacterRestoreDays) * 24 * time.Hour
+}
+
+// PendingDeletion tells if the character was deleted and waits for the purge.
+func (t *Character) PendingDeletion() bool {
+	return t.DeletedAt.Valid
+}
+
+// PurgeAt is when the character of a pending deletion is purged.
+func (t *Character) PurgeAt() time.Time {
+	return t.DeletedAt.Time.Add(restoreWindow())
+}
+
+// SoftDelete marks the character as deleted. It can be restored until the
+// restore window ends, its name stays taken until then.
+func (t *Character) SoftDelete() error {
+	t.DeletedAt = null.TimeFrom(time.Now())
+	return t.Update()
+}
+
+// Restore takes the character back from a pending deletion, if the account
+// has a free character slot.
+func (t *Character) Restore() error {
+	characterPurgeMutex.Lock()
+	defer characterPurgeMutex.Unlock()
+
+	if !t.PendingDeletion() {
+		return fmt.Errorf("%s is not deleted", t.Name)
+	}
+	if time.Now().After(t.PurgeAt()) {
+		return fmt.Errorf("the restore window of %s is over", t.Name)
+	}
+	chars, err := FindCharactersByUserID(t.UserID)
+	if err != nil {
+		return err
+	}
+	if len(WithoutPendingDeletion(chars)) >= cfg.Game.CharacterSlots {
+		return ErrNoCharacterSlot
+	}
+
+	t.DeletedAt = null.Time{}
+	return t.Update()
+}
+
+// WithoutPendingDeletion leaves out the characters pending deletion.
+func WithoutPendingDeletion(chars []*Character) []*Character {
+	live := make([]*Character, 0, len(chars))
+	for _, c := range chars {
+		if !c.PendingDeletion() {
+			live = append(live, c)
+		}
+	}
+	return live
+}
+
+// StartCharacterPurge purges the deleted characters whose restore window is
+// over every CHARACTER_PURGE_POLL.
+func StartCharacterPurge() {
+	go func() {
+		for {
+			purgeDeletedCharacters()
+			time.Sleep(CHARACTER_PURGE_POLL)
+		}
+	}()
+}
+
+func purgeDeletedCharacters() {
+	var ids []int64
+	query := `select id from hops.characters where deleted_at is not null and deleted_at < $1`
+	if _, err := pgsql_DbMap.Select(&ids, query, time.Now().Add(-restoreWindow())); err != nil {
+		log.Printf("purgeDeletedCharacters: %s", err)
+		return
+	}
+
+	for _, id := range ids {
+		c, err := FindCharacterByID(int(id))
+		if err != nil || c == nil {
+			continue
+		}
+
+		characterPurgeMutex.Lock()
+		if c.PendingDeletion() && time.Now().After(c.PurgeAt()) {
+			if err := c.Purge(); err != nil {
+				log.Printf("Purge of character %s(%d): %s", c.Name, c.ID, err)
+			} else {
+				text := fmt.Sprintf("Character %s(%d) of user %s purged, deleted at %s", c.Name, c.ID, c.UserID, c.DeletedAt.Time.Format("2006-01-02 15:04:05"))
+				log.Print(text)
+				utils.NewLog("logs/character_purges.txt", text)
+			}
+		}
+		characterPurgeMutex.Unlock()
+	}
+}
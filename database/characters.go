@@ -273,6 +273,7 @@ type Character struct {
 	YingYangTicketsLeft int       `db:"ying_yang_tickets" json:"ying_yang_tickets"`
 	Relaxation          int       `db:"relaxation"`
 	OnlineHours         int       `db:"online_hours"`
+	DeletedAt           null.Time `db:"deleted_at" json:"deleted_at"` // pending deletion, see SoftDelete
 
 	ShowUpgradingRate bool `db:"-" json:"-"`
 
@@ -440,6 +441,63 @@ func (t *Character) Delete() error {
 	return err
 }
 
+// Purge deletes the character with its guild membership, consignment items,
+// stats and skills.
+func (t *Character) Purge() error {
+	if err := t.Delete(); err != nil {
+		return err
+	}
+
+	if t.GuildID > 0 {
+		guild, err := FindGuildByID(t.GuildID)
+		if err != nil {
+			return err
+		}
+
+		if guild != nil {
+			if guild.LeaderID == t.ID {
+				guild.Delete()
+			} else {
+				guild.RemoveMember(t.ID)
+				go guild.Update()
+			}
+		}
+	}
+
+	consItems, err := FindConsignmentItemsBySellerID(t.ID)
+	if err != nil {
+		return err
+	}
+
+	for _, item := range consItems {
+		if err = item.Delete(); err != nil {
+			return err
+		}
+		RemoveConsignmentData(item.ID)
+	}
+
+	stat, err := FindStatByID(t.ID)
+	if err != nil {
+		return err
+	}
+	if stat != nil {
+		if err = stat.Delete(); err != nil {
+			return err
+		}
+	}
+
+	skills, err := FindSkillsByID(t.ID)
+	if err != nil {
+		return err
+	}
+	if skills != nil {
+		skills.Delete()
+	}
+
+	DeleteAllFriendsByCharID(t.ID)
+	return nil
+}
+
 func (c *Character) BoxOpenerStorage() ([]*InventorySlot, error) {
 	c.BoxOpenerBank = make([]*InventorySlot, 450)
 	for i := range c.BoxOpenerBank {
@@ -579,23 +637,22 @@ func IsValidUsername(name string) (bool, error) {
 
 func FindCharacterByName(name string) (*Character, error) {
 
+	characterMutex.RLock()
 	for _, c := range characters {
 		if c.Name == name {
+			characterMutex.RUnlock()
 			return c, nil
 		}
 	}
+	characterMutex.RUnlock()
 
 	character := &Character{}
-	err := pgsql_DbMap.SelectOne(&character, "select * from hops.users where login = $1", name)
+	err := pgsql_DbMap.SelectOne(&character, "select * from hops.characters where name = $1", name)
 	if err != nil {
 		return nil, err
 	}
 
 	characterMutex.Lock()
-	defer characterMutex.Unlock()
-
-	characterMutex.Lock()
-
 	characters[character.ID] = character
 	characterMutex.Unlock()
 	return character, nil
@@ -2354,6 +2411,7 @@ func (c *Character) Handler() {
 			log.Printf("handler error: %+v", string(dbg.Stack()))
 			c.HandlerCB = nil
 			c.Update()
+			c.Socket.SetCloseReason(SESSION_ERROR)
 			c.Socket.Conn.Close()
 		}
 	}()
@@ -3720,8 +3778,9 @@ func (c *Character) ChangeName(newname string) ([]byte, error) {
 	CHARACTER_MENU := utils.Packet{0xAA, 0x55, 0x03, 0x00, 0x09, 0x09, 0x00, 0x55, 0xAA}
 	resp := CHARACTER_MENU
 	if c != nil {
-		c.Socket.User.ConnectingIP = c.Socket.ClientAddr
-		c.Socket.User.ConnectingTo = c.Socket.User.ConnectedServer
+		if err := c.Socket.IssueTicket(c.Socket.User.ConnectedServer); err != nil {
+			return nil, err
+		}
 		c.Logout()
 	}
 	return resp, nil
@@ -3827,6 +3886,11 @@ func (c *Character) ChangeMap(mapID int16, coordinate *utils.Location, args ...i
 		p := nats.CastPacket{CastNear: true, CharacterID: c.ID, Type: nats.PLAYER_RESPAWN}
 		p.Cast()
 	}
+	if c.Socket != nil {
+		if jail := c.Socket.Sanction(SANCTION_JAIL); jail != nil && mapID != jail.MapID {
+			mapID, coordinate = jail.MapID, nil
+		}
+	}
 	if !c.IsAllowedInMap(mapID) {
 		return messaging.SystemMessage(messaging.NO_LEVEL_REQUIREMENT), nil
 	}
@@ -3863,7 +3927,7 @@ func (c *Character) ChangeMap(mapID int16, coordinate *utils.Location, args ...i
 		}
 
 	}*/
-	if funk.Contains(sharedMaps, mapID) { // shared map
+	if funk.Contains(Tuned().SharedMaps, mapID) { // shared map
 		c.Socket.User.ConnectedServer = 1
 	} else if c.Socket.User.ConnectedServer >= 1 && c.Socket.User.ConnectedServer <= 7 {
 		c.Socket.User.ConnectedServer = c.Socket.User.SelectedServerID
@@ -4318,9 +4382,10 @@ func (c *Character) BSUpgrade(slotID int64, stones []*InventorySlot, luck, prote
 		return resp, nil
 	}
 
-	strrate := STRRates[item.Plus]
+	strRates := Tuned().STRRates
+	strrate := strRates[item.Plus]
 
-	strrate += int(float64(STRRates[item.Plus]) * STRHappyHourRate)
+	strrate += int(float64(strRates[item.Plus]) * STRHappyHourRate())
 	strrate += int(float64(strrate) * float64(c.Socket.Stats.EnhancedProbabilitiesBuff) / 1000)
 
 	rate := float64(strrate * len(stones))
@@ -4342,8 +4407,9 @@ func (c *Character) BSUpgrade(slotID int64, stones []*InventorySlot, luck, prote
 			plus = 15
 		}
 
-		strrate := BEASTstrRates[plus-1]
-		strrate += int(float64(STRRates[item.Plus]) * STRHappyHourRate)
+		tuned := Tuned()
+		strrate := tuned.BeastSTRRates[plus-1]
+		strrate += int(float64(tuned.STRRates[item.Plus]) * STRHappyHourRate())
 		strrate += int(float64(c.Socket.Stats.EnhancedProbabilitiesBuff) / 1000)
 		rate = float64(strrate * len(stones))
 	}
@@ -6110,7 +6176,7 @@ func (c *Character) UseConsumable(item *InventorySlot, slotID int16) ([]byte, er
 
 				} else if funk.Contains(haxBoxes, item.ItemID) { // Hax Box
 					seed := utils.RandInt(0, 1000)
-					plus = uint8(sort.SearchInts(plusRates, int(seed)) + 1)
+					plus = uint8(sort.SearchInts(Tuned().PlusRates, int(seed)) + 1)
 
 					upgradesArray := []byte{}
 					rewardType := rewardInfo.GetType()
@@ -9613,6 +9679,7 @@ func (c *Character) DealDamage(ai *AI, dmg int, isSkill bool) {
 			if rand < 100 {
 				amount := uint64(1000)
 				s.User.NCash += 1000
+				NCashCreated.Add(1000)
 				s.User.Update()
 				s.Write(messaging.InfoMessage(fmt.Sprintf("You earned %d nC from boss hunting.", amount)))
 			}
@@ -9858,7 +9925,7 @@ func (c *Character) CanAttack(enemy *Character) bool {
 	if (c.Map == 255) && c.Faction == enemy.Faction {
 		return false
 	}
-	rr := (c.DuelID == enemy.ID && c.DuelStarted) || funk.Contains(PvPZones, c.Map)
+	rr := (c.DuelID == enemy.ID && c.DuelStarted) || funk.Contains(Tuned().PvPZones, c.Map)
 	return rr
 }
 
@@ -11646,6 +11713,11 @@ func (c *Character) LootGold(amount uint64) []byte {
 		return resp
 	}
 	c.Gold += amount
+	if int64(amount) < 0 { // callers take gold with LootGold(-cost)
+		GoldDestroyed.Add(float64(-int64(amount)))
+	} else {
+		GoldCreated.Add(float64(amount))
+	}
 	/*	if c.Gold < 0 {
 		c.Gold = 0
 	}*/
@@ -11670,6 +11742,7 @@ func (c *Character) SubtractGold(amount uint64) bool {
 		return false
 	}
 	c.Gold -= amount
+	GoldDestroyed.Add(float64(amount))
 	go c.Update()
 	c.Socket.Write(c.GetGold())
 	return true
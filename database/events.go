@@ -436,6 +436,7 @@ func endLoto() {
 				return
 			}
 			user.NCash += lotoPrice
+			NCashCreated.Add(float64(lotoPrice))
 			user.Update()
 			msg := "Lottery event canceled because not enough participants to the event."
 			makeAnnouncement(msg)
@@ -460,6 +461,7 @@ func endLoto() {
 		}
 		winnings := lotoPrice * uint64(len(participants))
 		user.NCash += winnings
+		NCashCreated.Add(float64(winnings))
 		user.Update()
 		msg := fmt.Sprintf("Lottery: %s has aquired %d ncash by winning the Lottery.", winner.character.Name, winnings)
 		makeAnnouncement(msg)
@@ -502,6 +504,7 @@ func AddPlayer(s *Socket, number int) {
 		return
 	} else {
 		s.User.NCash -= lotoPrice
+		NCashDestroyed.Add(float64(lotoPrice))
 		s.User.Update()
 		participant := &Participant{
 			character: s.Character,
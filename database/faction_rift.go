@@ -20,6 +20,8 @@ var (
 )
 
 func (ai *AI) AIRiftHandler() {
+	AIGoroutines.With(AI_RIFT_HANDLER).Inc()
+	defer AIGoroutines.With(AI_RIFT_HANDLER).Dec()
 
 	npcPos := GetNPCPosByID(ai.PosID)
 	if npcPos == nil {
@@ -2,7 +2,6 @@ package database
 
 import (
 	"fmt"
-	"strings"
 	"time"
 
 	"github.com/twodragon/kore-server/nats"
@@ -160,45 +159,10 @@ func AddMemberToFactionWar(char *Character) {
 	}
 	checkMembersInFactionWarMap()
 
-	for _, player := range fw_zhuangFactionWarMembersList {
-		user, err := FindUserByID(player.UserID)
-		if err != nil {
-			continue
-		}
-		user2, err := FindUserByID(char.UserID)
-		if err != nil {
-			return
-		}
-		ip1 := strings.Split(user.ConnectedIP, ":")
-		ip1x := ip1[0]
-		ip2 := strings.Split(user2.ConnectedIP, ":")
-		ip2x := ip2[0]
-
-		if ip1x == ip2x {
-			char.Socket.Write(messaging.InfoMessage("You cannot enter with more than one character!"))
-			return
-		}
-	}
-	for _, player := range fw_shaoFactionWarMembersList {
-
-		user, err := FindUserByID(player.UserID)
-		if err != nil {
-			continue
-		}
-		user2, err := FindUserByID(char.UserID)
-		if err != nil {
-			return
-		}
-		ip1 := strings.Split(user.ConnectedIP, ":")
-		ip1x := ip1[0]
-		ip2 := strings.Split(user2.ConnectedIP, ":")
-		ip2x := ip2[0]
-
-		if ip1x == ip2x {
-			char.Socket.Write(messaging.InfoMessage("You cannot enter with more than one character!"))
-			return
-		}
-
+	members := append(append([]*Character{}, fw_zhuangFactionWarMembersList...), fw_shaoFactionWarMembersList...)
+	if d := DecideEventEntry(char, EVENT_FACTION_WAR, members); !d.Allow {
+		char.Socket.Write(messaging.InfoMessage("You cannot enter with more than one character!"))
+		return
 	}
 	coordinate := &utils.Location{X: 325, Y: 465}
 	data, _ := char.ChangeMap(255, coordinate)
@@ -345,6 +309,7 @@ func checkMembersInFactionWarMap() {
 		}
 	}
 }
+
 func makeAnnouncement(msg string) {
 	length := int16(len(msg) + 3)
 
@@ -363,7 +328,13 @@ func getHour(date null.Time) (int, int) {
 	}
 	return 0, 0
 }
+
+// FactionWarSchedule prepares a faction war at 20:00 every other day.
 func FactionWarSchedule() {
+	WarSchedules[WAR_FACTION].Start()
+}
+
+func checkFactionWarSchedule() {
 	if !fw_isFactionWarEntranceActive && !fw_isFactionWarStarted {
 		if time.Now().Day()%2 == 0 {
 			hour, minutes := getHour(null.NewTime(time.Now(), true))
@@ -373,7 +344,4 @@ func FactionWarSchedule() {
 			}
 		}
 	}
-	time.AfterFunc(time.Minute, func() {
-		FactionWarSchedule()
-	})
 }
@@ -404,7 +404,13 @@ func checkMembersInFlagKingdomMap() {
 		}
 	}
 }
+
+// FlagKingdomSchedule prepares a flag kingdom war at 20:00 every day.
 func FlagKingdomSchedule() {
+	WarSchedules[WAR_FLAG_KINGDOM].Start()
+}
+
+func checkFlagKingdomSchedule() {
 	if !isFlagKingdomEntranceActive && !isFlagKingdomStarted {
 		hour, minutes := getHour(null.NewTime(time.Now(), true))
 		if hour == 20 && minutes == 0 {
@@ -413,9 +419,6 @@ func FlagKingdomSchedule() {
 		}
 
 	}
-	time.AfterFunc(time.Minute, func() {
-		FlagKingdomSchedule()
-	})
 }
 func DropFlag(c *Character, flag int64) {
 	baseLocation := ConvertPointToLocation(c.Coordinate)
@@ -0,0 +1,95 @@
+package database
+
+import (
+	"bytes"
+	"errors"
+	"sync/atomic"
+)
+
+var (
+	FRAME_HEADER  = []byte{0xAA, 0x55}
+	FRAME_TRAILER = []byte{0x55, 0xAA}
+
+	MAX_FRAME_SIZE = 16384
+
+	ErrMalformedFrame = errors.New("malformed frame")
+	ErrOversizedFrame = errors.New("oversized frame")
+
+	framingErrors uint64
+)
+
+// Framer reassembles "AA 55 <len> ... 55 AA" frames out of a byte stream.
+// The length field is little endian and counts the bytes between itself and
+// the trailer, the same value utils.Packet.SetLength writes.
+type Framer struct {
+	buf     []byte
+	maxSize int
+}
+
+func NewFramer(maxSize int) *Framer {
+	if maxSize <= 0 {
+		maxSize = MAX_FRAME_SIZE
+	}
+	return &Framer{maxSize: maxSize}
+}
+
+// Feed appends bytes read from the connection to the internal buffer.
+func (f *Framer) Feed(data []byte) {
+	f.buf = append(f.buf, data...)
+}
+
+// Buffered returns the number of bytes waiting for the rest of their frame.
+func (f *Framer) Buffered() int {
+	return len(f.buf)
+}
+
+// Next returns the next complete frame or nil if more data is needed. Any
+// error is fatal for the stream, the buffered bytes can not be trusted anymore.
+func (f *Framer) Next() ([]byte, error) {
+	if len(f.buf) < 4 {
+		if len(f.buf) > 0 && f.buf[0] != FRAME_HEADER[0] {
+			return nil, f.fail(ErrMalformedFrame)
+		}
+		return nil, nil
+	}
+
+	if !bytes.Equal(f.buf[:2], FRAME_HEADER) {
+		return nil, f.fail(ErrMalformedFrame)
+	}
+
+	length := int(f.buf[2]) | int(f.buf[3])<<8
+	size := length + 6
+	if size > f.maxSize {
+		return nil, f.fail(ErrOversizedFrame)
+	}
+	if length < 2 { // at least the opcode
+		return nil, f.fail(ErrMalformedFrame)
+	}
+
+	if len(f.buf) < size {
+		return nil, nil
+	}
+
+	if !bytes.Equal(f.buf[size-2:size], FRAME_TRAILER) {
+		return nil, f.fail(ErrMalformedFrame)
+	}
+
+	frame := make([]byte, size)
+	copy(frame, f.buf[:size])
+
+	rest := copy(f.buf, f.buf[size:])
+	f.buf = f.buf[:rest]
+
+	return frame, nil
+}
+
+func (f *Framer) fail(err error) error {
+	atomic.AddUint64(&framingErrors, 1)
+	f.buf = nil
+	return err
+}
+
+// FramingErrors returns how many streams were dropped for bad framing since start.
+func FramingErrors() uint64 {
+	return atomic.LoadUint64(&framingErrors)
+}
@@ -0,0 +1,95 @@
+package database
+
+import (
+	"bytes"
+	"testing"
+)
+
+// frame builds an "AA 55 <len> body 55 AA" frame, body holding the opcode.
+func frame(body ...byte) []byte {
+	f := append([]byte{0xAA, 0x55, byte(len(body)), byte(len(body) >> 8)}, body...)
+	return append(f, 0x55, 0xAA)
+}
+
+func concat(parts ...[]byte) []byte {
+	return bytes.Join(parts, nil)
+}
+
+func TestFramer(t *testing.T) {
+	login := frame(0x00, 0x00, 0x01, 0x02, 0x03)
+	move := frame(0x44, 0x01, 0x09)
+	trailerInside := frame(0x01, 0x05, 0x55, 0xAA, 0x07)
+
+	for _, test := range []struct {
+		name   string
+		reads  [][]byte
+		frames [][]byte
+		err    error
+	}{
+		{"one frame", [][]byte{login}, [][]byte{login}, nil},
+		{"split across reads", [][]byte{login[:3], login[3:7], login[7:]}, [][]byte{login}, nil},
+		{"split in the trailer", [][]byte{login[:len(login)-1], login[len(login)-1:]}, [][]byte{login}, nil},
+		{"several in one read", [][]byte{concat(login, move, login)}, [][]byte{login, move, login}, nil},
+		{"several across reads", [][]byte{concat(login, move[:2]), move[2:]}, [][]byte{login, move}, nil},
+		{"trailer inside the payload", [][]byte{concat(trailerInside, move)}, [][]byte{trailerInside, move}, nil},
+		{"oversized length", [][]byte{{0xAA, 0x55, 0xFF, 0x7F}}, nil, ErrOversizedFrame},
+		{"length below the opcode", [][]byte{{0xAA, 0x55, 0x01, 0x00, 0x00, 0x55, 0xAA}}, nil, ErrMalformedFrame},
+		{"bad trailer", [][]byte{{0xAA, 0x55, 0x02, 0x00, 0x01, 0x02, 0xAA, 0x55}}, nil, ErrMalformedFrame},
+		{"bad header", [][]byte{{0x55, 0xAA, 0x02, 0x00, 0x01, 0x02, 0x55, 0xAA}}, nil, ErrMalformedFrame},
+		{"bad first byte alone", [][]byte{{0x00}}, nil, ErrMalformedFrame},
+		{"frame then garbage", [][]byte{concat(login, []byte{0x01, 0x02, 0x03, 0x04})}, [][]byte{login}, ErrMalformedFrame},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			f := NewFramer(1024)
+			var frames [][]byte
+			var err error
+			for _, read := range test.reads {
+				f.Feed(read)
+				for err == nil {
+					var next []byte
+					if next, err = f.Next(); next == nil {
+						break
+					}
+					frames = append(frames, next)
+				}
+			}
+
+			if err != test.err {
+				t.Fatalf("error %v, want %v", err, test.err)
+			}
+			if len(frames) != len(test.frames) {
+				t.Fatalf("%d frames, want %d: % X", len(frames), len(test.frames), frames)
+			}
+			for i := range frames {
+				if !bytes.Equal(frames[i], test.frames[i]) {
+					t.Errorf("frame %d: % X, want % X", i, frames[i], test.frames[i])
+				}
+			}
+			if f.Buffered() != 0 { // used up, or dropped after an error
+				t.Errorf("%d bytes left buffered", f.Buffered())
+			}
+		})
+	}
+}
+
+func TestFramerFramesAreCopies(t *testing.T) {
+	f := NewFramer(0)
+	f.Feed(concat(frame(0x01, 0x02), frame(0x03, 0x04)))
+
+	first, _ := f.Next()
+	second, _ := f.Next()
+	first[4] = 0xFF
+	if second[4] != 0x03 {
+		t.Errorf("frames share their bytes: % X", second)
+	}
+}
+
+func TestFramingErrorsCounted(t *testing.T) {
+	before := FramingErrors()
+	f := NewFramer(0)
+	f.Feed([]byte{0x00})
+	f.Next()
+	if FramingErrors() != before+1 {
+		t.Errorf("FramingErrors() = %d, want %d", FramingErrors(), before+1)
+	}
+}
@@ -56,6 +56,17 @@ func AddPlayerToGreatWar(c *Character) {
 		return
 	}
 
+	participants := make([]*Character, 0, len(OrderCharacters)+len(ShaoCharacters))
+	for _, chars := range []map[int]*Character{OrderCharacters, ShaoCharacters} {
+		for _, p := range chars {
+			participants = append(participants, p)
+		}
+	}
+	if d := DecideEventEntry(c, EVENT_GREAT_WAR, participants); !d.Allow {
+		c.Socket.Write(messaging.InfoMessage("You cannot enter with more than one character!"))
+		return
+	}
+
 	if c.Faction == 1 {
 		x := 75.0
 		y := 45.0
@@ -79,7 +90,25 @@ func AddPlayerToGreatWar(c *Character) {
 
 }
 
+// StartWarTimer counts down to the Great War, replacing a countdown running
+// already. StopWarTimer cancels it.
 func StartWarTimer(prepareWarStart int, level int) {
+	warTimerMutex.Lock()
+	warTimerGeneration++
+	warTimerRunning = true
+	generation := warTimerGeneration
+	warTimerMutex.Unlock()
+
+	countWarTimer(generation, prepareWarStart, level)
+}
+
+func countWarTimer(generation, prepareWarStart int, level int) {
+	warTimerMutex.Lock()
+	defer warTimerMutex.Unlock()
+	if generation != warTimerGeneration {
+		return
+	}
+
 	Level = level
 	min, sec := secondsToMinutes(prepareWarStart)
 	msg := fmt.Sprintf("%d minutes %d second after the Great War will start.", min, sec)
@@ -88,10 +117,11 @@ func StartWarTimer(prepareWarStart int, level int) {
 	makeAnnouncement(msg2)
 	if prepareWarStart > 0 {
 		time.AfterFunc(time.Second*10, func() {
-			StartWarTimer(prepareWarStart-10, level)
+			countWarTimer(generation, prepareWarStart-10, level)
 		})
 	} else {
-		StartWar()
+		warTimerRunning = false
+		go StartWar()
 	}
 }
 func secondsToMinutes(inSeconds int) (int, int) {
@@ -1,7 +1,9 @@
 package database
 
 import (
+	"context"
 	"database/sql"
+	"errors"
 
 	"fmt"
 	"log"
@@ -12,7 +14,7 @@ import (
 	"github.com/twodragon/kore-server/logging"
 	"github.com/twodragon/kore-server/utils"
 
-	_ "github.com/lib/pq"
+	"github.com/lib/pq"
 	gorp "gopkg.in/gorp.v1"
 )
 
@@ -50,10 +52,22 @@ var (
 	ArmorUpgrades  []byte
 	WeaponUpgrades []byte
 	HTarmorSockets []byte
-	plusRates      = []int{800, 900, 950, 980, 990, 996, 999}
 	logger         = logging.Logger
 )
 
+// ApplyConfig copies the game settings of the loaded configuration into the
+// package rates, it has to run before anything reads them.
+func ApplyConfig() {
+	DROP_RATE = cfg.Game.DropRate
+	DEFAULT_DROP_RATE = cfg.Game.DropRate
+	EXP_RATE = cfg.Game.ExpRate
+	DEFAULT_EXP_RATE = cfg.Game.ExpRate
+	GOLD_RATE = cfg.Game.GoldRate
+	DEFAULT_GOLD_RATE = cfg.Game.GoldRate
+	MAX_GUILD_MEMBERS = int8(cfg.Game.MaxGuildMembers)
+	DEFAULT_RUNNING_SPEED = cfg.Game.RunningSpeed
+}
+
 func InitPostgreSQL() error {
 
 	var (
@@ -72,10 +86,11 @@ func InitPostgreSQL() error {
 		conn        *sql.DB
 	)
 
-	conn, err = sql.Open("postgres", fmt.Sprintf("host=%s port=%d user=%s password=%s dbname=%s sslmode=%s", ip, port, user, pass, name, sslMode))
+	connector, err := pq.NewConnector(fmt.Sprintf("host=%s port=%d user=%s password=%s dbname=%s sslmode=%s", ip, port, user, pass, name, sslMode))
 	if err != nil {
 		return fmt.Errorf("database connection error: %s", err.Error())
 	}
+	conn = sql.OpenDB(timedConnector{connector})
 
 	conn.SetMaxIdleConns(maxIdle)
 	conn.SetMaxOpenConns(maxOpen)
@@ -101,6 +116,10 @@ func InitPostgreSQL() error {
 	pgsql_DbMap.AddTableWithNameAndSchema(Server{}, "hops", "servers").SetKeys(true, "id")
 	pgsql_DbMap.AddTableWithNameAndSchema(FiveClan{}, "hops", "fiveclan_war").SetKeys(true, "id")
 	pgsql_DbMap.AddTableWithNameAndSchema(BannedIp{}, "hops", "banned_ips").SetKeys(true, "id")
+	pgsql_DbMap.AddTableWithNameAndSchema(Sanction{}, "hops", "sanctions").SetKeys(true, "id")
+	pgsql_DbMap.AddTableWithNameAndSchema(AccountToken{}, "hops", "account_tokens").SetKeys(false, "hash")
+	pgsql_DbMap.AddTableWithNameAndSchema(Session{}, "hops", "sessions").SetKeys(true, "id")
+	pgsql_DbMap.AddTableWithNameAndSchema(AuditEntry{}, "hops", "admin_audit").SetKeys(true, "id")
 
 	pgsql_DbMap.AddTableWithNameAndSchema(Teleports{}, "hops", "characters_teleports").SetKeys(false, "id")
 	pgsql_DbMap.AddTableWithNameAndSchema(ConsignmentItem{}, "hops", "consign").SetKeys(false, "id")
@@ -129,18 +148,32 @@ func InitPostgreSQL() error {
 		pgsql_DbMap.TraceOn("[gorp]", log.New(os.Stdout, "myapp:", log.Lmicroseconds))
 	}
 
-	if err = resetDB(); err != nil {
+	return migrate()
+}
+
+// LoadTables resets the sessions left by the last run and reads the data
+// tables, once InitPostgreSQL connected.
+func LoadTables() error {
+	if err := resetDB(); err != nil {
 		return err
 	}
 
-	if err = getAll(); err != nil {
+	if err := getAll(); err != nil {
 		return err
 	}
 
-	Init <- err == nil
+	Init <- true
 	return nil
 }
 
+// Ping checks the connection to the database.
+func Ping(ctx context.Context) error {
+	if pgsql_DbMap == nil {
+		return errors.New("not connected yet")
+	}
+	return pgsql_DbMap.Db.PingContext(ctx)
+}
+
 func resetDB() error {
 
 	query := `update hops.characters set is_active = false, is_online = false`
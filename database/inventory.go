@@ -275,6 +275,7 @@ func (slot *InventorySlot) Insert() error {
 	if err != nil {
 		return err
 	}
+	ItemsCreated.Inc()
 
 	InventoryItems.Add(slot.ID, slot)
 	return nil
@@ -313,10 +314,11 @@ func (slot *InventorySlot) Update() error {
 func (slot *InventorySlot) Delete() error {
 
 	InventoryItems.Delete(slot.ID)
-	_, err := pgsql_DbMap.Delete(slot)
+	n, err := pgsql_DbMap.Delete(slot)
 	if err != nil {
 		log.Println(err)
 	}
+	ItemsDestroyed.Add(float64(n))
 
 	return nil
 }
@@ -26,21 +26,11 @@ var (
 		200: {18, 193, 200}, 201: {19, 194, 201}, 202: {25, 195, 202}, 203: {26, 196, 203}, 204: {27, 197, 204}, 205: {29, 198, 205}, 206: {30, 199, 206}, // Normal Maps
 	}
 
-	sharedMaps = []int16{1, 2, 3, 14, 15, 10, 20, 21, 22, 23, 26, 33, 34, 36, 37, 38, 42, 43, 44, 45, 46, 47,
-		70, 72, 73, 74, 75, 89, 100, 101, 102, 109, 110, 111, 112, 120, 164, 165, 166, 167, 168, 169, 170,
-		213, 214, 215, 221, 222, 223, 224, 225, 226, 227, 228, 233, 236, 237, 238, 239, 240, 243, 244, 252, 254, 255, 108, 110, 249}
-
 	DungeonZones = []int16{229}
 
 	PVPServers     = []int16{2, 3}
 	LoseEXPServers = []int16{}
 
-	GMRanks = []int16{2, 3, 4, 5}
-
-	DisabledAIDMaps = []int16{212, 230, 233, 243, 255}
-
-	PvPZones = []int16{12, 17, 100, 101, 102, 108, 109, 110, 111, 112, 255, 230, 249}
-
 	WarMaps = []int{230, 233, 255, 249}
 
 	ZhuangFactionMobs = []int{424203, 424204, 424205, 424206, 424207, 41766, 424201, //great war mobs
@@ -0,0 +1,181 @@
+package database
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"strconv"
+	"time"
+
+	"github.com/twodragon/kore-server/metrics"
+	"github.com/twodragon/kore-server/utils"
+)
+
+// the AI handlers counted by AIGoroutines
+const (
+	AI_HANDLER      = "ai"
+	AI_RIFT_HANDLER = "rift"
+	AI_MOVEMENT     = "movement"
+)
+
+var (
+	PacketsIn  = metrics.NewCounterVec("kore_packets_in_total", "Packets received by opcode, \"unknown\" for the opcodes with no route.", "opcode")
+	PacketsOut = metrics.NewCounterVec("kore_packets_out_total", "Packets sent by opcode.", "opcode")
+
+	dbQueryDuration = metrics.NewHistogramVec("kore_db_query_duration_seconds", "Latency of the database queries, exec or query.",
+		metrics.DefBuckets, "op")
+
+	AIGoroutines = metrics.NewGaugeVec("kore_ai_goroutines", "AI handler goroutines running now, by handler.", "handler")
+
+	// the economy, transfers between players count on both sides
+	GoldCreated    = metrics.NewCounter("kore_gold_created_total", "Gold given to characters.")
+	GoldDestroyed  = metrics.NewCounter("kore_gold_destroyed_total", "Gold taken from characters.")
+	NCashCreated   = metrics.NewCounter("kore_ncash_created_total", "Ncash given to accounts.")
+	NCashDestroyed = metrics.NewCounter("kore_ncash_destroyed_total", "Ncash spent by accounts.")
+	ItemsCreated   = metrics.NewCounter("kore_items_created_total", "Item slots inserted in hops.items_characters.")
+	ItemsDestroyed = metrics.NewCounter("kore_items_destroyed_total", "Item slots deleted from hops.items_characters.")
+)
+
+func init() {
+	metrics.NewCollector("kore_sockets", "Sockets of logged in accounts by server, 0 before a server is selected.", metrics.GAUGE, []string{"server"},
+		func(emit func(float64, ...string)) {
+			counts := make(map[int]int)
+			for _, s := range AllSockets() {
+				server := 0
+				if s.User != nil {
+					server = s.User.ConnectedServer
+				}
+				counts[server]++
+			}
+			for server, n := range counts {
+				emit(float64(n), strconv.Itoa(server))
+			}
+		})
+
+	metrics.NewCollector("kore_characters_online", "Characters in game by server and map.", metrics.GAUGE, []string{"server", "map"},
+		func(emit func(float64, ...string)) {
+			type place struct {
+				server int
+				mapID  int16
+			}
+			counts := make(map[place]int)
+			for _, s := range AllSockets() {
+				if c := s.Character; c != nil && c.IsOnline && s.User != nil {
+					counts[place{s.User.ConnectedServer, c.Map}]++
+				}
+			}
+			for p, n := range counts {
+				emit(float64(n), strconv.Itoa(p.server), strconv.Itoa(int(p.mapID)))
+			}
+		})
+
+	metrics.NewCollector("kore_drops", "Drops on the ground by server.", metrics.GAUGE, []string{"server"},
+		func(emit func(float64, ...string)) {
+			drMutex.RLock()
+			defer drMutex.RUnlock()
+			for server, maps := range DropRegister {
+				n := 0
+				for _, drops := range maps {
+					n += len(drops)
+				}
+				if n > 0 {
+					emit(float64(n), strconv.Itoa(server))
+				}
+			}
+		})
+
+	metrics.NewCollector("kore_framing_errors_total", "Streams dropped for bad framing.", metrics.COUNTER, nil,
+		func(emit func(float64, ...string)) {
+			emit(float64(FramingErrors()))
+		})
+
+	for _, stat := range []struct {
+		name, help, kind string
+		value            func(sql.DBStats) float64
+	}{
+		{"kore_db_connections_open", "Database connections open, in use or idle.", metrics.GAUGE,
+			func(s sql.DBStats) float64 { return float64(s.OpenConnections) }},
+		{"kore_db_connections_in_use", "Database connections in use.", metrics.GAUGE,
+			func(s sql.DBStats) float64 { return float64(s.InUse) }},
+		{"kore_db_connections_idle", "Database connections idle.", metrics.GAUGE,
+			func(s sql.DBStats) float64 { return float64(s.Idle) }},
+		{"kore_db_connections_max_open", "Limit of the database connections open, 0 for none.", metrics.GAUGE,
+			func(s sql.DBStats) float64 { return float64(s.MaxOpenConnections) }},
+		{"kore_db_connection_waits_total", "Times a query waited for a database connection.", metrics.COUNTER,
+			func(s sql.DBStats) float64 { return float64(s.WaitCount) }},
+		{"kore_db_connection_wait_seconds_total", "Time the queries waited for a database connection.", metrics.COUNTER,
+			func(s sql.DBStats) float64 { return s.WaitDuration.Seconds() }},
+	} {
+		value := stat.value
+		metrics.NewCollector(stat.name, stat.help, stat.kind, nil, func(emit func(float64, ...string)) {
+			if pgsql_DbMap != nil {
+				emit(value(pgsql_DbMap.Db.Stats()))
+			}
+		})
+	}
+}
+
+// countPackets counts the packets of data by opcode, data being one or more
+// frames. Many server packets carry a wrong length, frames are split after
+// their trailer instead; a piece not starting with the header, cut by a
+// trailer inside a payload, is not counted.
+func countPackets(counter *metrics.CounterVec, data []byte) {
+	for _, packet := range bytes.SplitAfter(data, FRAME_TRAILER) {
+		if len(packet) < 6 || !bytes.HasPrefix(packet, FRAME_HEADER) {
+			continue
+		}
+		opcode := uint16(utils.BytesToInt(packet[4:6], false))
+		counter.With(strconv.Itoa(int(opcode))).Inc()
+	}
+}
+
+// timedConnector times the queries of the connections of a driver, see
+// dbQueryDuration.
+type timedConnector struct {
+	driver.Connector
+}
+
+// timedConn is a connection of timedConnector.
+type timedConn struct {
+	pqConn
+}
+
+// pqConn are the interfaces of a lib/pq connection.
+type pqConn interface {
+	driver.Conn
+	driver.ConnBeginTx
+	driver.ConnPrepareContext
+	driver.QueryerContext
+	driver.ExecerContext
+	driver.Pinger
+}
+
+func (c timedConnector) Connect(ctx context.Context) (driver.Conn, error) {
+	cn, err := c.Connector.Connect(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if pq, ok := cn.(pqConn); ok {
+		return timedConn{pq}, nil
+	}
+	return cn, nil
+}
+
+func (c timedConn) QueryContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	start := time.Now()
+	rows, err := c.pqConn.QueryContext(ctx, query, args)
+	if err != driver.ErrSkip {
+		dbQueryDuration.With("query").Observe(time.Since(start).Seconds())
+	}
+	return rows, err
+}
+
+func (c timedConn) ExecContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	start := time.Now()
+	result, err := c.pqConn.ExecContext(ctx, query, args)
+	if err != driver.ErrSkip {
+		dbQueryDuration.With("exec").Observe(time.Since(start).Seconds())
+	}
+	return result, err
+}
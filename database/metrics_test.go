@@ -0,0 +1,34 @@
+package database
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/twodragon/kore-server/metrics"
+)
+
+func TestCountPackets(t *testing.T) {
+	counter := metrics.NewCounterVec("test_count_packets_total", "Packets.", "opcode")
+
+	var data []byte
+	data = append(data, 0xAA, 0x55, 0x0A, 0x00, 0x00, 0x2A, 0x01, 0x02, 0x55, 0xAA) // length says 10, body is 2
+	data = append(data, 0xAA, 0x55, 0x09, 0x00, 0x00, 0x2B, 0x01, 0x55, 0xAA)       // length says 9, body is 1
+	data = append(data, 0xAA, 0x55, 0x04, 0x00, 0x00, 0x2A, 0x55, 0xAA, 0x55, 0xAA) // trailer inside the payload
+	countPackets(counter, data)
+
+	var buf bytes.Buffer
+	metrics.WriteTo(&buf)
+	out := buf.String()
+	for _, want := range []string{
+		`test_count_packets_total{opcode="42"} 2`,
+		`test_count_packets_total{opcode="43"} 1`,
+	} {
+		if !strings.Contains(out, want+"\n") {
+			t.Errorf("missing %s in\n%s", want, out)
+		}
+	}
+	if n := strings.Count(out, "test_count_packets_total{"); n != 2 {
+		t.Errorf("%d series, want 2:\n%s", n, out)
+	}
+}
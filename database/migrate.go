@@ -0,0 +1,202 @@
+package database
+
+import (
+	"fmt"
+	"log"
+	"time"
+
+	gorp "gopkg.in/gorp.v1"
+)
+
+// migration changes the schema with query, or with run for what SQL alone
+// can not do safely.
+type migration struct {
+	query string
+	run   func(tx *gorp.Transaction) error
+}
+
+// migrations are run once each, in order, and recorded by their position in
+// hops.schema_migrations: only append to the list. Those before the table
+// existed are safe to run again on a database that already has them.
+var migrations = []migration{
+	{query: `alter table hops.banned_ips add column if not exists reason text not null default ''`},
+	{query: `alter table hops.banned_ips add column if not exists expires_at timestamptz`},
+	{query: `alter table hops.users alter column password type text`}, // argon2id and bcrypt hashes are longer than sha256
+	{query: `alter table hops.users add column if not exists pin text not null default ''`},
+	{query: `alter table hops.users add column if not exists totp_secret text not null default ''`},
+	{query: `alter table hops.users add column if not exists mail_verified boolean not null default false`},
+	{query: `alter table hops.users add column if not exists delete_after timestamptz`},
+	{query: `create table if not exists hops.account_tokens (
+		hash text primary key,
+		kind text not null,
+		user_id text not null,
+		expires_at timestamptz not null
+	)`},
+	{query: `create table if not exists hops.session_tickets (
+		username text primary key,
+		ticket text not null,
+		expires_at timestamptz not null
+	)`},
+	{query: `create table if not exists hops.sanctions (
+		id serial primary key,
+		type text not null,
+		scope text not null,
+		target text not null,
+		map_id smallint not null default 0,
+		issuer text not null,
+		reason text not null default '',
+		starts_at timestamptz not null,
+		ends_at timestamptz,
+		lifted_at timestamptz,
+		lifted_by text not null default ''
+	)`},
+	{query: `alter table hops.characters add column if not exists deleted_at timestamptz`},
+	{query: `create index if not exists sanctions_target on hops.sanctions (scope, target)`},
+	{run: convertLegacyBans},
+	{query: `create table if not exists hops.sessions (
+		id serial primary key,
+		user_id text not null,
+		ip text not null,
+		client_addr text not null default '',
+		server integer not null default 0,
+		characters bigint[] not null default '{}',
+		opened_at timestamptz not null,
+		closed_at timestamptz,
+		close_reason text not null default '',
+		ticket_nonce text not null default ''
+	)`},
+	{query: `create index if not exists sessions_user on hops.sessions (user_id, opened_at)`},
+	{query: `create index if not exists sessions_ip on hops.sessions (ip)`},
+	{query: `alter table hops.sessions add column if not exists country text not null default ''`},
+	{query: `alter table hops.sessions add column if not exists proxy boolean not null default false`},
+	{query: `create table if not exists hops.admin_audit (
+		id serial primary key,
+		issuer text not null,
+		issuer_id text not null,
+		target text not null default '',
+		target_user_id text not null default '',
+		command text not null,
+		arguments text not null default '',
+		changes jsonb not null default '[]',
+		approved_by text not null default '',
+		error text not null default '',
+		created_at timestamptz not null
+	)`},
+	{query: `create index if not exists admin_audit_issuer on hops.admin_audit (issuer_id, created_at)`},
+	{query: `create index if not exists admin_audit_target on hops.admin_audit (target_user_id, created_at)`},
+	// the audit is append only
+	{query: `create or replace rule admin_audit_no_update as on update to hops.admin_audit do instead nothing`},
+	{query: `create or replace rule admin_audit_no_delete as on delete to hops.admin_audit do instead nothing`},
+	// tickets are redeemed by username, ip and server in one statement
+	{query: `alter table hops.session_tickets add column if not exists ip text not null default ''`},
+	{query: `alter table hops.session_tickets add column if not exists server integer not null default 0`},
+	{query: `alter table hops.users add column if not exists totp_step bigint not null default 0`},
+	{run: uniqueUsernames},
+	// the session policy counts online sessions by fingerprint across servers
+	{query: `alter table hops.sessions add column if not exists fingerprint text not null default ''`},
+}
+
+func migrate() error {
+	query := `create table if not exists hops.schema_migrations (version integer primary key, applied_at timestamptz not null)`
+	if _, err := pgsql_DbMap.Exec(query); err != nil {
+		return fmt.Errorf("migrate: %s", err)
+	}
+	applied, err := pgsql_DbMap.SelectInt(`select coalesce(max(version), 0) from hops.schema_migrations`)
+	if err != nil {
+		return fmt.Errorf("migrate: %s", err)
+	}
+
+	for i := int(applied); i < len(migrations); i++ {
+		if err := runMigration(i+1, migrations[i]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// runMigration runs m and records it as version in one transaction.
+func runMigration(version int, m migration) error {
+	tx, err := pgsql_DbMap.Begin()
+	if err != nil {
+		return fmt.Errorf("migrate %d: %s", version, err)
+	}
+	if m.run != nil {
+		err = m.run(tx)
+	} else {
+		_, err = tx.Exec(m.query)
+	}
+	if err == nil {
+		_, err = tx.Exec(`insert into hops.schema_migrations (version, applied_at) values ($1, now())`, version)
+	}
+	if err != nil {
+		tx.Rollback()
+		return fmt.Errorf("migrate %d: %s", version, err)
+	}
+	return tx.Commit()
+}
+
+// uniqueUsernames makes the user names unique in any case. Names already
+// shared by accounts in different cases are logged for an admin to rename
+// them and the index is not created, the account service refusing new ones
+// all the same.
+func uniqueUsernames(tx *gorp.Transaction) error {
+	var shared []string
+	if _, err := tx.Select(&shared, `select lower(user_name) from hops.users group by lower(user_name) having count(*) > 1`); err != nil {
+		return err
+	}
+	if len(shared) > 0 {
+		log.Printf("User names %v are shared by accounts in different cases, users_user_name_lower is not created", shared)
+		return nil
+	}
+	_, err := tx.Exec(`create unique index if not exists users_user_name_lower on hops.users (lower(user_name))`)
+	return err
+}
+
+// legacyDateLayouts are the formats disabled_until was written in.
+var legacyDateLayouts = []string{"2006-01-02 15:04:05", "2006-01-02 15:04:05.999999999", "2006-01-02"}
+
+// convertLegacyBans turns the bans of old, user_type 0 until disabled_until,
+// into sanctions. Nothing lifted the bans whose date has passed, the poller
+// being off, so they stay in force as permanent bans with the date in their
+// reason, as do the bans whose date does not parse.
+func convertLegacyBans(tx *gorp.Transaction) error {
+	var banned []struct {
+		ID            string `db:"id"`
+		DisabledUntil string `db:"disabled_until"`
+	}
+	if _, err := tx.Select(&banned, `select id, coalesce(disabled_until::text, '') as disabled_until from hops.users where user_type = 0`); err != nil {
+		return err
+	}
+
+	now := time.Now()
+	for _, u := range banned {
+		var endsAt interface{}
+		reason := ""
+		parsed := false
+		for _, layout := range legacyDateLayouts {
+			if t, err := time.ParseInLocation(layout, u.DisabledUntil, time.Local); err == nil {
+				parsed = true
+				if t.After(now) {
+					endsAt = t
+				} else {
+					reason = fmt.Sprintf("legacy ban until %s, never lifted", u.DisabledUntil)
+				}
+				break
+			}
+		}
+		if !parsed {
+			reason = fmt.Sprintf("legacy ban until %q, not a date", u.DisabledUntil)
+		}
+		if endsAt == nil {
+			log.Printf("Legacy ban of user %s: %s, the ban is permanent", u.ID, reason)
+		}
+		query := `insert into hops.sanctions (type, scope, target, issuer, reason, starts_at, ends_at) values ('ban', 'account', $1, 'legacy', $2, now(), $3)`
+		if _, err := tx.Exec(query, u.ID, reason, endsAt); err != nil {
+			return err
+		}
+		if _, err := tx.Exec(`update hops.users set user_type = 1 where id = $1`, u.ID); err != nil {
+			return err
+		}
+	}
+	return nil
+}
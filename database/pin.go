@@ -0,0 +1,179 @@
+package database
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/twodragon/kore-server/logging"
+	"github.com/twodragon/kore-server/passwd"
+	"github.com/twodragon/kore-server/utils"
+)
+
+const (
+	PIN_MIN_LENGTH = 4
+	PIN_MAX_LENGTH = 8
+)
+
+var (
+	ErrBadPIN    = fmt.Errorf("PIN must be %d to %d digits", PIN_MIN_LENGTH, PIN_MAX_LENGTH)
+	ErrWrongPIN  = errors.New("wrong PIN")
+	ErrPINLocked = errors.New("PIN locked, try again later")
+
+	pinLocks     = make(map[string]*pinLock) // by user ID
+	pinLockMutex sync.Mutex
+)
+
+type pinLock struct {
+	failures int
+	until    time.Time
+}
+
+// HasPIN reports whether the account asks for a secondary PIN, or for a
+// TOTP code when an authenticator is set up instead.
+func (u *User) HasPIN() bool {
+	return u.PIN != "" || u.TOTPSecret != ""
+}
+
+func (u *User) SetPIN(pin string) error {
+	if len(pin) < PIN_MIN_LENGTH || len(pin) > PIN_MAX_LENGTH {
+		return ErrBadPIN
+	}
+	for _, r := range pin {
+		if r < '0' || r > '9' {
+			return ErrBadPIN
+		}
+	}
+
+	hash, err := passwd.Hash(pin)
+	if err != nil {
+		return err
+	}
+	u.PIN = hash
+	return u.Update()
+}
+
+// SetTOTPSecret turns the authenticator on, step being the step of the code
+// that confirmed it.
+func (u *User) SetTOTPSecret(secret string, step int64) error {
+	sealed, err := passwd.SealTOTPSecret(secret)
+	if err != nil {
+		return err
+	}
+	u.TOTPSecret = sealed
+	u.TOTPStep = step
+	return u.Update()
+}
+
+// ResetPIN removes the PIN and the authenticator of the account, for a GM
+// helping a player who lost them.
+func (u *User) ResetPIN() error {
+	u.PIN = ""
+	u.TOTPSecret = ""
+	u.TOTPStep = 0
+
+	pinLockMutex.Lock()
+	delete(pinLocks, u.ID)
+	pinLockMutex.Unlock()
+	return u.Update()
+}
+
+// VerifyPIN checks code against the PIN, or the authenticator, of the socket
+// user and unlocks the socket. Too many failures lock the PIN for a while.
+func (s *Socket) VerifyPIN(code string) error {
+	u := s.User
+	if u == nil {
+		return ErrWrongPIN
+	}
+	if !u.HasPIN() {
+		s.pinVerifiedAt = time.Now()
+		return nil
+	}
+
+	pinLockMutex.Lock()
+	lock, ok := pinLocks[u.ID]
+	if !ok {
+		lock = &pinLock{}
+		pinLocks[u.ID] = lock
+	}
+	locked := lock.until.After(time.Now())
+	pinLockMutex.Unlock()
+	if locked {
+		return ErrPINLocked
+	}
+
+	var valid bool
+	var step int64
+	var secret string
+	sealed := true
+	if u.TOTPSecret != "" {
+		var err error
+		secret, sealed, err = passwd.OpenTOTPSecret(u.TOTPSecret)
+		if err != nil {
+			return err
+		}
+		step, valid = passwd.VerifyTOTP(secret, code, time.Now(), u.TOTPStep)
+	} else {
+		valid, _, _ = passwd.Verify(code, u.PIN)
+	}
+
+	pinLockMutex.Lock()
+	defer pinLockMutex.Unlock()
+	if valid && step != 0 && step <= u.TOTPStep { // the same code entered twice at once
+		valid = false
+	}
+	if valid {
+		delete(pinLocks, u.ID)
+		s.pinVerifiedAt = time.Now()
+		if step != 0 {
+			u.TOTPStep = step
+			if !sealed { // stored before secrets were sealed
+				if resealed, err := passwd.SealTOTPSecret(secret); err == nil {
+					u.TOTPSecret = resealed
+				}
+			}
+			go u.Update()
+		}
+		return nil
+	}
+
+	lock.failures++
+	if max := cfg.Auth.PINMaxFailures; max > 0 && lock.failures >= max {
+		duration := time.Duration(cfg.Auth.PINLockDuration) * time.Minute
+		lock.until = time.Now().Add(duration)
+		lock.failures = 0
+
+		text := fmt.Sprintf("PIN of %s locked for %s after %d failures, last from %s", u.Username, duration, max, s.ClientAddr)
+		logger.Log(logging.ACTION_LOGIN, 0, text, u.ID)
+		utils.NewLog("logs/login_locks.txt", text)
+		return ErrPINLocked
+	}
+	return ErrWrongPIN
+}
+
+// PINAtMenu reports whether the character menu asks for the PIN before
+// selecting, deleting and restoring characters. The stock client has no PIN
+// entry, the PIN then guards bank withdrawals and trades only, entered in
+// game with /pin.
+func PINAtMenu() bool {
+	return cfg.Auth.PINClient
+}
+
+// PINUnlocked reports whether the socket may pick a character. Without a PIN
+// entry in the menu a character is picked before the PIN is asked, the PIN
+// then guards deleting and restoring characters, the bank and trades, see
+// PINFresh.
+func (s *Socket) PINUnlocked() bool {
+	return s.User != nil && (!PINAtMenu() || !s.User.HasPIN() || !s.pinVerifiedAt.IsZero())
+}
+
+// PINFresh reports whether the PIN was entered recently enough for deleting
+// characters, withdrawing from the bank and trading.
+func (s *Socket) PINFresh() bool {
+	if s.User == nil {
+		return false
+	}
+	grace := time.Duration(cfg.Auth.PINGrace) * time.Second
+	return !s.User.HasPIN() || time.Since(s.pinVerifiedAt) < grace
+}
@@ -0,0 +1,156 @@
+package database
+
+import (
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/twodragon/kore-server/utils"
+)
+
+// rates SetRate changes
+const (
+	RATE_GOLD = "gold"
+	RATE_EXP  = "exp"
+	RATE_DROP = "drop"
+)
+
+// Rate is a rate of the game as it is now.
+type Rate struct {
+	Value       float64 `json:"value"`
+	Default     float64 `json:"default"`
+	SecondsLeft int64   `json:"seconds_left"` // until it is back to its default
+}
+
+var (
+	rateGenerations = make(map[string]int) // the latest SetRate of each rate
+	ratesMutex      sync.Mutex
+
+	// the upgrade happy hour, a bonus over str_happy_hour_rate of the tuning
+	happyHourBonus      float64
+	happyHourGeneration int
+)
+
+func rateVars(kind string) (rate *float64, seconds *int64, defaultRate *float64, ok bool) {
+	switch kind {
+	case RATE_GOLD:
+		return &GOLD_RATE, &GOLD_RATE_TIME, &DEFAULT_GOLD_RATE, true
+	case RATE_EXP:
+		return &EXP_RATE, &EXP_RATE_TIME, &DEFAULT_EXP_RATE, true
+	case RATE_DROP:
+		return &DROP_RATE, &DROP_RATE_TIME, &DEFAULT_DROP_RATE, true
+	}
+	return nil, nil, nil, false
+}
+
+// SetRate sets a rate for d, then back to its default. A later SetRate of
+// the same rate replaces the earlier one.
+func SetRate(kind string, value float64, d time.Duration) error {
+	rate, seconds, defaultRate, ok := rateVars(kind)
+	if !ok {
+		return fmt.Errorf("rate %s not found", kind)
+	}
+
+	ratesMutex.Lock()
+	rateGenerations[kind]++
+	generation := rateGenerations[kind]
+	*rate = value
+	*seconds = int64(d / time.Second)
+	ratesMutex.Unlock()
+
+	go func() {
+		for {
+			time.Sleep(time.Second)
+			ratesMutex.Lock()
+			if rateGenerations[kind] != generation {
+				ratesMutex.Unlock()
+				return
+			}
+			*seconds--
+			if *seconds <= 0 {
+				*rate = *defaultRate
+				ratesMutex.Unlock()
+				return
+			}
+			ratesMutex.Unlock()
+		}
+	}()
+	return nil
+}
+
+// setDefaultRate changes the default of a rate, and the rate itself unless a
+// SetRate is counting down for it.
+func setDefaultRate(kind string, value float64) {
+	rate, seconds, defaultRate, _ := rateVars(kind)
+
+	ratesMutex.Lock()
+	defer ratesMutex.Unlock()
+	if *seconds <= 0 {
+		*rate = value
+	}
+	*defaultRate = value
+}
+
+// StartHappyHour adds bonus to the upgrade rates for d, then calls end. The
+// happy hour is a temporary overlay over the tuning, it is not saved and a
+// tuning reload leaves it alone.
+func StartHappyHour(bonus float64, d time.Duration, end func()) {
+	ratesMutex.Lock()
+	happyHourGeneration++
+	generation := happyHourGeneration
+	happyHourBonus = bonus
+	ratesMutex.Unlock()
+
+	text := fmt.Sprintf("Upgrade happy hour started: +%v for %s", bonus, d)
+	log.Print(text)
+	utils.NewLog("logs/tuning_changes.txt", text)
+
+	time.AfterFunc(d, func() {
+		ratesMutex.Lock()
+		ended := happyHourGeneration == generation && happyHourBonus != 0
+		if ended {
+			happyHourBonus = 0
+		}
+		ratesMutex.Unlock()
+
+		if ended && end != nil {
+			utils.NewLog("logs/tuning_changes.txt", "Upgrade happy hour ended")
+			end()
+		}
+	})
+}
+
+// StopHappyHour ends the happy hour, if one is running.
+func StopHappyHour() bool {
+	ratesMutex.Lock()
+	running := happyHourBonus != 0
+	happyHourBonus = 0
+	ratesMutex.Unlock()
+
+	if running {
+		utils.NewLog("logs/tuning_changes.txt", "Upgrade happy hour stopped")
+	}
+	return running
+}
+
+// STRHappyHourRate returns the upgrade bonus in effect, the tuned one and the
+// one of a running happy hour.
+func STRHappyHourRate() float64 {
+	ratesMutex.Lock()
+	defer ratesMutex.Unlock()
+	return Tuned().STRHappyHourRate + happyHourBonus
+}
+
+// Rates returns the gold, exp and drop rates by name.
+func Rates() map[string]Rate {
+	ratesMutex.Lock()
+	defer ratesMutex.Unlock()
+
+	rates := make(map[string]Rate)
+	for _, kind := range []string{RATE_GOLD, RATE_EXP, RATE_DROP} {
+		rate, seconds, defaultRate, _ := rateVars(kind)
+		rates[kind] = Rate{Value: *rate, Default: *defaultRate, SecondsLeft: *seconds}
+	}
+	return rates
+}
@@ -0,0 +1,387 @@
+package database
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/lib/pq"
+	"github.com/twodragon/kore-server/messaging"
+	"github.com/twodragon/kore-server/utils"
+	null "gopkg.in/guregu/null.v3"
+)
+
+const (
+	SANCTION_BAN         = "ban"
+	SANCTION_MUTE        = "mute"
+	SANCTION_JAIL        = "jail"
+	SANCTION_TRADE_BLOCK = "trade_block"
+
+	SCOPE_ACCOUNT   = "account"
+	SCOPE_CHARACTER = "character"
+	SCOPE_IP        = "ip"
+
+	JAIL_RELEASE_MAP = 1 // where jailed characters go when they are released
+)
+
+var (
+	SANCTION_POLL = 30 * time.Second
+
+	ErrSanctionNotActive = errors.New("not active")
+
+	activeSanctions = make(map[int]*Sanction)
+	sanctionsMutex  sync.RWMutex
+)
+
+// Sanction is a ban, mute, jail or trade block of an account, a character or
+// an IP. Sanctions are never deleted, lifting one keeps it as history.
+type Sanction struct {
+	ID       int       `db:"id" json:"id"`
+	Type     string    `db:"type" json:"type"`
+	Scope    string    `db:"scope" json:"scope"`
+	Target   string    `db:"target" json:"target"` // user ID, character ID or IP
+	MapID    int16     `db:"map_id" json:"map_id"` // of a jail
+	Issuer   string    `db:"issuer" json:"issuer"`
+	Reason   string    `db:"reason" json:"reason"`
+	StartsAt time.Time `db:"starts_at" json:"starts_at"`
+	EndsAt   null.Time `db:"ends_at" json:"ends_at"` // null for a permanent sanction
+	LiftedAt null.Time `db:"lifted_at" json:"lifted_at"`
+	LiftedBy string    `db:"lifted_by" json:"lifted_by"` // "expired" when it ran out
+}
+
+func (s *Sanction) Create() error {
+	return pgsql_DbMap.Insert(s)
+}
+
+func (s *Sanction) Update() error {
+	_, err := pgsql_DbMap.Update(s)
+	return err
+}
+
+func (s *Sanction) Active(now time.Time) bool {
+	return !s.LiftedAt.Valid && (!s.EndsAt.Valid || now.Before(s.EndsAt.Time))
+}
+
+// Until is the end of the sanction as players see it.
+func (s *Sanction) Until() string {
+	if !s.EndsAt.Valid {
+		return "further notice"
+	}
+	return s.EndsAt.Time.Format("2006-01-02 15:04:05")
+}
+
+func (s *Sanction) String() string {
+	text := fmt.Sprintf("#%d %s of %s %s by %s until %s", s.ID, s.Type, s.Scope, s.Target, s.Issuer, s.Until())
+	if s.Reason != "" {
+		text += ": " + s.Reason
+	}
+	if s.LiftedAt.Valid {
+		text += fmt.Sprintf(" (lifted by %s at %s)", s.LiftedBy, s.LiftedAt.Time.Format("2006-01-02 15:04"))
+	}
+	return text
+}
+
+func (s *Sanction) matches(userID string, characterID int, ip string) bool {
+	switch s.Scope {
+	case SCOPE_ACCOUNT:
+		return userID != "" && s.Target == userID
+	case SCOPE_CHARACTER:
+		return characterID != 0 && s.Target == strconv.Itoa(characterID)
+	case SCOPE_IP:
+		return ip != "" && s.Target == ip
+	}
+	return false
+}
+
+// ActiveSanction returns the active sanction of kind on the account, the
+// character or the IP, the longest one if there are several. Empty arguments
+// are not checked.
+func ActiveSanction(kind, userID string, characterID int, ip string) *Sanction {
+	sanctionsMutex.RLock()
+	defer sanctionsMutex.RUnlock()
+
+	now := time.Now()
+	var found *Sanction
+	for _, s := range activeSanctions {
+		if s.Type != kind || !s.Active(now) || !s.matches(userID, characterID, ip) {
+			continue
+		}
+		if found == nil || !s.EndsAt.Valid || (found.EndsAt.Valid && s.EndsAt.Time.After(found.EndsAt.Time)) {
+			found = s
+		}
+	}
+	return found
+}
+
+// Sanction returns the active sanction of kind on the account, character or
+// IP of the socket.
+func (s *Socket) Sanction(kind string) *Sanction {
+	var userID string
+	var characterID int
+	if s.User != nil {
+		userID = s.User.ID
+	}
+	if s.Character != nil {
+		characterID = s.Character.ID
+	}
+	return ActiveSanction(kind, userID, characterID, s.ClientIP())
+}
+
+// ParseSanctionDuration reads 30m, 12h or 7d, a plain number being hours.
+// 0 and perm are forever.
+func ParseSanctionDuration(text string) (time.Duration, error) {
+	text = strings.ToLower(text)
+	if text == "perm" || text == "0" {
+		return 0, nil
+	}
+	if n, err := strconv.Atoi(text); err == nil && n > 0 {
+		return time.Duration(n) * time.Hour, nil
+	}
+	if strings.HasSuffix(text, "d") {
+		n, err := strconv.Atoi(strings.TrimSuffix(text, "d"))
+		if err != nil || n <= 0 {
+			return 0, fmt.Errorf("bad duration %s", text)
+		}
+		return time.Duration(n) * 24 * time.Hour, nil
+	}
+	d, err := time.ParseDuration(text)
+	if err != nil || d <= 0 {
+		return 0, fmt.Errorf("bad duration %s", text)
+	}
+	return d, nil
+}
+
+// SanctionTarget resolves a character name to the target of scope. IP
+// scopes take an IP as well.
+func SanctionTarget(scope, name string) (string, error) {
+	if scope == SCOPE_IP && net.ParseIP(name) != nil {
+		return name, nil
+	}
+
+	c, err := FindCharacterByName(name)
+	if err != nil {
+		return "", err
+	}
+	if c == nil {
+		return "", fmt.Errorf("character %s not found", name)
+	}
+
+	switch scope {
+	case SCOPE_ACCOUNT:
+		return c.UserID, nil
+	case SCOPE_CHARACTER:
+		return strconv.Itoa(c.ID), nil
+	}
+
+	sock := GetSocket(c.UserID)
+	if sock == nil {
+		return "", fmt.Errorf("%s is not online, ban the IP itself", name)
+	}
+	return sock.ClientIP(), nil
+}
+
+// IssueSanction issues a sanction for d, forever if d is 0, and applies it to
+// the players online.
+func IssueSanction(kind, scope, target string, mapID int16, d time.Duration, issuer, reason string) (*Sanction, error) {
+	s := &Sanction{Type: kind, Scope: scope, Target: target, MapID: mapID, Issuer: issuer, Reason: reason, StartsAt: time.Now()}
+	if d > 0 {
+		s.EndsAt = null.TimeFrom(s.StartsAt.Add(d))
+	}
+	if err := s.Create(); err != nil {
+		return nil, err
+	}
+
+	sanctionsMutex.Lock()
+	activeSanctions[s.ID] = s
+	sanctionsMutex.Unlock()
+
+	logSanction(fmt.Sprintf("Issued %s", s))
+	s.apply()
+	return s, nil
+}
+
+// LiftSanction ends the sanction with id before its time.
+func LiftSanction(id int, who string) (*Sanction, error) {
+	sanctionsMutex.Lock()
+	s, ok := activeSanctions[id]
+	delete(activeSanctions, id)
+	sanctionsMutex.Unlock()
+
+	if !ok {
+		return nil, fmt.Errorf("sanction #%d is %w", id, ErrSanctionNotActive)
+	}
+
+	s.LiftedAt = null.TimeFrom(time.Now())
+	s.LiftedBy = who
+	if err := s.Update(); err != nil {
+		return nil, err
+	}
+
+	logSanction(fmt.Sprintf("Lifted %s", s))
+	s.release()
+	return s, nil
+}
+
+// FindSanctions returns every sanction of the account and its characters,
+// the newest first.
+func FindSanctions(userID string) ([]*Sanction, error) {
+	characters, err := FindCharactersByUserID(userID)
+	if err != nil {
+		return nil, err
+	}
+	ids := make([]string, 0, len(characters))
+	for _, c := range characters {
+		ids = append(ids, strconv.Itoa(c.ID))
+	}
+
+	var sanctions []*Sanction
+	query := `select * from hops.sanctions where (scope = 'account' and target = $1) or (scope = 'character' and target = any($2)) order by starts_at desc`
+	if _, err := pgsql_DbMap.Select(&sanctions, query, userID, pq.Array(ids)); err != nil {
+		return nil, fmt.Errorf("FindSanctions: %s", err)
+	}
+	return sanctions, nil
+}
+
+// FindSanctionsByIP returns every sanction of the IP, the newest first.
+func FindSanctionsByIP(ip string) ([]*Sanction, error) {
+	var sanctions []*Sanction
+	query := `select * from hops.sanctions where scope = 'ip' and target = $1 order by starts_at desc`
+	if _, err := pgsql_DbMap.Select(&sanctions, query, ip); err != nil {
+		return nil, fmt.Errorf("FindSanctionsByIP: %s", err)
+	}
+	return sanctions, nil
+}
+
+// InitSanctions loads the active sanctions and starts lifting the expired
+// ones. Sanctions issued by other processes show up within SANCTION_POLL.
+func InitSanctions() error {
+	if err := refreshSanctions(); err != nil {
+		return err
+	}
+
+	go func() {
+		for range time.Tick(SANCTION_POLL) {
+			if err := refreshSanctions(); err != nil {
+				log.Print(err)
+			}
+		}
+	}()
+	return nil
+}
+
+func refreshSanctions() error {
+	var expired []*Sanction
+	query := `update hops.sanctions set lifted_at = now(), lifted_by = 'expired' where lifted_at is null and ends_at <= now() returning *`
+	if _, err := pgsql_DbMap.Select(&expired, query); err != nil {
+		return fmt.Errorf("refreshSanctions: %s", err)
+	}
+	for _, s := range expired {
+		logSanction(fmt.Sprintf("Expired %s", s))
+	}
+
+	var active []*Sanction
+	query = `select * from hops.sanctions where lifted_at is null`
+	if _, err := pgsql_DbMap.Select(&active, query); err != nil {
+		return fmt.Errorf("refreshSanctions: %s", err)
+	}
+
+	loaded := make(map[int]*Sanction, len(active))
+	for _, s := range active {
+		loaded[s.ID] = s
+	}
+
+	sanctionsMutex.Lock()
+	old := activeSanctions
+	activeSanctions = loaded
+	sanctionsMutex.Unlock()
+
+	for id, s := range old {
+		if _, ok := loaded[id]; !ok {
+			s.release()
+		}
+	}
+	for id, s := range loaded {
+		if _, ok := old[id]; !ok {
+			s.apply() // issued by another process
+		}
+	}
+	return nil
+}
+
+// sockets returns the online sockets the sanction is on.
+func (s *Sanction) sockets() []*Socket {
+	var found []*Socket
+	for _, sock := range AllSockets() {
+		var characterID int
+		if sock.Character != nil {
+			characterID = sock.Character.ID
+		}
+		userID := ""
+		if sock.User != nil {
+			userID = sock.User.ID
+		}
+		if s.matches(userID, characterID, sock.ClientIP()) {
+			found = append(found, sock)
+		}
+	}
+	return found
+}
+
+// apply makes the sanction felt by the players online.
+func (s *Sanction) apply() {
+	for _, sock := range s.sockets() {
+		switch s.Type {
+		case SANCTION_BAN:
+			sock.SetCloseReason(SESSION_BANNED)
+			sock.Conn.Close()
+
+		case SANCTION_MUTE:
+			sock.Write(messaging.InfoMessage(fmt.Sprintf("You are muted until %s.", s.Until())))
+
+		case SANCTION_JAIL:
+			if c := sock.Character; c != nil && c.IsOnline {
+				if c.TradeID != "" {
+					c.CancelTrade()
+				}
+				if data, err := c.ChangeMap(s.MapID, nil); err == nil {
+					sock.Write(data)
+				}
+				sock.Write(messaging.InfoMessage(fmt.Sprintf("You are jailed until %s.", s.Until())))
+			}
+
+		case SANCTION_TRADE_BLOCK:
+			if c := sock.Character; c != nil && c.TradeID != "" {
+				c.CancelTrade()
+			}
+			sock.Write(messaging.InfoMessage(fmt.Sprintf("Trading is blocked until %s.", s.Until())))
+		}
+	}
+}
+
+// release undoes what apply did where it matters, jailed characters are
+// taken out of the jail map.
+func (s *Sanction) release() {
+	if s.Type != SANCTION_JAIL {
+		return
+	}
+	for _, sock := range s.sockets() {
+		c := sock.Character
+		if c == nil || !c.IsOnline || c.Map != s.MapID || sock.Sanction(SANCTION_JAIL) != nil {
+			continue
+		}
+		if data, err := c.ChangeMap(JAIL_RELEASE_MAP, nil); err == nil {
+			sock.Write(data)
+		}
+		sock.Write(messaging.InfoMessage("You have been released from jail."))
+	}
+}
+
+func logSanction(text string) {
+	log.Print(text)
+	utils.NewLog("logs/sanctions.txt", text)
+}
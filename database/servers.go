@@ -4,9 +4,11 @@ import (
 	"database/sql"
 	"fmt"
 	"log"
+	"sync"
 	"time"
 
 	"github.com/thoas/go-funk"
+	"github.com/twodragon/kore-server/health"
 	gorp "gopkg.in/gorp.v1"
 )
 
@@ -122,6 +124,21 @@ func EpochHandler() {
 	}
 	time.AfterFunc(time.Second, EpochHandler)
 }
+
+// StartWatchdog beats health.GAME_LOOP every second once it got the locks of
+// the sockets, characters and drops, so that a deadlock among them fails the
+// liveness of the server.
+func StartWatchdog() {
+	go func() {
+		for range time.Tick(time.Second) {
+			for _, m := range []*sync.RWMutex{&socketMutex, &characterMutex, &drMutex} {
+				m.RLock()
+				m.RUnlock()
+			}
+			health.Beat(health.GAME_LOOP)
+		}
+	}()
+}
 func GetServerEpoch() int64 {
 	server, err := GetServerByID("1")
 	if err != nil {
@@ -0,0 +1,191 @@
+package database
+
+import (
+	"fmt"
+	"log"
+	"sort"
+	"time"
+
+	"github.com/thoas/go-funk"
+	"github.com/twodragon/kore-server/utils"
+)
+
+const (
+	POLICY_KICK   = "kick"
+	POLICY_REFUSE = "refuse"
+
+	EVENT_FACTION_WAR   = "faction war"
+	EVENT_GREAT_WAR     = "great war"
+	EVENT_FIVE_CLAN_WAR = "five clan war"
+)
+
+// Decision is what the session policy made of a login or of a character
+// joining an event.
+type Decision struct {
+	Allow  bool
+	Kick   []*Socket // sessions to close before the new one goes on
+	Reason string
+}
+
+func allow(reason string) Decision {
+	return Decision{Allow: true, Reason: reason}
+}
+
+func refuse(format string, args ...interface{}) Decision {
+	return Decision{Reason: fmt.Sprintf(format, args...)}
+}
+
+// exempt tells if the policy leaves the user alone.
+func exempt(u *User) bool {
+	return cfg.Sessions.ExemptGMs && u != nil && funk.Contains(Tuned().GMRanks, int16(u.UserType))
+}
+
+// DecideLogin applies the session policy to user logging in on s: another
+// session of the account, and the accounts online from the same IP or
+// hardware fingerprint. The limits count the sessions open in hops.sessions,
+// on every server sharing the database, but only those of this process can
+// be kicked: over a limit held by other servers the login is refused. The
+// fingerprint is sent by the client and advisory, a modified client sends
+// any it likes.
+func DecideLogin(user *User, s *Socket) Decision {
+	d := decideLogin(user, s)
+	logDecision(fmt.Sprintf("Login of %s(%s) from %s fp=%q", user.Username, user.ID, s.ClientIP(), s.Fingerprint), d)
+	return d
+}
+
+func decideLogin(user *User, s *Socket) Decision {
+	policy := cfg.Sessions
+	d := allow("within limits")
+	if exempt(user) {
+		return allow("GM exempt")
+	}
+
+	if old := GetSocket(user.ID); old != nil && old != s {
+		if policy.OnDuplicate == POLICY_REFUSE {
+			return refuse("account already online")
+		}
+		d.Kick = append(d.Kick, old)
+		d.Reason = "account already online, old session kicked"
+	}
+
+	others := make([]*Socket, 0)
+	for _, other := range AllSockets() {
+		if other == s || other.User == nil || other.User.ID == user.ID || exempt(other.User) {
+			continue
+		}
+		others = append(others, other)
+	}
+
+	limits := []struct {
+		what  string
+		value string
+		max   int
+		same  func(*Socket) bool
+	}{
+		{"IP", s.ClientIP(), policy.MaxPerIP, func(o *Socket) bool { return o.ClientIP() == s.ClientIP() }},
+		{"fingerprint", s.Fingerprint, policy.MaxPerFingerprint, func(o *Socket) bool { return o.Fingerprint == s.Fingerprint }},
+	}
+	for _, l := range limits {
+		if l.max <= 0 || l.value == "" {
+			continue
+		}
+
+		var same []*Socket
+		for _, o := range others {
+			if l.same(o) && !funk.Contains(d.Kick, o) {
+				same = append(same, o)
+			}
+		}
+		online := len(same)
+		if n, err := onlineAccounts(l.what, l.value, user.ID); err != nil {
+			log.Print(err) // the sessions of this process still count
+		} else {
+			for _, k := range d.Kick {
+				if k.User != nil && k.User.ID != user.ID && l.same(k) {
+					n-- // kicked for the other limit
+				}
+			}
+			if n > online {
+				online = n
+			}
+		}
+		if online < l.max {
+			continue
+		}
+
+		if policy.OnLimit == POLICY_REFUSE {
+			return refuse("%d accounts online from %s %s, limit %d", online, l.what, l.value, l.max)
+		}
+		kick := online - l.max + 1
+		if kick > len(same) {
+			return refuse("%d accounts online from %s %s, limit %d, on other servers", online, l.what, l.value, l.max)
+		}
+		sort.Slice(same, func(i, j int) bool { return lastLogin(same[i]).Before(lastLogin(same[j])) })
+		d.Kick = append(d.Kick, same[:kick]...)
+		d.Reason = fmt.Sprintf("%d accounts online from %s %s, limit %d, oldest kicked", online, l.what, l.value, l.max)
+	}
+	return d
+}
+
+func lastLogin(s *Socket) time.Time {
+	t, _ := time.ParseInLocation("2006-01-02 15:04:05", s.User.LastLogin, time.Local)
+	return t
+}
+
+// DecideEventEntry applies the multiboxing rule of the events to c joining
+// event, where the participants already are.
+func DecideEventEntry(c *Character, event string, participants []*Character) Decision {
+	d := decideEventEntry(c, participants)
+	logDecision(fmt.Sprintf("%s entry of %s(%d)", event, c.Name, c.ID), d)
+	return d
+}
+
+func decideEventEntry(c *Character, participants []*Character) Decision {
+	policy := cfg.Sessions
+	s := c.Socket
+	if s == nil {
+		return refuse("not online")
+	}
+	if exempt(s.User) {
+		return allow("GM exempt")
+	}
+
+	var sameIP, sameFingerprint int
+	for _, p := range participants {
+		if p == nil || p.ID == c.ID || p.Socket == nil || !p.IsOnline || exempt(p.Socket.User) {
+			continue
+		}
+		if p.Socket.ClientIP() == s.ClientIP() {
+			sameIP++
+		}
+		if s.Fingerprint != "" && p.Socket.Fingerprint == s.Fingerprint {
+			sameFingerprint++
+		}
+	}
+
+	if policy.EventMaxPerIP > 0 && sameIP >= policy.EventMaxPerIP {
+		return refuse("%d characters in from IP %s, limit %d", sameIP, s.ClientIP(), policy.EventMaxPerIP)
+	}
+	if policy.EventMaxPerFingerprint > 0 && sameFingerprint >= policy.EventMaxPerFingerprint {
+		return refuse("%d characters in from fingerprint %q, limit %d", sameFingerprint, s.Fingerprint, policy.EventMaxPerFingerprint)
+	}
+	return allow("within limits")
+}
+
+func logDecision(what string, d Decision) {
+	verdict := "allowed"
+	if !d.Allow {
+		verdict = "refused"
+	}
+	text := fmt.Sprintf("%s %s: %s", what, verdict, d.Reason)
+	for _, k := range d.Kick {
+		if k.User != nil {
+			text += fmt.Sprintf(", kicks %s(%s)", k.User.Username, k.User.ID)
+		}
+	}
+
+	if !d.Allow || len(d.Kick) > 0 {
+		log.Print(text)
+	}
+	utils.NewLog("logs/session_policy.txt", text)
+}
@@ -0,0 +1,75 @@
+package database
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/twodragon/kore-server/config"
+)
+
+// useSessions starts the test with the policy and the accounts online on
+// the other servers given, and no socket registered.
+func useSessions(t *testing.T, policy func(p *config.Sessions), elsewhere int, err error) {
+	t.Helper()
+	savedCfg, savedOnline := *cfg, onlineAccounts
+	socketMutex.Lock()
+	savedSockets := Sockets
+	Sockets = make(map[string]*Socket)
+	socketMutex.Unlock()
+	t.Cleanup(func() {
+		*cfg, onlineAccounts = savedCfg, savedOnline
+		socketMutex.Lock()
+		Sockets = savedSockets
+		socketMutex.Unlock()
+	})
+
+	policy(&cfg.Sessions)
+	onlineAccounts = func(by, value, userID string) (int, error) {
+		local := 0
+		for _, s := range AllSockets() {
+			if s.User.ID != userID && s.ClientIP() == value {
+				local++
+			}
+		}
+		return local + elsewhere, err
+	}
+}
+
+func online(id, lastLogin string) *Socket {
+	s := &Socket{ClientAddr: "10.0.0.1:5000", User: &User{ID: id, Username: id, LastLogin: lastLogin}}
+	s.Add(id)
+	return s
+}
+
+func TestDecideLoginCountsOtherServers(t *testing.T) {
+	for _, test := range []struct {
+		name      string
+		onLimit   string
+		elsewhere int
+		err       error
+		allow     bool
+		kick      int
+	}{
+		{"within limits", POLICY_KICK, 0, nil, true, 0},
+		{"oldest local kicked", POLICY_KICK, 2, nil, true, 2},
+		{"held by other servers", POLICY_KICK, 3, nil, false, 0},
+		{"refused over the limit", POLICY_REFUSE, 1, nil, false, 0},
+		{"database down", POLICY_KICK, 5, errors.New("down"), true, 0},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			useSessions(t, func(p *config.Sessions) {
+				p.MaxPerIP, p.OnLimit, p.ExemptGMs = 3, test.onLimit, false
+			}, test.elsewhere, test.err)
+			oldest := online("a", "2026-01-01 10:00:00")
+			online("b", "2026-01-02 10:00:00")
+
+			d := decideLogin(&User{ID: "new"}, &Socket{ClientAddr: "10.0.0.1:6000"})
+			if d.Allow != test.allow || len(d.Kick) != test.kick {
+				t.Fatalf("got allow %v kick %d (%s), want allow %v kick %d", d.Allow, len(d.Kick), d.Reason, test.allow, test.kick)
+			}
+			if test.kick > 0 && d.Kick[0] != oldest {
+				t.Errorf("kicked %s first, want the oldest", d.Kick[0].User.ID)
+			}
+		})
+	}
+}
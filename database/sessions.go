@@ -0,0 +1,220 @@
+package database
+
+import (
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/lib/pq"
+	null "gopkg.in/guregu/null.v3"
+)
+
+// reasons a session ended
+const (
+	SESSION_QUIT           = "quit"
+	SESSION_DISCONNECTED   = "disconnected"
+	SESSION_RATE_KICK      = "rate_kick"
+	SESSION_SLOW_CLIENT    = "slow_client"
+	SESSION_DUPLICATE      = "duplicate_login"
+	SESSION_LIMIT          = "session_limit"
+	SESSION_BANNED         = "banned"
+	SESSION_KICKED         = "kicked"
+	SESSION_ERROR          = "error"
+	SESSION_SHUTDOWN       = "shutdown"
+	SESSION_TICKET_EXPIRED = "ticket_expired"
+	SESSION_SERVER_RESTART = "server_restart"
+)
+
+// Session is an account from its login to its disconnection, across the
+// handoff from the login server to a game server.
+type Session struct {
+	ID          int           `db:"id" json:"id"`
+	UserID      string        `db:"user_id" json:"user_id"`
+	IP          string        `db:"ip" json:"ip"`
+	ClientAddr  string        `db:"client_addr" json:"client_addr"`
+	Fingerprint string        `db:"fingerprint" json:"fingerprint"` // sent by the client, advisory
+	Country     string        `db:"country" json:"country"`
+	Proxy       bool          `db:"proxy" json:"proxy"`
+	Server      int           `db:"server" json:"server"`
+	Characters  pq.Int64Array `db:"characters" json:"characters"` // played, in order
+	OpenedAt    time.Time     `db:"opened_at" json:"opened_at"`
+	ClosedAt    null.Time     `db:"closed_at" json:"closed_at"`
+	CloseReason string        `db:"close_reason" json:"close_reason"`
+	TicketNonce string        `db:"ticket_nonce" json:"-"` // of the ticket handing it over, until redeemed
+}
+
+// IPLink is an account that played from an IP of another one.
+type IPLink struct {
+	UserID   string    `db:"user_id" json:"user_id"`
+	Username string    `db:"user_name" json:"username"`
+	IP       string    `db:"ip" json:"ip"`
+	Sessions int       `db:"sessions" json:"sessions"`
+	LastSeen time.Time `db:"last_seen" json:"last_seen"`
+}
+
+func (s *Session) Create() error {
+	return pgsql_DbMap.Insert(s)
+}
+
+// PlayTime is how long the session lasted, or lasts so far.
+func (s *Session) PlayTime() time.Duration {
+	if s.ClosedAt.Valid {
+		return s.ClosedAt.Time.Sub(s.OpenedAt)
+	}
+	return time.Since(s.OpenedAt)
+}
+
+// OpenSession records the socket user logging in.
+func (s *Socket) OpenSession() {
+	session := &Session{UserID: s.User.ID, IP: s.ClientIP(), ClientAddr: s.ClientAddr, Fingerprint: s.Fingerprint, OpenedAt: time.Now(), Characters: pq.Int64Array{}}
+	if info := s.IPInfo; info != nil {
+		session.Country, session.Proxy = info.Country, info.Proxy
+	}
+	if err := session.Create(); err != nil {
+		log.Printf("OpenSession: %s", err)
+		return
+	}
+	s.sessionID = session.ID
+}
+
+// SetCloseReason tells why the socket is about to close, the first reason
+// given is the one recorded.
+func (s *Socket) SetCloseReason(reason string) {
+	s.closeMutex.Lock()
+	defer s.closeMutex.Unlock()
+	if s.closeReason == "" {
+		s.closeReason = reason
+	}
+}
+
+func (s *Socket) closeSession() {
+	s.closeMutex.Lock()
+	id, reason := s.sessionID, s.closeReason
+	s.sessionID = 0
+	s.closeMutex.Unlock()
+
+	if id == 0 {
+		return
+	}
+	if reason == "" {
+		reason = SESSION_DISCONNECTED
+	}
+	query := `update hops.sessions set closed_at = now(), close_reason = $2 where id = $1 and closed_at is null`
+	if _, err := pgsql_DbMap.Exec(query, id, reason); err != nil {
+		log.Printf("closeSession: %s", err)
+	}
+}
+
+// handOffSession marks the session as handed over with the ticket nonce, and
+// closes it if the ticket is not redeemed in time.
+func (s *Socket) handOffSession(t *Ticket) {
+	id := s.sessionID
+	if id == 0 {
+		return
+	}
+	query := `update hops.sessions set server = $2, ticket_nonce = $3 where id = $1`
+	if _, err := pgsql_DbMap.Exec(query, id, t.Server, t.Nonce); err != nil {
+		log.Printf("handOffSession: %s", err)
+	}
+
+	time.AfterFunc(time.Until(time.Unix(t.Expires, 0))+5*time.Second, func() {
+		query := `update hops.sessions set closed_at = now(), close_reason = $3 where id = $1 and ticket_nonce = $2 and closed_at is null`
+		pgsql_DbMap.Exec(query, id, t.Nonce, SESSION_TICKET_EXPIRED)
+	})
+}
+
+// claimSession continues the session of a redeemed ticket on the socket.
+func (s *Socket) claimSession(t *Ticket) {
+	if t.SessionID == 0 {
+		s.OpenSession()
+	} else {
+		s.sessionID = t.SessionID
+	}
+
+	query := `update hops.sessions set server = $2, ticket_nonce = '' where id = $1`
+	if _, err := pgsql_DbMap.Exec(query, s.sessionID, t.Server); err != nil {
+		log.Printf("claimSession: %s", err)
+	}
+}
+
+// RecordCharacter adds the character to the ones played in the session.
+func (s *Socket) RecordCharacter(id int) {
+	if s.sessionID == 0 {
+		return
+	}
+	query := `update hops.sessions set characters = array_append(characters, $2) where id = $1 and not ($2 = any(characters))`
+	if _, err := pgsql_DbMap.Exec(query, s.sessionID, int64(id)); err != nil {
+		log.Printf("recordCharacter: %s", err)
+	}
+}
+
+// CloseStaleSessions closes the sessions a previous run of this process left
+// open on its servers.
+func CloseStaleSessions() {
+	var ids []int64
+	for _, id := range cfg.Server.ServerIDs {
+		ids = append(ids, int64(id))
+	}
+
+	query := `update hops.sessions set closed_at = now(), close_reason = $1 where closed_at is null and ($2::int[] is null or server = any($2))`
+	res, err := pgsql_DbMap.Exec(query, SESSION_SERVER_RESTART, pq.Int64Array(ids))
+	if err != nil {
+		log.Printf("CloseStaleSessions: %s", err)
+		return
+	}
+	if n, _ := res.RowsAffected(); n > 0 {
+		log.Printf("Closed %d sessions left open by the last run", n)
+	}
+}
+
+// onlineAccounts counts the accounts other than userID with a session open
+// from the IP or the fingerprint, on every server sharing the database. GMs
+// are left out when exempt. A variable for the tests.
+var onlineAccounts = func(by, value, userID string) (int, error) {
+	column := "ip"
+	if by == "fingerprint" {
+		column = "fingerprint"
+	}
+	var ranks pq.Int64Array
+	if cfg.Sessions.ExemptGMs {
+		for _, r := range Tuned().GMRanks {
+			ranks = append(ranks, int64(r))
+		}
+	}
+
+	query := `select count(distinct s.user_id) from hops.sessions s
+		left join hops.users u on u.id::text = s.user_id
+		where s.closed_at is null and s.` + column + ` = $1 and s.user_id <> $2
+		and not coalesce(u.user_type = any($3), false)`
+	n, err := pgsql_DbMap.SelectInt(query, value, userID, ranks)
+	if err != nil {
+		return 0, fmt.Errorf("onlineAccounts: %s", err)
+	}
+	return int(n), nil
+}
+
+// FindSessions returns the latest sessions of the account, the newest first.
+func FindSessions(userID string, limit int) ([]*Session, error) {
+	var sessions []*Session
+	query := `select * from hops.sessions where user_id = $1 order by opened_at desc limit $2`
+	if _, err := pgsql_DbMap.Select(&sessions, query, userID, limit); err != nil {
+		return nil, fmt.Errorf("FindSessions: %s", err)
+	}
+	return sessions, nil
+}
+
+// FindIPLinks returns the other accounts that played from an IP the account
+// played from, the most recent first.
+func FindIPLinks(userID string) ([]*IPLink, error) {
+	var links []*IPLink
+	query := `select other.user_id, coalesce(u.user_name, '') as user_name, other.ip, count(*) as sessions, max(other.opened_at) as last_seen
+		from hops.sessions other
+		left join hops.users u on u.id::text = other.user_id
+		where other.user_id <> $1 and other.ip in (select distinct ip from hops.sessions where user_id = $1)
+		group by other.user_id, u.user_name, other.ip
+		order by last_seen desc`
+	if _, err := pgsql_DbMap.Select(&links, query, userID); err != nil {
+		return nil, fmt.Errorf("FindIPLinks: %s", err)
+	}
+	return links, nil
+}
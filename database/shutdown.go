@@ -0,0 +1,151 @@
+package database
+
+import (
+	"fmt"
+	"log"
+	"sync/atomic"
+
+	"github.com/twodragon/kore-server/messaging"
+)
+
+var (
+	draining int32
+)
+
+type SaveReport struct {
+	Characters int
+	Failed     []string
+}
+
+// Drain puts the server in drain mode, new logins are refused from now on.
+func Drain() {
+	atomic.StoreInt32(&draining, 1)
+}
+
+func IsDraining() bool {
+	return atomic.LoadInt32(&draining) == 1
+}
+
+func Announce(msg string) {
+	makeAnnouncement(msg)
+}
+
+func AllSockets() []*Socket {
+	socketMutex.RLock()
+	defer socketMutex.RUnlock()
+
+	arr := make([]*Socket, 0, len(Sockets))
+	for _, s := range Sockets {
+		arr = append(arr, s)
+	}
+	return arr
+}
+
+// EndEvents finishes the wars and events that are running, so nobody is left
+// in a war map or with a paid loto ticket after a restart.
+func EndEvents() {
+	if fw_isFactionWarStarted {
+		log.Print("Finishing faction war...")
+		finishFactionWar()
+	}
+	fw_isFactionWarEntranceActive = false
+
+	if isFlagKingdomStarted {
+		log.Print("Finishing flag kingdom...")
+		finishFlagKingdom()
+	}
+	isFlagKingdomEntranceActive = false
+
+	if WarStarted || CanJoinWar {
+		log.Print("Finishing great war...")
+		for _, chars := range []map[int]*Character{OrderCharacters, ShaoCharacters, LobbyCharacters} {
+			for id, char := range chars {
+				char.WarKillCount = 0
+				char.WarContribution = 0
+				char.IsinWar = false
+				delete(chars, id)
+			}
+		}
+		CanJoinWar = false
+		WarStarted = false
+	}
+
+	if isActive {
+		log.Print("Refunding loto tickets...")
+		refundLoto()
+	}
+
+	isCiRunning = false
+}
+
+func refundLoto() {
+	for _, participant := range participants {
+		user, err := FindUserByID(participant.character.UserID)
+		if err != nil || user == nil {
+			continue
+		}
+		user.NCash += lotoPrice
+		NCashCreated.Add(float64(lotoPrice))
+		user.Update()
+		if participant.character.Socket != nil {
+			msg := "Lottery event canceled because of server maintenance. The ticket value has been restored to your account."
+			participant.character.Socket.Write(messaging.InfoMessage(msg))
+		}
+	}
+	participants = nil
+	isActive = false
+}
+
+// SaveAll writes every online character, its stats, skills and inventory to
+// the database and reports whatever could not be saved.
+func SaveAll() *SaveReport {
+	report := &SaveReport{}
+
+	characters, err := FindOnlineCharacters()
+	if err != nil {
+		report.Failed = append(report.Failed, fmt.Sprintf("find online characters: %s", err))
+		return report
+	}
+
+	for _, c := range characters {
+		report.Characters++
+		fail := func(what string, err error) {
+			report.Failed = append(report.Failed, fmt.Sprintf("%s (%d) %s: %s", c.Name, c.ID, what, err))
+		}
+
+		if err := c.Update(); err != nil {
+			fail("character", err)
+		}
+
+		if s := c.Socket; s != nil {
+			if s.Stats != nil {
+				if err := s.Stats.Update(); err != nil {
+					fail("stats", err)
+				}
+			}
+			if s.Skills != nil {
+				if err := s.Skills.Update(); err != nil {
+					fail("skills", err)
+				}
+			}
+			if s.User != nil {
+				if err := s.User.Update(); err != nil {
+					fail("user", err)
+				}
+			}
+		}
+
+		slots, err := c.InventorySlots()
+		if err != nil {
+			fail("inventory", err)
+			continue
+		}
+		for _, slot := range slots {
+			if err := slot.Update(); err != nil {
+				fail(fmt.Sprintf("inventory slot %d", slot.SlotID), err)
+			}
+		}
+	}
+
+	return report
+}
@@ -1,22 +1,35 @@
 package database
 
 import (
-	"bytes"
+	"errors"
 	"fmt"
 	"log"
 	"net"
-	"os"
-	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
+	"github.com/twodragon/kore-server/geoip"
 	"github.com/twodragon/kore-server/utils"
 
 	"github.com/nats-io/nats.go"
 	"github.com/paulbellamy/ratecounter"
 )
 
+const (
+	QUEUE_POLICY_DROP  = "drop"
+	QUEUE_POLICY_KICK  = "kick"
+	QUEUE_POLICY_STALL = "stall"
+)
+
+const (
+	WRITE_BATCH_SIZE = 16 * 1024
+)
+
 var (
+	ErrSocketClosed = errors.New("socket closed")
+	ErrSlowConsumer = errors.New("outbound queue full")
+
 	Handler        func(*Socket, []byte, uint16) ([]byte, error)
 	Sockets        = make(map[string]*Socket)
 	socketMutex    sync.RWMutex
@@ -33,6 +46,31 @@ type Socket struct {
 	Skills     *Skills
 	Teleports  *Teleports
 	HoustonSub *nats.Subscription
+
+	RateCounters map[uint16]*ratecounter.RateCounter // per opcode, used by the factory
+
+	inbound     chan []byte
+	outbound    chan []byte
+	queuedBytes int64
+	done        chan struct{}
+	closeOnce   sync.Once
+	onCloseOnce sync.Once // OnClose runs once, whoever calls it first
+
+	Fingerprint string      // of the client hardware, empty if the client sent none
+	IPInfo      *geoip.Info // of the client IP, nil if unknown
+
+	admittedIP  string
+	releaseOnce sync.Once
+	loggedIn    int32 // set once a handler gave the socket a user
+
+	pinVerifiedAt time.Time
+
+	handoff bool    // a ticket was issued, the session moves to another socket
+	ticket  *Ticket // redeemed by this socket
+
+	sessionID   int    // in hops.sessions, 0 before login
+	closeReason string // recorded when the session closes
+	closeMutex  sync.Mutex
 }
 
 func init() {
@@ -44,10 +82,13 @@ func (s *Socket) Add(id string) {
 	Sockets[id] = s
 }
 
+// Remove unregisters the socket of id, unless another socket took its place.
 func (s *Socket) Remove(id string) {
 	socketMutex.Lock()
 	defer socketMutex.Unlock()
-	delete(Sockets, id)
+	if Sockets[id] == s {
+		delete(Sockets, id)
+	}
 }
 
 func GetSocket(id string) *Socket {
@@ -59,37 +100,64 @@ func GetSocket(id string) *Socket {
 func (s *Socket) Read() {
 
 	counter := ratecounter.NewRateCounter(1 * time.Second)
+	framer := NewFramer(MAX_FRAME_SIZE)
+	s.ClientAddr = s.Conn.RemoteAddr().String() // the player, also behind a proxy
+	if err := s.admit(); err != nil {
+		s.refuse(err)
+		return
+	}
+
+	size := cfg.Server.InboundQueueSize
+	if size <= 0 {
+		size = 64
+	}
+	s.inbound = make(chan []byte, size)
+
+	size = cfg.Server.OutboundQueueSize
+	if size <= 0 {
+		size = 512
+	}
+	s.outbound = make(chan []byte, size)
+
+	s.done = make(chan struct{})
+	go s.processInbound()
+	go s.processOutbound()
+
+	if timeout := cfg.Admission.HandshakeTimeout; timeout > 0 {
+		time.AfterFunc(time.Duration(timeout)*time.Second, s.checkHandshake)
+	}
 
 	for {
 		buf := make([]byte, 4096)
 		n, err := s.Conn.Read(buf)
 		if err != nil { // do not remove connecting ip here
+			s.SetCloseReason(SESSION_DISCONNECTED)
 			s.OnClose()
 			break
 		}
 
-		go func() {
-			counter.Incr(1)
-			resp, err := s.recognizePacket(buf[:n])
+		counter.Incr(1) // reads, as before framing: a read often carries several packets
+		framer.Feed(buf[:n])
+		for {
+			frame, err := framer.Next()
 			if err != nil {
-				log.Println("recognize packet error:", err)
+				text := fmt.Sprintf("IP %s disconnected from server for bad framing: %s", s.ClientAddr, err)
+				log.Print(text)
+				utils.NewLog("logs/rate_kicks.txt", text)
+				s.SetCloseReason(SESSION_ERROR)
+				s.OnClose()
+				return
+			}
+			if frame == nil {
+				break
 			}
 
-			if len(resp) > 0 {
-				packets := bytes.SplitAfter(resp, []byte{0x55, 0xAA})
-				for _, packet := range packets {
-					if len(packet) == 0 {
-						continue
-					}
-					err := s.Write(packet)
-					if err != nil {
-						s.OnClose()
-						break
-					}
-					time.Sleep(time.Duration(len(packet)/25) * time.Millisecond)
-				}
+			if !s.enqueue(frame) {
+				s.SetCloseReason(SESSION_RATE_KICK)
+				s.OnClose()
+				return
 			}
-		}()
+		}
 
 		if counter.Rate() > 35 {
 			if s.User != nil {
@@ -102,6 +170,7 @@ func (s *Socket) Read() {
 				utils.NewLog("logs/rate_kicks.txt", text)
 			}
 
+			s.SetCloseReason(SESSION_RATE_KICK)
 			s.OnClose()
 			break
 		}
@@ -109,15 +178,88 @@ func (s *Socket) Read() {
 	}
 }
 
+// enqueue hands a frame to the socket worker, applying the configured policy
+// when the queue is full. It returns false if the socket should be closed.
+func (s *Socket) enqueue(frame []byte) bool {
+	select {
+	case s.inbound <- frame:
+		return true
+	default:
+	}
+
+	switch cfg.Server.InboundQueuePolicy {
+	case QUEUE_POLICY_DROP:
+		log.Printf("IP %s inbound queue full, packet dropped", s.ClientAddr)
+		return true
+	case QUEUE_POLICY_KICK:
+		text := "IP " + s.ClientAddr + " disconnected from server for full inbound queue."
+		log.Print(text)
+		utils.NewLog("logs/rate_kicks.txt", text)
+		return false
+	default: // QUEUE_POLICY_STALL
+		select {
+		case s.inbound <- frame:
+			return true
+		case <-s.done:
+			return false
+		}
+	}
+}
+
+// processInbound runs the handlers of a socket one packet at a time, in the
+// order the packets arrived.
+func (s *Socket) processInbound() {
+	for {
+		select {
+		case frame := <-s.inbound:
+			if !s.handleFrame(frame) {
+				return
+			}
+		case <-s.done:
+			return
+		}
+	}
+}
+
+func (s *Socket) handleFrame(frame []byte) bool {
+	resp, err := s.recognizePacket(frame)
+	if err != nil {
+		log.Println("recognize packet error:", err)
+	}
+	if s.User != nil {
+		atomic.StoreInt32(&s.loggedIn, 1)
+	}
+
+	if len(resp) > 0 {
+		if err := s.Write(resp); err != nil {
+			return false
+		}
+	}
+	return true
+}
+
+// OnClose releases the socket. The reader, the writer and a kick may all
+// call it; only the first call does anything.
 func (s *Socket) OnClose() {
 	if s == nil {
 		return
 	}
-	s.Conn.Close()
+	s.onCloseOnce.Do(s.onClose)
+}
+
+func (s *Socket) onClose() {
+	if s.done != nil {
+		s.closeOnce.Do(func() { close(s.done) })
+	}
+	if s.outbound == nil { // otherwise the writer closes it after flushing
+		s.Conn.Close()
+	}
+	s.releaseAdmission()
 	if u := s.User; u != nil {
 		s.Remove(u.ID)
-		if s.User.ConnectingIP == "" {
+		if !s.handoff {
 			u.Logout()
+			s.closeSession()
 		}
 	}
 	if c := s.Character; c != nil {
@@ -126,91 +268,131 @@ func (s *Socket) OnClose() {
 	if s.HoustonSub != nil {
 		s.HoustonSub.Unsubscribe()
 	}
-	s = nil
 }
 
-func (s *Socket) recognizePacket(data []byte) ([]byte, error) {
-	packets := bytes.SplitAfter(data, []byte{0x55, 0xAA})
+func (s *Socket) recognizePacket(packet []byte) ([]byte, error) {
+	sign := uint16(utils.BytesToInt(packet[4:6], false))
+	return Handler(s, packet, sign)
+}
 
-	resp := utils.Packet{}
-	for _, packet := range packets {
+// Write queues data for the socket writer. A client that falls too far behind
+// is disconnected instead of blocking the caller.
+func (s *Socket) Write(data []byte) error {
 
-		if len(packet) < 6 {
-			continue
+	if s == nil || s.Conn == nil || len(data) == 0 {
+		return nil
+	}
+
+	if s.outbound == nil {
+		_, err := s.Conn.Write(data)
+		if err != nil {
+			s.OnClose()
+			return err
 		}
+		countPackets(PacketsOut, data)
+		return nil
+	}
 
-		if os.Getenv("PROXY_ENABLED") == "5" {
-			header, body := []byte{}, []byte{}
-			if bytes.Contains(packet, []byte{0xAA, 0x55}) {
-				pParts := bytes.Split(packet, []byte{0xAA, 0x55})
-				if len(pParts) == 1 {
-					body = append([]byte{0xAA, 0x55}, pParts[0]...)
-
-				} else {
-					header = pParts[0]
-					body = append([]byte{0xAA, 0x55}, pParts[1]...)
-				}
-			} else {
-				header = packet
-			}
+	packet := make([]byte, len(data))
+	copy(packet, data)
 
-			s.ParseHeader(header)
+	queued := atomic.AddInt64(&s.queuedBytes, int64(len(packet))) // taken off by flush
+	if limit := int64(cfg.Server.OutboundQueueBytes); limit > 0 && queued > limit {
+		atomic.AddInt64(&s.queuedBytes, -int64(len(packet)))
+		s.kickSlowConsumer()
+		return ErrSlowConsumer
+	}
 
-			if len(body) > 0 {
-				sign := uint16(utils.BytesToInt(body[4:6], false))
-				d, err := Handler(s, body, sign)
-				if err != nil {
-					return nil, err
-				}
+	select {
+	case <-s.done:
+		atomic.AddInt64(&s.queuedBytes, -int64(len(packet)))
+		return ErrSocketClosed
+	default:
+	}
 
-				resp.Concat(d)
-			}
+	select {
+	case s.outbound <- packet:
+		countPackets(PacketsOut, packet)
+		return nil
+	default:
+		atomic.AddInt64(&s.queuedBytes, -int64(len(packet)))
+		s.kickSlowConsumer()
+		return ErrSlowConsumer
+	}
+}
 
-		} else {
-			s.ClientAddr = s.Conn.RemoteAddr().String()
+// kickSlowConsumer only signals the kick, Write runs on the goroutines of
+// the callers that may hold map or party locks. The writer then closes the
+// connection and the reader runs OnClose.
+func (s *Socket) kickSlowConsumer() {
+	s.closeOnce.Do(func() {
+		text := "IP " + s.ClientAddr + " disconnected from server for slow outbound queue."
+		if s.User != nil {
+			text = "Account " + s.User.Username + " disconnected from server for slow outbound queue."
+		}
+		log.Print(text)
+		utils.NewLog("logs/rate_kicks.txt", text)
+		s.SetCloseReason(SESSION_SLOW_CLIENT)
+		close(s.done)
+	})
+}
 
-			sign := uint16(utils.BytesToInt(packet[4:6], false))
-			d, err := Handler(s, packet, sign)
-			if err != nil {
-				return nil, err
-			}
+// processOutbound is the only goroutine writing to the connection. Packets
+// queued at the same time are sent together in one write.
+func (s *Socket) processOutbound() {
+	defer s.Conn.Close()
 
-			resp.Concat(d)
+	batch := make([]byte, 0, WRITE_BATCH_SIZE)
+	for {
+		select {
+		case packet := <-s.outbound:
+			batch = s.collect(append(batch[:0], packet...))
+			if err := s.flush(batch); err != nil {
+				s.OnClose()
+				return
+			}
+		case <-s.done:
+			// best effort for packets sent right before a kick, briefly as
+			// the client may be the slow one
+			batch = s.collect(batch[:0])
+			if len(batch) > 0 {
+				s.Conn.SetWriteDeadline(time.Now().Add(time.Second))
+				s.Conn.Write(batch)
+			}
+			return
 		}
 	}
-
-	return resp, nil
 }
 
-func (s *Socket) Write(data []byte) error {
-
-	if s != nil && s.Conn != nil {
-		_, err := s.Conn.Write(data)
-		if err != nil {
-			s.OnClose()
-			return err
+func (s *Socket) collect(batch []byte) []byte {
+	for len(batch) < WRITE_BATCH_SIZE {
+		select {
+		case packet := <-s.outbound:
+			batch = append(batch, packet...)
+		default:
+			return batch
 		}
 	}
-
-	return nil
+	return batch
 }
 
-func (s *Socket) ParseHeader(header []byte) {
+func (s *Socket) flush(batch []byte) error {
+	defer atomic.AddInt64(&s.queuedBytes, -int64(len(batch)))
 
-	if len(header) == 0 {
-		return
+	if timeout := cfg.Server.WriteTimeout; timeout > 0 {
+		s.Conn.SetWriteDeadline(time.Now().Add(time.Duration(timeout) * time.Second))
 	}
+	_, err := s.Conn.Write(batch)
+	return err
+}
 
-	sHeader := string(header)
-	if !strings.HasPrefix(sHeader, "PROXY TCP4") {
-		return
+// ClientIP returns the IP part of ClientAddr.
+func (s *Socket) ClientIP() string {
+	host, _, err := net.SplitHostPort(s.ClientAddr)
+	if err != nil {
+		return s.ClientAddr
 	}
-
-	parts := strings.Split(sHeader, " ")
-	clientIP := parts[2]
-	clientPort := parts[4]
-
-	s.ClientAddr = fmt.Sprintf("%s:%s", clientIP, clientPort)
+	return host
 }
 
 type HeartBeat struct {
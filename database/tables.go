@@ -0,0 +1,64 @@
+package database
+
+import (
+	"fmt"
+	"log"
+	"sort"
+)
+
+// TABLES_ALL reloads the tables a running server changes most, see
+// ReloadTable.
+const TABLES_ALL = "all"
+
+// dataTables are the tables ReloadTable reloads by name.
+var dataTables = map[string]func() error{
+	"relics":          GetRelics,
+	"savepoints":      GetAllSavePoints,
+	"scripts":         RefreshScripts,
+	"htshop":          GetHTItems,
+	"items":           GetAllItems,
+	"buffinf":         GetBuffInfections,
+	"advancedfusions": GetAdvancedFusions,
+	"gamblings":       GetGamblings,
+	"craftitems":      GetCraftItem,
+	"productions":     GetProductions,
+	"drops":           ReadAllDropsInfo,
+	"shopitems":       GetShopItems,
+	"itemsets":        ReadItemSets,
+	"shoptable":       GetShopsTable,
+	"exp":             GetExps,
+	"skills":          GetSkills,
+	"npcs":            ReadAllNPCsInfo,
+	"gates":           GetGates,
+	"houses":          GetHouseItems,
+}
+
+// TableNames returns the names of the tables ReloadTable reloads, sorted.
+func TableNames() []string {
+	names := make([]string, 0, len(dataTables))
+	for name := range dataTables {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// ReloadTable reloads a data table from the database. TABLES_ALL reloads the
+// scripts, gates, shops, gamblings, NPCs and exps, logging the tables that
+// fail.
+func ReloadTable(name string) error {
+	if name == TABLES_ALL {
+		for _, name := range []string{"scripts", "gates", "shoptable", "gamblings", "npcs", "exp"} {
+			if err := dataTables[name](); err != nil {
+				log.Printf("ReloadTable: %s: %s", name, err)
+			}
+		}
+		return nil
+	}
+
+	reload, ok := dataTables[name]
+	if !ok {
+		return fmt.Errorf("table %s not found", name)
+	}
+	return reload()
+}
@@ -0,0 +1,192 @@
+package database
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+var (
+	ErrNoTicket      = errors.New("ticket: none issued for this user, ip and server")
+	ErrBadTicket     = errors.New("ticket: bad signature")
+	ErrTicketExpired = errors.New("ticket: expired")
+	ErrTicketBinding = errors.New("ticket: issued for another user, server or ip")
+)
+
+// Ticket lets a socket of a game server take over the session a login
+// server opened. Tickets are kept in hops.session_tickets, so the login and
+// game servers may be separate processes sharing the database.
+//
+// The game socket does not present the ticket: the stock client reconnects
+// with its username alone, and SELECTED_SERVER, an IP and a port, carries
+// nothing it would echo back. A ticket is therefore found by username and
+// only redeemed by a socket from the IP it was issued to, for a server of
+// this process, within auth.ticket_ttl and once. Its signature with
+// auth.ticket_secret only keeps rows written to the table by anything but a
+// server from being redeemed, it does not tell two sockets of one IP apart.
+type Ticket struct {
+	UserID   string `json:"uid"`
+	Username string `json:"name"`
+	Server   int    `json:"server"`
+	IP       string `json:"ip"`
+	Expires  int64  `json:"exp"`
+	Nonce    string `json:"nonce"`
+
+	Fingerprint string `json:"fp,omitempty"`
+	SessionID   int    `json:"sid,omitempty"`
+}
+
+// key signs the tickets, config.Validate makes sure every process has one
+// and that it is long enough.
+func key() []byte {
+	return []byte(cfg.Auth.TicketSecret)
+}
+
+func (t *Ticket) sign() (string, error) {
+	payload, err := json.Marshal(t)
+	if err != nil {
+		return "", err
+	}
+	mac := hmac.New(sha256.New, key())
+	mac.Write(payload)
+	enc := base64.RawURLEncoding
+	return enc.EncodeToString(payload) + "." + enc.EncodeToString(mac.Sum(nil)), nil
+}
+
+func parseTicket(signed string) (*Ticket, error) {
+	enc := base64.RawURLEncoding
+	parts := strings.Split(signed, ".")
+	if len(parts) != 2 {
+		return nil, ErrBadTicket
+	}
+	payload, err := enc.DecodeString(parts[0])
+	if err != nil {
+		return nil, ErrBadTicket
+	}
+	sum, err := enc.DecodeString(parts[1])
+	if err != nil {
+		return nil, ErrBadTicket
+	}
+
+	mac := hmac.New(sha256.New, key())
+	mac.Write(payload)
+	if !hmac.Equal(sum, mac.Sum(nil)) {
+		return nil, ErrBadTicket
+	}
+
+	t := &Ticket{}
+	if err := json.Unmarshal(payload, t); err != nil {
+		return nil, ErrBadTicket
+	}
+	return t, nil
+}
+
+// IssueTicket lets the socket user enter server from the same IP within
+// auth.ticket_ttl seconds. The socket is not logged out when it closes,
+// the session now belongs to the socket that redeems the ticket.
+func (s *Socket) IssueTicket(server int) error {
+	nonce := make([]byte, 16)
+	if _, err := rand.Read(nonce); err != nil {
+		return err
+	}
+
+	t := &Ticket{
+		UserID:   s.User.ID,
+		Username: s.User.Username,
+		Server:   server,
+		IP:       s.ClientIP(),
+		Expires:  time.Now().Add(time.Duration(cfg.Auth.TicketTTL) * time.Second).Unix(),
+		Nonce:    base64.RawURLEncoding.EncodeToString(nonce),
+
+		Fingerprint: s.Fingerprint,
+		SessionID:   s.sessionID,
+	}
+	signed, err := t.sign()
+	if err != nil {
+		return err
+	}
+
+	query := `insert into hops.session_tickets (username, ticket, expires_at, ip, server) values ($1, $2, $3, $4, $5)
+		on conflict (username) do update set ticket = excluded.ticket, expires_at = excluded.expires_at,
+			ip = excluded.ip, server = excluded.server`
+	if _, err := pgsql_DbMap.Exec(query, t.Username, signed, time.Unix(t.Expires, 0), t.IP, t.Server); err != nil {
+		return fmt.Errorf("IssueTicket: %s", err)
+	}
+	pgsql_DbMap.Exec(`delete from hops.session_tickets where expires_at < now()`)
+
+	s.handoff = true
+	s.handOffSession(t)
+	return nil
+}
+
+// RedeemTicket takes the ticket issued for username if it was made for this
+// socket IP and a server of this process, then gives the socket the
+// session. A ticket bound to another IP or server is left for its socket.
+func (s *Socket) RedeemTicket(username string) (*Ticket, error) {
+	var servers []int64
+	for _, id := range cfg.Server.ServerIDs {
+		servers = append(servers, int64(id))
+	}
+
+	query := `delete from hops.session_tickets where username = $1 and ip = $2 and expires_at >= now()
+		and ($3::int[] is null or server = any($3)) returning ticket`
+	signed, err := pgsql_DbMap.SelectStr(query, username, s.ClientIP(), pq.Int64Array(servers))
+	if err != nil {
+		return nil, fmt.Errorf("RedeemTicket: %s", err)
+	}
+	if signed == "" {
+		return nil, ErrNoTicket
+	}
+
+	t, err := parseTicket(signed)
+	if err != nil {
+		return nil, err
+	}
+	if time.Now().Unix() > t.Expires {
+		return nil, ErrTicketExpired
+	}
+	if t.Username != username || t.IP != s.ClientIP() || !servesServer(t.Server) {
+		return nil, ErrTicketBinding
+	}
+
+	user, err := FindUserByID(t.UserID)
+	if err != nil || user == nil {
+		return nil, fmt.Errorf("ticket: user %s: %v", t.UserID, err)
+	}
+
+	s.User = user
+	s.Fingerprint = t.Fingerprint
+	s.handoff = false
+	s.ticket = t
+	s.claimSession(t)
+	return t, nil
+}
+
+// GameServer returns the server the socket redeemed a ticket for, 0 before.
+func (s *Socket) GameServer() int {
+	if s.ticket == nil {
+		return 0
+	}
+	return s.ticket.Server
+}
+
+func servesServer(server int) bool {
+	ids := cfg.Server.ServerIDs
+	if len(ids) == 0 {
+		return true
+	}
+	for _, id := range ids {
+		if id == server {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,297 @@
+package database
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/twodragon/kore-server/utils"
+	"gopkg.in/yaml.v3"
+)
+
+// Tuning holds the balancing values GMs change while the server runs. It is
+// read from the tuning file, swapped as a whole and never modified in place:
+// take one snapshot with Tuned() and read from it.
+type Tuning struct {
+	DropRate         float64 `yaml:"drop_rate"`
+	ExpRate          float64 `yaml:"exp_rate"`
+	GoldRate         float64 `yaml:"gold_rate"`
+	STRRates         []int   `yaml:"str_rates"`
+	BeastSTRRates    []int   `yaml:"beast_str_rates"`
+	PlusRates        []int   `yaml:"plus_rates"`
+	STRHappyHourRate float64 `yaml:"str_happy_hour_rate"`
+	PvPZones         []int16 `yaml:"pvp_zones"`
+	DisabledAIDMaps  []int16 `yaml:"disabled_aid_maps"`
+	GMRanks          []int16 `yaml:"gm_ranks"`
+	SharedMaps       []int16 `yaml:"shared_maps"`
+}
+
+var (
+	TUNING_POLL = 5 * time.Second
+
+	tuning      atomic.Value
+	tuningMutex sync.Mutex // serializes writers
+	tuningFile  string
+	tuningMod   time.Time
+
+	defaultTuning = &Tuning{
+		DropRate:         1.0,
+		ExpRate:          1.0,
+		GoldRate:         1.0,
+		STRRates:         []int{350, 350, 325, 225, 175, 150, 80, 110, 90, 75, 50, 40, 30, 20, 15},
+		BeastSTRRates:    []int{350, 350, 325, 225, 175, 150, 80, 110, 90, 75, 50, 40, 30, 20, 15},
+		PlusRates:        []int{800, 900, 950, 980, 990, 996, 999},
+		STRHappyHourRate: 0.00,
+		PvPZones:         []int16{12, 17, 100, 101, 102, 108, 109, 110, 111, 112, 255, 230, 249},
+		DisabledAIDMaps:  []int16{212, 230, 233, 243, 255},
+		GMRanks:          []int16{2, 3, 4, 5},
+		SharedMaps: []int16{1, 2, 3, 14, 15, 10, 20, 21, 22, 23, 26, 33, 34, 36, 37, 38, 42, 43, 44, 45, 46, 47,
+			70, 72, 73, 74, 75, 89, 100, 101, 102, 109, 110, 111, 112, 120, 164, 165, 166, 167, 168, 169, 170,
+			213, 214, 215, 221, 222, 223, 224, 225, 226, 227, 228, 233, 236, 237, 238, 239, 240, 243, 244, 252, 254, 255, 108, 110, 249},
+	}
+)
+
+func init() {
+	tuning.Store(defaultTuning)
+}
+
+// Tuned returns the tuning in effect.
+func Tuned() *Tuning {
+	return tuning.Load().(*Tuning)
+}
+
+func (t *Tuning) Validate() error {
+	var errs []string
+	if t.DropRate <= 0 || t.ExpRate <= 0 || t.GoldRate <= 0 {
+		errs = append(errs, "rates must be positive")
+	}
+	if len(t.STRRates) != 15 || len(t.BeastSTRRates) != 15 {
+		errs = append(errs, "str_rates and beast_str_rates need 15 values")
+	}
+	for _, r := range append(append([]int{}, t.STRRates...), t.BeastSTRRates...) {
+		if r < 0 || r > 1000 {
+			errs = append(errs, fmt.Sprintf("upgrade rate %d is not between 0 and 1000", r))
+			break
+		}
+	}
+	if len(t.PlusRates) == 0 || !sort.IntsAreSorted(t.PlusRates) {
+		errs = append(errs, "plus_rates must be sorted and not empty")
+	}
+	if t.STRHappyHourRate < 0 || t.STRHappyHourRate > 1 {
+		errs = append(errs, "str_happy_hour_rate must be between 0 and 1")
+	}
+
+	if len(errs) > 0 {
+		return errors.New("tuning: " + strings.Join(errs, "; "))
+	}
+	return nil
+}
+
+// swapTuning makes t the tuning in effect and records the differences with
+// the previous one in the change log.
+func swapTuning(t *Tuning, who string) {
+	old := Tuned()
+	applyTuning(t)
+
+	for _, change := range diffTuning(old, t) {
+		text := fmt.Sprintf("%s changed %s", who, change)
+		log.Print(text)
+		utils.NewLog("logs/tuning_changes.txt", text)
+	}
+}
+
+func applyTuning(t *Tuning) {
+	tuning.Store(t)
+
+	setDefaultRate(RATE_GOLD, t.GoldRate)
+	setDefaultRate(RATE_EXP, t.ExpRate)
+	setDefaultRate(RATE_DROP, t.DropRate)
+}
+
+func diffTuning(old, cur *Tuning) []string {
+	var changes []string
+	ov, nv := reflect.ValueOf(old).Elem(), reflect.ValueOf(cur).Elem()
+	for i := 0; i < ov.NumField(); i++ {
+		a, b := ov.Field(i).Interface(), nv.Field(i).Interface()
+		if !reflect.DeepEqual(a, b) {
+			name := ov.Type().Field(i).Tag.Get("yaml")
+			changes = append(changes, fmt.Sprintf("%s: %v -> %v", name, a, b))
+		}
+	}
+	return changes
+}
+
+// InitTuning loads the tuning file, writing it with the current values if it
+// does not exist yet, and starts watching it for changes. The rates of the
+// configuration only seed a new file, the file wins over them afterwards.
+func InitTuning(path string) error {
+	tuningMutex.Lock()
+	defer tuningMutex.Unlock()
+
+	tuningFile = path
+	t := *defaultTuning
+	rates := Rates()
+	t.DropRate, t.ExpRate, t.GoldRate = rates[RATE_DROP].Default, rates[RATE_EXP].Default, rates[RATE_GOLD].Default
+	tuning.Store(&t)
+
+	if _, err := os.Stat(path); os.IsNotExist(err) {
+		if err := writeTuning(&t); err != nil {
+			return err
+		}
+	} else {
+		loaded, mod, err := readTuning()
+		if err != nil {
+			return err
+		}
+		tuningMod = mod
+		warnConfigRates(loaded)
+		applyTuning(loaded)
+	}
+
+	go watchTuning()
+	return nil
+}
+
+// warnConfigRates logs the rates of the configuration, file or environment,
+// that the tuning file t overrides.
+func warnConfigRates(t *Tuning) {
+	for _, r := range []struct {
+		key            string
+		config, tuning float64
+	}{
+		{"drop_rate", cfg.Game.DropRate, t.DropRate},
+		{"exp_rate", cfg.Game.ExpRate, t.ExpRate},
+		{"gold_rate", cfg.Game.GoldRate, t.GoldRate},
+	} {
+		if r.config != r.tuning {
+			log.Printf("WARNING: game.%s is %v in the configuration but %v in %s, the tuning file wins: change it there or with /tuning",
+				r.key, r.config, r.tuning, tuningFile)
+		}
+	}
+}
+
+// ReloadTuning reads the tuning file again, keeping the current tuning if the
+// file is not valid.
+func ReloadTuning(who string) error {
+	tuningMutex.Lock()
+	defer tuningMutex.Unlock()
+	return reloadTuning(who)
+}
+
+func reloadTuning(who string) error {
+	t, mod, err := readTuning()
+	if err != nil {
+		return err
+	}
+
+	tuningMod = mod
+	swapTuning(t, who)
+	return nil
+}
+
+func readTuning() (*Tuning, time.Time, error) {
+	info, err := os.Stat(tuningFile)
+	if err != nil {
+		return nil, time.Time{}, fmt.Errorf("tuning: %s", err)
+	}
+	data, err := os.ReadFile(tuningFile)
+	if err != nil {
+		return nil, time.Time{}, fmt.Errorf("tuning: %s", err)
+	}
+
+	t := &Tuning{}
+	if err := yaml.Unmarshal(data, t); err != nil {
+		return nil, time.Time{}, fmt.Errorf("tuning: %s: %s", tuningFile, err)
+	}
+	if err := t.Validate(); err != nil {
+		return nil, time.Time{}, err
+	}
+	return t, info.ModTime(), nil
+}
+
+// SetTuning changes one key of the tuning, value being YAML, and saves the
+// result to the tuning file so it survives restarts.
+func SetTuning(key, value, who string) error {
+	tuningMutex.Lock()
+	defer tuningMutex.Unlock()
+
+	data, err := yaml.Marshal(Tuned())
+	if err != nil {
+		return err
+	}
+	fields := map[string]interface{}{}
+	if err := yaml.Unmarshal(data, &fields); err != nil {
+		return err
+	}
+	if _, ok := fields[key]; !ok {
+		return fmt.Errorf("tuning: unknown key %s", key)
+	}
+
+	var v interface{}
+	if err := yaml.Unmarshal([]byte(value), &v); err != nil {
+		return fmt.Errorf("tuning: %s: %s", key, err)
+	}
+	fields[key] = v
+
+	if data, err = yaml.Marshal(fields); err != nil {
+		return err
+	}
+	t := &Tuning{}
+	if err := yaml.Unmarshal(data, t); err != nil {
+		return fmt.Errorf("tuning: %s: %s", key, err)
+	}
+	if err := t.Validate(); err != nil {
+		return err
+	}
+
+	if err := writeTuning(t); err != nil {
+		return err
+	}
+	swapTuning(t, who)
+	return nil
+}
+
+func writeTuning(t *Tuning) error {
+	data, err := yaml.Marshal(t)
+	if err != nil {
+		return err
+	}
+
+	tmp := tuningFile + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return fmt.Errorf("tuning: %s", err)
+	}
+	if err := os.Rename(tmp, tuningFile); err != nil {
+		return fmt.Errorf("tuning: %s", err)
+	}
+
+	if info, err := os.Stat(tuningFile); err == nil {
+		tuningMod = info.ModTime()
+	}
+	return nil
+}
+
+func watchTuning() {
+	for range time.Tick(TUNING_POLL) {
+		info, err := os.Stat(tuningFile)
+		if err != nil {
+			continue
+		}
+
+		tuningMutex.Lock()
+		if info.ModTime().After(tuningMod) {
+			if err := reloadTuning("file"); err != nil {
+				log.Println(err)
+				tuningMod = info.ModTime() // do not retry until the file changes again
+			}
+		}
+		tuningMutex.Unlock()
+	}
+}
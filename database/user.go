@@ -2,6 +2,7 @@ package database
 
 import (
 	"fmt"
+	"strings"
 	"sync"
 	"time"
 
@@ -20,25 +21,29 @@ var (
 )
 
 type User struct {
-	ID              string `db:"id" json:"ID"`
-	Username        string `db:"user_name" json:"Username"`
-	Password        string `db:"password" json:"Password"`
-	UserType        int8   `db:"user_type" json:"UserType"`
-	ConnectedIP     string `db:"ip" json:"ConnectedIP"`
-	ConnectedServer int    `db:"server" json:"ConnectedServer"`
-	NCash           uint64 `db:"ncash" json:"NCash"`
-	BankGold        uint64 `db:"bank_gold" json:"BankGold"`
-	Mail            string `db:"mail" json:"Mail"`
-	CreatedAt       string `db:"created_at" json:"createdAt"`
-	DisabledUntil   string `db:"disabled_until" json:"disabledUntil"`
-	LastLogin       string `db:"last_login" json:"last_login"`
-	CheckinCounter  int    `db:"checkin_counter" json:"checkin_counter"`
-
-	ConnectingIP string `db:"-"`
-	ConnectingTo int    `db:"-"`
+	ID              string    `db:"id" json:"ID"`
+	Username        string    `db:"user_name" json:"Username"`
+	Password        string    `db:"password" json:"Password"`
+	UserType        int8      `db:"user_type" json:"UserType"`
+	ConnectedIP     string    `db:"ip" json:"ConnectedIP"`
+	ConnectedServer int       `db:"server" json:"ConnectedServer"`
+	NCash           uint64    `db:"ncash" json:"NCash"`
+	BankGold        uint64    `db:"bank_gold" json:"BankGold"`
+	Mail            string    `db:"mail" json:"Mail"`
+	CreatedAt       string    `db:"created_at" json:"createdAt"`
+	DisabledUntil   string    `db:"disabled_until" json:"disabledUntil"`
+	LastLogin       string    `db:"last_login" json:"last_login"`
+	CheckinCounter  int       `db:"checkin_counter" json:"checkin_counter"`
+	PIN             string    `db:"pin" json:"-"`         // hashed, see SetPIN
+	TOTPSecret      string    `db:"totp_secret" json:"-"` // replaces the PIN when set, sealed with auth.totp_key
+	TOTPStep        int64     `db:"totp_step" json:"-"`   // of the last code used, see passwd.VerifyTOTP
+	MailVerified    bool      `db:"mail_verified" json:"mailVerified"`
+	DeleteAfter     null.Time `db:"delete_after" json:"deleteAfter"` // deletion requested, see account.Service
 
 	SelectedServerID int `db:"-"`
 
+	PendingTOTPSecret string `db:"-"` // waiting for a first code
+
 	RelicCooldown int `db:"-"`
 
 	MapBookCooldown uint16 `db:"-"`
@@ -90,6 +95,24 @@ func FindUserByName(name string) (*User, error) {
 	return &user, nil
 }
 
+// FindUserByNameFold finds the account named name in any case, as the names
+// of new accounts have to be unique. Logins still match the name exactly.
+func FindUserByNameFold(name string) (*User, error) {
+
+	for _, u := range AllUsers() {
+		if strings.EqualFold(u.Username, name) {
+			return u, nil
+		}
+	}
+
+	var user User
+	err := pgsql_DbMap.SelectOne(&user, "select * from hops.users where lower(user_name) = lower($1) limit 1", name)
+	if err != nil {
+		return nil, err
+	}
+	return &user, nil
+}
+
 func FindUserByID(id string) (*User, error) {
 	if id == "" {
 		return nil, fmt.Errorf("id is empty")
@@ -150,7 +173,7 @@ func FindUserByMail(mail string) (*User, error) {
 	}
 
 	var user User
-	err := pgsql_DbMap.SelectOne(&user, "select * from hops.users where email = $1", mail)
+	err := pgsql_DbMap.SelectOne(&user, "select * from hops.users where mail = $1", mail)
 	if err != nil {
 		return nil, err
 	}
@@ -194,28 +217,6 @@ func DeleteUserFromCache(id string) {
 	defer userMutex.Unlock()
 	delete(Users, id)
 }
-func UnbanUsers() {
-
-	userMutex.RLock()
-	all := funk.Values(Users).([]*User)
-	userMutex.RUnlock()
-
-	all = funk.Filter(all, func(u *User) bool {
-		date, _ := time.Parse("2006-01-02 15:04:05", u.DisabledUntil)
-
-		return u.UserType == 0 && time.Since(date) >= 0
-	}).([]*User)
-
-	for _, u := range all {
-		u.UserType = 1
-		u.Update()
-	}
-
-	time.AfterFunc(time.Minute, func() {
-		UnbanUsers()
-	})
-}
-
 func (u *User) GetTime() []byte {
 
 	resp := CLOCK
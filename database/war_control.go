@@ -0,0 +1,120 @@
+package database
+
+import (
+	"sync"
+	"time"
+)
+
+// wars the schedules and the admin API know
+const (
+	WAR_FACTION      = "faction"
+	WAR_FLAG_KINGDOM = "flag_kingdom"
+	WAR_GREAT        = "great"
+)
+
+// WarSchedule checks every minute whether its war is due while it runs.
+type WarSchedule struct {
+	check      func()
+	running    bool
+	generation int // of the latest Start
+	mutex      sync.Mutex
+}
+
+var (
+	WarSchedules = map[string]*WarSchedule{
+		WAR_FACTION:      {check: checkFactionWarSchedule},
+		WAR_FLAG_KINGDOM: {check: checkFlagKingdomSchedule},
+	}
+
+	warTimerGeneration int // of the latest great war countdown, see StartWarTimer
+	warTimerRunning    bool
+	warTimerMutex      sync.Mutex
+)
+
+// Start runs the schedule, it returns false if it was running already.
+func (s *WarSchedule) Start() bool {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.running {
+		return false
+	}
+	s.running = true
+	s.generation++
+	go s.run(s.generation)
+	return true
+}
+
+// Stop stops the schedule, a war it prepared already goes on. It returns
+// false if the schedule was not running.
+func (s *WarSchedule) Stop() bool {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if !s.running {
+		return false
+	}
+	s.running = false
+	return true
+}
+
+func (s *WarSchedule) Running() bool {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.running
+}
+
+func (s *WarSchedule) run(generation int) {
+	for {
+		s.mutex.Lock()
+		stopped := !s.running || s.generation != generation
+		s.mutex.Unlock()
+		if stopped {
+			return
+		}
+
+		s.check()
+		time.Sleep(time.Minute)
+	}
+}
+
+// StopWarTimer cancels the countdown of StartWarTimer, it returns false if
+// none runs.
+func StopWarTimer() bool {
+	warTimerMutex.Lock()
+	defer warTimerMutex.Unlock()
+	if !warTimerRunning {
+		return false
+	}
+	warTimerGeneration++
+	warTimerRunning = false
+	CanJoinWar = false
+	makeAnnouncement("The Great War was cancelled.")
+	return true
+}
+
+// WarStatus is where a war is at.
+type WarStatus struct {
+	Scheduled    bool `json:"scheduled"`     // by its schedule, faction and flag kingdom wars
+	EntranceOpen bool `json:"entrance_open"` // counting down to the start
+	Started      bool `json:"started"`
+}
+
+// WarStatuses returns the status of every war by name.
+func WarStatuses() map[string]WarStatus {
+	warTimerMutex.Lock()
+	great := WarStatus{EntranceOpen: warTimerRunning, Started: WarStarted}
+	warTimerMutex.Unlock()
+
+	return map[string]WarStatus{
+		WAR_FACTION: {
+			Scheduled:    WarSchedules[WAR_FACTION].Running(),
+			EntranceOpen: fw_isFactionWarEntranceActive,
+			Started:      fw_isFactionWarStarted,
+		},
+		WAR_FLAG_KINGDOM: {
+			Scheduled:    WarSchedules[WAR_FLAG_KINGDOM].Running(),
+			EntranceOpen: isFlagKingdomEntranceActive,
+			Started:      isFlagKingdomStarted,
+		},
+		WAR_GREAT: great,
+	}
+}
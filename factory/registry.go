@@ -0,0 +1,208 @@
+package factoy
+
+import (
+	"fmt"
+	"log"
+	dbg "runtime/debug"
+	"strconv"
+	"time"
+
+	"github.com/paulbellamy/ratecounter"
+	"github.com/twodragon/kore-server/database"
+	"github.com/twodragon/kore-server/metrics"
+	"github.com/twodragon/kore-server/server"
+	"github.com/twodragon/kore-server/utils"
+)
+
+// State is the session state a route needs before its handler is called.
+type State byte
+
+const (
+	IN_GAME            State = iota // character spawned in the world, the default
+	ANY                             // no login yet
+	LOGGED_IN                       // account logged in
+	CHARACTER_SELECTED              // character selected, not spawned yet
+	GM_ONLY                         // in game with a GM account
+)
+
+var (
+	SLOW_HANDLER = 250 * time.Millisecond
+
+	handlerDuration = metrics.NewHistogramVec("kore_handler_duration_seconds", "Latency of the packet handlers by opcode.",
+		metrics.DefBuckets, "opcode")
+	handlerPanics = metrics.NewCounterVec("kore_handler_panics_total", "Panics recovered in the packet handlers by opcode.", "opcode")
+)
+
+// UNKNOWN_OPCODE labels the metrics of the packets with no route.
+const UNKNOWN_OPCODE = "unknown"
+
+type Route struct {
+	Handler   Factory
+	State     State
+	MinLength int // whole packet, header and trailer included
+	Rate      int // packets per second per socket, 0 for no limit
+}
+
+type Request struct {
+	Socket *database.Socket
+	Data   []byte
+	Opcode uint16
+	Route  *Route
+	Label  string // opcode label of the metrics, see opcodeLabel
+}
+
+type Next func(*Request) ([]byte, error)
+
+type Middleware func(Next) Next
+
+// Chain wraps h with the middlewares, the first one being the outermost.
+func Chain(h Next, middlewares ...Middleware) Next {
+	for i := len(middlewares) - 1; i >= 0; i-- {
+		h = middlewares[i](h)
+	}
+	return h
+}
+
+func Register(opcode uint16, route *Route) {
+	pkgTypes[opcode] = route
+}
+
+func RegisterByte(opcode byte, route *Route) {
+	pkgTypes2[opcode] = route
+}
+
+// opcodeLabel is the metric label of opcode: the opcode of a route registered
+// by opcode, its high byte followed by "xx" for a route registered by byte and
+// UNKNOWN_OPCODE otherwise, so that clients can't create series at will.
+func opcodeLabel(opcode uint16) string {
+	if _, ok := pkgTypes[opcode]; ok {
+		return strconv.Itoa(int(opcode))
+	}
+	if _, ok := pkgTypes2[byte(opcode/256)]; ok {
+		return strconv.Itoa(int(opcode/256)) + "xx"
+	}
+	return UNKNOWN_OPCODE
+}
+
+func handle(r *Request) ([]byte, error) {
+	return r.Route.Handler.Handle(r.Socket, r.Data)
+}
+
+func Recover(next Next) Next {
+	return func(r *Request) (resp []byte, err error) {
+		defer func() {
+			if e := recover(); e != nil {
+				handlerPanics.With(r.Label).Inc()
+				s := r.Socket
+				log.Println()
+				log.Println(e)
+				log.Printf("%+v", string(dbg.Stack()))
+
+				if s.User != nil {
+					log.Printf("User ID: %s", s.User.ID)
+				}
+				if s.Character != nil {
+					log.Printf("Character ID: %d\t Character Name: %s", s.Character.ID, s.Character.Name)
+				}
+
+				fmt.Printf("Data: ")
+				p := utils.Packet{}
+				p.Concat(r.Data)
+				p.Print()
+				log.Println()
+
+				resp, err = nil, nil
+			}
+		}()
+
+		return next(r)
+	}
+}
+
+func Timing(next Next) Next {
+	return func(r *Request) ([]byte, error) {
+		start := time.Now()
+		resp, err := next(r)
+
+		elapsed := time.Since(start)
+		handlerDuration.With(r.Label).Observe(elapsed.Seconds())
+		if elapsed > SLOW_HANDLER {
+			log.Printf("Slow handler for opcode %d: %s", r.Opcode, elapsed)
+		}
+		return resp, err
+	}
+}
+
+func Logging(next Next) Next {
+	return func(r *Request) ([]byte, error) {
+		switch database.DEBUG_FACTORY {
+		case 1:
+			log.Print(r.Opcode)
+			log.Print(r.Opcode / 256)
+		case 2:
+			log.Print(r.Opcode)
+		case 3:
+			log.Print(r.Opcode / 256)
+		}
+		return next(r)
+	}
+}
+
+func RequireState(next Next) Next {
+	return func(r *Request) ([]byte, error) {
+		s := r.Socket
+
+		ok := true
+		switch r.Route.State {
+		case LOGGED_IN:
+			ok = s.User != nil
+		case CHARACTER_SELECTED:
+			ok = s.User != nil && s.Character != nil
+		case IN_GAME:
+			ok = s.User != nil && s.Character != nil && s.Character.IsOnline
+		case GM_ONLY:
+			ok = s.User != nil && s.Character != nil && s.Character.IsOnline && s.User.UserType >= server.GM_USER
+		}
+
+		if !ok {
+			if active_logs {
+				log.Printf("Opcode %d dropped, state %d not reached by %s", r.Opcode, r.Route.State, s.ClientAddr)
+			}
+			return nil, nil
+		}
+		return next(r)
+	}
+}
+
+func RequireLength(next Next) Next {
+	return func(r *Request) ([]byte, error) {
+		if len(r.Data) < r.Route.MinLength {
+			return nil, fmt.Errorf("opcode %d: packet too short (%d < %d)", r.Opcode, len(r.Data), r.Route.MinLength)
+		}
+		return next(r)
+	}
+}
+
+func RateLimit(next Next) Next {
+	return func(r *Request) ([]byte, error) {
+		if r.Route.Rate <= 0 {
+			return next(r)
+		}
+
+		s := r.Socket
+		if s.RateCounters == nil {
+			s.RateCounters = make(map[uint16]*ratecounter.RateCounter)
+		}
+		counter, ok := s.RateCounters[r.Opcode]
+		if !ok {
+			counter = ratecounter.NewRateCounter(time.Second)
+			s.RateCounters[r.Opcode] = counter
+		}
+
+		counter.Incr(1)
+		if counter.Rate() > int64(r.Route.Rate) {
+			return nil, nil
+		}
+		return next(r)
+	}
+}
@@ -0,0 +1,46 @@
+package factoy
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/twodragon/kore-server/database"
+	"github.com/twodragon/kore-server/metrics"
+)
+
+func TestOpcodeLabel(t *testing.T) {
+	RegisterByte(0xF0, &Route{Handler: nil})
+	defer delete(pkgTypes2, 0xF0)
+
+	for opcode, want := range map[uint16]string{
+		0:      "0",
+		2313:   "2313",
+		0xF012: "240xx",
+		0xF0FF: "240xx",
+		0xEEEE: UNKNOWN_OPCODE,
+		1:      UNKNOWN_OPCODE,
+	} {
+		if got := opcodeLabel(opcode); got != want {
+			t.Errorf("opcodeLabel(%d) = %q, want %q", opcode, got, want)
+		}
+	}
+}
+
+func TestUnknownOpcodesShareASeries(t *testing.T) {
+	for opcode := uint16(0xEE00); opcode < 0xEE10; opcode++ {
+		if resp, err := database.Handler(nil, nil, opcode); resp != nil || err != nil {
+			t.Fatalf("opcode %d: %v, %v", opcode, resp, err)
+		}
+	}
+
+	var buf bytes.Buffer
+	metrics.WriteTo(&buf)
+	out := buf.String()
+	if !strings.Contains(out, `kore_packets_in_total{opcode="unknown"} 16`+"\n") {
+		t.Errorf("unknown opcodes not counted together:\n%s", out)
+	}
+	if strings.Contains(out, `opcode="60928"`) {
+		t.Errorf("unknown opcode got its own series")
+	}
+}
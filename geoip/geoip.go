@@ -0,0 +1,70 @@
+// Package geoip looks IPs up in local IP intelligence files, MaxMind DB
+// (.mmdb) and IP2Location or IP2Proxy (.BIN) files. Nothing is queried over
+// the network.
+package geoip
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+var ErrBadFile = errors.New("geoip: unknown or corrupt file")
+
+// Info is what the files know about an IP. Empty fields are unknown.
+type Info struct {
+	Country     string // ISO 3166 code
+	CountryName string
+	Proxy       bool
+	ProxyType   string // VPN, TOR, PUB, ... as the file names them
+}
+
+// Provider looks IPs up. A nil Info with a nil error means the IP is not in
+// the file.
+type Provider interface {
+	Lookup(ip net.IP) (*Info, error)
+}
+
+// Open loads the file at path, a MaxMind DB by its .mmdb extension and an
+// IP2Location or IP2Proxy file otherwise.
+func Open(path string) (Provider, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("geoip: %s", err)
+	}
+
+	if strings.EqualFold(filepath.Ext(path), ".mmdb") {
+		return openMMDB(data)
+	}
+	return openBIN(data, strings.Contains(strings.ToUpper(filepath.Base(path)), "PROXY"))
+}
+
+// Chain asks every provider and merges what they know, the first country
+// found is kept and an IP any of them flags is a proxy.
+type Chain []Provider
+
+func (c Chain) Lookup(ip net.IP) (*Info, error) {
+	var merged *Info
+	for _, p := range c {
+		info, err := p.Lookup(ip)
+		if err != nil {
+			return nil, err
+		}
+		if info == nil {
+			continue
+		}
+		if merged == nil {
+			merged = &Info{}
+		}
+		if merged.Country == "" {
+			merged.Country, merged.CountryName = info.Country, info.CountryName
+		}
+		if info.Proxy && !merged.Proxy {
+			merged.Proxy, merged.ProxyType = true, info.ProxyType
+		}
+	}
+	return merged, nil
+}
@@ -0,0 +1,42 @@
+package geoip
+
+import (
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestOpenAndChain(t *testing.T) {
+	dir := t.TempDir()
+	f := newMMDB(6)
+	f.insert("198.51.100.0/24", f.add(mmdbValue([][2]interface{}{{"is_anonymous_vpn", true}})))
+	files := map[string][]byte{
+		"anonymous.MMDB":           f.bytes(),
+		"IP2LOCATION-LITE-DB1.BIN": binFixture(1, 0, 2, []binRow{{"0.0.0.0", []string{"-"}}, {"198.51.100.0", []string{"KR"}}, {"198.51.101.0", []string{"-"}}}, nil, false),
+	}
+	var chain Chain
+	for name, data := range files {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, data, 0o600); err != nil {
+			t.Fatal(err)
+		}
+		p, err := Open(path)
+		if err != nil {
+			t.Fatalf("%s: %s", name, err)
+		}
+		chain = append(chain, p)
+	}
+	if _, err := Open(filepath.Join(dir, "missing.mmdb")); err == nil {
+		t.Error("opened a missing file")
+	}
+
+	info, err := chain.Lookup(net.ParseIP("198.51.100.7"))
+	want := Info{Country: "KR", CountryName: "South Korea", Proxy: true, ProxyType: "VPN"}
+	if err != nil || info == nil || *info != want {
+		t.Errorf("merged %+v %v, want %+v", info, err, want)
+	}
+	if info, err := chain.Lookup(net.ParseIP("2001:db8::1")); info != nil || err != nil {
+		t.Errorf("unknown IP: %+v %v", info, err)
+	}
+}
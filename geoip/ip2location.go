@@ -0,0 +1,169 @@
+package geoip
+
+import (
+	"bytes"
+	"encoding/binary"
+	"net"
+)
+
+const (
+	BIN_HEADER_SIZE = 29
+
+	PRODUCT_IP2LOCATION = 1
+	PRODUCT_IP2PROXY    = 2
+)
+
+// bin reads the IP2Location (country, DB1 and up) and IP2Proxy (PX1 and up)
+// formats. Rows start with the first IP of their range followed by 4 byte
+// columns, the string columns pointing to length prefixed strings.
+type bin struct {
+	file    []byte
+	proxy   bool
+	columns uint32
+
+	v4Count, v4Base, v4Index uint32
+	v6Count, v6Base, v6Index uint32
+
+	countryColumn uint32 // 0 based, after the IP
+	typeColumn    int    // of proxies, -1 if the file has none
+}
+
+// openBIN loads an IP2Location or IP2Proxy file. Files older than 2021 do not
+// say which product they are, proxy tells it from the file name then.
+func openBIN(file []byte, proxy bool) (*bin, error) {
+	if len(file) < BIN_HEADER_SIZE {
+		return nil, ErrBadFile
+	}
+	u32 := func(at int) uint32 { return binary.LittleEndian.Uint32(file[at:]) }
+
+	db := &bin{
+		file:    file,
+		columns: uint32(file[1]),
+		v4Count: u32(5), v4Base: u32(9),
+		v6Count: u32(13), v6Base: u32(17),
+		v4Index: u32(21), v6Index: u32(25),
+	}
+	if len(file) > BIN_HEADER_SIZE {
+		switch file[29] {
+		case PRODUCT_IP2LOCATION:
+			proxy = false
+		case PRODUCT_IP2PROXY:
+			proxy = true
+		}
+	}
+	db.proxy = proxy
+	if db.columns < 2 || db.v4Base == 0 {
+		return nil, ErrBadFile
+	}
+
+	// the country is the second column of every IP2Location file and of
+	// IP2Proxy PX1, the others have the proxy type there
+	db.countryColumn, db.typeColumn = 0, -1
+	if proxy && file[0] > 1 && db.columns >= 3 {
+		db.countryColumn, db.typeColumn = 1, 0
+	}
+	return db, nil
+}
+
+func (db *bin) Lookup(ip net.IP) (*Info, error) {
+	var key []byte // big endian
+	var count, base, index, ipSize uint32
+	if v4 := ip.To4(); v4 != nil {
+		key, count, base, index, ipSize = v4, db.v4Count, db.v4Base, db.v4Index, 4
+	} else if v6 := ip.To16(); v6 != nil && db.v6Count > 0 {
+		key, count, base, index, ipSize = v6, db.v6Count, db.v6Base, db.v6Index, 16
+	} else {
+		return nil, nil
+	}
+	if bytes.Equal(key, bytes.Repeat([]byte{0xFF}, len(key))) { // the last row ends before it
+		key = append([]byte{}, key...)
+		key[len(key)-1] = 0xFE
+	}
+	rowSize := ipSize + (db.columns-1)*4
+
+	low, high := uint32(0), count
+	if index > 0 {
+		at := index - 1 + (uint32(key[0])<<8|uint32(key[1]))*8
+		if !db.has(at, 8) {
+			return nil, ErrBadFile
+		}
+		low, high = binary.LittleEndian.Uint32(db.file[at:]), binary.LittleEndian.Uint32(db.file[at+4:])
+		if low > high || high > count {
+			return nil, ErrBadFile
+		}
+	}
+
+	for low <= high {
+		mid := (low + high) / 2
+		at := base - 1 + mid*rowSize
+		if !db.has(at, rowSize+ipSize) {
+			return nil, ErrBadFile
+		}
+		from, to := db.ip(at, ipSize), db.ip(at+rowSize, ipSize)
+
+		switch {
+		case bytes.Compare(key, from) < 0:
+			if mid == 0 {
+				return nil, nil
+			}
+			high = mid - 1
+		case bytes.Compare(key, to) >= 0:
+			low = mid + 1
+		default:
+			return db.row(at + ipSize), nil
+		}
+	}
+	return nil, nil
+}
+
+func (db *bin) has(at, n uint32) bool {
+	return uint64(at)+uint64(n) <= uint64(len(db.file))
+}
+
+// ip reads the little endian IP at as big endian.
+func (db *bin) ip(at, size uint32) []byte {
+	ip := make([]byte, size)
+	for i := range ip {
+		ip[i] = db.file[at+size-1-uint32(i)]
+	}
+	return ip
+}
+
+func (db *bin) str(at uint32) string {
+	if !db.has(at, 1) || !db.has(at+1, uint32(db.file[at])) {
+		return ""
+	}
+	return string(db.file[at+1 : at+1+uint32(db.file[at])])
+}
+
+func (db *bin) column(row uint32, i uint32) uint32 {
+	return binary.LittleEndian.Uint32(db.file[row+i*4:])
+}
+
+func (db *bin) row(at uint32) *Info {
+	p := db.column(at, db.countryColumn)
+	info := &Info{Country: db.str(p), CountryName: db.str(p + 3)}
+	if info.Country == "-" {
+		info.Country, info.CountryName = "", ""
+	}
+	if !db.proxy {
+		return info
+	}
+
+	if db.typeColumn < 0 { // PX1 only lists proxies
+		if info.Country != "" {
+			info.Proxy, info.ProxyType = true, "PROXY"
+		}
+		return info
+	}
+	kind := db.str(db.column(at, uint32(db.typeColumn)))
+	switch kind {
+	case "", "-", "DCH", "SES": // data centers and search engines do not hide anyone
+	default:
+		info.Proxy = true
+	}
+	if kind != "-" {
+		info.ProxyType = kind
+	}
+	return info
+}
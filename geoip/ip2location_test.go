@@ -0,0 +1,221 @@
+package geoip
+
+import (
+	"encoding/binary"
+	"errors"
+	"net"
+	"testing"
+)
+
+// binRow is a range of the file from its first IP, with the values of its
+// string columns; the last range ends at the top of the address space.
+type binRow struct {
+	from    string
+	columns []string
+}
+
+// binFixture builds an IP2Location or IP2Proxy file of the IPv4 and IPv6
+// rows, with the first-two-bytes index of the IPv4 table when indexed.
+func binFixture(dbType, product byte, columns int, v4, v6 []binRow, indexed bool) []byte {
+	file := make([]byte, 64)
+	file[0], file[1], file[29] = dbType, byte(columns), product
+	u32 := func(at int, v int) { binary.LittleEndian.PutUint32(file[at:], uint32(v)) }
+
+	strings := map[string]int{}
+	var pending []struct {
+		at    int
+		value string
+	}
+	table := func(rows []binRow, size int) (base int) {
+		base = len(file) + 1
+		top := append(rows, binRow{from: map[int]string{4: "255.255.255.255", 16: "ffff:ffff:ffff:ffff:ffff:ffff:ffff:ffff"}[size]})
+		for _, r := range top {
+			ip := net.ParseIP(r.from).To16()
+			if size == 4 {
+				ip = ip.To4()
+			}
+			for i := len(ip) - 1; i >= 0; i-- {
+				file = append(file, ip[i])
+			}
+			for c := 0; c < columns-1; c++ {
+				value := ""
+				if c < len(r.columns) {
+					value = r.columns[c]
+				}
+				pending = append(pending, struct {
+					at    int
+					value string
+				}{len(file), value})
+				file = append(file, 0, 0, 0, 0)
+			}
+		}
+		return base
+	}
+
+	u32(5, len(v4))
+	u32(9, table(v4, 4))
+	if len(v6) > 0 {
+		u32(13, len(v6))
+		u32(17, table(v6, 16))
+	}
+
+	if indexed {
+		u32(21, len(file)+1)
+		for i := 0; i < 1<<16; i++ {
+			first, last := uint32(i)<<16, uint32(i)<<16|0xFFFF
+			file = binary.LittleEndian.AppendUint32(file, uint32(binRowOf(v4, first)))
+			file = binary.LittleEndian.AppendUint32(file, uint32(binRowOf(v4, last)))
+		}
+	}
+
+	// the country code and name are read 3 bytes apart, "US" then its name
+	for _, p := range pending {
+		at, ok := strings[p.value]
+		if !ok {
+			at = len(file)
+			strings[p.value] = at
+			code, name := p.value, p.value
+			if len(code) == 2 {
+				name = map[string]string{"US": "United States", "KR": "South Korea", "-": "-"}[code]
+			}
+			file = append(file, byte(len(code)))
+			file = append(file, code...)
+			if len(code) == 2 {
+				file = append(file, byte(len(name)))
+				file = append(file, name...)
+			}
+		}
+		binary.LittleEndian.PutUint32(file[p.at:], uint32(at))
+	}
+	return file
+}
+
+// binRowOf returns the row of the IPv4 rows holding ip.
+func binRowOf(rows []binRow, ip uint32) int {
+	row := 0
+	for i, r := range rows {
+		if binary.BigEndian.Uint32(net.ParseIP(r.from).To4()) <= ip {
+			row = i
+		}
+	}
+	return row
+}
+
+var binV4 = []binRow{
+	{"0.0.0.0", []string{"-"}},
+	{"203.0.113.0", []string{"KR"}},
+	{"203.0.114.0", []string{"-"}},
+	{"224.0.0.0", []string{"US"}},
+}
+
+var binV6 = []binRow{
+	{"::", []string{"-"}},
+	{"2001:db8::", []string{"US"}},
+	{"2001:db9::", []string{"-"}},
+}
+
+func lookups(t *testing.T, name string, db Provider, want map[string]*Info) {
+	t.Helper()
+	for ip, w := range want {
+		info, err := db.Lookup(net.ParseIP(ip))
+		if err != nil {
+			t.Errorf("%s, %s: %s", name, ip, err)
+		} else if (info == nil) != (w == nil) || (info != nil && *info != *w) {
+			t.Errorf("%s, %s: %+v, want %+v", name, ip, info, w)
+		}
+	}
+}
+
+func TestIP2Location(t *testing.T) {
+	kr, us, none := &Info{Country: "KR", CountryName: "South Korea"}, &Info{Country: "US", CountryName: "United States"}, &Info{}
+
+	for _, indexed := range []bool{false, true} {
+		db, err := openBIN(binFixture(1, PRODUCT_IP2LOCATION, 2, binV4, binV6, indexed), true)
+		if err != nil {
+			t.Fatal(err)
+		}
+		name := map[bool]string{false: "DB1", true: "DB1 indexed"}[indexed]
+		lookups(t, name, db, map[string]*Info{
+			"0.0.0.0":            none,
+			"203.0.112.255":      none,
+			"203.0.113.0":        kr,
+			"203.0.113.255":      kr,
+			"203.0.114.0":        none,
+			"255.255.255.255":    us,
+			"::ffff:203.0.113.9": kr,
+			"2001:db8::1":        us,
+			"2001:db9::":         none,
+			"ffff::1":            none,
+		})
+	}
+
+	db, err := openBIN(binFixture(1, 0, 2, binV4, nil, false), false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lookups(t, "DB1 without IPv6", db, map[string]*Info{"203.0.113.1": kr, "2001:db8::1": nil})
+}
+
+func TestIP2Proxy(t *testing.T) {
+	px1, err := openBIN(binFixture(1, 0, 2, []binRow{{"0.0.0.0", []string{"-"}}, {"198.51.100.0", []string{"US"}}, {"198.51.101.0", []string{"-"}}}, nil, false), true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lookups(t, "PX1", px1, map[string]*Info{
+		"198.51.100.7": {Country: "US", CountryName: "United States", Proxy: true, ProxyType: "PROXY"},
+		"198.51.101.7": {},
+	})
+
+	px2, err := openBIN(binFixture(2, PRODUCT_IP2PROXY, 3, []binRow{
+		{"0.0.0.0", []string{"-", "-"}},
+		{"198.51.100.0", []string{"VPN", "US"}},
+		{"198.51.100.128", []string{"DCH", "KR"}},
+		{"198.51.101.0", []string{"-", "-"}},
+	}, nil, false), false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lookups(t, "PX2", px2, map[string]*Info{
+		"198.51.100.7":   {Country: "US", CountryName: "United States", Proxy: true, ProxyType: "VPN"},
+		"198.51.100.200": {Country: "KR", CountryName: "South Korea", ProxyType: "DCH"},
+		"198.51.101.7":   {},
+	})
+}
+
+func TestIP2LocationCorrupt(t *testing.T) {
+	good := binFixture(1, PRODUCT_IP2LOCATION, 2, binV4, binV6, true)
+
+	noColumns := append([]byte{}, good...)
+	noColumns[1] = 1
+	for name, file := range map[string][]byte{
+		"empty":        nil,
+		"short header": good[:BIN_HEADER_SIZE-1],
+		"one column":   noColumns,
+		"no IPv4 base": make([]byte, 64),
+	} {
+		if _, err := openBIN(file, false); !errors.Is(err, ErrBadFile) {
+			t.Errorf("%s: err %v, want %v", name, err, ErrBadFile)
+		}
+	}
+
+	badIndex := append([]byte{}, good...)
+	index := binary.LittleEndian.Uint32(badIndex[21:])
+	binary.LittleEndian.PutUint32(badIndex[index-1+203*256*8:], 1<<20) // 203.0/16 points past the rows
+	for name, test := range map[string]struct {
+		file []byte
+		ip   string
+	}{
+		"truncated rows":       {good[:70], "203.0.113.1"},
+		"truncated index":      {good[:int(index)+100], "203.0.113.1"},
+		"index past the rows":  {badIndex, "203.0.113.1"},
+		"truncated IPv6 table": {good[:int(binary.LittleEndian.Uint32(good[17:]))+20], "2001:db8::1"},
+	} {
+		db, err := openBIN(test.file, false)
+		if err != nil {
+			t.Fatalf("%s: %s", name, err)
+		}
+		if _, err := db.Lookup(net.ParseIP(test.ip)); !errors.Is(err, ErrBadFile) {
+			t.Errorf("%s: err %v, want %v", name, err, ErrBadFile)
+		}
+	}
+}
@@ -0,0 +1,277 @@
+package geoip
+
+import (
+	"bytes"
+	"encoding/binary"
+	"math"
+	"net"
+)
+
+const MMDB_DATA_SEPARATOR = 16 // zero bytes between the search tree and the data
+
+var mmdbMetadataMarker = []byte("\xAB\xCD\xEFMaxMind.com")
+
+// mmdb reads the MaxMind DB format, GeoIP2 and GeoLite2 Country or City for
+// the country and GeoIP2 Anonymous IP for proxies.
+type mmdb struct {
+	tree       []byte
+	data       []byte
+	nodeCount  uint
+	recordSize uint // bits
+	ipVersion  uint
+	ipv4Start  uint // node of ::/96 in an IPv6 tree
+}
+
+func openMMDB(file []byte) (*mmdb, error) {
+	at := bytes.LastIndex(file, mmdbMetadataMarker)
+	if at < 0 {
+		return nil, ErrBadFile
+	}
+	meta, _, err := decode(file[at+len(mmdbMetadataMarker):], 0)
+	if err != nil {
+		return nil, err
+	}
+	fields, ok := meta.(map[string]interface{})
+	if !ok {
+		return nil, ErrBadFile
+	}
+
+	db := &mmdb{
+		nodeCount:  uintOf(fields["node_count"]),
+		recordSize: uintOf(fields["record_size"]),
+		ipVersion:  uintOf(fields["ip_version"]),
+	}
+	switch db.recordSize {
+	case 24, 28, 32:
+	default:
+		return nil, ErrBadFile
+	}
+
+	treeSize := db.nodeCount * db.recordSize / 4
+	if treeSize+MMDB_DATA_SEPARATOR > uint(at) {
+		return nil, ErrBadFile
+	}
+	db.tree = file[:treeSize]
+	db.data = file[treeSize+MMDB_DATA_SEPARATOR : at]
+
+	if db.ipVersion == 6 {
+		for i := 0; i < 96 && db.ipv4Start < db.nodeCount; i++ {
+			db.ipv4Start = db.record(db.ipv4Start, 0)
+		}
+	}
+	return db, nil
+}
+
+// record reads the left (0) or right (1) record of node.
+func (db *mmdb) record(node, bit uint) uint {
+	b := db.tree[node*db.recordSize/4:]
+	switch db.recordSize {
+	case 24:
+		b = b[bit*3:]
+		return uint(b[0])<<16 | uint(b[1])<<8 | uint(b[2])
+	case 28:
+		if bit == 0 {
+			return uint(b[3]&0xF0)<<20 | uint(b[0])<<16 | uint(b[1])<<8 | uint(b[2])
+		}
+		return uint(b[3]&0x0F)<<24 | uint(b[4])<<16 | uint(b[5])<<8 | uint(b[6])
+	default:
+		return uint(binary.BigEndian.Uint32(b[bit*4:]))
+	}
+}
+
+func (db *mmdb) Lookup(ip net.IP) (*Info, error) {
+	node := uint(0)
+	bits := ip.To16()
+	if v4 := ip.To4(); v4 != nil {
+		bits = v4
+		if db.ipVersion == 6 {
+			node = db.ipv4Start
+		}
+	} else if db.ipVersion == 4 {
+		return nil, nil
+	}
+
+	for i := 0; i < len(bits)*8 && node < db.nodeCount; i++ {
+		node = db.record(node, uint(bits[i/8]>>(7-i%8))&1)
+	}
+	if node <= db.nodeCount {
+		return nil, nil // not found
+	}
+
+	offset := node - db.nodeCount - MMDB_DATA_SEPARATOR
+	if offset >= uint(len(db.data)) {
+		return nil, ErrBadFile
+	}
+	value, _, err := decode(db.data, offset)
+	if err != nil {
+		return nil, err
+	}
+	fields, _ := value.(map[string]interface{})
+	return mmdbInfo(fields), nil
+}
+
+// proxy flags of the Anonymous IP database, in the order they name the type
+var mmdbProxyFlags = []struct{ key, kind string }{
+	{"is_tor_exit_node", "TOR"},
+	{"is_public_proxy", "PUB"},
+	{"is_residential_proxy", "RES"},
+	{"is_anonymous_vpn", "VPN"},
+	{"is_anonymous", "ANON"},
+}
+
+func mmdbInfo(fields map[string]interface{}) *Info {
+	info := &Info{}
+	for _, key := range []string{"country", "registered_country"} {
+		country, _ := fields[key].(map[string]interface{})
+		if code, _ := country["iso_code"].(string); code != "" {
+			names, _ := country["names"].(map[string]interface{})
+			info.Country = code
+			info.CountryName, _ = names["en"].(string)
+			break
+		}
+	}
+
+	for _, flag := range mmdbProxyFlags {
+		if on, _ := fields[flag.key].(bool); on {
+			info.Proxy, info.ProxyType = true, flag.kind
+			break
+		}
+	}
+	traits, _ := fields["traits"].(map[string]interface{})
+	if on, _ := traits["is_anonymous_proxy"].(bool); on && !info.Proxy {
+		info.Proxy, info.ProxyType = true, "ANON"
+	}
+	return info
+}
+
+func uintOf(v interface{}) uint {
+	n, _ := v.(uint64)
+	return uint(n)
+}
+
+// decode reads the value of the data section at offset and returns it with
+// the offset after it. Pointers are followed, relative to the start of data.
+func decode(data []byte, offset uint) (interface{}, uint, error) {
+	if offset >= uint(len(data)) {
+		return nil, 0, ErrBadFile
+	}
+	ctrl := data[offset]
+	offset++
+	kind := uint(ctrl >> 5)
+
+	if kind == 1 { // pointer
+		size := uint(ctrl>>3) & 0x3
+		if offset+size+1 > uint(len(data)) {
+			return nil, 0, ErrBadFile
+		}
+		p := uint(ctrl & 0x7)
+		b := data[offset : offset+size+1]
+		switch size {
+		case 0:
+			p = p<<8 | uint(b[0])
+		case 1:
+			p = (p<<16 | uint(b[0])<<8 | uint(b[1])) + 2048
+		case 2:
+			p = (p<<24 | uint(b[0])<<16 | uint(b[1])<<8 | uint(b[2])) + 526336
+		default:
+			p = uint(binary.BigEndian.Uint32(b))
+		}
+		value, _, err := decode(data, p)
+		return value, offset + size + 1, err
+	}
+
+	if kind == 0 { // extended
+		if offset >= uint(len(data)) {
+			return nil, 0, ErrBadFile
+		}
+		kind = 7 + uint(data[offset])
+		offset++
+	}
+
+	size := uint(ctrl & 0x1F)
+	if size >= 29 {
+		n := size - 28
+		if offset+n > uint(len(data)) {
+			return nil, 0, ErrBadFile
+		}
+		extra := uint(0)
+		for _, b := range data[offset : offset+n] {
+			extra = extra<<8 | uint(b)
+		}
+		offset += n
+		size = []uint{29, 285, 65821}[n-1] + extra
+	}
+
+	switch kind {
+	case 7: // map
+		m := make(map[string]interface{}, size)
+		for i := uint(0); i < size; i++ {
+			key, next, err := decode(data, offset)
+			if err != nil {
+				return nil, 0, err
+			}
+			value, next, err := decode(data, next)
+			if err != nil {
+				return nil, 0, err
+			}
+			name, _ := key.(string)
+			m[name] = value
+			offset = next
+		}
+		return m, offset, nil
+
+	case 11: // array
+		a := make([]interface{}, 0, size)
+		for i := uint(0); i < size; i++ {
+			value, next, err := decode(data, offset)
+			if err != nil {
+				return nil, 0, err
+			}
+			a = append(a, value)
+			offset = next
+		}
+		return a, offset, nil
+
+	case 14: // boolean, the value is the size
+		return size != 0, offset, nil
+
+	case 13: // end marker
+		return nil, offset, nil
+	}
+
+	if offset+size > uint(len(data)) {
+		return nil, 0, ErrBadFile
+	}
+	b := data[offset : offset+size]
+	offset += size
+
+	switch kind {
+	case 2: // string
+		return string(b), offset, nil
+	case 3: // double
+		if size != 8 {
+			return nil, 0, ErrBadFile
+		}
+		return math.Float64frombits(binary.BigEndian.Uint64(b)), offset, nil
+	case 15: // float
+		if size != 4 {
+			return nil, 0, ErrBadFile
+		}
+		return float64(math.Float32frombits(binary.BigEndian.Uint32(b))), offset, nil
+	case 5, 6, 9: // uint16, uint32, uint64
+		n := uint64(0)
+		for _, c := range b {
+			n = n<<8 | uint64(c)
+		}
+		return n, offset, nil
+	case 8: // int32
+		n := int32(0)
+		for _, c := range b {
+			n = n<<8 | int32(c)
+		}
+		return int64(n), offset, nil
+	case 4, 10: // bytes, uint128
+		return b, offset, nil
+	}
+	return nil, 0, ErrBadFile
+}
@@ -0,0 +1,222 @@
+package geoip
+
+import (
+	"bytes"
+	"errors"
+	"net"
+	"testing"
+)
+
+// mmdbValue encodes v in the data section format: strings, uint32, bools,
+// maps, and pointers to an offset of the data section. Bytes are taken as
+// already encoded.
+type mmdbPointer uint
+
+func mmdbValue(v interface{}) []byte {
+	switch v := v.(type) {
+	case string:
+		return append([]byte{2<<5 | byte(len(v))}, v...)
+	case uint32:
+		return []byte{6<<5 | 4, byte(v >> 24), byte(v >> 16), byte(v >> 8), byte(v)}
+	case bool:
+		if v {
+			return []byte{1, 14 - 7}
+		}
+		return []byte{0, 14 - 7}
+	case []byte:
+		return v
+	case mmdbPointer:
+		return []byte{1<<5 | byte(v>>8)&0x7, byte(v)}
+	case [][2]interface{}: // a map, in order
+		b := []byte{byte(len(v)), 7 - 7}
+		for _, kv := range v {
+			b = append(b, mmdbValue(kv[0])...)
+			b = append(b, mmdbValue(kv[1])...)
+		}
+		return b
+	}
+	panic("mmdbValue: unsupported value")
+}
+
+// mmdbFixture builds a 24 bit record tree of the networks, each pointing to
+// the value of the data section at its offset.
+type mmdbFixture struct {
+	ipVersion int
+	nodes     [][2]int // child node, or -1 - the data offset, or 0 for none
+	data      []byte
+}
+
+func newMMDB(ipVersion int) *mmdbFixture {
+	return &mmdbFixture{ipVersion: ipVersion, nodes: [][2]int{{}}}
+}
+
+// add stores the value and returns its data offset.
+func (f *mmdbFixture) add(value []byte) int {
+	offset := len(f.data)
+	f.data = append(f.data, value...)
+	return offset
+}
+
+func (f *mmdbFixture) insert(cidr string, offset int) {
+	ip, network, err := net.ParseCIDR(cidr)
+	if err != nil {
+		panic(err)
+	}
+	bits, _ := network.Mask.Size()
+	key := ip.To4()
+	if f.ipVersion == 6 {
+		if key != nil { // IPv4 networks live under ::/96
+			bits += 96
+		}
+		key = ip.To16()
+		if ip.To4() != nil {
+			key = append(make([]byte, 12), ip.To4()...)
+		}
+	}
+
+	node := 0
+	for i := 0; i < bits; i++ {
+		bit := int(key[i/8]>>(7-i%8)) & 1
+		if i == bits-1 {
+			f.nodes[node][bit] = -1 - offset
+			return
+		}
+		if f.nodes[node][bit] <= 0 {
+			f.nodes = append(f.nodes, [2]int{})
+			f.nodes[node][bit] = len(f.nodes) - 1
+		}
+		node = f.nodes[node][bit]
+	}
+}
+
+func (f *mmdbFixture) bytes() []byte {
+	count := len(f.nodes)
+	var file []byte
+	for _, n := range f.nodes {
+		for _, r := range n {
+			v := count // not found
+			if r > 0 {
+				v = r
+			} else if r < 0 {
+				v = count + MMDB_DATA_SEPARATOR + (-1 - r)
+			}
+			file = append(file, byte(v>>16), byte(v>>8), byte(v))
+		}
+	}
+	file = append(file, make([]byte, MMDB_DATA_SEPARATOR)...)
+	file = append(file, f.data...)
+	file = append(file, mmdbMetadataMarker...)
+	return append(file, mmdbValue([][2]interface{}{
+		{"node_count", uint32(count)},
+		{"record_size", uint32(24)},
+		{"ip_version", uint32(f.ipVersion)},
+	})...)
+}
+
+func country(code, name string) []byte {
+	return mmdbValue([][2]interface{}{{"iso_code", code}, {"names", [][2]interface{}{{"en", name}}}})
+}
+
+func TestMMDB(t *testing.T) {
+	for _, ipVersion := range []int{4, 6} {
+		f := newMMDB(ipVersion)
+		kr := f.add(country("KR", "South Korea"))
+		krCountry := f.add(mmdbValue([][2]interface{}{{"country", mmdbPointer(kr)}}))
+		f.insert("203.0.113.0/24", krCountry)
+		tor := f.add(mmdbValue([][2]interface{}{{"is_anonymous", true}, {"is_tor_exit_node", true}}))
+		f.insert("198.51.100.7/32", tor)
+		registered := f.add(mmdbValue([][2]interface{}{
+			{"registered_country", [][2]interface{}{{"iso_code", "DE"}}},
+			{"traits", [][2]interface{}{{"is_anonymous_proxy", true}}},
+		}))
+		f.insert("192.0.2.0/25", registered)
+		if ipVersion == 6 {
+			us := f.add(mmdbValue([][2]interface{}{{"country", country("US", "United States")}}))
+			f.insert("2001:db8::/32", us)
+		}
+
+		db, err := openMMDB(f.bytes())
+		if err != nil {
+			t.Fatalf("IPv%d tree: %s", ipVersion, err)
+		}
+		tests := []struct {
+			ip   string
+			info *Info
+		}{
+			{"203.0.113.9", &Info{Country: "KR", CountryName: "South Korea"}},
+			{"198.51.100.7", &Info{Proxy: true, ProxyType: "TOR"}},
+			{"198.51.100.8", nil},
+			{"192.0.2.1", &Info{Country: "DE", Proxy: true, ProxyType: "ANON"}},
+			{"192.0.2.200", nil},
+			{"::ffff:203.0.113.9", &Info{Country: "KR", CountryName: "South Korea"}},
+			{"2001:db8::1", nil}, // in an IPv4 tree
+		}
+		if ipVersion == 6 {
+			tests[len(tests)-1].info = &Info{Country: "US", CountryName: "United States"}
+			tests = append(tests, struct {
+				ip   string
+				info *Info
+			}{"2001:db9::1", nil})
+		}
+		for _, test := range tests {
+			info, err := db.Lookup(net.ParseIP(test.ip))
+			if err != nil {
+				t.Errorf("IPv%d tree, %s: %s", ipVersion, test.ip, err)
+			} else if (info == nil) != (test.info == nil) || (info != nil && *info != *test.info) {
+				t.Errorf("IPv%d tree, %s: %+v, want %+v", ipVersion, test.ip, info, test.info)
+			}
+		}
+	}
+}
+
+func TestMMDBCorrupt(t *testing.T) {
+	f := newMMDB(6)
+	f.insert("203.0.113.0/24", f.add(mmdbValue([][2]interface{}{{"country", country("KR", "South Korea")}})))
+	good := f.bytes()
+	metadata := bytes.LastIndex(good, mmdbMetadataMarker)
+
+	for _, test := range []struct {
+		name string
+		file []byte
+	}{
+		{"empty", nil},
+		{"no metadata", good[:metadata]},
+		{"truncated metadata", good[:len(good)-10]},
+		{"tree larger than the file", good[len(good)-(len(good)-metadata):]},
+		{"record size 20", bytes.Replace(good, append([]byte("record_size"), 6<<5|4, 0, 0, 0, 24), append([]byte("record_size"), 6<<5|4, 0, 0, 0, 20), 1)},
+	} {
+		if _, err := openMMDB(test.file); !errors.Is(err, ErrBadFile) {
+			t.Errorf("%s: err %v, want %v", test.name, err, ErrBadFile)
+		}
+	}
+
+	for _, test := range []struct {
+		name string
+		data []byte
+	}{
+		{"map past the end", []byte{5, 0, 2<<5 | 1, 'a'}},
+		{"string past the end", mmdbValue([][2]interface{}{{"country", "KR"}})[:6]},
+		{"pointer past the end", mmdbValue([][2]interface{}{{"country", mmdbPointer(1000)}})},
+		{"unknown type", []byte{0, 20}},
+	} {
+		f := newMMDB(4)
+		f.insert("203.0.113.0/24", f.add(test.data))
+		db, err := openMMDB(f.bytes())
+		if err != nil {
+			t.Fatalf("%s: %s", test.name, err)
+		}
+		if _, err := db.Lookup(net.ParseIP("203.0.113.1")); !errors.Is(err, ErrBadFile) {
+			t.Errorf("%s: err %v, want %v", test.name, err, ErrBadFile)
+		}
+	}
+
+	f = newMMDB(4)
+	f.insert("203.0.113.0/24", 5000) // past the data section
+	db, err := openMMDB(f.bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.Lookup(net.ParseIP("203.0.113.1")); !errors.Is(err, ErrBadFile) {
+		t.Errorf("record past the data: err %v, want %v", err, ErrBadFile)
+	}
+}
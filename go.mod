@@ -9,17 +9,18 @@ require (
 	github.com/lib/pq v1.10.7
 	github.com/nats-io/nats-server/v2 v2.9.15
 	github.com/nats-io/nats.go v1.24.0
-	github.com/orcaman/concurrent-map v1.0.0
 	github.com/osamingo/boolconv v0.0.0-20151016060535-9ef56333404f
 	github.com/paulbellamy/ratecounter v0.2.0
 	github.com/robfig/cron v1.2.0
 	github.com/thoas/go-funk v0.9.3
 	github.com/tidwall/gjson v1.14.4
 	github.com/xuri/excelize/v2 v2.7.0
+	golang.org/x/crypto v0.7.0
 	golang.org/x/net v0.8.0
 	golang.org/x/text v0.8.0
 	gopkg.in/gorp.v1 v1.7.2
 	gopkg.in/guregu/null.v3 v3.5.0
+	gopkg.in/yaml.v3 v3.0.1
 )
 
 require (
@@ -36,7 +37,6 @@ require (
 	github.com/tidwall/pretty v1.2.1 // indirect
 	github.com/xuri/efp v0.0.0-20220603152613-6918739fd470 // indirect
 	github.com/xuri/nfp v0.0.0-20220409054826-5e722a1d9e22 // indirect
-	golang.org/x/crypto v0.7.0 // indirect
 	golang.org/x/sync v0.1.0 // indirect
 	golang.org/x/sys v0.6.0 // indirect
 	golang.org/x/time v0.3.0 // indirect
@@ -0,0 +1,225 @@
+// Package health tells orchestrators whether the server is alive and ready
+// for players. Liveness is made of the heartbeats of the server loops, sent
+// with Beat. Readiness is made of the startup steps the server reports with
+// Set and of probes checked on every request.
+package health
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"runtime"
+	"sort"
+	"sync"
+	"time"
+)
+
+// checks of the game server
+const (
+	DATABASE = "database"      // connection to the database at startup
+	PING     = "database_ping" // ping of the database
+	TABLES   = "tables"        // data tables read from the database
+	AI       = "ai"            // NPCs and mobs spawned
+	NATS     = "nats"          // connection to the embedded NATS server
+	LISTENER = "listener"      // game port bound
+	DRAINING = "draining"      // the server takes no more logins
+)
+
+// heartbeats of the game server
+const (
+	GAME_LOOP = "game_loop" // the watchdog taking the game locks
+)
+
+const (
+	STATUS_OK      = "ok"
+	STATUS_PENDING = "pending"
+	STATUS_FAILED  = "failed"
+
+	PROBE_TIMEOUT     = 2 * time.Second
+	HEARTBEAT_TIMEOUT = 30 * time.Second
+)
+
+// Check is the state of a check.
+type Check struct {
+	Name     string    `json:"name"`
+	Status   string    `json:"status"`
+	Error    string    `json:"error,omitempty"`
+	Attempts int       `json:"attempts,omitempty"` // of a startup step
+	Since    time.Time `json:"since"`              // of the status, of the run for probes
+	Duration string    `json:"duration,omitempty"` // of a probe
+}
+
+type probe func(ctx context.Context) error
+
+var (
+	startedAt = time.Now()
+
+	steps  = make(map[string]*Check)
+	probes = make(map[string]probe)
+	beats  = make(map[string]time.Time)
+	mutex  sync.Mutex
+)
+
+// Expect adds startup steps, pending until they are Set.
+func Expect(names ...string) {
+	mutex.Lock()
+	defer mutex.Unlock()
+	for _, name := range names {
+		if _, ok := steps[name]; !ok {
+			steps[name] = &Check{Name: name, Status: STATUS_PENDING, Since: time.Now()}
+		}
+	}
+}
+
+// Set reports an attempt of a startup step, done if err is nil. A step that
+// failed stays not ready until an attempt succeeds.
+func Set(name string, err error) {
+	mutex.Lock()
+	defer mutex.Unlock()
+	c, ok := steps[name]
+	if !ok {
+		c = &Check{Name: name}
+		steps[name] = c
+	}
+
+	status, text := STATUS_OK, ""
+	if err != nil {
+		status, text = STATUS_FAILED, err.Error()
+	}
+	if c.Status != status {
+		c.Since = time.Now()
+	}
+	c.Status, c.Error = status, text
+	c.Attempts++
+}
+
+// Beat reports that the loop name still runs. Once it beat, the server is not
+// alive anymore when it stops beating for HEARTBEAT_TIMEOUT.
+func Beat(name string) {
+	mutex.Lock()
+	defer mutex.Unlock()
+	beats[name] = time.Now()
+}
+
+// Stale returns the loops that stopped beating at now, sorted by name.
+func Stale(now time.Time) []string {
+	mutex.Lock()
+	defer mutex.Unlock()
+	var stale []string
+	for name, last := range beats {
+		if now.Sub(last) > HEARTBEAT_TIMEOUT {
+			stale = append(stale, name)
+		}
+	}
+	sort.Strings(stale)
+	return stale
+}
+
+// Probe adds a check run on every readiness request, ready if it returns
+// nil within PROBE_TIMEOUT.
+func Probe(name string, p func(ctx context.Context) error) {
+	mutex.Lock()
+	defer mutex.Unlock()
+	probes[name] = p
+}
+
+// Checks runs the probes and returns every check sorted by name, and whether
+// they are all ok.
+func Checks(ctx context.Context) ([]*Check, bool) {
+	mutex.Lock()
+	checks := make([]*Check, 0, len(steps)+len(probes))
+	for _, c := range steps {
+		step := *c
+		checks = append(checks, &step)
+	}
+	run := make(map[string]probe, len(probes))
+	for name, p := range probes {
+		run[name] = p
+	}
+	mutex.Unlock()
+
+	var wg sync.WaitGroup
+	var checksMutex sync.Mutex
+	for name, p := range run {
+		wg.Add(1)
+		go func(name string, p probe) {
+			defer wg.Done()
+			ctx, cancel := context.WithTimeout(ctx, PROBE_TIMEOUT)
+			defer cancel()
+
+			start := time.Now()
+			c := &Check{Name: name, Status: STATUS_OK, Since: start}
+			if err := runProbe(ctx, p); err != nil {
+				c.Status, c.Error = STATUS_FAILED, err.Error()
+			}
+			c.Duration = time.Since(start).Round(time.Microsecond).String()
+
+			checksMutex.Lock()
+			checks = append(checks, c)
+			checksMutex.Unlock()
+		}(name, p)
+	}
+	wg.Wait()
+
+	sort.Slice(checks, func(i, j int) bool {
+		return checks[i].Name < checks[j].Name
+	})
+	ready := true
+	for _, c := range checks {
+		ready = ready && c.Status == STATUS_OK
+	}
+	return checks, ready
+}
+
+// runProbe returns the error of p, or of ctx if p does not return in time.
+func runProbe(ctx context.Context, p probe) error {
+	done := make(chan error, 1)
+	go func() {
+		done <- p(ctx)
+	}()
+	select {
+	case err := <-done:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Healthz answers 200 while every loop beats, 503 with the stale loops once
+// one stops, orchestrators restart the server when it does not answer 200.
+func Healthz(w http.ResponseWriter, r *http.Request) {
+	status, code := STATUS_OK, http.StatusOK
+	stale := Stale(time.Now())
+	if len(stale) > 0 {
+		status, code = STATUS_FAILED, http.StatusServiceUnavailable
+	}
+	body := map[string]interface{}{
+		"status":     status,
+		"uptime":     time.Since(startedAt).Round(time.Second).String(),
+		"goroutines": runtime.NumGoroutine(),
+	}
+	if len(stale) > 0 {
+		body["stale"] = stale
+	}
+	writeJSON(w, code, body)
+}
+
+// Readyz answers 200 when every check is ok, 503 otherwise, with the checks.
+func Readyz(w http.ResponseWriter, r *http.Request) {
+	checks, ready := Checks(r.Context())
+	status, code := "ready", http.StatusOK
+	if !ready {
+		status, code = "not_ready", http.StatusServiceUnavailable
+	}
+	writeJSON(w, code, map[string]interface{}{
+		"status": status,
+		"checks": checks,
+	})
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
@@ -1,6 +1,9 @@
 package main
 
 import (
+	"context"
+	"errors"
+	"flag"
 	"fmt"
 	"log"
 	"net"
@@ -11,30 +14,49 @@ import (
 	"time"
 
 	"github.com/robfig/cron"
+	"github.com/twodragon/kore-server/account"
+	"github.com/twodragon/kore-server/admin"
 	"github.com/twodragon/kore-server/ai"
+	"github.com/twodragon/kore-server/auth"
 	"github.com/twodragon/kore-server/config"
 	"github.com/twodragon/kore-server/database"
 	_ "github.com/twodragon/kore-server/factory"
+	"github.com/twodragon/kore-server/health"
 	"github.com/twodragon/kore-server/logging"
+	"github.com/twodragon/kore-server/metrics"
 	"github.com/twodragon/kore-server/nats"
+	"github.com/twodragon/kore-server/proxy"
 	//	"github.com/twodragon/kore-server/redis"
 )
 
 var (
 	logger    = logging.Logger
 	CacheFile = "cache.json"
+
+	configFile  = flag.String("config", "config.yaml", "path of the YAML configuration file")
+	printConfig = flag.Bool("print-config", false, "print the effective configuration with secrets redacted and exit")
 )
 
 func initDB_PostgreSQL() {
 	for {
 		err := database.InitPostgreSQL()
+		health.Set(health.DATABASE, err)
 		if err == nil {
 			log.Print("Connected to PostgreSQL database...")
-			return
+			break
 		}
 		log.Print(fmt.Sprintf("PostgreSQL Database connection error: %+v, waiting 30 sec...", err))
 		time.Sleep(time.Duration(30) * time.Second)
 	}
+	for {
+		err := database.LoadTables()
+		health.Set(health.TABLES, err)
+		if err == nil {
+			return
+		}
+		log.Print(fmt.Sprintf("PostgreSQL Database tables error: %+v, waiting 30 sec...", err))
+		time.Sleep(time.Duration(30) * time.Second)
+	}
 }
 
 /*
@@ -64,19 +86,51 @@ func StartLogging() {
 	log.SetOutput(fi)
 }
 
-func startServer() {
+// initHealth sets the checks of /readyz: the startup steps main reports, and
+// the database, NATS and drain mode probed on every request. /healthz fails
+// once the watchdog stops beating.
+func initHealth() {
+	health.Expect(health.DATABASE, health.TABLES, health.AI, health.LISTENER)
+	health.Probe(health.PING, database.Ping)
+	health.Probe(health.NATS, func(ctx context.Context) error {
+		return nats.Connected()
+	})
+	health.Probe(health.DRAINING, func(ctx context.Context) error {
+		if database.IsDraining() {
+			return errors.New("shutting down")
+		}
+		return nil
+	})
+}
+
+func startServer() net.Listener {
 	cfg := config.Default
 	port := cfg.Server.Port
 	listen, err := net.Listen("tcp4", ":"+strconv.Itoa(port))
 	if err != nil {
-		fmt.Printf("Socket listen port %d failed,%s", port, err)
+		log.Fatalf("Socket listen port %d failed,%s", port, err)
+	}
+	if cfg.Server.ProxyProtocol {
+		timeout := time.Duration(cfg.Server.ProxyHeaderTimeout) * time.Second
+		listen, err = proxy.NewListener(listen, cfg.Server.ProxyTrustedCIDRs, timeout)
+		if err != nil {
+			log.Fatalln(err)
+		}
+		log.Printf("PROXY protocol enabled for %v", cfg.Server.ProxyTrustedCIDRs)
 	}
-	defer listen.Close()
 	log.Printf("Begin listen port: %d", port)
 	//StartLogging()
+	go acceptConnections(listen)
+	return listen
+}
+
+func acceptConnections(listen net.Listener) {
 	for {
 		conn, err := listen.Accept()
 		if err != nil {
+			if database.IsDraining() {
+				return
+			}
 			log.Fatalln(err)
 			continue
 		}
@@ -85,7 +139,6 @@ func startServer() {
 		go ws.Read()
 
 	}
-
 }
 
 //
@@ -109,9 +162,42 @@ func cronHandler() {
 func main() {
 
 	var err error
-	go func() {
-		fmt.Println(http.ListenAndServe("localhost:8080", nil))
-	}()
+	flag.Parse()
+	if path := os.Getenv("KORE_CONFIG"); path != "" {
+		*configFile = path
+	}
+	if err = config.Load(*configFile); err != nil {
+		log.Fatalln(err)
+	}
+	if *printConfig {
+		if err = config.Default.Dump(os.Stdout); err != nil {
+			log.Fatalln(err)
+		}
+		return
+	}
+	database.ApplyConfig()
+	if err = database.InitTuning(config.Default.Game.TuningFile); err != nil {
+		log.Fatalln(err)
+	}
+	nats.DefaultOptions.Host = config.Default.Nats.Host
+	nats.DefaultOptions.Port = config.Default.Nats.Port
+
+	if addr := config.Default.Debug.PprofAddress; addr != "" {
+		go func() {
+			fmt.Println(http.ListenAndServe(addr, nil))
+		}()
+	}
+	initHealth()
+	database.StartWatchdog()
+	if addr := config.Default.Monitoring.Address; addr != "" {
+		go func() {
+			mux := http.NewServeMux()
+			mux.Handle("/metrics", metrics.Handler())
+			mux.HandleFunc("/healthz", health.Healthz)
+			mux.HandleFunc("/readyz", health.Readyz)
+			log.Println(http.ListenAndServe(addr, mux))
+		}()
+	}
 	log.Print("-----------------Initialize pgsql-------------------------------")
 	initDB_PostgreSQL()
 	log.Print("--------------------------------------------------------------")
@@ -119,29 +205,51 @@ func main() {
 	cronHandler()
 	log.Print("--------------------------------------------------------------")
 	err = ai.Init()
+	health.Set(health.AI, err)
 	if err != nil {
-		log.Fatalln(err)
+		log.Fatalln(err) // no retry, Init waits for the registers once
 	}
 	log.Print("--------------------------------------------------------------")
 	//ai.InitBabyPets()
 	//ai.InitHouseItems()
 	//go database.HandleClanBuffs()
 	//go database.StartLoto() buglu
-	//go database.UnbanUsers()
+	database.CloseStaleSessions()
+	if err = auth.InitGeoIP(); err != nil {
+		log.Fatalln(err)
+	}
+	if err = database.InitSanctions(); err != nil {
+		log.Fatalln(err)
+	}
+	database.StartCharacterPurge()
 	//go database.InitDiscordBot()
 	//go database.DeleteInexistentItems()
 	//go database.FactionWarSchedule()
 	go database.DeleteUnusedStats()
 	log.Print("--------------------------------------------------------------")
-	s := nats.RunServer(nil)
+	s, err := nats.RunServer(nil)
+	if err != nil {
+		log.Fatalln(err)
+	}
 	log.Print("-------------------------------------------------------------*")
-	defer s.Shutdown()
 	c, err := nats.ConnectSelf(nil)
 	if err != nil {
 		log.Fatalln(err)
 	}
-	defer c.Close()
 	go database.EpochHandler()
-	startServer()
+	if addr := config.Default.Account.Address; addr != "" {
+		go func() {
+			log.Println(account.ListenAndServe(addr, config.Default.Account.APIKey))
+		}()
+	}
+	if addr := config.Default.Admin.Address; addr != "" {
+		go func() {
+			log.Println(admin.ListenAndServe(addr, config.Default.Admin.APIKey))
+		}()
+	}
+	listen := startServer()
+	health.Set(health.LISTENER, nil)
+
+	os.Exit(waitForShutdown(listen, s, c))
 
 }
@@ -0,0 +1,368 @@
+// Package metrics keeps counters, gauges and histograms and writes them in
+// the Prometheus text exposition format. The packages measuring something
+// declare their metrics in package variables, Handler serves them all.
+package metrics
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+const (
+	COUNTER   = "counter"
+	GAUGE     = "gauge"
+	HISTOGRAM = "histogram"
+)
+
+var (
+	// DefBuckets are the histogram buckets for latencies in seconds.
+	DefBuckets = []float64{.0005, .001, .0025, .005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+	families      []*family
+	familiesMutex sync.Mutex
+)
+
+// family is a metric with its series.
+type family struct {
+	name, help, kind string
+	labels           []string
+	collect          func(emit func(value float64, labelValues ...string))
+	histogram        *HistogramVec
+}
+
+func register(f *family) {
+	familiesMutex.Lock()
+	defer familiesMutex.Unlock()
+	for _, other := range families {
+		if other.name == f.name {
+			panic("metrics: " + f.name + " registered twice")
+		}
+	}
+	families = append(families, f)
+}
+
+// value is a float64 changed atomically.
+type value struct {
+	bits uint64
+}
+
+func (v *value) add(delta float64) {
+	for {
+		old := atomic.LoadUint64(&v.bits)
+		new := math.Float64bits(math.Float64frombits(old) + delta)
+		if atomic.CompareAndSwapUint64(&v.bits, old, new) {
+			return
+		}
+	}
+}
+
+func (v *value) set(f float64) {
+	atomic.StoreUint64(&v.bits, math.Float64bits(f))
+}
+
+func (v *value) get() float64 {
+	return math.Float64frombits(atomic.LoadUint64(&v.bits))
+}
+
+// Counter only goes up.
+type Counter struct {
+	v value
+}
+
+func (c *Counter) Inc() {
+	c.v.add(1)
+}
+
+// Add adds delta, which can not be negative.
+func (c *Counter) Add(delta float64) {
+	if delta < 0 {
+		return
+	}
+	c.v.add(delta)
+}
+
+// Gauge goes up and down.
+type Gauge struct {
+	v value
+}
+
+func (g *Gauge) Set(f float64) {
+	g.v.set(f)
+}
+
+func (g *Gauge) Add(delta float64) {
+	g.v.add(delta)
+}
+
+func (g *Gauge) Inc() {
+	g.v.add(1)
+}
+
+func (g *Gauge) Dec() {
+	g.v.add(-1)
+}
+
+// vec keeps the series of a metric by label values.
+type vec struct {
+	labels []string
+	series map[string]interface{}
+	values map[string][]string
+	mutex  sync.RWMutex
+}
+
+func newVec(labels []string) *vec {
+	return &vec{labels: labels, series: make(map[string]interface{}), values: make(map[string][]string)}
+}
+
+func (v *vec) with(labelValues []string, create func() interface{}) interface{} {
+	if len(labelValues) != len(v.labels) {
+		panic(fmt.Sprintf("metrics: %d label values for %d labels", len(labelValues), len(v.labels)))
+	}
+	key := strings.Join(labelValues, "\xff")
+
+	v.mutex.RLock()
+	s, ok := v.series[key]
+	v.mutex.RUnlock()
+	if ok {
+		return s
+	}
+
+	v.mutex.Lock()
+	defer v.mutex.Unlock()
+	if s, ok = v.series[key]; !ok {
+		s = create()
+		v.series[key] = s
+		v.values[key] = append([]string(nil), labelValues...)
+	}
+	return s
+}
+
+// each calls f with the series sorted by label values.
+func (v *vec) each(f func(s interface{}, labelValues []string)) {
+	v.mutex.RLock()
+	keys := make([]string, 0, len(v.series))
+	for key := range v.series {
+		keys = append(keys, key)
+	}
+	v.mutex.RUnlock()
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		v.mutex.RLock()
+		s, values := v.series[key], v.values[key]
+		v.mutex.RUnlock()
+		f(s, values)
+	}
+}
+
+// CounterVec is a counter by label values.
+type CounterVec struct {
+	vec *vec
+}
+
+func (c *CounterVec) With(labelValues ...string) *Counter {
+	return c.vec.with(labelValues, func() interface{} { return &Counter{} }).(*Counter)
+}
+
+// GaugeVec is a gauge by label values.
+type GaugeVec struct {
+	vec *vec
+}
+
+func (g *GaugeVec) With(labelValues ...string) *Gauge {
+	return g.vec.with(labelValues, func() interface{} { return &Gauge{} }).(*Gauge)
+}
+
+// Histogram counts observations in buckets.
+type Histogram struct {
+	buckets []float64 // upper bounds
+	counts  []uint64  // by bucket, not cumulative
+	sum     value
+	count   uint64
+}
+
+func newHistogram(buckets []float64) *Histogram {
+	return &Histogram{buckets: buckets, counts: make([]uint64, len(buckets))}
+}
+
+func (h *Histogram) Observe(f float64) {
+	if i := sort.SearchFloat64s(h.buckets, f); i < len(h.buckets) {
+		atomic.AddUint64(&h.counts[i], 1)
+	}
+	h.sum.add(f)
+	atomic.AddUint64(&h.count, 1)
+}
+
+// HistogramVec is a histogram by label values.
+type HistogramVec struct {
+	buckets []float64
+	vec     *vec
+}
+
+func (h *HistogramVec) With(labelValues ...string) *Histogram {
+	return h.vec.with(labelValues, func() interface{} { return newHistogram(h.buckets) }).(*Histogram)
+}
+
+// NewCounter registers a counter without labels.
+func NewCounter(name, help string) *Counter {
+	c := &Counter{}
+	register(&family{name: name, help: help, kind: COUNTER, collect: func(emit func(float64, ...string)) {
+		emit(c.v.get())
+	}})
+	return c
+}
+
+func NewCounterVec(name, help string, labels ...string) *CounterVec {
+	c := &CounterVec{vec: newVec(labels)}
+	register(&family{name: name, help: help, kind: COUNTER, labels: labels, collect: func(emit func(float64, ...string)) {
+		c.vec.each(func(s interface{}, values []string) {
+			emit(s.(*Counter).v.get(), values...)
+		})
+	}})
+	return c
+}
+
+// NewGauge registers a gauge without labels.
+func NewGauge(name, help string) *Gauge {
+	g := &Gauge{}
+	register(&family{name: name, help: help, kind: GAUGE, collect: func(emit func(float64, ...string)) {
+		emit(g.v.get())
+	}})
+	return g
+}
+
+func NewGaugeVec(name, help string, labels ...string) *GaugeVec {
+	g := &GaugeVec{vec: newVec(labels)}
+	register(&family{name: name, help: help, kind: GAUGE, labels: labels, collect: func(emit func(float64, ...string)) {
+		g.vec.each(func(s interface{}, values []string) {
+			emit(s.(*Gauge).v.get(), values...)
+		})
+	}})
+	return g
+}
+
+// NewHistogram registers a histogram without labels, buckets being sorted
+// upper bounds.
+func NewHistogram(name, help string, buckets []float64) *Histogram {
+	return NewHistogramVec(name, help, buckets).With()
+}
+
+func NewHistogramVec(name, help string, buckets []float64, labels ...string) *HistogramVec {
+	h := &HistogramVec{buckets: buckets, vec: newVec(labels)}
+	register(&family{name: name, help: help, kind: HISTOGRAM, labels: labels, histogram: h})
+	return h
+}
+
+// NewCollector registers a metric read when it is written, collect emitting
+// a value for every series. Kind is COUNTER or GAUGE.
+func NewCollector(name, help, kind string, labels []string, collect func(emit func(value float64, labelValues ...string))) {
+	register(&family{name: name, help: help, kind: kind, labels: labels, collect: collect})
+}
+
+// WriteTo writes every metric in the text exposition format.
+func WriteTo(w io.Writer) error {
+	familiesMutex.Lock()
+	all := append([]*family(nil), families...)
+	familiesMutex.Unlock()
+	sort.Slice(all, func(i, j int) bool {
+		return all[i].name < all[j].name
+	})
+
+	out := bufio.NewWriter(w)
+	for _, f := range all {
+		fmt.Fprintf(out, "# HELP %s %s\n", f.name, escapeHelp(f.help))
+		fmt.Fprintf(out, "# TYPE %s %s\n", f.name, f.kind)
+		if f.histogram != nil {
+			f.histogram.vec.each(func(s interface{}, values []string) {
+				writeHistogram(out, f, s.(*Histogram), values)
+			})
+			continue
+		}
+		f.collect(func(v float64, values ...string) {
+			writeSample(out, f.name, f.labels, values, "", "", v)
+		})
+	}
+	return out.Flush()
+}
+
+func writeHistogram(out *bufio.Writer, f *family, h *Histogram, values []string) {
+	cumulative := uint64(0)
+	for i, bound := range h.buckets {
+		cumulative += atomic.LoadUint64(&h.counts[i])
+		writeSample(out, f.name+"_bucket", f.labels, values, "le", formatFloat(bound), float64(cumulative))
+	}
+	count := atomic.LoadUint64(&h.count)
+	writeSample(out, f.name+"_bucket", f.labels, values, "le", "+Inf", float64(count))
+	writeSample(out, f.name+"_sum", f.labels, values, "", "", h.sum.get())
+	writeSample(out, f.name+"_count", f.labels, values, "", "", float64(count))
+}
+
+// writeSample writes a line of the metric, extra being a label of its own
+// like le.
+func writeSample(out *bufio.Writer, name string, labels, values []string, extra, extraValue string, v float64) {
+	out.WriteString(name)
+	if len(labels) > 0 || extra != "" {
+		out.WriteByte('{')
+		for i, label := range labels {
+			if i > 0 {
+				out.WriteByte(',')
+			}
+			value := ""
+			if i < len(values) {
+				value = values[i]
+			}
+			fmt.Fprintf(out, "%s=\"%s\"", label, escapeLabel(value))
+		}
+		if extra != "" {
+			if len(labels) > 0 {
+				out.WriteByte(',')
+			}
+			fmt.Fprintf(out, "%s=\"%s\"", extra, extraValue)
+		}
+		out.WriteByte('}')
+	}
+	out.WriteByte(' ')
+	out.WriteString(formatFloat(v))
+	out.WriteByte('\n')
+}
+
+func formatFloat(f float64) string {
+	switch {
+	case math.IsInf(f, 1):
+		return "+Inf"
+	case math.IsInf(f, -1):
+		return "-Inf"
+	case math.IsNaN(f):
+		return "NaN"
+	}
+	return strconv.FormatFloat(f, 'g', -1, 64)
+}
+
+var (
+	helpEscaper  = strings.NewReplacer(`\`, `\\`, "\n", `\n`)
+	labelEscaper = strings.NewReplacer(`\`, `\\`, "\n", `\n`, `"`, `\"`)
+)
+
+func escapeHelp(s string) string {
+	return helpEscaper.Replace(s)
+}
+
+func escapeLabel(s string) string {
+	return labelEscaper.Replace(s)
+}
+
+// Handler serves the metrics to Prometheus.
+func Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		WriteTo(w)
+	})
+}
@@ -0,0 +1,216 @@
+package metrics
+
+import (
+	"bytes"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// useFamilies starts the test with no metric registered.
+func useFamilies(t *testing.T) {
+	t.Helper()
+	familiesMutex.Lock()
+	saved := families
+	families = nil
+	familiesMutex.Unlock()
+	t.Cleanup(func() {
+		familiesMutex.Lock()
+		families = saved
+		familiesMutex.Unlock()
+	})
+}
+
+func write(t *testing.T) string {
+	t.Helper()
+	var buf bytes.Buffer
+	if err := WriteTo(&buf); err != nil {
+		t.Fatal(err)
+	}
+	return buf.String()
+}
+
+func TestCounterAndGauge(t *testing.T) {
+	useFamilies(t)
+
+	c := NewCounter("test_requests_total", "Requests served.")
+	c.Inc()
+	c.Add(2.5)
+	c.Add(-1)
+
+	g := NewGauge("test_temperature", "Temperature now.")
+	g.Set(10)
+	g.Inc()
+	g.Dec()
+	g.Add(-0.5)
+
+	want := `# HELP test_requests_total Requests served.
+# TYPE test_requests_total counter
+test_requests_total 3.5
+# HELP test_temperature Temperature now.
+# TYPE test_temperature gauge
+test_temperature 9.5
+`
+	if got := write(t); got != want {
+		t.Errorf("got\n%s\nwant\n%s", got, want)
+	}
+}
+
+func TestVecSortedAndEscaped(t *testing.T) {
+	useFamilies(t)
+
+	c := NewCounterVec("test_packets_total", "Packets with \\ and\nlines.", "opcode", "kind")
+	c.With("b", "in").Inc()
+	c.With("a", `say "hi"\`+"\n").Add(2)
+	c.With("b", "in").Inc()
+
+	want := `# HELP test_packets_total Packets with \\ and\nlines.
+# TYPE test_packets_total counter
+test_packets_total{opcode="a",kind="say \"hi\"\\\n"} 2
+test_packets_total{opcode="b",kind="in"} 2
+`
+	if got := write(t); got != want {
+		t.Errorf("got\n%s\nwant\n%s", got, want)
+	}
+}
+
+func TestFamiliesSortedByName(t *testing.T) {
+	useFamilies(t)
+
+	NewGauge("test_b", "B.")
+	NewGauge("test_a", "A.")
+	NewGaugeVec("test_c", "C.", "x")
+
+	got := write(t)
+	a, b := strings.Index(got, "# HELP test_a"), strings.Index(got, "# HELP test_b")
+	if a < 0 || b < 0 || a > b {
+		t.Errorf("families not sorted:\n%s", got)
+	}
+	if strings.Contains(got, "test_c{") {
+		t.Errorf("vec without series wrote a sample:\n%s", got)
+	}
+}
+
+func TestHistogram(t *testing.T) {
+	useFamilies(t)
+
+	h := NewHistogramVec("test_latency_seconds", "Latency.", []float64{0.1, 1}, "op")
+	h.With("read").Observe(0.05)
+	h.With("read").Observe(0.1)
+	h.With("read").Observe(0.5)
+	h.With("read").Observe(3)
+
+	want := `# HELP test_latency_seconds Latency.
+# TYPE test_latency_seconds histogram
+test_latency_seconds_bucket{op="read",le="0.1"} 2
+test_latency_seconds_bucket{op="read",le="1"} 3
+test_latency_seconds_bucket{op="read",le="+Inf"} 4
+test_latency_seconds_sum{op="read"} 3.65
+test_latency_seconds_count{op="read"} 4
+`
+	if got := write(t); got != want {
+		t.Errorf("got\n%s\nwant\n%s", got, want)
+	}
+}
+
+func TestHistogramWithoutLabels(t *testing.T) {
+	useFamilies(t)
+
+	h := NewHistogram("test_size_bytes", "Size.", []float64{10})
+	h.Observe(20)
+
+	want := `# HELP test_size_bytes Size.
+# TYPE test_size_bytes histogram
+test_size_bytes_bucket{le="10"} 0
+test_size_bytes_bucket{le="+Inf"} 1
+test_size_bytes_sum 20
+test_size_bytes_count 1
+`
+	if got := write(t); got != want {
+		t.Errorf("got\n%s\nwant\n%s", got, want)
+	}
+}
+
+func TestCollector(t *testing.T) {
+	useFamilies(t)
+
+	calls := 0
+	NewCollector("test_sockets", "Sockets.", GAUGE, []string{"server"}, func(emit func(float64, ...string)) {
+		calls++
+		emit(3, "1")
+		emit(0, "2")
+	})
+
+	want := `# HELP test_sockets Sockets.
+# TYPE test_sockets gauge
+test_sockets{server="1"} 3
+test_sockets{server="2"} 0
+`
+	if got := write(t); got != want {
+		t.Errorf("got\n%s\nwant\n%s", got, want)
+	}
+	if calls != 1 {
+		t.Errorf("collector called %d times, want 1", calls)
+	}
+}
+
+func TestSpecialValues(t *testing.T) {
+	for f, want := range map[float64]string{
+		1e21:  "1e+21",
+		0.001: "0.001",
+		-2:    "-2",
+	} {
+		if got := formatFloat(f); got != want {
+			t.Errorf("formatFloat(%v) = %q, want %q", f, got, want)
+		}
+	}
+
+	useFamilies(t)
+	g := NewGauge("test_inf", "Inf.")
+	g.Set(1)
+	g.Add(1e308)
+	g.Add(1e308)
+	if got := write(t); !strings.HasSuffix(got, "test_inf +Inf\n") {
+		t.Errorf("got\n%s", got)
+	}
+}
+
+func TestRegisterTwicePanics(t *testing.T) {
+	useFamilies(t)
+
+	NewCounter("test_twice_total", "Twice.")
+	defer func() {
+		if recover() == nil {
+			t.Error("second registration did not panic")
+		}
+	}()
+	NewGauge("test_twice_total", "Twice.")
+}
+
+func TestWrongLabelCountPanics(t *testing.T) {
+	useFamilies(t)
+
+	c := NewCounterVec("test_labels_total", "Labels.", "a", "b")
+	defer func() {
+		if recover() == nil {
+			t.Error("With with one value for two labels did not panic")
+		}
+	}()
+	c.With("x")
+}
+
+func TestHandler(t *testing.T) {
+	useFamilies(t)
+
+	NewCounter("test_served_total", "Served.").Inc()
+
+	w := httptest.NewRecorder()
+	Handler().ServeHTTP(w, httptest.NewRequest("GET", "/metrics", nil))
+
+	if ct := w.Header().Get("Content-Type"); !strings.HasPrefix(ct, "text/plain; version=0.0.4") {
+		t.Errorf("Content-Type = %q", ct)
+	}
+	if !strings.Contains(w.Body.String(), "test_served_total 1\n") {
+		t.Errorf("body\n%s", w.Body.String())
+	}
+}
@@ -2,11 +2,13 @@ package nats
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"time"
 
 	"github.com/nats-io/nats-server/v2/server"
 	"github.com/nats-io/nats.go"
+	"github.com/twodragon/kore-server/metrics"
 )
 
 const (
@@ -15,6 +17,9 @@ const (
 
 var (
 	conn *nats.Conn
+
+	published     = metrics.NewCounterVec("kore_nats_published_total", "Messages published to NATS by subject.", "subject")
+	publishErrors = metrics.NewCounterVec("kore_nats_publish_errors_total", "Messages NATS refused to publish by subject.", "subject")
 )
 
 type CastPacket struct {
@@ -56,6 +61,18 @@ func Connection() *nats.Conn {
 	return conn
 }
 
+// Connected returns nil if the connection to the server is up, an error
+// with its state otherwise.
+func Connected() error {
+	if conn == nil {
+		return errors.New("not connected yet")
+	}
+	if !conn.IsConnected() {
+		return fmt.Errorf("connection %s", conn.Status())
+	}
+	return nil
+}
+
 func (p *CastPacket) Cast() error {
 
 	data, err := json.Marshal(p)
@@ -63,5 +80,10 @@ func (p *CastPacket) Cast() error {
 		return err
 	}
 
-	return Connection().Publish(HOUSTON_CH, data)
+	if err := Connection().Publish(HOUSTON_CH, data); err != nil {
+		publishErrors.With(HOUSTON_CH).Inc()
+		return err
+	}
+	published.With(HOUSTON_CH).Inc()
+	return nil
 }
@@ -1,6 +1,8 @@
 package nats
 
 import (
+	"errors"
+	"fmt"
 	"time"
 
 	"github.com/nats-io/nats-server/v2/server"
@@ -16,24 +18,25 @@ var DefaultOptions = server.Options{
 }
 
 // RunServer starts a new Go routine based server
-func RunServer(opts *server.Options) *server.Server {
+func RunServer(opts *server.Options) (*server.Server, error) {
 	if opts == nil {
 		opts = &DefaultOptions
 	}
 
 	s, err := server.NewServer(opts)
 	if err != nil {
-		panic(err)
+		return nil, fmt.Errorf("NATS server: %s", err)
 	}
 	if s == nil {
-		panic("No NATS Server object returned.")
+		return nil, errors.New("NATS server: no server object returned")
 	}
 
 	// Run server in Go routine.
 	go s.Start()
 	// Wait for accept loop(s) to be started
 	if !s.ReadyForConnections(10 * time.Second) {
-		panic("Unable to start NATS Server in Go Routine")
+		s.Shutdown()
+		return nil, fmt.Errorf("NATS server: not ready for connections on %s:%d", opts.Host, opts.Port)
 	}
-	return s
+	return s, nil
 }
@@ -761,8 +761,10 @@ func (h *PressButtonHandler) Handle(s *database.Socket, data []byte) ([]byte, er
 				return messaging.InfoMessage("You don't have enough gold."), nil
 			}
 			s.Character.Gold -= 500000000
+			database.GoldDestroyed.Add(500000000)
 			resp.Concat(c.GetGold())
 			c.Socket.User.NCash += uint64(1000)
+			database.NCashCreated.Add(1000)
 			c.Socket.User.Update()
 			data := c.DecrementItem(item.SlotID, 1)
 			c.Socket.Write(*data)
@@ -0,0 +1,161 @@
+// Package passwd hashes and verifies account passwords. Hashes are stored in
+// hops.users.password in the format of the scheme that made them, so a user
+// keeps logging in while the configured scheme changes; Verify reports when
+// the stored hash should be replaced.
+package passwd
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/twodragon/kore-server/config"
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+)
+
+const (
+	SCHEME_ARGON2ID = "argon2id"
+	SCHEME_BCRYPT   = "bcrypt"
+	SCHEME_LEGACY   = "legacy" // sha256(padded client field + auth.login_salt), uppercase hex, verify only
+
+	SALT_LENGTH = 16
+	KEY_LENGTH  = 32
+
+	CLIENT_FIELD_LENGTH = 35 // of the password field of the login packet, NUL padded
+)
+
+var (
+	ErrUnknownHash   = errors.New("passwd: unknown hash format")
+	ErrUnknownScheme = errors.New("passwd: unknown scheme")
+
+	b64 = base64.RawStdEncoding
+)
+
+// Normalize returns the password typed by the user from the NUL padded field
+// of the login packet. Passwords typed in the account API have no padding,
+// both paths hash and verify what Normalize returns.
+func Normalize(password string) string {
+	if i := strings.IndexByte(password, 0); i >= 0 {
+		return password[:i]
+	}
+	return password
+}
+
+// Hash hashes password with the configured scheme and a new random salt.
+func Hash(password string) (string, error) {
+	cfg := config.Default.Auth
+	switch cfg.PasswordScheme {
+	case SCHEME_ARGON2ID:
+		salt := make([]byte, SALT_LENGTH)
+		if _, err := rand.Read(salt); err != nil {
+			return "", err
+		}
+		p := argon2Params{memory: uint32(cfg.Argon2Memory), time: uint32(cfg.Argon2Time), threads: uint8(cfg.Argon2Threads)}
+		key := argon2.IDKey([]byte(password), salt, p.time, p.memory, p.threads, KEY_LENGTH)
+		return fmt.Sprintf("$argon2id$v=%d$m=%d,t=%d,p=%d$%s$%s", argon2.Version, p.memory, p.time, p.threads,
+			b64.EncodeToString(salt), b64.EncodeToString(key)), nil
+	case SCHEME_BCRYPT:
+		hash, err := bcrypt.GenerateFromPassword([]byte(password), cfg.BcryptCost)
+		if err != nil {
+			return "", err
+		}
+		return string(hash), nil
+	}
+	return "", ErrUnknownScheme
+}
+
+// Verify checks password against a stored hash in constant time. rehash is
+// true when the password matched but the hash was not made with the
+// configured scheme and parameters, and should be replaced with Hash.
+func Verify(password, hash string) (ok, rehash bool, err error) {
+	cfg := config.Default.Auth
+	switch Scheme(hash) {
+	case SCHEME_ARGON2ID:
+		p, salt, key, err := decodeArgon2(hash)
+		if err != nil {
+			return false, false, err
+		}
+		other := argon2.IDKey([]byte(password), salt, p.time, p.memory, p.threads, uint32(len(key)))
+		if subtle.ConstantTimeCompare(key, other) != 1 {
+			return false, false, nil
+		}
+		current := p.memory == uint32(cfg.Argon2Memory) && p.time == uint32(cfg.Argon2Time) && p.threads == uint8(cfg.Argon2Threads)
+		return true, cfg.PasswordScheme != SCHEME_ARGON2ID || !current, nil
+
+	case SCHEME_BCRYPT:
+		err := bcrypt.CompareHashAndPassword([]byte(hash), []byte(password))
+		if err == bcrypt.ErrMismatchedHashAndPassword {
+			return false, false, nil
+		} else if err != nil {
+			return false, false, err
+		}
+		cost, _ := bcrypt.Cost([]byte(hash))
+		return true, cfg.PasswordScheme != SCHEME_BCRYPT || cost != cfg.BcryptCost, nil
+
+	case SCHEME_LEGACY: // made over the padded client field
+		if len(password) < CLIENT_FIELD_LENGTH {
+			password += strings.Repeat("\x00", CLIENT_FIELD_LENGTH-len(password))
+		}
+		sum := sha256.Sum256([]byte(password + cfg.LoginSalt))
+		other := strings.ToUpper(hex.EncodeToString(sum[:]))
+		if subtle.ConstantTimeCompare([]byte(strings.ToUpper(hash)), []byte(other)) != 1 {
+			return false, false, nil
+		}
+		return true, true, nil
+	}
+	return false, false, ErrUnknownHash
+}
+
+// Scheme tells which scheme made hash, or "" if it is not recognized.
+func Scheme(hash string) string {
+	switch {
+	case strings.HasPrefix(hash, "$argon2id$"):
+		return SCHEME_ARGON2ID
+	case strings.HasPrefix(hash, "$2a$"), strings.HasPrefix(hash, "$2b$"), strings.HasPrefix(hash, "$2y$"):
+		return SCHEME_BCRYPT
+	case len(hash) == sha256.Size*2:
+		if _, err := hex.DecodeString(hash); err == nil {
+			return SCHEME_LEGACY
+		}
+	}
+	return ""
+}
+
+type argon2Params struct {
+	memory  uint32 // KiB
+	time    uint32
+	threads uint8
+}
+
+// decodeArgon2 reads "$argon2id$v=19$m=65536,t=3,p=2$<salt>$<key>".
+func decodeArgon2(hash string) (argon2Params, []byte, []byte, error) {
+	var p argon2Params
+	parts := strings.Split(hash, "$")
+	if len(parts) != 6 {
+		return p, nil, nil, ErrUnknownHash
+	}
+
+	var version int
+	if _, err := fmt.Sscanf(parts[2], "v=%d", &version); err != nil || version != argon2.Version {
+		return p, nil, nil, fmt.Errorf("passwd: argon2 version %s not supported", parts[2])
+	}
+	if _, err := fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &p.memory, &p.time, &p.threads); err != nil {
+		return p, nil, nil, ErrUnknownHash
+	}
+
+	salt, err := b64.DecodeString(parts[4])
+	if err != nil {
+		return p, nil, nil, ErrUnknownHash
+	}
+	key, err := b64.DecodeString(parts[5])
+	if err != nil || len(key) == 0 {
+		return p, nil, nil, ErrUnknownHash
+	}
+	return p, salt, key, nil
+}
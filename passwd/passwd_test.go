@@ -0,0 +1,135 @@
+package passwd
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/twodragon/kore-server/config"
+)
+
+// useAuth starts the test with the auth settings changed by set.
+func useAuth(t *testing.T, set func(a *config.Auth)) {
+	t.Helper()
+	saved := config.Default.Auth
+	t.Cleanup(func() { config.Default.Auth = saved })
+	set(&config.Default.Auth)
+}
+
+// cheap keeps the hashes fast, the parameters are checked, not their cost.
+func cheap(scheme string) func(a *config.Auth) {
+	return func(a *config.Auth) {
+		a.PasswordScheme = scheme
+		a.Argon2Memory, a.Argon2Time, a.Argon2Threads = 64, 1, 1
+		a.BcryptCost = 4
+	}
+}
+
+func TestNormalize(t *testing.T) {
+	for in, want := range map[string]string{
+		"secret":                              "secret",
+		"secret" + strings.Repeat("\x00", 29): "secret",
+		"sec\x00ret":                          "sec",
+		"":                                    "",
+	} {
+		if got := Normalize(in); got != want {
+			t.Errorf("Normalize(%q) = %q, want %q", in, got, want)
+		}
+	}
+}
+
+// The hash the login handler stored before the schemes: "%X" of
+// sha256(password NUL padded to 35 bytes + "188.132.128.35").
+const LEGACY_SECRET = "BAB84CE81C2AAEC9150B14349219A5EF98E03BE8DA23C01206D7FE27011FB80B"
+
+func TestVerifyLegacy(t *testing.T) {
+	useAuth(t, func(a *config.Auth) { a.LoginSalt = "188.132.128.35" })
+
+	for _, test := range []struct {
+		password, hash string
+		ok             bool
+	}{
+		{"secret", LEGACY_SECRET, true},
+		{"secret", strings.ToLower(LEGACY_SECRET), true},
+		{"Secret", LEGACY_SECRET, false},
+		{"secret" + strings.Repeat("\x00", 29), LEGACY_SECRET, true}, // the padded field, as stored
+	} {
+		ok, rehash, err := Verify(test.password, test.hash)
+		if err != nil || ok != test.ok || rehash != test.ok {
+			t.Errorf("Verify(%q, %s) = %v %v %v, want %v and a rehash when ok", test.password, test.hash, ok, rehash, err, test.ok)
+		}
+	}
+
+	useAuth(t, func(a *config.Auth) { a.LoginSalt = "another salt" })
+	if ok, _, _ := Verify("secret", LEGACY_SECRET); ok {
+		t.Error("legacy hash verified with another salt")
+	}
+}
+
+func TestHashAndVerify(t *testing.T) {
+	for _, scheme := range []string{SCHEME_ARGON2ID, SCHEME_BCRYPT} {
+		t.Run(scheme, func(t *testing.T) {
+			useAuth(t, cheap(scheme))
+
+			hash, err := Hash("secret")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if Scheme(hash) != scheme {
+				t.Errorf("Scheme(%s) = %q", hash, Scheme(hash))
+			}
+			if other, _ := Hash("secret"); other == hash {
+				t.Error("two hashes of a password are equal, the salt is not random")
+			}
+
+			if ok, rehash, err := Verify("secret", hash); !ok || rehash || err != nil {
+				t.Errorf("right password: %v %v %v, want ok without rehash", ok, rehash, err)
+			}
+			if ok, rehash, err := Verify("Secret", hash); ok || rehash || err != nil {
+				t.Errorf("wrong password: %v %v %v, want refused", ok, rehash, err)
+			}
+		})
+	}
+}
+
+func TestRehash(t *testing.T) {
+	useAuth(t, cheap(SCHEME_ARGON2ID))
+	argon, _ := Hash("secret")
+	useAuth(t, cheap(SCHEME_BCRYPT))
+	bcrypt, _ := Hash("secret")
+
+	for _, test := range []struct {
+		name   string
+		set    func(a *config.Auth)
+		hash   string
+		rehash bool
+	}{
+		{"argon2id, same parameters", cheap(SCHEME_ARGON2ID), argon, false},
+		{"argon2id, more memory", func(a *config.Auth) { cheap(SCHEME_ARGON2ID)(a); a.Argon2Memory = 128 }, argon, true},
+		{"argon2id, more passes", func(a *config.Auth) { cheap(SCHEME_ARGON2ID)(a); a.Argon2Time = 2 }, argon, true},
+		{"argon2id, more threads", func(a *config.Auth) { cheap(SCHEME_ARGON2ID)(a); a.Argon2Threads = 2 }, argon, true},
+		{"argon2id, scheme now bcrypt", cheap(SCHEME_BCRYPT), argon, true},
+		{"bcrypt, same cost", cheap(SCHEME_BCRYPT), bcrypt, false},
+		{"bcrypt, higher cost", func(a *config.Auth) { cheap(SCHEME_BCRYPT)(a); a.BcryptCost = 5 }, bcrypt, true},
+		{"bcrypt, scheme now argon2id", cheap(SCHEME_ARGON2ID), bcrypt, true},
+	} {
+		useAuth(t, test.set)
+		if ok, rehash, err := Verify("secret", test.hash); !ok || rehash != test.rehash || err != nil {
+			t.Errorf("%s: %v %v %v, want ok and rehash %v", test.name, ok, rehash, err, test.rehash)
+		}
+	}
+}
+
+func TestVerifyUnknown(t *testing.T) {
+	for _, hash := range []string{
+		"",
+		"plain text",
+		"$argon2id$v=19$m=64,t=1,p=1$c2FsdA",
+		"$argon2id$v=18$m=64,t=1,p=1$c2FsdA$a2V5",
+		"$argon2id$v=19$m=64,t=1,p=1$!!$a2V5",
+		strings.Repeat("G", 64),
+	} {
+		if ok, _, err := Verify("secret", hash); ok || err == nil {
+			t.Errorf("Verify(%q) = %v %v, want an error", hash, ok, err)
+		}
+	}
+}
@@ -0,0 +1,133 @@
+package passwd
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base32"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/twodragon/kore-server/config"
+)
+
+const (
+	TOTP_DIGITS = 6
+	TOTP_PERIOD = 30 // seconds
+	TOTP_SKEW   = 1  // periods accepted before and after the current one
+	TOTP_ISSUER = "Kore"
+
+	SEALED_PREFIX = "v1:" // of the secrets sealed with auth.totp_key
+)
+
+var (
+	ErrNoTOTPKey    = errors.New("passwd: auth.totp_key is not set, authenticators are disabled")
+	ErrSealedSecret = errors.New("passwd: can not open the authenticator secret")
+
+	b32 = base32.StdEncoding.WithPadding(base32.NoPadding)
+)
+
+// NewTOTPSecret returns a random base32 secret for an authenticator app.
+func NewTOTPSecret() (string, error) {
+	secret := make([]byte, 20)
+	if _, err := rand.Read(secret); err != nil {
+		return "", err
+	}
+	return b32.EncodeToString(secret), nil
+}
+
+// TOTPURI returns the otpauth:// URI authenticator apps import the secret from.
+func TOTPURI(secret, account string) string {
+	label := url.PathEscape(TOTP_ISSUER + ":" + account)
+	return fmt.Sprintf("otpauth://totp/%s?secret=%s&issuer=%s&digits=%d&period=%d", label, secret, url.QueryEscape(TOTP_ISSUER), TOTP_DIGITS, TOTP_PERIOD)
+}
+
+// VerifyTOTP checks an RFC 6238 code (HMAC-SHA1) against secret at now and
+// returns the time step it matched. Codes of steps up to last, the step of
+// the code used before, are refused so a code can not be replayed within
+// the skew.
+func VerifyTOTP(secret, code string, now time.Time, last int64) (int64, bool) {
+	key, err := b32.DecodeString(strings.ToUpper(strings.TrimSpace(secret)))
+	if err != nil || len(code) != TOTP_DIGITS {
+		return 0, false
+	}
+
+	counter := now.Unix() / TOTP_PERIOD
+	step := int64(0)
+	for i := -TOTP_SKEW; i <= TOTP_SKEW; i++ {
+		c := counter + int64(i)
+		if subtle.ConstantTimeCompare([]byte(totp(key, uint64(c))), []byte(code)) == 1 && c > last {
+			step = c
+		}
+	}
+	return step, step != 0
+}
+
+// SealTOTPSecret encrypts secret with auth.totp_key for hops.users.totp_secret.
+func SealTOTPSecret(secret string) (string, error) {
+	aead, err := totpCipher()
+	if err != nil {
+		return "", err
+	}
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+	sealed := aead.Seal(nonce, nonce, []byte(secret), nil)
+	return SEALED_PREFIX + base64.RawStdEncoding.EncodeToString(sealed), nil
+}
+
+// OpenTOTPSecret decrypts a secret of SealTOTPSecret. Secrets stored before
+// they were sealed are returned as they are, sealed reports which it was.
+func OpenTOTPSecret(stored string) (secret string, sealed bool, err error) {
+	if !strings.HasPrefix(stored, SEALED_PREFIX) {
+		return stored, false, nil
+	}
+	aead, err := totpCipher()
+	if err != nil {
+		return "", true, err
+	}
+	raw, err := base64.RawStdEncoding.DecodeString(strings.TrimPrefix(stored, SEALED_PREFIX))
+	if err != nil || len(raw) < aead.NonceSize() {
+		return "", true, ErrSealedSecret
+	}
+	plain, err := aead.Open(nil, raw[:aead.NonceSize()], raw[aead.NonceSize():], nil)
+	if err != nil {
+		return "", true, ErrSealedSecret
+	}
+	return string(plain), true, nil
+}
+
+func totpCipher() (cipher.AEAD, error) {
+	secret := config.Default.Auth.TOTPKey
+	if secret == "" {
+		return nil, ErrNoTOTPKey
+	}
+	key := sha256.Sum256([]byte(secret))
+	block, err := aes.NewCipher(key[:])
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+func totp(key []byte, counter uint64) string {
+	msg := make([]byte, 8)
+	binary.BigEndian.PutUint64(msg, counter)
+	mac := hmac.New(sha1.New, key)
+	mac.Write(msg)
+	sum := mac.Sum(nil)
+
+	offset := sum[len(sum)-1] & 0x0F
+	value := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7FFFFFFF
+	return fmt.Sprintf("%0*d", TOTP_DIGITS, value%1000000)
+}
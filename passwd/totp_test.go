@@ -0,0 +1,103 @@
+package passwd
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/twodragon/kore-server/config"
+)
+
+// The SHA1 secret of RFC 6238 appendix B, "12345678901234567890".
+const RFC_SECRET = "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ"
+
+func TestTOTPVectors(t *testing.T) {
+	// the 8 digit codes of the RFC, of which the authenticators show the last 6
+	for unix, code := range map[int64]string{
+		59:          "287082",
+		1111111109:  "081804",
+		1111111111:  "050471",
+		1234567890:  "005924",
+		2000000000:  "279037",
+		20000000000: "353130",
+	} {
+		key, _ := b32.DecodeString(RFC_SECRET)
+		if got := totp(key, uint64(unix/TOTP_PERIOD)); got != code {
+			t.Errorf("T=%d: %s, want %s", unix, got, code)
+		}
+		step, ok := VerifyTOTP(RFC_SECRET, code, time.Unix(unix, 0), 0)
+		if !ok || step != unix/TOTP_PERIOD {
+			t.Errorf("T=%d: VerifyTOTP = %d %v, want step %d", unix, step, ok, unix/TOTP_PERIOD)
+		}
+	}
+}
+
+func TestVerifyTOTP(t *testing.T) {
+	at := time.Unix(1111111109, 0) // code 081804, step 37037036
+	const step = 1111111109 / TOTP_PERIOD
+
+	for _, test := range []struct {
+		name   string
+		secret string
+		code   string
+		now    time.Time
+		last   int64
+		ok     bool
+	}{
+		{"current step", RFC_SECRET, "081804", at, 0, true},
+		{"lower case secret with spaces", " " + strings.ToLower(RFC_SECRET) + " ", "081804", at, 0, true},
+		{"one step late", RFC_SECRET, "081804", at.Add(TOTP_PERIOD * time.Second), 0, true},
+		{"one step early", RFC_SECRET, "081804", at.Add(-TOTP_PERIOD * time.Second), 0, true},
+		{"two steps late", RFC_SECRET, "081804", at.Add(2 * TOTP_PERIOD * time.Second), 0, false},
+		{"replayed", RFC_SECRET, "081804", at, step, false},
+		{"after an older code", RFC_SECRET, "081804", at, step - 1, true},
+		{"wrong code", RFC_SECRET, "081805", at, 0, false},
+		{"short code", RFC_SECRET, "81804", at, 0, false},
+		{"bad secret", "not base32!", "081804", at, 0, false},
+	} {
+		if _, ok := VerifyTOTP(test.secret, test.code, test.now, test.last); ok != test.ok {
+			t.Errorf("%s: ok %v, want %v", test.name, ok, test.ok)
+		}
+	}
+}
+
+func TestNewTOTPSecret(t *testing.T) {
+	secret, err := NewTOTPSecret()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if key, err := b32.DecodeString(secret); err != nil || len(key) != 20 {
+		t.Errorf("secret %q: %d bytes, %v", secret, len(key), err)
+	}
+	uri := TOTPURI(secret, "player one")
+	if !strings.HasPrefix(uri, "otpauth://totp/Kore:player%20one?secret="+secret+"&issuer=Kore&digits=6&period=30") {
+		t.Errorf("uri %s", uri)
+	}
+}
+
+func TestSealTOTPSecret(t *testing.T) {
+	useAuth(t, func(a *config.Auth) { a.TOTPKey = "" })
+	if _, err := SealTOTPSecret(RFC_SECRET); err != ErrNoTOTPKey {
+		t.Errorf("sealed without a key: %v", err)
+	}
+	if secret, sealed, err := OpenTOTPSecret(RFC_SECRET); secret != RFC_SECRET || sealed || err != nil {
+		t.Errorf("unsealed secret: %q %v %v", secret, sealed, err)
+	}
+
+	useAuth(t, func(a *config.Auth) { a.TOTPKey = "a key" })
+	stored, err := SealTOTPSecret(RFC_SECRET)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(stored, SEALED_PREFIX) || strings.Contains(stored, RFC_SECRET) {
+		t.Errorf("sealed %q", stored)
+	}
+	if secret, sealed, err := OpenTOTPSecret(stored); secret != RFC_SECRET || !sealed || err != nil {
+		t.Errorf("opened %q %v %v", secret, sealed, err)
+	}
+
+	useAuth(t, func(a *config.Auth) { a.TOTPKey = "another key" })
+	if _, _, err := OpenTOTPSecret(stored); err != ErrSealedSecret {
+		t.Errorf("opened with another key: %v", err)
+	}
+}
@@ -9,6 +9,7 @@ import (
 
 	"github.com/osamingo/boolconv"
 	"github.com/thoas/go-funk"
+	"github.com/twodragon/kore-server/codec"
 	"github.com/twodragon/kore-server/database"
 	"github.com/twodragon/kore-server/messaging"
 	"github.com/twodragon/kore-server/nats"
@@ -72,16 +73,17 @@ var (
 	PaidLotQuantities = map[int]int{92000001: 5, 92000011: 5, 10820001: 5, 17500346: 10, 10601023: 20, 10601024: 20, 10601007: 50, 10601008: 50, 10600057: 10,
 		17502966: 5, 17502967: 5, 243: 3}
 
-	BATTLE_MODE         = utils.Packet{0xAA, 0x55, 0x04, 0x00, 0x43, 0x00, 0x55, 0xAA}
-	MEDITATION_MODE     = utils.Packet{0xAA, 0x55, 0x05, 0x00, 0x82, 0x05, 0x00, 0x55, 0xAA}
-	TACTICAL_SPACE_MENU = utils.Packet{0xAA, 0x55, 0x03, 0x00, 0x50, 0x01, 0x01, 0x55, 0xAA, 0xAA, 0x55, 0x05, 0x00, 0x28, 0xFF, 0x00, 0x00, 0x00, 0x55, 0xAA}
-	TACTICAL_SPACE_TP   = utils.Packet{0xAA, 0x55, 0x07, 0x00, 0x01, 0xB9, 0x0A, 0x00, 0x00, 0x00, 0x01, 0x55, 0xAA}
-	OPEN_LOT            = utils.Packet{0xAA, 0x55, 0x0C, 0x00, 0xA2, 0x01, 0x32, 0x00, 0x00, 0x00, 0x00, 0x01, 0x55, 0xAA}
-	SELECTION_CHANGED   = utils.Packet{0xAA, 0x55, 0x09, 0x00, 0xCF, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x55, 0xAA}
-	PVP_REQUEST         = utils.Packet{0xAA, 0x55, 0x04, 0x00, 0x2A, 0x01, 0x55, 0xAA}
-	PVP_STARTED         = utils.Packet{0xAA, 0x55, 0x0A, 0x00, 0x2A, 0x02, 0x55, 0xAA}
-	CLANCASTLE_MAP      = utils.Packet{0xaa, 0x55, 0x62, 0x00, 0xbb, 0x03, 0x05, 0x55, 0xAA}
-	CANNOT_MOVE         = utils.Packet{0xaa, 0x55, 0x04, 0x00, 0xbb, 0x02, 0x00, 0x00, 0x55, 0xaa}
+	BATTLE_MODE              = utils.Packet{0xAA, 0x55, 0x04, 0x00, 0x43, 0x00, 0x55, 0xAA}
+	MEDITATION_MODE          = utils.Packet{0xAA, 0x55, 0x05, 0x00, 0x82, 0x05, 0x00, 0x55, 0xAA}
+	TACTICAL_SPACE_MENU      = utils.Packet{0xAA, 0x55, 0x03, 0x00, 0x50, 0x01, 0x01, 0x55, 0xAA, 0xAA, 0x55, 0x05, 0x00, 0x28, 0xFF, 0x00, 0x00, 0x00, 0x55, 0xAA}
+	TACTICAL_SPACE_TP        = utils.Packet{0xAA, 0x55, 0x07, 0x00, 0x01, 0xB9, 0x0A, 0x00, 0x00, 0x00, 0x01, 0x55, 0xAA}
+	OPEN_LOT                 = utils.Packet{0xAA, 0x55, 0x0C, 0x00, 0xA2, 0x01, 0x32, 0x00, 0x00, 0x00, 0x00, 0x01, 0x55, 0xAA}
+	SELECTION_CHANGED        = codec.NewLayout("selection_changed", codec.Const("opcode", 0xCF), codec.U16("id"), codec.Pad(6))
+	TARGET_SELECTION_REQUEST = codec.NewLayout("target_selection", codec.Const("opcode", 0xCF), codec.U16("id"))
+	PVP_REQUEST              = utils.Packet{0xAA, 0x55, 0x04, 0x00, 0x2A, 0x01, 0x55, 0xAA}
+	PVP_STARTED              = utils.Packet{0xAA, 0x55, 0x0A, 0x00, 0x2A, 0x02, 0x55, 0xAA}
+	CLANCASTLE_MAP           = utils.Packet{0xaa, 0x55, 0x62, 0x00, 0xbb, 0x03, 0x05, 0x55, 0xAA}
+	CANNOT_MOVE              = utils.Packet{0xaa, 0x55, 0x04, 0x00, 0xbb, 0x02, 0x00, 0x00, 0x55, 0xaa}
 
 	HOUSE_APPEAR = utils.Packet{0xAA, 0x55, 0x3A, 0x00, 0xAC, 0x03, 0x0A, 0x00, 0xD5, 0x1B, 0x01, 0x00, 0xD5, 0x1B, 0x01, 0x00, 0x90, 0x01,
 		0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x55, 0xAA}
@@ -589,13 +591,13 @@ func (h *MeditationHandler) Handle(s *database.Socket, data []byte) ([]byte, err
 
 func (h *TargetSelectionHandler) Handle(s *database.Socket, data []byte) ([]byte, error) {
 
-	id := int(utils.BytesToInt(data[5:7], true))
-	s.Character.Selection = id
-
-	resp := SELECTION_CHANGED
-	resp.Insert(utils.IntToBytes(uint64(s.Character.Selection), 2, true), 5)
+	req, err := TARGET_SELECTION_REQUEST.Decode(data)
+	if err != nil {
+		return nil, err
+	}
 
-	return resp, nil
+	s.Character.Selection = int(req.Uint("id"))
+	return SELECTION_CHANGED.Encode(codec.Values{"id": s.Character.Selection})
 }
 
 func (h *TravelToCastleHandler) Handle(s *database.Socket, data []byte) ([]byte, error) {
@@ -732,6 +734,7 @@ func (h *OpenLotHandler) Handle(s *database.Socket, data []byte) ([]byte, error)
 
 	if itemID == 10002 {
 		s.User.NCash += 1000
+		database.NCashCreated.Add(1000)
 		s.User.Update()
 
 	} else {
@@ -946,6 +949,15 @@ func (h *StyleHandler) Handle(s *database.Socket, data []byte) ([]byte, error) {
 }
 func (h *TravelToFiveClanArea) Handle(s *database.Socket, data []byte) ([]byte, error) {
 	areaID := int16(data[7])
+	if areaID > 0 { // the temples
+		var inMap []*database.Character
+		for _, c := range database.FindCharactersInMap(s.Character.Map) {
+			inMap = append(inMap, c)
+		}
+		if d := database.DecideEventEntry(s.Character, database.EVENT_FIVE_CLAN_WAR, inMap); !d.Allow {
+			return messaging.InfoMessage("You cannot enter with more than one character!"), nil
+		}
+	}
 	switch areaID {
 	case 0:
 		x := "508,564"
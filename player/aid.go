@@ -22,7 +22,7 @@ func (h *AidHandler) Handle(s *database.Socket, data []byte) ([]byte, error) {
 	}
 	c := s.Character
 
-	if funk.Contains(database.DisabledAIDMaps, c.Map) {
+	if funk.Contains(database.Tuned().DisabledAIDMaps, c.Map) {
 		return nil, nil
 	}
 	if !c.CanMove {
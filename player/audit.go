@@ -0,0 +1,317 @@
+package player
+
+import (
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/twodragon/kore-server/config"
+	"github.com/twodragon/kore-server/database"
+	"github.com/twodragon/kore-server/messaging"
+	"github.com/twodragon/kore-server/server"
+)
+
+const (
+	AUDIT_LINES       = 10
+	AUDIT_LINE_LENGTH = 250
+)
+
+var (
+	// the audit store, tests can replace them
+	snapshot    = database.SnapshotCharacter
+	recordAudit = database.RecordAudit
+
+	approvals      = make(map[int]*approval)
+	approvalsMutex sync.Mutex
+	lastApproval   int
+)
+
+// touch is a character an audited command may change.
+type touch struct {
+	character *database.Character
+	before    *database.AuditSnapshot
+	named     bool // by the caller, the caller itself is touched by default
+}
+
+// Touch marks c as changed by the command, the audit compares it before and
+// after the command. Character arguments are touched already, handlers touch
+// the characters they find otherwise before changing them.
+func (inv *Invocation) Touch(c *database.Character) {
+	inv.touch(c, true)
+}
+
+func (inv *Invocation) touch(c *database.Character, named bool) {
+	if c == nil {
+		return
+	}
+	for _, t := range inv.touched {
+		if t.character == c {
+			t.named = t.named || named
+			return
+		}
+	}
+	inv.touched = append(inv.touched, &touch{character: c, before: snapshot(c), named: named})
+}
+
+// touchArgs touches the characters of the arguments, the caller if there are
+// none.
+func (inv *Invocation) touchArgs() {
+	for _, arg := range inv.Command.Args {
+		if c := inv.Args.Character(arg.Name); c != nil {
+			inv.Touch(c)
+		}
+	}
+	if len(inv.touched) == 0 {
+		inv.touch(inv.Character, false)
+	}
+}
+
+// audit records the invocation, an entry for every character it touched.
+func (inv *Invocation) audit(err error) {
+	entry := database.AuditEntry{
+		Issuer:     inv.Caller(),
+		IssuerID:   inv.User.ID,
+		Command:    inv.Command.Name,
+		Arguments:  strings.Join(inv.Words, " "),
+		ApprovedBy: inv.ApprovedBy,
+		CreatedAt:  time.Now(),
+	}
+	if err != nil {
+		entry.Error = err.Error()
+	}
+
+	entries := []database.AuditEntry{}
+	for _, t := range inv.touched {
+		e := entry
+		e.Changes = t.before.Diff(snapshot(t.character))
+		if t.named || len(e.Changes) > 0 {
+			e.Target, e.TargetUserID = t.character.Name, t.character.UserID
+		}
+		if e.Target != "" || len(inv.touched) == 1 {
+			entries = append(entries, e)
+		}
+	}
+	if len(entries) == 0 {
+		entries = append(entries, entry)
+	}
+
+	for i := range entries {
+		if err := recordAudit(&entries[i]); err != nil {
+			log.Println(err)
+		}
+	}
+}
+
+// approval is a command waiting for a second GM.
+type approval struct {
+	id          int
+	inv         *Invocation
+	requestedAt time.Time
+	timer       *time.Timer
+}
+
+func (a *approval) String() string {
+	left := time.Until(a.requestedAt.Add(approvalTimeout())).Round(time.Minute)
+	return fmt.Sprintf("#%d %s: /%s %s, %s left", a.id, a.inv.Caller(), a.inv.Command.Name, strings.Join(a.inv.Words, " "), left)
+}
+
+func approvalTimeout() time.Duration {
+	return time.Duration(config.Default.Audit.ApprovalTimeout) * time.Minute
+}
+
+// needsApproval tells if the command is one of config audit.approval.
+func needsApproval(c *Command) bool {
+	for _, name := range config.Default.Audit.Approval {
+		if strings.EqualFold(name, c.Name) {
+			return true
+		}
+	}
+	return false
+}
+
+// requestApproval keeps the invocation until a second GM approves it and
+// tells the GMs online who may.
+func requestApproval(inv *Invocation) []byte {
+	approvalsMutex.Lock()
+	lastApproval++
+	a := &approval{id: lastApproval, inv: inv, requestedAt: time.Now()}
+	approvals[a.id] = a
+	a.timer = time.AfterFunc(approvalTimeout(), func() {
+		if takeApproval(a.id) != nil {
+			inv.Socket.Write(messaging.InfoMessage(fmt.Sprintf("Request #%d lapsed.", a.id)))
+		}
+	})
+	approvalsMutex.Unlock()
+
+	notice := messaging.InfoMessage(fmt.Sprintf("%s asks to run /%s %s: /approve %d or /deny %d.", inv.Caller(), inv.Command.Name, strings.Join(inv.Words, " "), a.id, a.id))
+	if characters, err := database.FindOnlineCharacters(); err == nil {
+		for _, c := range characters {
+			if c.Socket != nil && c.Socket.User != nil && c.Socket.User.UserType >= inv.Command.Rank && c.UserID != inv.User.ID {
+				c.Socket.Write(notice)
+			}
+		}
+	}
+	return messaging.InfoMessage(fmt.Sprintf("/%s needs the approval of another GM, request #%d sent.", inv.Command.Name, a.id))
+}
+
+func takeApproval(id int) *approval {
+	approvalsMutex.Lock()
+	defer approvalsMutex.Unlock()
+	a := approvals[id]
+	if a != nil {
+		delete(approvals, id)
+		a.timer.Stop()
+	}
+	return a
+}
+
+func findApproval(inv *Invocation) (*approval, []byte) {
+	id := int(inv.Args.Int("request"))
+	approvalsMutex.Lock()
+	a := approvals[id]
+	approvalsMutex.Unlock()
+	if a == nil {
+		return nil, messaging.InfoMessage(fmt.Sprintf("There is no request #%d.", id))
+	}
+	return a, nil
+}
+
+// approveCommand runs a request of another GM as them.
+func approveCommand(inv *Invocation) ([]byte, error) {
+	a, resp := findApproval(inv)
+	if a == nil {
+		return resp, nil
+	}
+	if a.inv.User.ID == inv.User.ID {
+		return messaging.InfoMessage("Another GM has to approve your request."), nil
+	}
+	if inv.User.UserType < a.inv.Command.Rank {
+		return messaging.InfoMessage(fmt.Sprintf("You can not run /%s yourself.", a.inv.Command.Name)), nil
+	}
+	if takeApproval(a.id) == nil {
+		return messaging.InfoMessage(fmt.Sprintf("There is no request #%d.", a.id)), nil
+	}
+
+	req, c := a.inv, a.inv.Command
+	args, err := c.Parse(req.Words) // characters may have gone offline since
+	if err != nil {
+		req.Socket.Write(messaging.InfoMessage(fmt.Sprintf("Request #%d failed: %s.", a.id, err)))
+		return messaging.InfoMessage(fmt.Sprintf("Request #%d failed: %s.", a.id, err)), nil
+	}
+	req.Args, req.ApprovedBy = args, inv.Caller()
+
+	out, err := c.call(req)
+	req.Socket.Write(messaging.InfoMessage(fmt.Sprintf("Request #%d approved by %s.", a.id, req.ApprovedBy)))
+	req.Socket.Write(out)
+	if err != nil {
+		log.Println(err)
+		return messaging.InfoMessage(fmt.Sprintf("Request #%d failed: %s.", a.id, err)), nil
+	}
+	return messaging.InfoMessage(fmt.Sprintf("Request #%d approved.", a.id)), nil
+}
+
+// denyCommand drops a request, of another GM or one's own.
+func denyCommand(inv *Invocation) ([]byte, error) {
+	a, resp := findApproval(inv)
+	if a == nil {
+		return resp, nil
+	}
+	if a.inv.User.ID != inv.User.ID && inv.User.UserType < a.inv.Command.Rank {
+		return messaging.InfoMessage(fmt.Sprintf("You can not run /%s yourself.", a.inv.Command.Name)), nil
+	}
+	if takeApproval(a.id) == nil {
+		return messaging.InfoMessage(fmt.Sprintf("There is no request #%d.", a.id)), nil
+	}
+
+	if a.inv.User.ID != inv.User.ID {
+		a.inv.Socket.Write(messaging.InfoMessage(fmt.Sprintf("Request #%d denied by %s.", a.id, inv.Caller())))
+	}
+	return messaging.InfoMessage(fmt.Sprintf("Request #%d denied.", a.id)), nil
+}
+
+func pendingCommand(inv *Invocation) ([]byte, error) {
+	approvalsMutex.Lock()
+	var pending []*approval
+	for id := 1; id <= lastApproval; id++ {
+		if a := approvals[id]; a != nil && inv.User.UserType >= a.inv.Command.Rank {
+			pending = append(pending, a)
+		}
+	}
+	approvalsMutex.Unlock()
+
+	if len(pending) == 0 {
+		return messaging.InfoMessage("No requests wait for approval."), nil
+	}
+	resp := messaging.InfoMessage(fmt.Sprintf("%d requests wait for approval:", len(pending)))
+	for _, a := range pending {
+		resp = append(resp, messaging.InfoMessage(truncate(a.String(), AUDIT_LINE_LENGTH))...)
+	}
+	return resp, nil
+}
+
+// auditCommand lists the latest audit entries on or by the account of a
+// character.
+func auditCommand(inv *Invocation) ([]byte, error) {
+	c := inv.Args.Character("character")
+	filter := database.AuditFilter{Target: c.UserID, Limit: AUDIT_LINES}
+	if inv.Args.String("as") == "by" {
+		filter = database.AuditFilter{Issuer: c.UserID, Limit: AUDIT_LINES}
+	}
+	entries, err := database.FindAudit(filter)
+	if err != nil {
+		return nil, err
+	}
+	if len(entries) == 0 {
+		return messaging.InfoMessage(fmt.Sprintf("No audit entries for %s.", c.Name)), nil
+	}
+
+	resp := messaging.InfoMessage(fmt.Sprintf("Latest audit entries for %s:", c.Name))
+	for _, e := range entries {
+		resp = append(resp, messaging.InfoMessage(truncate(e.String(), AUDIT_LINE_LENGTH))...)
+	}
+	return resp, nil
+}
+
+func truncate(text string, length int) string {
+	if len(text) <= length {
+		return text
+	}
+	return text[:length-3] + "..."
+}
+
+func init() {
+	RegisterCommands(
+		&Command{
+			Name: "audit",
+			Rank: server.GM_USER,
+			Args: []Arg{
+				{Name: "character", Type: ARG_CHARACTER},
+				{Name: "as", Type: ARG_WORD, Optional: true, Choices: []string{"on", "by"}},
+			},
+			Help:    "Lists the latest GM commands on the account of a character, or by it.",
+			Handler: auditCommand,
+		},
+		&Command{
+			Name:    "approve",
+			Rank:    server.GM_USER,
+			Args:    []Arg{{Name: "request", Type: ARG_INT}},
+			Help:    "Runs a command another GM asked to run.",
+			Handler: approveCommand,
+		},
+		&Command{
+			Name:    "deny",
+			Rank:    server.GM_USER,
+			Args:    []Arg{{Name: "request", Type: ARG_INT}},
+			Help:    "Drops a command waiting for approval.",
+			Handler: denyCommand,
+		},
+		&Command{
+			Name:    "pending",
+			Rank:    server.GM_USER,
+			Help:    "Lists the commands waiting for approval.",
+			Handler: pendingCommand,
+		},
+	)
+}
@@ -0,0 +1,345 @@
+package player
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/twodragon/kore-server/database"
+	"github.com/twodragon/kore-server/messaging"
+	"github.com/twodragon/kore-server/server"
+	"github.com/twodragon/kore-server/utils"
+)
+
+const HELP_LINE_LENGTH = 200 // info messages hold 255 bytes
+
+// argument types of commands
+const (
+	ARG_INT       = iota
+	ARG_FLOAT     //
+	ARG_WORD      // one word, one of Choices if there are
+	ARG_TEXT      // the rest of the line, last argument only
+	ARG_CHARACTER // a character name, looked up
+	ARG_ONLINE    // a character name, looked up and online
+)
+
+// Arg is an argument of a command. Numbers are checked against Min and Max
+// when Min < Max.
+type Arg struct {
+	Name     string
+	Type     int
+	Optional bool // the following ones are too
+	Min, Max float64
+	Choices  []string
+}
+
+// Command is a chat command. Users below Rank do not see it, commands above
+// COMMON_USER rank are audited.
+type Command struct {
+	Name    string
+	Aliases []string
+	Rank    int8
+	Args    []Arg
+	Help    string
+	Handler func(inv *Invocation) ([]byte, error)
+}
+
+// Invocation is a command being run. Handlers reach the caller through it
+// only and give their replies back, so they can run with a Socket without a
+// connection, or no Socket at all if they do not need its stats.
+type Invocation struct {
+	Command   *Command
+	Name      string // as typed, an alias maybe
+	Socket    *database.Socket
+	User      *database.User
+	Character *database.Character
+	Args      Args
+	Words     []string // the arguments as typed
+	Packet    []byte   // the chat packet the command came in
+
+	ApprovedBy string // the second GM, of a command needing approval
+
+	out     utils.Packet
+	touched []*touch // for the audit
+}
+
+// Write queues data for the caller before the reply of the handler.
+func (inv *Invocation) Write(data []byte) {
+	inv.out.Concat(data)
+}
+
+// Caller names the caller in logs and histories.
+func (inv *Invocation) Caller() string {
+	return fmt.Sprintf("%s(%s)", inv.Character.Name, inv.User.ID)
+}
+
+// Target is the character of the argument name if it was given, the caller
+// otherwise.
+func (inv *Invocation) Target(name string) *database.Character {
+	if c := inv.Args.Character(name); c != nil {
+		return c
+	}
+	return inv.Character
+}
+
+// Args are the parsed arguments of an invocation by name. Missing optional
+// arguments read as zero values.
+type Args map[string]interface{}
+
+func (a Args) Has(name string) bool {
+	_, ok := a[name]
+	return ok
+}
+
+func (a Args) Int(name string) int64 {
+	n, _ := a[name].(int64)
+	return n
+}
+
+func (a Args) Float(name string) float64 {
+	f, _ := a[name].(float64)
+	return f
+}
+
+func (a Args) String(name string) string {
+	s, _ := a[name].(string)
+	return s
+}
+
+func (a Args) Character(name string) *database.Character {
+	c, _ := a[name].(*database.Character)
+	return c
+}
+
+var (
+	commands    = make(map[string]*Command) // by name and alias
+	commandList []*Command
+
+	// findCharacter resolves character arguments, tests can replace it
+	findCharacter = database.FindCharacterByName
+)
+
+// RegisterCommands adds commands to the registry. Names are unique, a
+// duplicate is a programming error.
+func RegisterCommands(list ...*Command) {
+	for _, c := range list {
+		for _, name := range append([]string{c.Name}, c.Aliases...) {
+			if _, ok := commands[name]; ok {
+				panic("command /" + name + " registered twice")
+			}
+			commands[name] = c
+		}
+		for i, arg := range c.Args {
+			if arg.Type == ARG_TEXT && i != len(c.Args)-1 {
+				panic("command /" + c.Name + ": text argument is not the last")
+			}
+		}
+		commandList = append(commandList, c)
+	}
+}
+
+// FindCommand returns the command name or alias if rank may run it.
+func FindCommand(name string, rank int8) *Command {
+	c := commands[strings.ToLower(name)]
+	if c == nil || rank < c.Rank {
+		return nil
+	}
+	return c
+}
+
+// Usage is how the command is typed.
+func (c *Command) Usage() string {
+	usage := "/" + c.Name
+	for _, arg := range c.Args {
+		name := arg.Name
+		if len(arg.Choices) > 0 {
+			name = strings.Join(arg.Choices, "|")
+		}
+		if arg.Optional {
+			usage += " [" + name + "]"
+		} else {
+			usage += " <" + name + ">"
+		}
+	}
+	return usage
+}
+
+// Parse checks words against the arguments of the command.
+func (c *Command) Parse(words []string) (Args, error) {
+	args := make(Args, len(c.Args))
+	for i, arg := range c.Args {
+		if i >= len(words) {
+			if arg.Optional {
+				break
+			}
+			return nil, fmt.Errorf("%s is missing", arg.Name)
+		}
+
+		word := words[i]
+		switch arg.Type {
+		case ARG_INT:
+			n, err := strconv.ParseInt(word, 10, 64)
+			if err != nil {
+				return nil, fmt.Errorf("%s %q is not a whole number", arg.Name, word)
+			}
+			if err := arg.bounds(float64(n)); err != nil {
+				return nil, err
+			}
+			args[arg.Name] = n
+
+		case ARG_FLOAT:
+			f, err := strconv.ParseFloat(word, 64)
+			if err != nil || math.IsNaN(f) || math.IsInf(f, 0) {
+				return nil, fmt.Errorf("%s %q is not a number", arg.Name, word)
+			}
+			if err := arg.bounds(f); err != nil {
+				return nil, err
+			}
+			args[arg.Name] = f
+
+		case ARG_WORD:
+			if len(arg.Choices) > 0 {
+				found := false
+				for _, choice := range arg.Choices {
+					if strings.EqualFold(choice, word) {
+						word, found = choice, true
+						break
+					}
+				}
+				if !found {
+					return nil, fmt.Errorf("%s %q is not one of the choices", arg.Name, word)
+				}
+			}
+			args[arg.Name] = word
+
+		case ARG_TEXT:
+			args[arg.Name] = strings.Join(words[i:], " ")
+			return args, nil
+
+		case ARG_CHARACTER, ARG_ONLINE:
+			ch, err := findCharacter(word)
+			if err != nil || ch == nil {
+				return nil, fmt.Errorf("character %s not found", word)
+			}
+			if arg.Type == ARG_ONLINE && (ch.Socket == nil || !ch.IsOnline) {
+				return nil, fmt.Errorf("%s is not online", ch.Name)
+			}
+			args[arg.Name] = ch
+		}
+	}
+
+	if len(words) > len(c.Args) && (len(c.Args) == 0 || c.Args[len(c.Args)-1].Type != ARG_TEXT) {
+		return nil, fmt.Errorf("too many arguments")
+	}
+	return args, nil
+}
+
+func (arg Arg) bounds(n float64) error {
+	if arg.Min < arg.Max && (n < arg.Min || n > arg.Max) {
+		return fmt.Errorf("%s must be between %v and %v", arg.Name, arg.Min, arg.Max)
+	}
+	return nil
+}
+
+// Run parses the words of the command line and calls its handler.
+func (c *Command) Run(inv *Invocation, words []string) ([]byte, error) {
+	args, err := c.Parse(words)
+	if err != nil {
+		resp := messaging.InfoMessage(err.Error() + ".")
+		return append(resp, messaging.InfoMessage("Usage: "+c.Usage())...), nil
+	}
+	inv.Command, inv.Args, inv.Words = c, args, words
+
+	if inv.ApprovedBy == "" && needsApproval(c) {
+		return requestApproval(inv), nil
+	}
+	return c.call(inv)
+}
+
+// call calls the handler of the parsed invocation and audits it.
+func (c *Command) call(inv *Invocation) ([]byte, error) {
+	audited := c.Rank > server.COMMON_USER
+	if audited {
+		inv.touchArgs()
+	}
+
+	resp, err := c.Handler(inv)
+	if audited {
+		inv.audit(err)
+	}
+	return append(inv.out, resp...), err
+}
+
+// runCommand runs the command line the socket user typed in chat.
+func runCommand(s *database.Socket, line string, packet []byte) ([]byte, error) {
+	words := strings.Fields(line)
+	if len(words) == 0 {
+		return nil, nil
+	}
+
+	name := strings.ToLower(strings.TrimPrefix(words[0], "/"))
+	c := FindCommand(name, s.User.UserType)
+	if c == nil {
+		return messaging.InfoMessage(fmt.Sprintf("Unknown command /%s, see /help.", name)), nil
+	}
+
+	inv := &Invocation{Name: name, Socket: s, User: s.User, Character: s.Character, Packet: packet}
+	return c.Run(inv, words[1:])
+}
+
+// helpCommand lists the commands the caller may run, or tells how to use one.
+func helpCommand(inv *Invocation) ([]byte, error) {
+	rank := inv.User.UserType
+	if name := inv.Args.String("command"); name != "" {
+		c := FindCommand(strings.TrimPrefix(name, "/"), rank)
+		if c == nil {
+			return messaging.InfoMessage(fmt.Sprintf("Unknown command /%s.", name)), nil
+		}
+		resp := messaging.InfoMessage(c.Usage())
+		if c.Help != "" {
+			resp = append(resp, messaging.InfoMessage(c.Help)...)
+		}
+		if len(c.Aliases) > 0 {
+			resp = append(resp, messaging.InfoMessage("Also /"+strings.Join(c.Aliases, ", /"))...)
+		}
+		return resp, nil
+	}
+
+	var names []string
+	for _, c := range commandList {
+		if rank >= c.Rank {
+			names = append(names, "/"+c.Name)
+		}
+	}
+	sort.Strings(names)
+
+	resp := messaging.InfoMessage("Commands, /help <command> tells more:")
+	line := ""
+	for _, name := range names {
+		if len(line)+len(name)+2 > HELP_LINE_LENGTH {
+			resp = append(resp, messaging.InfoMessage(line)...)
+			line = ""
+		}
+		if line != "" {
+			line += ", "
+		}
+		line += name
+	}
+	if line != "" {
+		resp = append(resp, messaging.InfoMessage(line)...)
+	}
+	return resp, nil
+}
+
+func init() {
+	RegisterCommands(&Command{
+		Name:    "help",
+		Aliases: []string{"commands"},
+		Rank:    server.COMMON_USER,
+		Args:    []Arg{{Name: "command", Type: ARG_WORD, Optional: true}},
+		Help:    "Lists the commands you can use.",
+		Handler: helpCommand,
+	})
+}
@@ -0,0 +1,636 @@
+package player
+
+import (
+	"fmt"
+	"log"
+	"math"
+
+	"github.com/twodragon/kore-server/database"
+	"github.com/twodragon/kore-server/logging"
+	"github.com/twodragon/kore-server/messaging"
+	"github.com/twodragon/kore-server/nats"
+	"github.com/twodragon/kore-server/server"
+	"github.com/twodragon/kore-server/utils"
+)
+
+const GOD_STATS = 99999
+
+// addExp gives exp to c and sends it the new stats.
+func addExp(c *database.Character, amount int64) {
+	data, levelUp := c.AddExp(amount)
+	if levelUp {
+		statData, err := c.GetStats()
+		if err == nil && c.Socket != nil {
+			c.Socket.Write(statData)
+		}
+	}
+	if c.Socket != nil {
+		c.Socket.Write(data)
+	}
+}
+
+// findCharacterByID returns the character of the argument name, touched
+// for the audit.
+func findCharacterByID(inv *Invocation, name string) (*database.Character, error) {
+	c, err := database.FindCharacterByID(int(inv.Args.Int(name)))
+	if err != nil || c == nil {
+		return nil, fmt.Errorf("character %d not found", inv.Args.Int(name))
+	}
+	inv.Touch(c)
+	return c, nil
+}
+
+func skillCommand(inv *Invocation) ([]byte, error) {
+	inv.Character.DoAnimation(int(inv.Args.Int("skill")))
+	return nil, nil
+}
+
+func attackSpeedCommand(inv *Invocation) ([]byte, error) {
+	stats := inv.Socket.Stats
+	stats.AdditionalAttackSpeed = int(inv.Args.Int("amount"))
+	stats.Update()
+
+	statData, err := inv.Character.GetStats()
+	if err != nil {
+		return nil, err
+	}
+	resp := utils.Packet{}
+	resp.Concat(statData)
+	resp.Concat(messaging.InfoMessage(fmt.Sprintf("Attack speed set to %d", inv.Args.Int("amount"))))
+	return resp, nil
+}
+
+func classCommand(inv *Invocation) ([]byte, error) {
+	c := inv.Args.Character("character")
+	c.Class = int(inv.Args.Int("class"))
+	c.Update()
+
+	resp := utils.Packet{0xAA, 0x55, 0x03, 0x00, 0x57, 0x09, 0x00, 0x55, 0xAA}
+	resp[6] = byte(c.Class)
+	return resp, nil
+}
+
+func godCommand(inv *Invocation) ([]byte, error) {
+	stats := inv.Socket.Stats
+	bonus := GOD_STATS
+	if stats.STR >= GOD_STATS && stats.DEX >= GOD_STATS {
+		bonus = -GOD_STATS
+	}
+	stats.STR += bonus
+	stats.DEX += bonus
+	stats.INT += bonus
+	stats.Fire += bonus
+	stats.Water += bonus
+	stats.Wind += bonus
+
+	stats.Calculate()
+	stats.HP = stats.MaxHP
+	stats.CHI = stats.MaxCHI
+	stats.Update()
+	return inv.Character.GetStats()
+}
+
+func playerSkillResetCommand(inv *Invocation) ([]byte, error) {
+	go inv.Args.Character("character").ResetPlayerSkillBook()
+	return nil, nil
+}
+
+func allJobsResetCommand(inv *Invocation) ([]byte, error) {
+	chars, _ := database.FindAllCharacter()
+	for _, char := range chars {
+		char.ResetPlayerSkillBook()
+	}
+	return nil, nil
+}
+
+func addStatPointsCommand(inv *Invocation) ([]byte, error) {
+	c := inv.Args.Character("character")
+	c.Socket.Stats.StatPoints += int(inv.Args.Int("points"))
+	spawnData, err := c.SpawnCharacter()
+	if err == nil {
+		c.Socket.Write(spawnData)
+		c.Update()
+	}
+	return nil, nil
+}
+
+func expCommand(inv *Invocation) ([]byte, error) {
+	addExp(inv.Target("character"), inv.Args.Int("amount"))
+	return nil, nil
+}
+
+func petExpCommand(inv *Invocation) ([]byte, error) {
+	slots, err := inv.Character.InventorySlots()
+	if err != nil {
+		log.Println(err)
+		return nil, nil
+	}
+
+	petSlot := slots[0x0A]
+	pet := petSlot.Pet
+	if pet == nil || petSlot.ItemID == 0 || !pet.IsOnline {
+		return messaging.InfoMessage("Your pet is not out."), nil
+	}
+	pet.AddExp(inv.Character, float64(inv.Args.Int("amount")))
+	return nil, nil
+}
+
+func honorCommand(inv *Invocation) ([]byte, error) {
+	stats := inv.Args.Character("character").Socket.Stats
+	stats.Honor = int(inv.Args.Int("points"))
+	stats.Update()
+	return nil, nil
+}
+
+func rankCommand(inv *Invocation) ([]byte, error) {
+	c := inv.Character
+	c.HonorRank = inv.Args.Int("rank")
+	c.Update()
+
+	resp := database.CHANGE_RANK
+	resp.Insert(utils.IntToBytes(uint64(c.PseudoID), 2, true), 6)
+	resp.Insert(utils.IntToBytes(uint64(c.HonorRank), 4, true), 8)
+	statData, _ := c.GetStats()
+	resp.Concat(statData)
+	return resp, nil
+}
+
+func addGuildCommand(inv *Invocation) ([]byte, error) {
+	ch := inv.Target("character")
+	guildID := int(inv.Args.Int("guild"))
+
+	if guildID == -1 { // out of the guild
+		guild, err := database.FindGuildByID(ch.GuildID)
+		if err != nil {
+			return nil, err
+		}
+		if guild == nil {
+			return messaging.InfoMessage(fmt.Sprintf("%s is not in a guild.", ch.Name)), nil
+		}
+		if err = guild.RemoveMember(ch.ID); err != nil {
+			return nil, err
+		}
+		guild.Update()
+	} else {
+		guild, err := database.FindGuildByID(guildID)
+		if err != nil {
+			return nil, err
+		}
+		if guild == nil {
+			return messaging.InfoMessage(fmt.Sprintf("Guild %d not found.", guildID)), nil
+		}
+		guild.AddMember(&database.GuildMember{ID: ch.ID, Role: database.GROLE_MEMBER})
+		guild.Update()
+	}
+	ch.GuildID = guildID
+
+	spawnData, err := ch.SpawnCharacter()
+	if err == nil {
+		p := nats.CastPacket{CastNear: true, CharacterID: ch.ID, Type: nats.PLAYER_SPAWN, Data: spawnData}
+		p.Cast()
+		ch.Socket.Write(spawnData)
+	}
+	return messaging.InfoMessage(fmt.Sprintf("Player new guild id: %d", ch.GuildID)), nil
+}
+
+func divineCommand(inv *Invocation) ([]byte, error) {
+	ch := inv.Args.Character("character")
+	addExp(ch, 233332051411)
+	ch.GoDivine()
+	return nil, nil
+}
+
+func rebornCommand(inv *Invocation) ([]byte, error) {
+	ch := inv.Args.Character("character")
+	addExp(ch, 233332051411)
+	ch.Reborn()
+	return nil, nil
+}
+
+func setInjuryCommand(inv *Invocation) ([]byte, error) {
+	ch := inv.Args.Character("character")
+	ch.Injury = inv.Args.Float("injury")
+	ch.Update()
+	return nil, nil
+}
+
+func checkInCommand(inv *Invocation) ([]byte, error) {
+	c := inv.Args.Character("character")
+	user, err := database.FindUserByID(c.UserID)
+	if err == nil && user != nil {
+		user.CheckinCounter = int(inv.Args.Int("count"))
+	}
+	return nil, nil
+}
+
+func buffCommand(inv *Invocation) ([]byte, error) {
+	infection := database.BuffInfections[int(inv.Args.Int("infection"))]
+	if infection == nil {
+		return messaging.InfoMessage(fmt.Sprintf("Buff %d not found.", inv.Args.Int("infection"))), nil
+	}
+	inv.Character.AddBuff(infection, inv.Args.Int("duration"))
+	return nil, nil
+}
+
+func visibilityCommand(inv *Invocation) ([]byte, error) {
+	invisible := inv.Args.String("on") == "1"
+	if invisible {
+		data := database.BUFF_INFECTION
+		data.Insert(utils.IntToBytes(uint64(70), 4, true), 6)     // infection id
+		data.Insert(utils.IntToBytes(uint64(99999), 4, true), 11) // buff remaining time
+		inv.Write(data)
+	} else {
+		r := database.BUFF_EXPIRED
+		r.Insert(utils.IntToBytes(uint64(70), 4, true), 6) // buff infection id
+		inv.Write(r)
+	}
+	inv.Character.Invisible = invisible
+	return nil, nil
+}
+
+func speedCommand(inv *Invocation) ([]byte, error) {
+	inv.Character.RunningSpeed = inv.Args.Float("speed")
+	return inv.Character.GetStats()
+}
+
+func skillPointCommand(inv *Invocation) ([]byte, error) {
+	c := inv.Args.Character("character")
+	c.Socket.Skills.SkillPoints += int(inv.Args.Int("points"))
+	c.Socket.Write(c.GetExpAndSkillPts())
+	return nil, nil
+}
+
+func formCommand(inv *Invocation) ([]byte, error) {
+	c := inv.Character
+	npcID := inv.Args.Int("npc")
+
+	if npcID > 0 {
+		c.Morphed = true
+		c.MorphedNPCID = int(npcID)
+		r := utils.Packet{0xAA, 0x55, 0x05, 0x00, 0x37, 0x55, 0xAA}
+		r.Insert(utils.IntToBytes(uint64(npcID), 4, true), 5) // form npc id
+		data, err := c.GetStats()
+		if err == nil {
+			r.Concat(data)
+		}
+		inv.Write(r)
+	} else {
+		c.Morphed = false
+		c.MorphedNPCID = 0
+		FORM_DEACTIVATED := utils.Packet{0xAA, 0x55, 0x01, 0x00, 0x38, 0x55, 0xAA}
+		inv.Write(FORM_DEACTIVATED)
+	}
+
+	characters, err := c.GetNearbyCharacters()
+	if err != nil {
+		log.Println(err)
+		return nil, nil
+	}
+	for _, chars := range characters {
+		delete(chars.OnSight.Players, c.ID)
+	}
+	return nil, nil
+}
+
+func nameCommand(inv *Invocation) ([]byte, error) {
+	c, err := findCharacterByID(inv, "character id")
+	if err != nil {
+		return messaging.InfoMessage(err.Error()), nil
+	}
+	name := inv.Args.String("name")
+	if other, _ := database.FindCharacterByName(name); other != nil {
+		return messaging.InfoMessage(fmt.Sprintf("The name %s is taken.", name)), nil
+	}
+
+	c.Name = name
+	c.Update()
+	return nil, nil
+}
+
+func roleCommand(inv *Invocation) ([]byte, error) {
+	c, err := findCharacterByID(inv, "character id")
+	if err != nil {
+		return messaging.InfoMessage(err.Error()), nil
+	}
+	user, err := database.FindUserByID(c.UserID)
+	if err != nil {
+		return nil, err
+	}
+	if user == nil {
+		return messaging.InfoMessage("Account not found."), nil
+	}
+
+	user.UserType = int8(inv.Args.Int("role"))
+	user.Update()
+	return nil, nil
+}
+
+func typeCommand(inv *Invocation) ([]byte, error) {
+	c, err := findCharacterByID(inv, "character id")
+	if err != nil {
+		return messaging.InfoMessage(err.Error()), nil
+	}
+	c.Type = int(inv.Args.Int("type"))
+	c.Update()
+	return nil, nil
+}
+
+func infoCommand(inv *Invocation) ([]byte, error) {
+	c := inv.Args.Character("character")
+	resp := utils.Packet{}
+	resp.Concat(messaging.InfoMessage(fmt.Sprintf("%s player details:", c.Name)))
+	resp.Concat(messaging.InfoMessage(fmt.Sprintf("CharID: %d | UserName: %s", c.ID, c.Socket.User.Username)))
+	resp.Concat(messaging.InfoMessage(fmt.Sprintf("Map: %d | Location: %s", c.Map, c.Coordinate)))
+	resp.Concat(messaging.InfoMessage(fmt.Sprintf("Level: %d | Exp: %d", c.Level, c.Exp)))
+	resp.Concat(messaging.InfoMessage(fmt.Sprint("Gold: ", c.Gold)))
+	resp.Concat(messaging.InfoMessage(fmt.Sprint("Bank Gold: ", c.Socket.User.BankGold)))
+	resp.Concat(messaging.InfoMessage(fmt.Sprint("Ncash: ", c.Socket.User.NCash)))
+	resp.Concat(messaging.InfoMessage(fmt.Sprintf("AID: %d | AID-:%t", c.AidTime, c.AidMode)))
+	resp.Concat(messaging.InfoMessage(fmt.Sprint("SkillPoints: ", c.Socket.Skills.SkillPoints)))
+	return resp, nil
+}
+
+func uidCommand(inv *Invocation) ([]byte, error) {
+	return messaging.InfoMessage(inv.Args.Character("character").UserID), nil
+}
+
+func uuidCommand(inv *Invocation) ([]byte, error) {
+	return messaging.InfoMessage(fmt.Sprint(inv.Args.Character("character").ID)), nil
+}
+
+func restoreCharacterCommand(inv *Invocation) ([]byte, error) {
+	c := inv.Args.Character("character")
+	if err := c.Restore(); err != nil {
+		return messaging.InfoMessage(err.Error()), nil
+	}
+
+	logger.Log(logging.ACTION_DELETE_CHARACTER, c.ID, fmt.Sprintf("Character restored by %s", inv.Caller()), c.UserID)
+	return messaging.InfoMessage(fmt.Sprintf("%s restored.", c.Name)), nil
+}
+
+// restoreOwnCharacterCommand lets players restore a deleted character of
+// their own account, after a PIN entry if the account has a PIN.
+func restoreOwnCharacterCommand(inv *Invocation) ([]byte, error) {
+	name := inv.Args.String("name")
+	if !inv.Socket.PINFresh() {
+		return messaging.InfoMessage(PIN_NEEDED), nil
+	}
+
+	characters, err := database.FindCharactersByUserID(inv.User.ID)
+	if err != nil {
+		return nil, err
+	}
+	for _, c := range characters {
+		if c.Name != name || !c.PendingDeletion() {
+			continue
+		}
+		if err := c.Restore(); err != nil {
+			return messaging.InfoMessage(err.Error()), nil
+		}
+		logger.Log(logging.ACTION_DELETE_CHARACTER, c.ID, "Character restored by its owner", c.UserID)
+		return messaging.InfoMessage(fmt.Sprintf("%s restored, it is back in your character menu.", c.Name)), nil
+	}
+	return messaging.InfoMessage(fmt.Sprintf("You have no deleted character named %s.", name)), nil
+}
+
+func init() {
+	RegisterCommands(
+		&Command{
+			Name:    "skill",
+			Rank:    server.GM_USER,
+			Args:    []Arg{{Name: "skill", Type: ARG_INT}},
+			Help:    "Plays the animation of a skill.",
+			Handler: skillCommand,
+		},
+		&Command{
+			Name:    "attackspeed",
+			Rank:    server.HGM_USER,
+			Args:    []Arg{{Name: "amount", Type: ARG_INT}},
+			Help:    "Sets your additional attack speed.",
+			Handler: attackSpeedCommand,
+		},
+		&Command{
+			Name: "class",
+			Rank: server.HGM_USER,
+			Args: []Arg{
+				{Name: "character", Type: ARG_CHARACTER},
+				{Name: "class", Type: ARG_INT, Min: 0, Max: 255},
+			},
+			Help:    "Sets the class of a character.",
+			Handler: classCommand,
+		},
+		&Command{
+			Name:    "god",
+			Rank:    server.GM_USER,
+			Help:    "Turns the god stats of your character on or off.",
+			Handler: godCommand,
+		},
+		&Command{
+			Name:    "playerskillreset",
+			Rank:    server.HGM_USER,
+			Args:    []Arg{{Name: "character", Type: ARG_CHARACTER}},
+			Help:    "Resets the skill book of a character.",
+			Handler: playerSkillResetCommand,
+		},
+		&Command{
+			Name:    "alljobsreset",
+			Rank:    server.HGM_USER,
+			Help:    "Resets the skill book of every character.",
+			Handler: allJobsResetCommand,
+		},
+		&Command{
+			Name: "addstatpoints",
+			Rank: server.HGM_USER,
+			Args: []Arg{
+				{Name: "character", Type: ARG_ONLINE},
+				{Name: "points", Type: ARG_INT, Min: math.MinInt32, Max: math.MaxInt32},
+			},
+			Help:    "Gives stat points to a character.",
+			Handler: addStatPointsCommand,
+		},
+		&Command{
+			Name: "exp",
+			Rank: server.GM_USER,
+			Args: []Arg{
+				{Name: "amount", Type: ARG_INT},
+				{Name: "character", Type: ARG_CHARACTER, Optional: true},
+			},
+			Help:    "Gives exp to you or to a character.",
+			Handler: expCommand,
+		},
+		&Command{
+			Name:    "petexp",
+			Rank:    server.GM_USER,
+			Args:    []Arg{{Name: "amount", Type: ARG_INT, Min: 1, Max: math.MaxInt64}},
+			Help:    "Gives exp to your pet.",
+			Handler: petExpCommand,
+		},
+		&Command{
+			Name: "honor",
+			Rank: server.HGM_USER,
+			Args: []Arg{
+				{Name: "character", Type: ARG_ONLINE},
+				{Name: "points", Type: ARG_INT, Min: math.MinInt32, Max: math.MaxInt32},
+			},
+			Help:    "Sets the honor points of a character.",
+			Handler: honorCommand,
+		},
+		&Command{
+			Name:    "rank",
+			Rank:    server.GM_USER,
+			Args:    []Arg{{Name: "rank", Type: ARG_INT, Min: 0, Max: math.MaxUint32}},
+			Help:    "Sets your honor rank.",
+			Handler: rankCommand,
+		},
+		&Command{
+			Name: "addguild",
+			Rank: server.HGM_USER,
+			Args: []Arg{
+				{Name: "guild", Type: ARG_INT, Min: -1, Max: math.MaxInt32},
+				{Name: "character", Type: ARG_ONLINE, Optional: true},
+			},
+			Help:    "Puts you or a character in a guild, -1 takes them out.",
+			Handler: addGuildCommand,
+		},
+		&Command{
+			Name:    "divine",
+			Rank:    server.HGM_USER,
+			Args:    []Arg{{Name: "character", Type: ARG_CHARACTER}},
+			Help:    "Levels a character up and makes it divine.",
+			Handler: divineCommand,
+		},
+		&Command{
+			Name:    "reborn",
+			Rank:    server.HGM_USER,
+			Args:    []Arg{{Name: "character", Type: ARG_CHARACTER}},
+			Help:    "Levels a character up and makes it reborn.",
+			Handler: rebornCommand,
+		},
+		&Command{
+			Name: "setinjury",
+			Rank: server.HGM_USER,
+			Args: []Arg{
+				{Name: "character", Type: ARG_CHARACTER},
+				{Name: "injury", Type: ARG_FLOAT, Min: 0, Max: 100},
+			},
+			Help:    "Sets the injury of a character.",
+			Handler: setInjuryCommand,
+		},
+		&Command{
+			Name: "checkin",
+			Rank: server.HGM_USER,
+			Args: []Arg{
+				{Name: "character", Type: ARG_CHARACTER},
+				{Name: "count", Type: ARG_INT, Min: 0, Max: math.MaxInt32},
+			},
+			Help:    "Sets the check-in counter of the account of a character.",
+			Handler: checkInCommand,
+		},
+		&Command{
+			Name: "buff",
+			Rank: server.GM_USER,
+			Args: []Arg{
+				{Name: "infection", Type: ARG_INT},
+				{Name: "duration", Type: ARG_INT, Min: 1, Max: math.MaxInt32},
+			},
+			Help:    "Gives you a buff for a number of seconds.",
+			Handler: buffCommand,
+		},
+		&Command{
+			Name:    "visibility",
+			Rank:    server.GA_USER,
+			Args:    []Arg{{Name: "on", Type: ARG_WORD, Choices: []string{"0", "1"}}},
+			Help:    "Makes you invisible with 1, visible with 0.",
+			Handler: visibilityCommand,
+		},
+		&Command{
+			Name:    "speed",
+			Rank:    server.GAL_USER,
+			Args:    []Arg{{Name: "speed", Type: ARG_FLOAT, Min: 0, Max: 100}},
+			Help:    "Sets your running speed.",
+			Handler: speedCommand,
+		},
+		&Command{
+			Name: "skillpoint",
+			Rank: server.HGM_USER,
+			Args: []Arg{
+				{Name: "character", Type: ARG_ONLINE},
+				{Name: "points", Type: ARG_INT, Min: math.MinInt32, Max: math.MaxInt32},
+			},
+			Help:    "Gives skill points to a character.",
+			Handler: skillPointCommand,
+		},
+		&Command{
+			Name:    "form",
+			Rank:    server.HGM_USER,
+			Args:    []Arg{{Name: "npc", Type: ARG_INT, Min: 0, Max: math.MaxInt32}},
+			Help:    "Turns you into an NPC, 0 turns you back.",
+			Handler: formCommand,
+		},
+		&Command{
+			Name: "name",
+			Rank: server.HGM_USER,
+			Args: []Arg{
+				{Name: "character id", Type: ARG_INT},
+				{Name: "name", Type: ARG_WORD},
+			},
+			Help:    "Renames a character.",
+			Handler: nameCommand,
+		},
+		&Command{
+			Name: "role",
+			Rank: server.HGM_USER,
+			Args: []Arg{
+				{Name: "character id", Type: ARG_INT},
+				{Name: "role", Type: ARG_INT, Min: server.BANNED_USER, Max: server.HGM_USER},
+			},
+			Help:    "Sets the user type of the account of a character.",
+			Handler: roleCommand,
+		},
+		&Command{
+			Name: "type",
+			Rank: server.HGM_USER,
+			Args: []Arg{
+				{Name: "character id", Type: ARG_INT},
+				{Name: "type", Type: ARG_INT},
+			},
+			Help:    "Sets the type of a character.",
+			Handler: typeCommand,
+		},
+		&Command{
+			Name:    "info",
+			Rank:    server.GM_USER,
+			Args:    []Arg{{Name: "character", Type: ARG_ONLINE}},
+			Help:    "Shows the details of a character online.",
+			Handler: infoCommand,
+		},
+		&Command{
+			Name:    "uid",
+			Rank:    server.HGM_USER,
+			Args:    []Arg{{Name: "character", Type: ARG_CHARACTER}},
+			Help:    "Shows the account ID of a character.",
+			Handler: uidCommand,
+		},
+		&Command{
+			Name:    "uuid",
+			Rank:    server.HGM_USER,
+			Args:    []Arg{{Name: "character", Type: ARG_CHARACTER}},
+			Help:    "Shows the ID of a character.",
+			Handler: uuidCommand,
+		},
+		&Command{
+			Name:    "restorechar",
+			Rank:    server.GM_USER,
+			Args:    []Arg{{Name: "character", Type: ARG_CHARACTER}},
+			Help:    "Restores a deleted character within its restore window.",
+			Handler: restoreCharacterCommand,
+		},
+		&Command{
+			Name:    "restore",
+			Rank:    server.COMMON_USER,
+			Args:    []Arg{{Name: "name", Type: ARG_WORD}},
+			Help:    "Restores a deleted character of your account within its restore window.",
+			Handler: restoreOwnCharacterCommand,
+		},
+	)
+}
@@ -0,0 +1,328 @@
+package player
+
+import (
+	"fmt"
+	"log"
+	"math"
+	"sort"
+
+	"github.com/thoas/go-funk"
+	"github.com/twodragon/kore-server/database"
+	"github.com/twodragon/kore-server/messaging"
+	"github.com/twodragon/kore-server/nats"
+	"github.com/twodragon/kore-server/server"
+	"github.com/twodragon/kore-server/utils"
+)
+
+const MAX_QUANTITY = 1000000000
+
+// giftSlot makes the slot of an item given by a GM, pets grown to their
+// evolution. The quantity of timed items is their time unless one is given.
+func giftSlot(itemID int64, inv *Invocation, quantityArg string) (*database.InventorySlot, *database.Item, error) {
+	info, ok := database.GetItemInfo(itemID)
+	if !ok || info == nil {
+		return nil, nil, fmt.Errorf("item %d not found", itemID)
+	}
+
+	quantity := int64(1)
+	if info.Timer > 0 {
+		quantity = int64(info.Timer)
+	}
+	if inv.Args.Has(quantityArg) {
+		quantity = inv.Args.Int(quantityArg)
+	}
+	item := &database.InventorySlot{ItemID: itemID, Quantity: uint(quantity)}
+
+	if info.GetType() == database.PET_TYPE {
+		petInfo := database.Pets[itemID]
+		if petInfo == nil || petInfo.Level < 1 || petInfo.Level > len(database.PetExps) || petInfo.Evolution < 1 {
+			return nil, nil, fmt.Errorf("pet %d has no level info", itemID)
+		}
+		petExpInfo := database.PetExps[petInfo.Level-1]
+		targetExps := []int{petExpInfo.ReqExpEvo1, petExpInfo.ReqExpEvo2, petExpInfo.ReqExpEvo3, petExpInfo.ReqExpHt, petExpInfo.ReqExpDivEvo1, petExpInfo.ReqExpDivEvo2, petExpInfo.ReqExpDivEvo3, petExpInfo.ReqExpDivEvo4, petExpInfo.ReqExpDivEvo4}
+		if petInfo.Evolution > len(targetExps) {
+			return nil, nil, fmt.Errorf("pet %d has no level info", itemID)
+		}
+		item.Pet = &database.PetSlot{
+			Fullness: 100, Loyalty: 100,
+			Exp:   float64(targetExps[petInfo.Evolution-1]),
+			HP:    petInfo.BaseHP,
+			Level: byte(petInfo.Level),
+			Name:  petInfo.Name,
+			CHI:   petInfo.BaseChi}
+	}
+	return item, info, nil
+}
+
+func deleteItemSlotCommand(inv *Invocation) ([]byte, error) {
+	ch := inv.Target("character")
+	r, err := ch.RemoveItem(int16(inv.Args.Int("slot")))
+	if err != nil {
+		return nil, err
+	}
+	ch.Socket.Write(r)
+	return nil, nil
+}
+
+func boxInfoCommand(inv *Invocation) ([]byte, error) {
+	title, msg, _ := database.GetBoxContentInfo(inv.Args.Int("box"))
+	log.Print(title + msg)
+	return nil, nil
+}
+
+func itemCommand(inv *Invocation) ([]byte, error) {
+	if inv.Args.Has("character") && inv.User.UserType < server.HGM_USER {
+		return messaging.InfoMessage("Only HGMs can give items to others."), nil
+	}
+	item, _, err := giftSlot(inv.Args.Int("item"), inv, "quantity")
+	if err != nil {
+		return messaging.InfoMessage(err.Error()), nil
+	}
+
+	ch := inv.Target("character")
+	r, _, err := ch.AddItem(item, -1, false)
+	if err != nil || r == nil {
+		return nil, err
+	}
+	ch.Socket.Write(*r)
+	return nil, nil
+}
+
+func discItemCommand(inv *Invocation) ([]byte, error) {
+	item, _, err := giftSlot(inv.Args.Int("item"), inv, "quantity")
+	if err != nil {
+		return messaging.InfoMessage(err.Error()), nil
+	}
+	item.ItemType = int16(inv.Args.Int("type"))
+	item.JudgementStat = inv.Args.Int("judgement")
+	if item.Pet != nil {
+		item.Pet.Name = ""
+	}
+
+	ch := inv.Character
+	if inv.Args.Has("character id") {
+		if inv.User.UserType < server.HGM_USER {
+			return messaging.InfoMessage("Only HGMs can give items to others."), nil
+		}
+		c, err := database.FindCharacterByID(int(inv.Args.Int("character id")))
+		if err != nil || c == nil {
+			return messaging.InfoMessage("Character not found."), nil
+		}
+		inv.Touch(c)
+		ch = c
+	}
+
+	r, _, err := ch.AddItem(item, -1, false)
+	if err != nil || r == nil {
+		return nil, err
+	}
+	ch.Socket.Write(*r)
+	return nil, nil
+}
+
+func giveItemToAllCommand(inv *Invocation) ([]byte, error) {
+	characters, err := database.FindOnlineCharacters()
+	if err != nil {
+		return nil, err
+	}
+	online := funk.Values(characters).([]*database.Character)
+	sort.Slice(online, func(i, j int) bool {
+		return online[i].Name < online[j].Name
+	})
+
+	given := 0
+	for _, c := range online {
+		item, _, err := giftSlot(inv.Args.Int("item"), inv, "quantity")
+		if err != nil {
+			return messaging.InfoMessage(err.Error()), nil
+		}
+		inv.Touch(c)
+		r, _, err := c.AddItem(item, -1, false)
+		if err != nil || r == nil {
+			continue
+		}
+		c.Socket.Write(*r)
+		given++
+	}
+	return messaging.InfoMessage(fmt.Sprintf("Item given to %d players.", given)), nil
+}
+
+func relicCommand(inv *Invocation) ([]byte, error) {
+	ch := inv.Target("character")
+	slot, err := ch.FindFreeSlot()
+	if err != nil {
+		return nil, nil
+	}
+
+	itemID := inv.Args.Int("item")
+	itemData, _, _ := ch.AddItem(&database.InventorySlot{ItemID: itemID, Quantity: 1, ItemType: int16(inv.Args.Int("type"))}, slot, true)
+	if itemData != nil {
+		ch.Socket.Write(*itemData)
+		relicDrop := ch.RelicDrop(itemID)
+		p := nats.CastPacket{CastNear: false, Data: relicDrop, Type: nats.ITEM_DROP}
+		p.Cast()
+	}
+	return nil, nil
+}
+
+func upgradeCommand(inv *Invocation) ([]byte, error) {
+	slots, err := inv.Character.InventorySlots()
+	if err != nil {
+		return nil, err
+	}
+	slotID := inv.Args.Int("slot")
+	if slotID >= int64(len(slots)) {
+		return messaging.InfoMessage(fmt.Sprintf("There is no slot %d.", slotID)), nil
+	}
+
+	count := int64(1)
+	if inv.Args.Has("count") {
+		count = inv.Args.Int("count")
+	}
+	codes := []byte{}
+	for i := 0; i < int(count); i++ {
+		codes = append(codes, byte(inv.Args.Int("code")))
+	}
+	return slots[slotID].Upgrade(int16(slotID), codes...), nil
+}
+
+func clearInventoryCommand(inv *Invocation) ([]byte, error) {
+	inv.Character.ClearInventory()
+	return nil, nil
+}
+
+func goldCommand(inv *Invocation) ([]byte, error) {
+	inv.Write(inv.Character.LootGold(uint64(inv.Args.Int("amount"))))
+	return nil, nil
+}
+
+func cashCommand(inv *Invocation) ([]byte, error) {
+	c := inv.Args.Character("character")
+	user, err := database.FindUserByID(c.UserID)
+	if err != nil {
+		return nil, err
+	} else if user == nil {
+		return nil, nil
+	}
+
+	amount := inv.Args.Int("amount")
+	user.NCash += uint64(amount)
+	database.NCashCreated.Add(float64(amount))
+	user.Update()
+
+	return messaging.InfoMessage(fmt.Sprintf("%d nCash loaded to %s (%s).", amount, user.Username, user.ID)), nil
+}
+
+func shopCommand(inv *Invocation) ([]byte, error) {
+	resp := utils.Packet{0xAA, 0x55, 0x07, 0x00, 0x57, 0x03, 0x01, 0x55, 0xAA}
+	resp.Insert(utils.IntToBytes(uint64(inv.Args.Int("shop")), 4, true), 7) // shop id
+	return resp, nil
+}
+
+func init() {
+	RegisterCommands(
+		&Command{
+			Name: "deleteitemslot",
+			Rank: server.HGM_USER,
+			Args: []Arg{
+				{Name: "slot", Type: ARG_INT, Min: 0, Max: math.MaxInt16},
+				{Name: "character", Type: ARG_ONLINE, Optional: true},
+			},
+			Help:    "Removes the item in an inventory slot of yours or of a character.",
+			Handler: deleteItemSlotCommand,
+		},
+		&Command{
+			Name:    "boxinfo",
+			Rank:    server.GM_USER,
+			Args:    []Arg{{Name: "box", Type: ARG_INT}},
+			Help:    "Logs the content of a box item.",
+			Handler: boxInfoCommand,
+		},
+		&Command{
+			Name: "item",
+			Rank: server.GM_USER,
+			Args: []Arg{
+				{Name: "item", Type: ARG_INT},
+				{Name: "quantity", Type: ARG_INT, Optional: true, Min: 1, Max: MAX_QUANTITY},
+				{Name: "character", Type: ARG_ONLINE, Optional: true},
+			},
+			Help:    "Gives an item to you, or to a character for HGMs.",
+			Handler: itemCommand,
+		},
+		&Command{
+			Name: "discitem",
+			Rank: server.GM_USER,
+			Args: []Arg{
+				{Name: "item", Type: ARG_INT},
+				{Name: "quantity", Type: ARG_INT, Optional: true, Min: 1, Max: MAX_QUANTITY},
+				{Name: "type", Type: ARG_INT, Optional: true, Min: 0, Max: math.MaxInt16},
+				{Name: "judgement", Type: ARG_INT, Optional: true},
+				{Name: "character id", Type: ARG_INT, Optional: true},
+			},
+			Help:    "Gives an item with a type and a judgement stat.",
+			Handler: discItemCommand,
+		},
+		&Command{
+			Name: "giveitemtoall",
+			Rank: server.HGM_USER,
+			Args: []Arg{
+				{Name: "item", Type: ARG_INT},
+				{Name: "quantity", Type: ARG_INT, Optional: true, Min: 1, Max: MAX_QUANTITY},
+			},
+			Help:    "Gives an item to every player online.",
+			Handler: giveItemToAllCommand,
+		},
+		&Command{
+			Name: "relic",
+			Rank: server.HGM_USER,
+			Args: []Arg{
+				{Name: "item", Type: ARG_INT},
+				{Name: "character", Type: ARG_ONLINE, Optional: true},
+				{Name: "type", Type: ARG_INT, Optional: true, Min: 0, Max: 2},
+			},
+			Help:    "Gives a relic and announces its drop.",
+			Handler: relicCommand,
+		},
+		&Command{
+			Name: "upgrade",
+			Rank: server.HGM_USER,
+			Args: []Arg{
+				{Name: "slot", Type: ARG_INT, Min: 0, Max: math.MaxInt16},
+				{Name: "code", Type: ARG_INT, Min: 0, Max: 255},
+				{Name: "count", Type: ARG_INT, Optional: true, Min: 1, Max: 255},
+			},
+			Help:    "Upgrades the item in an inventory slot of yours with a code.",
+			Handler: upgradeCommand,
+		},
+		&Command{
+			Name:    "clearinv",
+			Rank:    server.GAL_USER,
+			Help:    "Empties your inventory.",
+			Handler: clearInventoryCommand,
+		},
+		&Command{
+			Name:    "gold",
+			Rank:    server.HGM_USER,
+			Args:    []Arg{{Name: "amount", Type: ARG_INT, Min: 1, Max: math.MaxInt64}},
+			Help:    "Gives you gold.",
+			Handler: goldCommand,
+		},
+		&Command{
+			Name: "cash",
+			Rank: server.HGM_USER,
+			Args: []Arg{
+				{Name: "amount", Type: ARG_INT, Min: 1, Max: math.MaxInt64},
+				{Name: "character", Type: ARG_CHARACTER},
+			},
+			Help:    "Loads nCash to the account of a character.",
+			Handler: cashCommand,
+		},
+		&Command{
+			Name:    "shop",
+			Rank:    server.GM_USER,
+			Args:    []Arg{{Name: "shop", Type: ARG_INT, Min: 0, Max: math.MaxInt32}},
+			Help:    "Opens a shop.",
+			Handler: shopCommand,
+		},
+	)
+}
@@ -0,0 +1,330 @@
+package player
+
+import (
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/twodragon/kore-server/database"
+	"github.com/twodragon/kore-server/messaging"
+	"github.com/twodragon/kore-server/server"
+)
+
+var rateArgs = []Arg{
+	{Name: "rate", Type: ARG_FLOAT, Min: 0, Max: 100},
+	{Name: "minutes", Type: ARG_INT, Min: 1, Max: 60 * 24 * 30},
+}
+
+func announceCommand(inv *Invocation) ([]byte, error) {
+	makeAnnouncement(inv.Args.String("message"))
+	return nil, nil
+}
+
+func maintenanceCommand(inv *Invocation) ([]byte, error) {
+	countMaintenance(60)
+	return nil, nil
+}
+
+func eventCommand(inv *Invocation) ([]byte, error) {
+	cmdEvents(inv.Args.String("event"))
+	return nil, nil
+}
+
+func eventProbCommand(inv *Invocation) ([]byte, error) {
+	database.EventProb = int(inv.Args.Int("probability"))
+	return messaging.InfoMessage(fmt.Sprintf("Succesfully change the new value is %d !", database.EventProb)), nil
+}
+
+// setRate sets a rate of database.SetRate for minutes.
+func setRate(inv *Invocation, kind string) error {
+	if err := database.SetRate(kind, inv.Args.Float("rate"), time.Duration(inv.Args.Int("minutes"))*time.Minute); err != nil {
+		return err
+	}
+	inv.Character.ShowEventsDetails()
+	return nil
+}
+
+func goldRateCommand(inv *Invocation) ([]byte, error) {
+	if err := setRate(inv, database.RATE_GOLD); err != nil {
+		return nil, err
+	}
+	return messaging.InfoMessage(fmt.Sprintf("Gold Rate now: %f, Default is : %f", database.GOLD_RATE, database.DEFAULT_GOLD_RATE)), nil
+}
+
+func expRateCommand(inv *Invocation) ([]byte, error) {
+	if err := setRate(inv, database.RATE_EXP); err != nil {
+		return nil, err
+	}
+	return messaging.InfoMessage(fmt.Sprintf("EXP Rate now: %f", database.EXP_RATE)), nil
+}
+
+func dropRateCommand(inv *Invocation) ([]byte, error) {
+	if err := setRate(inv, database.RATE_DROP); err != nil {
+		return nil, err
+	}
+	return messaging.InfoMessage(fmt.Sprintf("Drop Rate now: %f", database.DROP_RATE)), nil
+}
+
+func relicDropsCommand(inv *Invocation) ([]byte, error) {
+	database.RELIC_DROP_ENABLED = inv.Args.String("enabled") == "1"
+	if database.RELIC_DROP_ENABLED {
+		return messaging.InfoMessage("Relic drops enabled."), nil
+	}
+	return messaging.InfoMessage("Relic drops disabled."), nil
+}
+
+func debugCommand(inv *Invocation) ([]byte, error) {
+	database.DEBUG_FACTORY = int(inv.Args.Int("level"))
+	return nil, nil
+}
+
+func devlogCommand(inv *Invocation) ([]byte, error) {
+	database.DEVLOG = int(inv.Args.Int("level"))
+	return nil, nil
+}
+
+func factionWarCommand(inv *Invocation) ([]byte, error) {
+	database.PrepareFactionWar(int(inv.Args.Int("countdown")))
+	return nil, nil
+}
+
+func flagKingdomCommand(inv *Invocation) ([]byte, error) {
+	database.PrepareFlagKingdom(int(inv.Args.Int("countdown")))
+	return nil, nil
+}
+
+func greatWarCommand(inv *Invocation) ([]byte, error) {
+	database.CanJoinWar = true
+	database.StartWarTimer(int(inv.Args.Int("countdown")), 40)
+	return nil, nil
+}
+
+func verifyConsignmentCommand(inv *Invocation) ([]byte, error) {
+	database.IsHideBannedUserItems = true
+	return nil, nil
+}
+
+func refreshCommand(inv *Invocation) ([]byte, error) {
+	table := inv.Args.String("table")
+	if err := database.ReloadTable(table); err != nil {
+		return messaging.InfoMessage(err.Error()), nil
+	}
+	if table == database.TABLES_ALL {
+		return messaging.InfoMessage("Tables refreshed."), nil
+	}
+	return messaging.InfoMessage(fmt.Sprintf("%s refreshed.", table)), nil
+}
+
+// cleanItemsCommand deletes the items of accounts that do not exist.
+func cleanItemsCommand(inv *Invocation) ([]byte, error) {
+	users := database.Users
+	for _, item := range database.ReadAllItems() {
+		if users[item.UserID.String] == nil {
+			item.Delete()
+		}
+	}
+	return nil, nil
+}
+
+func startCiCommand(inv *Invocation) ([]byte, error) {
+	database.StartCiEventCountdown(600)
+	return nil, nil
+}
+
+func resetDungeonKeysCommand(inv *Invocation) ([]byte, error) {
+	if err := database.RefreshYingYangKeys(); err != nil {
+		fmt.Print(err)
+	}
+	return nil, nil
+}
+
+// deleteOrphansCommand deletes the characters of accounts that do not exist.
+func deleteOrphansCommand(inv *Invocation) ([]byte, error) {
+	chars, err := database.FindAllCharacter()
+	if err != nil {
+		log.Print(err)
+	}
+	for _, char := range chars {
+		user, err := database.FindUserByID(char.UserID)
+		if err != nil {
+			log.Print(err)
+		} else if user == nil {
+			char.Delete()
+		}
+	}
+	return nil, nil
+}
+
+func solveCommand(inv *Invocation) ([]byte, error) {
+	resolveOverlappingItems(inv.Args.String("account"))
+	return nil, nil
+}
+
+func tuningCommand(inv *Invocation) ([]byte, error) {
+	key, value := inv.Args.String("key"), inv.Args.String("value")
+	if key == "reload" && value == "" {
+		if err := database.ReloadTuning(inv.Caller()); err != nil {
+			return messaging.InfoMessage(err.Error()), nil
+		}
+		return messaging.InfoMessage("Tuning reloaded."), nil
+	}
+
+	if value == "" {
+		return messaging.InfoMessage("Usage: /tuning reload | /tuning <key> <value>"), nil
+	}
+	if err := database.SetTuning(key, value, inv.Caller()); err != nil {
+		return messaging.InfoMessage(err.Error()), nil
+	}
+	return messaging.InfoMessage(fmt.Sprintf("Tuning %s saved.", key)), nil
+}
+
+func init() {
+	tables := append([]string{database.TABLES_ALL}, database.TableNames()...)
+
+	RegisterCommands(
+		&Command{
+			Name:    "announce",
+			Rank:    server.GAL_USER,
+			Args:    []Arg{{Name: "message", Type: ARG_TEXT}},
+			Help:    "Announces a message to every player.",
+			Handler: announceCommand,
+		},
+		&Command{
+			Name:    "main",
+			Rank:    server.GAL_USER,
+			Help:    "Announces a maintenance in 60 seconds and counts down.",
+			Handler: maintenanceCommand,
+		},
+		&Command{
+			Name:    "event",
+			Rank:    server.HGM_USER,
+			Args:    []Arg{{Name: "event", Type: ARG_WORD}},
+			Help:    "Starts an event.",
+			Handler: eventCommand,
+		},
+		&Command{
+			Name:    "eventprob",
+			Rank:    server.HGM_USER,
+			Args:    []Arg{{Name: "probability", Type: ARG_INT, Min: 0, Max: 1000}},
+			Help:    "Sets the probability of event drops.",
+			Handler: eventProbCommand,
+		},
+		&Command{
+			Name:    "goldrate",
+			Rank:    server.HGM_USER,
+			Args:    rateArgs,
+			Help:    "Sets the gold rate for some minutes.",
+			Handler: goldRateCommand,
+		},
+		&Command{
+			Name:    "exprate",
+			Rank:    server.HGM_USER,
+			Args:    rateArgs,
+			Help:    "Sets the experience rate for some minutes.",
+			Handler: expRateCommand,
+		},
+		&Command{
+			Name:    "droprate",
+			Rank:    server.HGM_USER,
+			Args:    rateArgs,
+			Help:    "Sets the drop rate for some minutes.",
+			Handler: dropRateCommand,
+		},
+		&Command{
+			Name:    "relicdrops",
+			Rank:    server.HGM_USER,
+			Args:    []Arg{{Name: "enabled", Type: ARG_WORD, Choices: []string{"0", "1"}}},
+			Help:    "Turns relic drops off or on.",
+			Handler: relicDropsCommand,
+		},
+		&Command{
+			Name:    "debug",
+			Rank:    server.GM_USER,
+			Args:    []Arg{{Name: "level", Type: ARG_INT}},
+			Help:    "Sets the debug level.",
+			Handler: debugCommand,
+		},
+		&Command{
+			Name:    "devlog",
+			Rank:    server.GM_USER,
+			Args:    []Arg{{Name: "level", Type: ARG_INT}},
+			Help:    "Sets the developer log level.",
+			Handler: devlogCommand,
+		},
+		&Command{
+			Name:    "war",
+			Rank:    server.HGM_USER,
+			Args:    []Arg{{Name: "countdown", Type: ARG_INT, Min: 0, Max: 86400}},
+			Help:    "Prepares a faction war.",
+			Handler: factionWarCommand,
+		},
+		&Command{
+			Name:    "fgwar",
+			Rank:    server.HGM_USER,
+			Args:    []Arg{{Name: "countdown", Type: ARG_INT, Min: 0, Max: 86400}},
+			Help:    "Prepares a flag kingdom war.",
+			Handler: flagKingdomCommand,
+		},
+		&Command{
+			Name:    "gwar",
+			Rank:    server.HGM_USER,
+			Args:    []Arg{{Name: "countdown", Type: ARG_INT, Min: 0, Max: 86400}},
+			Help:    "Opens the great war.",
+			Handler: greatWarCommand,
+		},
+		&Command{
+			Name:    "verifyconsignment",
+			Rank:    server.HGM_USER,
+			Help:    "Hides the consignment items of banned accounts.",
+			Handler: verifyConsignmentCommand,
+		},
+		&Command{
+			Name:    "refresh",
+			Rank:    server.HGM_USER,
+			Args:    []Arg{{Name: "table", Type: ARG_WORD, Choices: tables}},
+			Help:    "Reloads a table from the database.",
+			Handler: refreshCommand,
+		},
+		&Command{
+			Name:    "cleanitemsdb",
+			Rank:    server.HGM_USER,
+			Help:    "Deletes the items of accounts that do not exist.",
+			Handler: cleanItemsCommand,
+		},
+		&Command{
+			Name:    "startci",
+			Rank:    server.HGM_USER,
+			Help:    "Starts the CI event in 10 minutes.",
+			Handler: startCiCommand,
+		},
+		&Command{
+			Name:    "resetdungkeys",
+			Rank:    server.HGM_USER,
+			Help:    "Gives the dungeon keys back.",
+			Handler: resetDungeonKeysCommand,
+		},
+		&Command{
+			Name:    "del",
+			Rank:    server.HGM_USER,
+			Help:    "Deletes the characters of accounts that do not exist.",
+			Handler: deleteOrphansCommand,
+		},
+		&Command{
+			Name:    "solve",
+			Rank:    server.HGM_USER,
+			Args:    []Arg{{Name: "account", Type: ARG_WORD}},
+			Help:    "Moves apart the bank items of an account that overlap.",
+			Handler: solveCommand,
+		},
+		&Command{
+			Name: "tuning",
+			Rank: server.HGM_USER,
+			Args: []Arg{
+				{Name: "key", Type: ARG_WORD},
+				{Name: "value", Type: ARG_TEXT, Optional: true},
+			},
+			Help:    "Sets a key of the tuning, /tuning reload reads it again.",
+			Handler: tuningCommand,
+		},
+	)
+}
@@ -0,0 +1,179 @@
+package player
+
+import (
+	"bytes"
+	"database/sql"
+	"strings"
+	"testing"
+
+	"github.com/twodragon/kore-server/database"
+	"github.com/twodragon/kore-server/server"
+)
+
+// useCharacters stands the characters in for the database behind
+// findCharacter.
+func useCharacters(t *testing.T, characters ...*database.Character) {
+	t.Helper()
+	find := findCharacter
+	findCharacter = func(name string) (*database.Character, error) {
+		for _, c := range characters {
+			if strings.EqualFold(c.Name, name) {
+				return c, nil
+			}
+		}
+		return nil, sql.ErrNoRows
+	}
+	t.Cleanup(func() { findCharacter = find })
+}
+
+func TestParse(t *testing.T) {
+	offline := &database.Character{ID: 1, Name: "Offline", UserID: "10"}
+	online := &database.Character{ID: 2, Name: "Online", UserID: "20", IsOnline: true, Socket: &database.Socket{}}
+	useCharacters(t, offline, online)
+
+	c := &Command{Name: "test", Args: []Arg{
+		{Name: "count", Type: ARG_INT, Min: 1, Max: 10},
+		{Name: "mode", Type: ARG_WORD, Choices: []string{"on", "off"}},
+		{Name: "rate", Type: ARG_FLOAT, Optional: true, Min: 0, Max: 2},
+		{Name: "who", Type: ARG_ONLINE, Optional: true},
+	}}
+
+	for _, tc := range []struct {
+		words []string
+		err   string
+	}{
+		{nil, "count is missing"},
+		{[]string{"x", "on"}, "not a whole number"},
+		{[]string{"0", "on"}, "between 1 and 10"},
+		{[]string{"11", "on"}, "between 1 and 10"},
+		{[]string{"5"}, "mode is missing"},
+		{[]string{"5", "maybe"}, "not one of the choices"},
+		{[]string{"5", "on", "NaN"}, "not a number"},
+		{[]string{"5", "on", "3"}, "between 0 and 2"},
+		{[]string{"5", "on", "1", "Nobody"}, "not found"},
+		{[]string{"5", "on", "1", "Offline"}, "not online"},
+		{[]string{"5", "on", "1", "Online", "extra"}, "too many arguments"},
+	} {
+		_, err := c.Parse(tc.words)
+		if err == nil || !strings.Contains(err.Error(), tc.err) {
+			t.Errorf("Parse(%q) = %v, want %q", tc.words, err, tc.err)
+		}
+	}
+
+	args, err := c.Parse([]string{"10", "OFF"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if args.Int("count") != 10 || args.String("mode") != "off" || args.Has("rate") || args.Character("who") != nil {
+		t.Errorf("Parse(10 OFF) = %v", args)
+	}
+
+	args, err = c.Parse([]string{"1", "on", "1.5", "online"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if args.Float("rate") != 1.5 || args.Character("who") != online {
+		t.Errorf("Parse(1 on 1.5 online) = %v", args)
+	}
+}
+
+func TestParseText(t *testing.T) {
+	c := &Command{Name: "test", Args: []Arg{
+		{Name: "target", Type: ARG_WORD},
+		{Name: "reason", Type: ARG_TEXT, Optional: true},
+	}}
+
+	args, err := c.Parse([]string{"someone", "spamming", "the", "chat"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if args.String("reason") != "spamming the chat" {
+		t.Errorf("reason = %q", args.String("reason"))
+	}
+	if args, err := c.Parse([]string{"someone"}); err != nil || args.Has("reason") {
+		t.Errorf("Parse(someone) = %v, %v", args, err)
+	}
+
+	none := &Command{Name: "test"}
+	if _, err := none.Parse([]string{"extra"}); err == nil {
+		t.Error("no arguments took one")
+	}
+}
+
+func TestRegisterTextNotLast(t *testing.T) {
+	defer func() {
+		if recover() == nil {
+			t.Error("text argument before another was registered")
+		}
+	}()
+	RegisterCommands(&Command{Name: "zz_text_first", Args: []Arg{{Name: "a", Type: ARG_TEXT}, {Name: "b", Type: ARG_WORD}}})
+}
+
+func TestFindCommandRank(t *testing.T) {
+	if FindCommand("help", server.COMMON_USER) == nil {
+		t.Error("/help not found")
+	}
+	if FindCommand("COMMANDS", server.COMMON_USER) == nil {
+		t.Error("alias /commands not found")
+	}
+	if FindCommand("resetpin", server.COMMON_USER) != nil {
+		t.Error("/resetpin found for a common user")
+	}
+	if FindCommand("resetpin", server.GM_USER) == nil {
+		t.Error("/resetpin not found for a GM")
+	}
+	if FindCommand("nosuchcommand", server.HGM_USER) != nil {
+		t.Error("unknown command found")
+	}
+}
+
+func TestHelpWithoutSocket(t *testing.T) {
+	help := FindCommand("help", server.COMMON_USER)
+	run := func(rank int8, words ...string) []byte {
+		inv := &Invocation{Name: "help", User: &database.User{ID: "1", UserType: rank}, Character: &database.Character{Name: "Caller"}}
+		resp, err := help.Run(inv, words)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return resp
+	}
+
+	player := run(server.COMMON_USER)
+	if !bytes.Contains(player, []byte("/pin")) || bytes.Contains(player, []byte("/resetpin")) {
+		t.Errorf("/help of a player: %q", player)
+	}
+	if gm := run(server.GM_USER); !bytes.Contains(gm, []byte("/resetpin")) {
+		t.Errorf("/help of a GM: %q", gm)
+	}
+
+	if resp := run(server.COMMON_USER, "pin"); !bytes.Contains(resp, []byte("/pin <pin> [code]")) {
+		t.Errorf("/help pin: %q", resp)
+	}
+	if resp := run(server.COMMON_USER, "resetpin"); !bytes.Contains(resp, []byte("Unknown command")) {
+		t.Errorf("/help resetpin of a player: %q", resp)
+	}
+	if resp := run(server.COMMON_USER, "pin", "extra"); !bytes.Contains(resp, []byte("Usage: /help")) {
+		t.Errorf("/help with too many arguments: %q", resp)
+	}
+}
+
+func TestHandlerWithoutSocket(t *testing.T) {
+	target := &database.Character{ID: 7, Name: "Target", UserID: "42"}
+	useCharacters(t, target)
+
+	uid := FindCommand("uid", server.HGM_USER)
+	if uid == nil {
+		t.Fatal("/uid not found")
+	}
+	args, err := uid.Parse([]string{"target"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := uid.Handler(&Invocation{Command: uid, Args: args, User: &database.User{ID: "1"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Contains(resp, []byte("42")) {
+		t.Errorf("/uid target: %q", resp)
+	}
+}
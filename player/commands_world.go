@@ -0,0 +1,419 @@
+package player
+
+import (
+	"encoding/hex"
+	"fmt"
+	"log"
+	"math"
+	"sort"
+
+	"github.com/thoas/go-funk"
+	"github.com/twodragon/kore-server/database"
+	"github.com/twodragon/kore-server/messaging"
+	"github.com/twodragon/kore-server/npc"
+	"github.com/twodragon/kore-server/server"
+	"github.com/twodragon/kore-server/utils"
+)
+
+var positionArg = Arg{Name: "position", Type: ARG_INT, Min: 0, Max: math.MaxInt32}
+
+func mapCommand(inv *Invocation) ([]byte, error) {
+	mapID := int16(inv.Args.Int("map"))
+	if c := inv.Args.Character("character"); c != nil {
+		data, err := c.ChangeMap(mapID, nil)
+		if err != nil {
+			return nil, err
+		}
+		c.Socket.Write(data)
+		return nil, nil
+	}
+	return inv.Character.ChangeMap(mapID, nil)
+}
+
+func teleportCommand(inv *Invocation) ([]byte, error) {
+	point := fmt.Sprintf("%.1f,%.1f", inv.Args.Float("x"), inv.Args.Float("y"))
+	return inv.Character.Teleport(database.ConvertPointToLocation(point)), nil
+}
+
+func teleportToPlayerCommand(inv *Invocation) ([]byte, error) {
+	c := inv.Args.Character("character")
+	if server := c.Socket.User.ConnectedServer; server != inv.User.ConnectedServer {
+		inv.User.ConnectedServer = server
+		inv.User.SelectedServerID = server
+	}
+	return inv.Character.ChangeMap(c.Map, database.ConvertPointToLocation(c.Coordinate))
+}
+
+func summonCommand(inv *Invocation) ([]byte, error) {
+	c := inv.Args.Character("character")
+	coordinate := database.ConvertPointToLocation(inv.Character.Coordinate)
+	gomap, _ := c.ChangeMap(inv.Character.Map, coordinate)
+	c.Socket.Write(gomap)
+	return gomap, nil
+}
+
+func dungeonCommand(inv *Invocation) ([]byte, error) {
+	resp := utils.Packet{}
+	data, err := inv.Character.ChangeMap(243, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp.Concat(data)
+	resp.Concat(inv.Character.Teleport(database.ConvertPointToLocation("377,246")))
+	return resp, nil
+}
+
+func kickCommand(inv *Invocation) ([]byte, error) {
+	c := inv.Args.Character("character")
+	sock := database.GetSocket(c.UserID)
+	if sock == nil {
+		return messaging.InfoMessage(fmt.Sprintf("%s is not online.", c.Name)), nil
+	}
+	sock.SetCloseReason(database.SESSION_KICKED)
+	sock.Conn.Close()
+	return nil, nil
+}
+
+func onlineCommand(inv *Invocation) ([]byte, error) {
+	characters, err := database.FindOnlineCharacters()
+	if err != nil {
+		return nil, err
+	}
+
+	online := funk.Values(characters).([]*database.Character)
+	sort.Slice(online, func(i, j int) bool {
+		return online[i].Name < online[j].Name
+	})
+
+	resp := utils.Packet{}
+	resp.Concat(messaging.InfoMessage(fmt.Sprintf("%d player(s) online.", len(characters))))
+	for _, c := range online {
+		u, _ := database.FindUserByID(c.UserID)
+		if u == nil {
+			continue
+		}
+		resp.Concat(messaging.InfoMessage(fmt.Sprintf("%s is in map %d (Dragon%d) at %s.", c.Name, c.Map, u.ConnectedServer, c.Coordinate)))
+	}
+	return resp, nil
+}
+
+// killCommand leaves the selected mob with 1 HP.
+func killCommand(inv *Invocation) ([]byte, error) {
+	ids, err := inv.Character.GetNearbyAIIDs()
+	if err != nil {
+		log.Println(err)
+		return nil, nil
+	}
+
+	for _, id := range ids {
+		if mob := database.AIs[id]; mob != nil && int(mob.PseudoID) == inv.Character.Selection {
+			mob.HP = 1
+			return nil, nil
+		}
+	}
+	return messaging.InfoMessage("Select a mob nearby first."), nil
+}
+
+func generateCommand(inv *Invocation) ([]byte, error) {
+	generateMobs(int(inv.Args.Int("n")), int(inv.Args.Int("m")))
+	return nil, nil
+}
+
+func addMobsCommand(inv *Invocation) ([]byte, error) {
+	c := inv.Character
+	spawnPoints := []string{c.Coordinate}
+	cmdSpawnMobs(int(inv.Args.Int("count")), int(inv.Args.Int("npc")), int(inv.User.ConnectedServer), int(c.Map), spawnPoints)
+	return nil, nil
+}
+
+func mobCommand(inv *Invocation) ([]byte, error) {
+	npcPos := database.GetNPCPosByID(int(inv.Args.Int("position")))
+	if npcPos == nil {
+		return messaging.InfoMessage("Position not found."), nil
+	}
+	npc, ok := database.GetNpcInfo(npcPos.NPCID)
+	if !ok {
+		return messaging.InfoMessage("NPC not found."), nil
+	}
+
+	ai := &database.AI{ID: len(database.AIs), HP: npc.MaxHp, Map: npcPos.MapID, PosID: npcPos.ID, RunningSpeed: 10, Server: 1, WalkingSpeed: 5, Once: true, Faction: 0, CanAttack: true}
+	database.GenerateIDForAI(ai)
+	ai.OnSightPlayers = make(map[int]interface{})
+
+	minLoc := database.ConvertPointToLocation(npcPos.MinLocation)
+	maxLoc := database.ConvertPointToLocation(npcPos.MaxLocation)
+	loc := utils.Location{X: utils.RandFloat(minLoc.X, maxLoc.X), Y: utils.RandFloat(minLoc.Y, maxLoc.Y)}
+	ai.NPCpos = npcPos
+	ai.Coordinate = loc.String()
+	ai.Handler = ai.AIHandler
+	go ai.Handler()
+
+	makeAnnouncement(fmt.Sprintf("%s is roaring. %s", npc.Name, ai.Coordinate))
+
+	database.AIsByMap[ai.Server][npcPos.MapID] = append(database.AIsByMap[ai.Server][npcPos.MapID], ai)
+	database.AIs[ai.ID] = ai
+	return nil, nil
+}
+
+func spawnCommand(inv *Invocation) ([]byte, error) {
+	npcPos := database.GetNPCPosByID(int(inv.Args.Int("position")))
+	if npcPos == nil {
+		return messaging.InfoMessage("Position not found."), nil
+	}
+	database.SpawnCreep(2, npcPos)
+	return nil, nil
+}
+
+func resetMobsCommand(inv *Invocation) ([]byte, error) {
+	npcPos := database.GetNPCPosByID(int(inv.Args.Int("position")))
+	if npcPos == nil {
+		return messaging.InfoMessage("Position not found."), nil
+	}
+	npc, ok := database.GetNpcInfo(npcPos.NPCID)
+	if !ok {
+		return messaging.InfoMessage("NPC not found."), nil
+	}
+
+	for i := 0; i < int(npcPos.Count); i++ {
+		if npc.ID == 0 {
+			continue
+		}
+
+		newai := &database.AI{ID: len(database.AIs), HP: npc.MaxHp, Map: npcPos.MapID, PosID: npcPos.ID, RunningSpeed: 10, Server: 1, WalkingSpeed: 5, Once: true}
+		database.GenerateIDForAI(newai)
+		newai.OnSightPlayers = make(map[int]interface{})
+
+		minLoc := database.ConvertPointToLocation(npcPos.MinLocation)
+		maxLoc := database.ConvertPointToLocation(npcPos.MaxLocation)
+		loc := utils.Location{X: utils.RandFloat(minLoc.X, maxLoc.X), Y: utils.RandFloat(minLoc.Y, maxLoc.Y)}
+		newai.Coordinate = loc.String()
+		newai.Handler = newai.AIHandler
+		database.AIsByMap[newai.Server][npcPos.MapID] = append(database.AIsByMap[newai.Server][npcPos.MapID], newai)
+		database.AIs[newai.ID] = newai
+		log.Print("New mob created", len(database.AIs))
+		newai.Create()
+		go newai.Handler()
+	}
+	log.Print("Finished")
+	return nil, nil
+}
+
+func resetAllMobsCommand(inv *Invocation) ([]byte, error) {
+	for _, npcPos := range database.GetNPCPostions() {
+		npc, ok := database.GetNpcInfo(npcPos.NPCID)
+		if !ok {
+			log.Print("Error")
+			continue
+		}
+
+		for i := 0; i < int(npc.Test); i++ {
+			if npc.ID == 0 || npcPos.IsNPC || !npcPos.Attackable {
+				continue
+			}
+			minLoc := database.ConvertPointToLocation(npcPos.MinLocation)
+			maxLoc := database.ConvertPointToLocation(npcPos.MaxLocation)
+			loc := utils.Location{X: utils.RandFloat(minLoc.X, maxLoc.X), Y: utils.RandFloat(minLoc.Y, maxLoc.Y)}
+			newai := &database.AI{
+				ID:         len(database.AIs) + 1,
+				Map:        npcPos.MapID,
+				PosID:      npcPos.ID,
+				Server:     1,
+				CanAttack:  true,
+				Faction:    0,
+				IsDead:     false,
+				Coordinate: loc.String(),
+			}
+
+			if err := newai.Create(); err != nil {
+				log.Print(err)
+			}
+			newai.Handler = newai.AIHandler
+			database.AIsByMap[newai.Server][npcPos.MapID] = append(database.AIsByMap[newai.Server][npcPos.MapID], newai)
+			database.AIs[newai.ID] = newai
+			log.Println("New mob created", newai.ID)
+		}
+	}
+	log.Print("Finished")
+	return nil, nil
+}
+
+// spawnMobCommand spawns the mob of a position next to you.
+func spawnMobCommand(inv *Invocation) ([]byte, error) {
+	npcPos := database.GetNPCPosByID(int(inv.Args.Int("position")))
+	if npcPos == nil {
+		return messaging.InfoMessage("Position not found."), nil
+	}
+	npc, ok := database.GetNpcInfo(npcPos.NPCID)
+	if !ok {
+		return messaging.InfoMessage("NPC not found."), nil
+	}
+
+	database.SetNPCPos(npcPos.ID, npcPos)
+	newai := &database.AI{ID: len(database.AIs), HP: npc.MaxHp, Map: npcPos.MapID, PosID: npcPos.ID, RunningSpeed: 10, Server: 1, WalkingSpeed: 5, Once: true}
+	newai.OnSightPlayers = make(map[int]interface{})
+	coordinate := database.ConvertPointToLocation(inv.Character.Coordinate)
+	randomLocX := randFloats(coordinate.X, coordinate.X+30)
+	randomLocY := randFloats(coordinate.Y, coordinate.Y+30)
+	loc := utils.Location{X: randomLocX, Y: randomLocY}
+	npcPos.MinLocation = fmt.Sprintf("%.1f,%.1f", randomLocX, randomLocY)
+	npcPos.MaxLocation = fmt.Sprintf("%.1f,%.1f", randomLocX+50, randomLocY+50)
+	newai.Coordinate = loc.String()
+	newai.Handler = newai.AIHandler
+
+	database.AIsByMap[newai.Server][newai.Map] = append(database.AIsByMap[newai.Server][newai.Map], newai)
+	database.AIs[newai.ID] = newai
+	database.GenerateIDForAI(newai)
+	if newai.WalkingSpeed > 0 {
+		go newai.Handler()
+	}
+	return nil, nil
+}
+
+func npcCommand(inv *Invocation) ([]byte, error) {
+	return npc.GetNPCMenu(int(inv.Args.Int("npc")), 999993, 0, []int{int(inv.Args.Int("action"))}), nil
+}
+
+// rawCommand sends you a packet typed in hex.
+func rawCommand(inv *Invocation) ([]byte, error) {
+	data, err := hex.DecodeString(inv.Args.String("packet"))
+	if err != nil {
+		return messaging.InfoMessage("The packet is not hex."), nil
+	}
+	log.Print(data)
+	return data, nil
+}
+
+func init() {
+	RegisterCommands(
+		&Command{
+			Name: "map",
+			Rank: server.GA_USER,
+			Args: []Arg{
+				{Name: "map", Type: ARG_INT, Min: 0, Max: math.MaxInt16},
+				{Name: "character", Type: ARG_ONLINE, Optional: true},
+			},
+			Help:    "Moves you or a character to a map.",
+			Handler: mapCommand,
+		},
+		&Command{
+			Name: "tp",
+			Rank: server.GA_USER,
+			Args: []Arg{
+				{Name: "x", Type: ARG_FLOAT},
+				{Name: "y", Type: ARG_FLOAT},
+			},
+			Help:    "Teleports you within the map.",
+			Handler: teleportCommand,
+		},
+		&Command{
+			Name:    "tpp",
+			Rank:    server.GAL_USER,
+			Args:    []Arg{{Name: "character", Type: ARG_ONLINE}},
+			Help:    "Teleports you to a character.",
+			Handler: teleportToPlayerCommand,
+		},
+		&Command{
+			Name:    "summon",
+			Rank:    server.GM_USER,
+			Args:    []Arg{{Name: "character", Type: ARG_ONLINE}},
+			Help:    "Brings a character to you.",
+			Handler: summonCommand,
+		},
+		&Command{
+			Name:    "dungeon",
+			Rank:    server.HGM_USER,
+			Help:    "Takes you to the dungeon.",
+			Handler: dungeonCommand,
+		},
+		&Command{
+			Name:    "kick",
+			Rank:    server.GAL_USER,
+			Args:    []Arg{{Name: "character", Type: ARG_CHARACTER}},
+			Help:    "Disconnects the account of a character.",
+			Handler: kickCommand,
+		},
+		&Command{
+			Name:    "online",
+			Rank:    server.GA_USER,
+			Help:    "Lists the players online and where they are.",
+			Handler: onlineCommand,
+		},
+		&Command{
+			Name:    "kill",
+			Rank:    server.HGM_USER,
+			Help:    "Leaves the mob you selected with 1 HP.",
+			Handler: killCommand,
+		},
+		&Command{
+			Name: "generate",
+			Rank: server.HGM_USER,
+			Args: []Arg{
+				{Name: "n", Type: ARG_INT},
+				{Name: "m", Type: ARG_INT},
+			},
+			Help:    "Generates mobs.",
+			Handler: generateCommand,
+		},
+		&Command{
+			Name: "addmobs",
+			Rank: server.HGM_USER,
+			Args: []Arg{
+				{Name: "npc", Type: ARG_INT},
+				{Name: "count", Type: ARG_INT, Min: 1, Max: 1000},
+			},
+			Help:    "Spawns mobs of an NPC where you stand.",
+			Handler: addMobsCommand,
+		},
+		&Command{
+			Name:    "mob",
+			Rank:    server.GAL_USER,
+			Args:    []Arg{positionArg},
+			Help:    "Spawns the mob of a position once and announces it.",
+			Handler: mobCommand,
+		},
+		&Command{
+			Name:    "spawn",
+			Rank:    server.HGM_USER,
+			Args:    []Arg{positionArg},
+			Help:    "Spawns the creeps of a position.",
+			Handler: spawnCommand,
+		},
+		&Command{
+			Name:    "resetmobs",
+			Rank:    server.HGM_USER,
+			Args:    []Arg{positionArg},
+			Help:    "Creates the mobs of a position again.",
+			Handler: resetMobsCommand,
+		},
+		&Command{
+			Name:    "resetallmobs",
+			Rank:    server.HGM_USER,
+			Help:    "Creates the mobs of every position again.",
+			Handler: resetAllMobsCommand,
+		},
+		&Command{
+			Name:    "spawnmob",
+			Rank:    server.GM_USER,
+			Args:    []Arg{positionArg},
+			Help:    "Spawns the mob of a position next to you.",
+			Handler: spawnMobCommand,
+		},
+		&Command{
+			Name: "npc",
+			Rank: server.HGM_USER,
+			Args: []Arg{
+				{Name: "npc", Type: ARG_INT},
+				{Name: "action", Type: ARG_INT},
+			},
+			Help:    "Opens the menu of an NPC.",
+			Handler: npcCommand,
+		},
+		&Command{
+			Name:    "r",
+			Rank:    server.HGM_USER,
+			Args:    []Arg{{Name: "packet", Type: ARG_WORD}},
+			Help:    "Sends you a packet typed in hex.",
+			Handler: rawCommand,
+		},
+	)
+}
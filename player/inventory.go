@@ -336,6 +336,10 @@ func (h *WithdrawHandler) Handle(s *database.Socket, data []byte) ([]byte, error
 		return nil, nil
 	}
 
+	if !s.PINFresh() {
+		return messaging.InfoMessage(PIN_NEEDED), nil
+	}
+
 	gold := uint64(utils.BytesToInt(data[6:14], true))
 	if u.BankGold >= gold {
 		if gold < 0 {
@@ -379,6 +383,7 @@ func (h *BuyHTItemHandler) Handle(s *database.Socket, data []byte) ([]byte, erro
 
 	if item, ok := database.TavernItems[int(itemID)]; ok && item.IsActive && s.User.NCash >= uint64(item.Price) {
 		s.User.NCash -= uint64(item.Price)
+		database.NCashDestroyed.Add(float64(item.Price))
 
 		info, ok := database.GetItemInfo(itemID)
 		if !ok {
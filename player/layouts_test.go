@@ -0,0 +1,104 @@
+package player
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/twodragon/kore-server/codec"
+)
+
+// decodesShort checks that the packet cut anywhere inside its body, with and
+// without a trailer, does not decode. Cuts shorter than a header and a
+// trailer are bad frames, the others short packets.
+func decodesShort(t *testing.T, layout *codec.Layout, packet []byte) {
+	t.Helper()
+	for end := codec.HEADER_SIZE; end < len(packet)-codec.TRAILER_SIZE; end++ {
+		cut := append([]byte{}, packet[:end]...)
+		for _, p := range [][]byte{cut, append(cut[:end:end], 0x55, 0xAA)} {
+			if _, err := layout.Decode(p); !errors.Is(err, codec.ErrShortPacket) && !errors.Is(err, codec.ErrBadFrame) {
+				t.Errorf("% X: err %v, want a short packet", p, err)
+			}
+		}
+	}
+}
+
+func TestMovementRequest(t *testing.T) {
+	packet := MOVEMENT_REQUEST.MustEncode(codec.Values{"opcode": 0x2201, "x": 101.5, "y": 202.25, "target_x": 103, "target_y": -4.5})
+	if len(packet) != 26+codec.TRAILER_SIZE {
+		t.Fatalf("%d bytes, the client sends 26 and a trailer", len(packet))
+	}
+	if packet[4] != 0x22 || packet[5] != 0x01 {
+		t.Errorf("opcode % X, want 22 01", packet[4:6])
+	}
+
+	req, err := MOVEMENT_REQUEST.Decode(packet)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if req.Uint("opcode") != 0x2201 || req.Float("x") != 101.5 || req.Float("y") != 202.25 ||
+		req.Float("target_x") != 103 || req.Float("target_y") != -4.5 {
+		t.Errorf("got %v", req)
+	}
+	decodesShort(t, MOVEMENT_REQUEST, packet)
+}
+
+func TestCharacterMovement(t *testing.T) {
+	packet := CHARACTER_MOVEMENT.MustEncode(codec.Values{"type": 0x22, "pseudo_id": 0x1234, "mode": 1, "x": 1, "y": 2, "target_x": 3, "target_y": 4, "speed": 5.5})
+	if packet[2] != 0x22 || packet[3] != 0x00 {
+		t.Errorf("length % X, want 22 00", packet[2:4])
+	}
+	if packet[5] != 0x34 || packet[6] != 0x12 || packet[7] != 1 {
+		t.Errorf("pseudo id and mode % X", packet[5:8])
+	}
+
+	resp, err := CHARACTER_MOVEMENT.Decode(packet)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.Uint("pseudo_id") != 0x1234 || resp.Float("speed") != 5.5 || resp.Float("target_y") != 4 {
+		t.Errorf("got %v", resp)
+	}
+}
+
+func TestTargetSelection(t *testing.T) {
+	packet := []byte{0xAA, 0x55, 0x03, 0x00, 0xCF, 0x39, 0x05, 0x55, 0xAA}
+
+	req, err := TARGET_SELECTION_REQUEST.Decode(packet)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if req.Uint("id") != 0x0539 {
+		t.Errorf("id %d, want %d", req.Uint("id"), 0x0539)
+	}
+	if again := TARGET_SELECTION_REQUEST.MustEncode(req); string(again) != string(packet) {
+		t.Errorf("encoded % X, want % X", again, packet)
+	}
+	decodesShort(t, TARGET_SELECTION_REQUEST, packet)
+
+	changed := SELECTION_CHANGED.MustEncode(codec.Values{"id": 0x0539})
+	want := []byte{0xAA, 0x55, 0x09, 0x00, 0xCF, 0x39, 0x05, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x55, 0xAA}
+	if string(changed) != string(want) {
+		t.Errorf("selection changed % X, want % X", changed, want)
+	}
+
+	packet[4] = 0xD0
+	if _, err := TARGET_SELECTION_REQUEST.Decode(packet); !errors.Is(err, codec.ErrBadConst) {
+		t.Errorf("opcode D0: err %v, want %v", err, codec.ErrBadConst)
+	}
+}
+
+func TestLootRequest(t *testing.T) {
+	packet := []byte{0xAA, 0x55, 0x05, 0x00, 0x59, 0x01, 0x00, 0x10, 0x27, 0x55, 0xAA}
+
+	req, err := LOOT_REQUEST.Decode(packet)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if req.Uint("drop_id") != 10000 {
+		t.Errorf("drop id %d, want 10000", req.Uint("drop_id"))
+	}
+	if again := LOOT_REQUEST.MustEncode(req); string(again) != string(packet) {
+		t.Errorf("encoded % X, want % X", again, packet)
+	}
+	decodesShort(t, LOOT_REQUEST, packet)
+}
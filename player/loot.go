@@ -1,6 +1,7 @@
 package player
 
 import (
+	"github.com/twodragon/kore-server/codec"
 	"github.com/twodragon/kore-server/database"
 	"github.com/twodragon/kore-server/messaging"
 	"github.com/twodragon/kore-server/nats"
@@ -10,6 +11,10 @@ import (
 type LootHandler struct {
 }
 
+var (
+	LOOT_REQUEST = codec.NewLayout("loot", codec.Opcode(22785), codec.Pad(1), codec.U16("drop_id"))
+)
+
 func (h *LootHandler) Handle(s *database.Socket, data []byte) ([]byte, error) {
 
 	c := s.Character
@@ -26,7 +31,12 @@ func (h *LootHandler) Handle(s *database.Socket, data []byte) ([]byte, error) {
 	defer c.Looting.Unlock()
 	resp := utils.Packet{}
 
-	dropID := uint16(utils.BytesToInt(data[7:9], true))
+	req, err := LOOT_REQUEST.Decode(data)
+	if err != nil {
+		return nil, err
+	}
+
+	dropID := uint16(req.Uint("drop_id"))
 	drop := database.GetDrop(s.User.ConnectedServer, s.Character.Map, dropID)
 	if drop != nil && drop.Item != nil && (drop.Claimer == nil || drop.Claimer.ID == s.Character.ID) {
 		if drop.Item.ItemID == 0 {
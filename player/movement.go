@@ -6,6 +6,7 @@ import (
 	"strings"
 	"time"
 
+	"github.com/twodragon/kore-server/codec"
 	"github.com/twodragon/kore-server/database"
 	"github.com/twodragon/kore-server/messaging"
 	"github.com/twodragon/kore-server/nats"
@@ -16,7 +17,17 @@ type MovementHandler struct {
 }
 
 var (
-	CHARACTER_MOVEMENT = utils.Packet{0xAA, 0x55, 0x22, 0x00, 0x22, 0x01, 0x00, 0x00, 0x00, 0x00, 0xC8, 0xB0, 0xFE, 0xBE, 0x00, 0x00, 0x55, 0xAA}
+	MOVEMENT_REQUEST = codec.NewLayout("movement",
+		codec.U16BE("opcode"),
+		codec.F32("x"), codec.F32("y"), codec.Pad(4),
+		codec.F32("target_x"), codec.F32("target_y"))
+
+	CHARACTER_MOVEMENT = codec.NewLayout("character_movement",
+		codec.U8("type"), codec.U16("pseudo_id"), codec.U8("mode"),
+		codec.F32("x"), codec.F32("y"), codec.Pad(4),
+		codec.F32("target_x"), codec.F32("target_y"),
+		codec.Const("unknown", 0xC8, 0xB0, 0xFE, 0xBE),
+		codec.F32("speed"), codec.Pad(2))
 )
 
 func (h *MovementHandler) Handle(s *database.Socket, data []byte) ([]byte, error) {
@@ -47,8 +58,9 @@ func (h *MovementHandler) Handle(s *database.Socket, data []byte) ([]byte, error
 		return nil, nil
 	}
 
-	if len(data) < 26 {
-		return nil, nil
+	req, err := MOVEMENT_REQUEST.Decode(data)
+	if err != nil {
+		return nil, err
 	}
 
 	if c.Map == 255 && database.IsFactionWarEntranceActive() {
@@ -69,7 +81,7 @@ func (h *MovementHandler) Handle(s *database.Socket, data []byte) ([]byte, error
 		}
 	}
 
-	movType := utils.BytesToInt(data[4:6], false)
+	movType := req.Uint("opcode")
 	speed := float64(0.0)
 	movMasodik := byte(0x00)
 	if c.Map == 249 {
@@ -116,10 +128,10 @@ func (h *MovementHandler) Handle(s *database.Socket, data []byte) ([]byte, error
 
 	if movType == 8705 { // movement
 		speed = 5.6
-		movMasodik = data[5]
+		movMasodik = byte(movType)
 	} else if movType == 8706 || movType == 9732 { // running or flying
 		speed = c.RunningSpeed + c.Socket.Stats.AdditionalRunningSpeed
-		movMasodik = data[5]
+		movMasodik = byte(movType)
 	}
 
 	if c.IsMounting {
@@ -127,26 +139,23 @@ func (h *MovementHandler) Handle(s *database.Socket, data []byte) ([]byte, error
 		movMasodik = byte(0x01)
 	}
 
-	resp := CHARACTER_MOVEMENT
-	resp.Insert(utils.IntToBytes(uint64(s.Character.PseudoID), 2, true), 5) // character pseudo id
-
-	coordinate := &utils.Location{X: utils.BytesToFloat(data[6:10], true), Y: utils.BytesToFloat(data[10:14], true)}
-	target := &utils.Location{X: utils.BytesToFloat(data[18:22], true), Y: utils.BytesToFloat(data[22:26], true)}
+	coordinate := &utils.Location{X: req.Float("x"), Y: req.Float("y")}
+	target := &utils.Location{X: req.Float("target_x"), Y: req.Float("target_y")}
 	distance := utils.CalculateDistance(coordinate, target)
 
-	resp[4] = data[4]
-	resp[7] = movMasodik //data[5]            // running mode
-
-	resp.Insert(utils.FloatToBytes(coordinate.X, 4, true), 8) // coordinate-x
-	resp.Insert(utils.FloatToBytes(coordinate.Y, 4, true), 12)
-
-	resp.Insert(utils.FloatToBytes(target.X, 4, true), 20) // coordinate-x
-	resp.Insert(utils.FloatToBytes(target.Y, 4, true), 24)
-
-	resp.Insert(utils.FloatToBytes(speed, 4, true), 32) // speed
+	resp := CHARACTER_MOVEMENT.MustEncode(codec.Values{
+		"type":      byte(movType >> 8),
+		"pseudo_id": s.Character.PseudoID,
+		"mode":      movMasodik, // running mode
+		"x":         coordinate.X,
+		"y":         coordinate.Y,
+		"target_x":  target.X,
+		"target_y":  target.Y,
+		"speed":     speed,
+	})
 
 	p := &nats.CastPacket{CastNear: true, CharacterID: s.Character.ID, Data: resp, Type: nats.PLAYER_MOVEMENT}
-	err := p.Cast()
+	err = p.Cast()
 	if err != nil {
 		return nil, err
 	}
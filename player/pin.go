@@ -0,0 +1,128 @@
+package player
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/twodragon/kore-server/config"
+	"github.com/twodragon/kore-server/database"
+	"github.com/twodragon/kore-server/logging"
+	"github.com/twodragon/kore-server/messaging"
+	"github.com/twodragon/kore-server/passwd"
+	"github.com/twodragon/kore-server/server"
+)
+
+const PIN_NEEDED = "Enter your PIN with /pin <PIN> first."
+
+// pinCommand lets players enter, set and remove their secondary PIN, and set
+// up an authenticator app in place of it. Changes need a recent PIN entry.
+func pinCommand(inv *Invocation) ([]byte, error) {
+	s, u := inv.Socket, inv.User
+	pin, code := inv.Args.String("pin"), inv.Args.String("code")
+
+	switch pin {
+	case "set":
+		if code == "" {
+			return messaging.InfoMessage("Usage: /pin set <new PIN>"), nil
+		}
+		if !s.PINFresh() {
+			return messaging.InfoMessage(PIN_NEEDED), nil
+		}
+		if u.TOTPSecret != "" {
+			return messaging.InfoMessage("Your account uses an authenticator, turn it off with /pin off first."), nil
+		}
+		if err := u.SetPIN(code); err != nil {
+			return messaging.InfoMessage(err.Error()), nil
+		}
+		s.VerifyPIN(code)
+		if database.PINAtMenu() {
+			return messaging.InfoMessage("PIN saved, it will be asked before selecting a character."), nil
+		}
+		return messaging.InfoMessage("PIN saved, it will be asked with /pin before deleting characters, bank withdrawals and trades."), nil
+
+	case "totp":
+		if !s.PINFresh() {
+			return messaging.InfoMessage(PIN_NEEDED), nil
+		}
+		if config.Default.Auth.TOTPKey == "" {
+			return messaging.InfoMessage("Authenticators are not available on this server."), nil
+		}
+		if code == "" {
+			secret, err := passwd.NewTOTPSecret()
+			if err != nil {
+				return nil, err
+			}
+			u.PendingTOTPSecret = secret
+			msg := fmt.Sprintf("Add this key to your authenticator app: %s (%s), then confirm with /pin totp <code>.", secret, passwd.TOTPURI(secret, u.Username))
+			return messaging.InfoMessage(msg), nil
+		}
+		secret := u.PendingTOTPSecret
+		step, ok := passwd.VerifyTOTP(secret, code, time.Now(), 0)
+		if secret == "" || !ok {
+			return messaging.InfoMessage("Wrong code, the authenticator was not turned on."), nil
+		}
+		u.PendingTOTPSecret = ""
+		if err := u.SetTOTPSecret(secret, step); err != nil {
+			return nil, err
+		}
+		return messaging.InfoMessage("Authenticator turned on, its codes replace your PIN."), nil
+
+	case "off":
+		if !s.PINFresh() {
+			return messaging.InfoMessage(PIN_NEEDED), nil
+		}
+		if err := u.ResetPIN(); err != nil {
+			return nil, err
+		}
+		return messaging.InfoMessage("PIN and authenticator turned off."), nil
+	}
+
+	switch err := s.VerifyPIN(pin); err {
+	case nil:
+		return messaging.InfoMessage("PIN accepted."), nil
+	default:
+		return messaging.InfoMessage(err.Error()), nil
+	}
+}
+
+// resetPINCommand removes the PIN and authenticator of an account.
+func resetPINCommand(inv *Invocation) ([]byte, error) {
+	user, err := database.FindUserByName(inv.Args.String("account"))
+	if err != nil {
+		return nil, err
+	}
+	if user == nil {
+		return messaging.InfoMessage("Account not found."), nil
+	}
+	if sock := database.GetSocket(user.ID); sock != nil && sock.User != nil {
+		user = sock.User // the online copy is the one saved on logout
+	}
+
+	if err := user.ResetPIN(); err != nil {
+		return nil, err
+	}
+	logger.Log(logging.ACTION_LOGIN, 0, "PIN reset by "+inv.Caller(), user.ID)
+	return messaging.InfoMessage(fmt.Sprintf("PIN of %s reset.", user.Username)), nil
+}
+
+func init() {
+	RegisterCommands(
+		&Command{
+			Name: "pin",
+			Rank: server.COMMON_USER,
+			Args: []Arg{
+				{Name: "pin", Type: ARG_WORD},
+				{Name: "code", Type: ARG_WORD, Optional: true},
+			},
+			Help:    "Enters your PIN. /pin set <new PIN>, /pin totp [code] and /pin off change it.",
+			Handler: pinCommand,
+		},
+		&Command{
+			Name:    "resetpin",
+			Rank:    server.GM_USER,
+			Args:    []Arg{{Name: "account", Type: ARG_WORD}},
+			Help:    "Removes the PIN and authenticator of an account.",
+			Handler: resetPINCommand,
+		},
+	)
+}
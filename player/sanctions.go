@@ -0,0 +1,195 @@
+package player
+
+import (
+	"fmt"
+	"math"
+	"net"
+	"strconv"
+	"strings"
+
+	"github.com/twodragon/kore-server/database"
+	"github.com/twodragon/kore-server/messaging"
+	"github.com/twodragon/kore-server/server"
+)
+
+const SANCTION_HISTORY_LINES = 10
+
+// sanctionCommands are the GM commands issuing sanctions with the type and
+// scope they issue, and the rank they need.
+var sanctionCommands = map[string]struct {
+	kind, scope string
+	rank        int8
+}{
+	"ban":        {database.SANCTION_BAN, database.SCOPE_ACCOUNT, server.GM_USER},
+	"banchar":    {database.SANCTION_BAN, database.SCOPE_CHARACTER, server.GM_USER},
+	"banip":      {database.SANCTION_BAN, database.SCOPE_IP, server.GM_USER},
+	"mute":       {database.SANCTION_MUTE, database.SCOPE_ACCOUNT, server.GA_USER},
+	"jail":       {database.SANCTION_JAIL, database.SCOPE_CHARACTER, server.GA_USER},
+	"tradeblock": {database.SANCTION_TRADE_BLOCK, database.SCOPE_ACCOUNT, server.GM_USER},
+}
+
+// liftCommands lift the active sanctions of a type on a character and its
+// account, or on an IP. The IP sanctions of a character's IP are left alone,
+// they may hold other accounts too.
+var liftCommands = map[string]struct {
+	kind string
+	rank int8
+}{
+	"unban":        {database.SANCTION_BAN, server.GM_USER},
+	"unmute":       {database.SANCTION_MUTE, server.GA_USER},
+	"unjail":       {database.SANCTION_JAIL, server.GA_USER},
+	"untradeblock": {database.SANCTION_TRADE_BLOCK, server.GM_USER},
+}
+
+// sanctionCommand issues the sanction of a command of sanctionCommands.
+func sanctionCommand(inv *Invocation) ([]byte, error) {
+	def := sanctionCommands[inv.Command.Name]
+	name := inv.Args.String("target")
+
+	target, err := database.SanctionTarget(def.scope, name)
+	if err != nil {
+		return messaging.InfoMessage(err.Error()), nil
+	}
+	d, err := database.ParseSanctionDuration(inv.Args.String("duration"))
+	if err != nil {
+		return messaging.InfoMessage(err.Error()), nil
+	}
+
+	sanction, err := database.IssueSanction(def.kind, def.scope, target, int16(inv.Args.Int("map")), d, inv.Caller(), inv.Args.String("reason"))
+	if err != nil {
+		return nil, err
+	}
+	return messaging.InfoMessage(fmt.Sprintf("Sanction #%d: %s of %s until %s.", sanction.ID, def.kind, name, sanction.Until())), nil
+}
+
+// liftCommand lifts the active sanctions of a command of liftCommands on the
+// character, or on the IP for unban.
+func liftCommand(inv *Invocation) ([]byte, error) {
+	def := liftCommands[inv.Command.Name]
+	name := inv.Args.String("target")
+
+	var userID, ip string
+	var characterID int
+	if net.ParseIP(name) != nil {
+		ip = name
+	} else {
+		c, err := database.FindCharacterByName(name)
+		if err != nil {
+			return nil, err
+		}
+		if c == nil {
+			return messaging.InfoMessage(fmt.Sprintf("character %s not found", name)), nil
+		}
+		userID, characterID = c.UserID, c.ID
+	}
+
+	lifted := 0
+	for sanction := database.ActiveSanction(def.kind, userID, characterID, ip); sanction != nil; sanction = database.ActiveSanction(def.kind, userID, characterID, ip) {
+		if _, err := database.LiftSanction(sanction.ID, inv.Caller()); err != nil {
+			return nil, err
+		}
+		lifted++
+	}
+	return messaging.InfoMessage(fmt.Sprintf("%d sanctions lifted.", lifted)), nil
+}
+
+// liftSanctionCommand lifts one sanction by its number.
+func liftSanctionCommand(inv *Invocation) ([]byte, error) {
+	id, err := strconv.Atoi(strings.TrimPrefix(inv.Args.String("number"), "#"))
+	if err != nil {
+		return messaging.InfoMessage("Usage: " + inv.Command.Usage()), nil
+	}
+
+	if _, err := database.LiftSanction(id, inv.Caller()); err != nil {
+		return messaging.InfoMessage(err.Error()), nil
+	}
+	return messaging.InfoMessage(fmt.Sprintf("Sanction #%d lifted.", id)), nil
+}
+
+// sanctionsCommand lists the latest sanctions of the account of a character
+// or of an IP.
+func sanctionsCommand(inv *Invocation) ([]byte, error) {
+	name := inv.Args.String("target")
+
+	var sanctions []*database.Sanction
+	var err error
+	if net.ParseIP(name) != nil {
+		sanctions, err = database.FindSanctionsByIP(name)
+	} else {
+		c, ferr := database.FindCharacterByName(name)
+		if ferr != nil {
+			return nil, ferr
+		}
+		if c == nil {
+			return messaging.InfoMessage(fmt.Sprintf("character %s not found", name)), nil
+		}
+		sanctions, err = database.FindSanctions(c.UserID)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if len(sanctions) == 0 {
+		return messaging.InfoMessage(fmt.Sprintf("No sanctions for %s.", name)), nil
+	}
+	resp := messaging.InfoMessage(fmt.Sprintf("%d sanctions for %s:", len(sanctions), name))
+	for i, sanction := range sanctions {
+		if i == SANCTION_HISTORY_LINES {
+			break
+		}
+		resp = append(resp, messaging.InfoMessage(sanction.String())...)
+	}
+	return resp, nil
+}
+
+func init() {
+	for name, def := range sanctionCommands {
+		target := map[string]string{
+			database.SCOPE_ACCOUNT:   "the account of a character",
+			database.SCOPE_CHARACTER: "a character",
+			database.SCOPE_IP:        "an IP or the IP of a character",
+		}[def.scope]
+		args := []Arg{{Name: "target", Type: ARG_WORD}}
+		if def.kind == database.SANCTION_JAIL {
+			args = append(args, Arg{Name: "map", Type: ARG_INT, Min: 0, Max: math.MaxInt16})
+		}
+		args = append(args,
+			Arg{Name: "duration", Type: ARG_WORD},
+			Arg{Name: "reason", Type: ARG_TEXT, Optional: true})
+
+		RegisterCommands(&Command{
+			Name:    name,
+			Rank:    def.rank,
+			Args:    args,
+			Help:    fmt.Sprintf("Issues a %s on %s for a duration like 30m, 12h, 7d or perm.", strings.Replace(def.kind, "_", " ", -1), target),
+			Handler: sanctionCommand,
+		})
+	}
+
+	for name, def := range liftCommands {
+		RegisterCommands(&Command{
+			Name:    name,
+			Rank:    def.rank,
+			Args:    []Arg{{Name: "target", Type: ARG_WORD}},
+			Help:    fmt.Sprintf("Lifts the active %s sanctions of a character and its account, or of an IP.", strings.Replace(def.kind, "_", " ", -1)),
+			Handler: liftCommand,
+		})
+	}
+
+	RegisterCommands(
+		&Command{
+			Name:    "lift",
+			Rank:    server.GM_USER,
+			Args:    []Arg{{Name: "number", Type: ARG_WORD}},
+			Help:    "Lifts one sanction by its number.",
+			Handler: liftSanctionCommand,
+		},
+		&Command{
+			Name:    "sanctions",
+			Rank:    server.GA_USER,
+			Args:    []Arg{{Name: "target", Type: ARG_WORD}},
+			Help:    "Lists the latest sanctions of the account of a character or of an IP.",
+			Handler: sanctionsCommand,
+		},
+	)
+}
@@ -0,0 +1,83 @@
+package player
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/twodragon/kore-server/database"
+	"github.com/twodragon/kore-server/messaging"
+	"github.com/twodragon/kore-server/server"
+)
+
+const SESSION_HISTORY_LINES = 10
+
+// sessionsCommand lists the latest sessions of the account of a character.
+func sessionsCommand(inv *Invocation) ([]byte, error) {
+	c := inv.Args.Character("character")
+	sessions, err := database.FindSessions(c.UserID, SESSION_HISTORY_LINES)
+	if err != nil {
+		return nil, err
+	}
+	if len(sessions) == 0 {
+		return messaging.InfoMessage(fmt.Sprintf("No sessions for %s.", c.Name)), nil
+	}
+
+	resp := messaging.InfoMessage(fmt.Sprintf("Latest sessions of %s:", c.Name))
+	for _, session := range sessions {
+		reason := session.CloseReason
+		if !session.ClosedAt.Valid {
+			reason = "online"
+		}
+		characters := make([]string, 0, len(session.Characters))
+		for _, id := range session.Characters {
+			characters = append(characters, fmt.Sprint(id))
+		}
+		text := fmt.Sprintf("%s %s server %d, %s, characters %s: %s", session.OpenedAt.Format("2006-01-02 15:04"), session.IP,
+			session.Server, session.PlayTime().Round(time.Second), strings.Join(characters, ","), reason)
+		resp = append(resp, messaging.InfoMessage(text)...)
+	}
+	return resp, nil
+}
+
+// ipLinksCommand lists the accounts that played from the IPs of the account
+// of a character.
+func ipLinksCommand(inv *Invocation) ([]byte, error) {
+	c := inv.Args.Character("character")
+	links, err := database.FindIPLinks(c.UserID)
+	if err != nil {
+		return nil, err
+	}
+	if len(links) == 0 {
+		return messaging.InfoMessage(fmt.Sprintf("No other account played from the IPs of %s.", c.Name)), nil
+	}
+
+	resp := messaging.InfoMessage(fmt.Sprintf("%d accounts share an IP with %s:", len(links), c.Name))
+	for i, link := range links {
+		if i == SESSION_HISTORY_LINES {
+			break
+		}
+		text := fmt.Sprintf("%s(%s) from %s, %d sessions, last %s", link.Username, link.UserID, link.IP, link.Sessions, link.LastSeen.Format("2006-01-02 15:04"))
+		resp = append(resp, messaging.InfoMessage(text)...)
+	}
+	return resp, nil
+}
+
+func init() {
+	RegisterCommands(
+		&Command{
+			Name:    "sessions",
+			Rank:    server.GA_USER,
+			Args:    []Arg{{Name: "character", Type: ARG_CHARACTER}},
+			Help:    "Lists the latest sessions of the account of a character.",
+			Handler: sessionsCommand,
+		},
+		&Command{
+			Name:    "iplinks",
+			Rank:    server.GA_USER,
+			Args:    []Arg{{Name: "character", Type: ARG_CHARACTER}},
+			Help:    "Lists the accounts that played from the IPs of a character.",
+			Handler: ipLinksCommand,
+		},
+	)
+}
@@ -29,8 +29,9 @@ func (h *Ping) Handle(s *database.Socket, data []byte) ([]byte, error) {
 func (h *CharacterMenuHandler) Handle(s *database.Socket, data []byte) ([]byte, error) {
 
 	if c := s.Character; c != nil {
-		s.User.ConnectingIP = s.ClientAddr
-		s.User.ConnectingTo = s.User.ConnectedServer
+		if err := s.IssueTicket(s.User.ConnectedServer); err != nil {
+			return nil, err
+		}
 		c.Logout()
 	}
 
@@ -53,7 +54,7 @@ func (h *QuitGameHandler) Handle(s *database.Socket, data []byte) ([]byte, error
 		resp := QUIT_GAME
 		resp.Insert(utils.IntToBytes(uint64(c.PseudoID), 2, true), 6)
 
-		s.User.ConnectingIP = ""
+		s.SetCloseReason(database.SESSION_QUIT)
 		s.OnClose()
 		s.Character.IsActive = false
 		s.Character.IsOnline = false
@@ -45,6 +45,9 @@ func (h *SendTradeRequestHandler) Handle(s *database.Socket, data []byte) ([]byt
 	if s.Character.Socket.User.UserType >= 2 && s.Character.Socket.User.UserType < 5 {
 		return messaging.SystemMessage(messaging.INVALID_TRADE_REQUEST), nil
 	}
+	if block := s.Sanction(database.SANCTION_TRADE_BLOCK); block != nil {
+		return messaging.InfoMessage(fmt.Sprintf("Trading is blocked until %s.", block.Until())), nil
+	}
 	if s.Character.TradeID != "" || !s.Character.IsActive {
 		return messaging.SystemMessage(messaging.INVALID_TRADE_REQUEST), nil
 	}
@@ -95,6 +98,9 @@ func (h *RespondTradeRequestHandler) Handle(s *database.Socket, data []byte) ([]
 	if s.Character.Socket.User.UserType >= 2 && s.Character.Socket.User.UserType < 5 {
 		return messaging.SystemMessage(messaging.INVALID_TRADE_REQUEST), nil
 	}
+	if block := s.Sanction(database.SANCTION_TRADE_BLOCK); block != nil {
+		return messaging.InfoMessage(fmt.Sprintf("Trading is blocked until %s.", block.Until())), nil
+	}
 	if s.Character.TradeID != "" {
 		return messaging.SystemMessage(messaging.INVALID_TRADE_REQUEST), nil
 	}
@@ -156,6 +162,9 @@ func (h *AddTradeItemHandler) Handle(s *database.Socket, data []byte) ([]byte, e
 	if s.Character.Socket.User.UserType >= 2 && s.Character.Socket.User.UserType < 5 {
 		return messaging.SystemMessage(messaging.INVALID_TRADE_REQUEST), nil
 	}
+	if block := s.Sanction(database.SANCTION_TRADE_BLOCK); block != nil {
+		return messaging.InfoMessage(fmt.Sprintf("Trading is blocked until %s.", block.Until())), nil
+	}
 	trade := database.FindTrade(s.Character)
 	if trade == nil {
 		return nil, nil
@@ -241,6 +250,9 @@ func (h *AddTradeGoldHandler) Handle(s *database.Socket, data []byte) ([]byte, e
 	if s.Character.Socket.User.UserType >= 2 && s.Character.Socket.User.UserType < 5 {
 		return messaging.SystemMessage(messaging.INVALID_TRADE_REQUEST), nil
 	}
+	if block := s.Sanction(database.SANCTION_TRADE_BLOCK); block != nil {
+		return messaging.InfoMessage(fmt.Sprintf("Trading is blocked until %s.", block.Until())), nil
+	}
 	trade := database.FindTrade(s.Character)
 	if trade == nil {
 		return nil, nil
@@ -327,6 +339,9 @@ func (h *AcceptTradeHandler) Handle(s *database.Socket, data []byte) ([]byte, er
 	if s.Character.Socket.User.UserType >= 2 && s.Character.Socket.User.UserType < 5 {
 		return messaging.SystemMessage(messaging.INVALID_TRADE_REQUEST), nil
 	}
+	if block := s.Sanction(database.SANCTION_TRADE_BLOCK); block != nil {
+		return messaging.InfoMessage(fmt.Sprintf("Trading is blocked until %s.", block.Until())), nil
+	}
 	trade := database.FindTrade(s.Character)
 	if trade == nil {
 		return nil, nil
@@ -344,6 +359,9 @@ func (h *AcceptTradeHandler) Handle(s *database.Socket, data []byte) ([]byte, er
 	tradelogger := log.New(f, "", log.LstdFlags)
 
 	accepted := data[6] == 1
+	if accepted && !s.PINFresh() {
+		return messaging.InfoMessage(PIN_NEEDED), nil
+	}
 
 	var conn net.Conn
 	isSender := trade.Sender.Character.UserID == s.Character.UserID
@@ -0,0 +1,161 @@
+package proxy
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+)
+
+const (
+	V1_MAX_LENGTH = 107  // "PROXY TCP6 " + two full IPv6 addresses and ports + "\r\n"
+	V2_MAX_LENGTH = 1024 // addresses and TLVs, we only need the addresses
+)
+
+var (
+	v1Prefix    = []byte("PROXY ")
+	v2Signature = []byte{0x0D, 0x0A, 0x0D, 0x0A, 0x00, 0x0D, 0x0A, 0x51, 0x55, 0x49, 0x54, 0x0A}
+
+	ErrBadHeader = errors.New("malformed PROXY header")
+)
+
+// ReadHeader consumes a PROXY v1 or v2 header from r and returns the client
+// address it carries. It returns a nil address, and consumes nothing, if the
+// stream does not start with a header; and a nil address for LOCAL and
+// UNKNOWN headers, which proxies send for their own health checks.
+func ReadHeader(r *bufio.Reader) (net.Addr, error) {
+	first, err := r.Peek(1)
+	if err != nil {
+		return nil, err
+	}
+
+	switch first[0] {
+	case v1Prefix[0]:
+		if !hasPrefix(r, v1Prefix) {
+			return nil, nil
+		}
+		return readV1(r)
+	case v2Signature[0]:
+		if !hasPrefix(r, v2Signature) {
+			return nil, nil
+		}
+		return readV2(r)
+	}
+	return nil, nil
+}
+
+// hasPrefix reports whether the buffered stream starts with prefix, reading
+// no more than needed to tell.
+func hasPrefix(r *bufio.Reader, prefix []byte) bool {
+	for i := 1; i <= len(prefix); i++ {
+		data, err := r.Peek(i)
+		if err != nil || data[i-1] != prefix[i-1] {
+			return false
+		}
+	}
+	return true
+}
+
+// readV1 parses "PROXY TCP4 <src> <dst> <sport> <dport>\r\n".
+func readV1(r *bufio.Reader) (net.Addr, error) {
+	line := make([]byte, 0, V1_MAX_LENGTH)
+	for !bytes.HasSuffix(line, []byte("\r\n")) {
+		if len(line) == V1_MAX_LENGTH {
+			return nil, fmt.Errorf("%w: v1 header too long", ErrBadHeader)
+		}
+		b, err := r.ReadByte()
+		if err != nil {
+			return nil, err
+		}
+		line = append(line, b)
+	}
+
+	fields := strings.Split(string(line[:len(line)-2]), " ")
+	if len(fields) >= 2 && fields[1] == "UNKNOWN" {
+		return nil, nil
+	}
+	if len(fields) != 6 {
+		return nil, fmt.Errorf("%w: %q", ErrBadHeader, line)
+	}
+
+	ip := net.ParseIP(fields[2])
+	if ip == nil || net.ParseIP(fields[3]) == nil {
+		return nil, fmt.Errorf("%w: bad address in %q", ErrBadHeader, line)
+	}
+	switch fields[1] {
+	case "TCP4":
+		if ip.To4() == nil {
+			return nil, fmt.Errorf("%w: %s is not IPv4", ErrBadHeader, fields[2])
+		}
+	case "TCP6":
+		if ip.To4() != nil {
+			return nil, fmt.Errorf("%w: %s is not IPv6", ErrBadHeader, fields[2])
+		}
+	default:
+		return nil, fmt.Errorf("%w: unknown protocol %s", ErrBadHeader, fields[1])
+	}
+
+	port, err := strconv.ParseUint(fields[4], 10, 16)
+	if err != nil {
+		return nil, fmt.Errorf("%w: bad port %s", ErrBadHeader, fields[4])
+	}
+	if _, err := strconv.ParseUint(fields[5], 10, 16); err != nil {
+		return nil, fmt.Errorf("%w: bad port %s", ErrBadHeader, fields[5])
+	}
+
+	return &net.TCPAddr{IP: ip, Port: int(port)}, nil
+}
+
+// readV2 parses the binary header: the signature, version and command,
+// address family and transport, length, then the addresses and TLVs.
+func readV2(r *bufio.Reader) (net.Addr, error) {
+	header := make([]byte, 16)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return nil, err
+	}
+
+	version, command := header[12]>>4, header[12]&0x0F
+	family := header[13] >> 4
+	length := int(binary.BigEndian.Uint16(header[14:16]))
+
+	if version != 2 {
+		return nil, fmt.Errorf("%w: version %d", ErrBadHeader, version)
+	}
+	if length > V2_MAX_LENGTH {
+		return nil, fmt.Errorf("%w: v2 header of %d bytes", ErrBadHeader, length)
+	}
+
+	body := make([]byte, length)
+	if _, err := io.ReadFull(r, body); err != nil {
+		return nil, err
+	}
+
+	switch command {
+	case 0x0: // LOCAL
+		return nil, nil
+	case 0x1: // PROXY
+	default:
+		return nil, fmt.Errorf("%w: command %d", ErrBadHeader, command)
+	}
+
+	switch family {
+	case 0x1: // AF_INET
+		if length < 12 {
+			return nil, fmt.Errorf("%w: short IPv4 address block", ErrBadHeader)
+		}
+		ip := net.IP(append([]byte{}, body[0:4]...))
+		return &net.TCPAddr{IP: ip, Port: int(binary.BigEndian.Uint16(body[8:10]))}, nil
+	case 0x2: // AF_INET6
+		if length < 36 {
+			return nil, fmt.Errorf("%w: short IPv6 address block", ErrBadHeader)
+		}
+		ip := net.IP(append([]byte{}, body[0:16]...))
+		return &net.TCPAddr{IP: ip, Port: int(binary.BigEndian.Uint16(body[32:34]))}, nil
+	}
+	return nil, nil // AF_UNSPEC or AF_UNIX, nothing to report
+}
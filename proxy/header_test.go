@@ -0,0 +1,194 @@
+package proxy
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"testing"
+	"time"
+)
+
+// v2 builds a v2 header with the command, family and address block given.
+func v2(command, family byte, block []byte) []byte {
+	h := append([]byte{}, v2Signature...)
+	h = append(h, 0x20|command, family<<4|0x1, 0, 0)
+	binary.BigEndian.PutUint16(h[14:16], uint16(len(block)))
+	return append(h, block...)
+}
+
+func v4Block(src, dst string, sport, dport uint16) []byte {
+	b := append(append([]byte{}, net.ParseIP(src).To4()...), net.ParseIP(dst).To4()...)
+	b = binary.BigEndian.AppendUint16(b, sport)
+	return binary.BigEndian.AppendUint16(b, dport)
+}
+
+func v6Block(src, dst string, sport, dport uint16) []byte {
+	b := append(append([]byte{}, net.ParseIP(src).To16()...), net.ParseIP(dst).To16()...)
+	b = binary.BigEndian.AppendUint16(b, sport)
+	return binary.BigEndian.AppendUint16(b, dport)
+}
+
+var game = []byte{0xAA, 0x55, 0x02, 0x00, 0x00, 0x01, 0x55, 0xAA}
+
+func TestReadHeader(t *testing.T) {
+	for _, test := range []struct {
+		name   string
+		stream []byte
+		addr   string // empty for none
+		err    error
+	}{
+		{"v1 TCP4", []byte("PROXY TCP4 203.0.113.7 10.0.0.1 51000 4510\r\n"), "203.0.113.7:51000", nil},
+		{"v1 TCP6", []byte("PROXY TCP6 2001:db8::7 2001:db8::1 51000 4510\r\n"), "[2001:db8::7]:51000", nil},
+		{"v1 UNKNOWN", []byte("PROXY UNKNOWN\r\n"), "", nil},
+		{"v1 UNKNOWN with addresses", []byte("PROXY UNKNOWN ffff:f::1 ffff:f::2 1 2\r\n"), "", nil},
+		{"v1 TCP4 with an IPv6 address", []byte("PROXY TCP4 2001:db8::7 10.0.0.1 51000 4510\r\n"), "", ErrBadHeader},
+		{"v1 bad port", []byte("PROXY TCP4 203.0.113.7 10.0.0.1 70000 4510\r\n"), "", ErrBadHeader},
+		{"v1 missing field", []byte("PROXY TCP4 203.0.113.7 10.0.0.1 51000\r\n"), "", ErrBadHeader},
+		{"v1 without end of line", append([]byte("PROXY TCP4 "), bytes.Repeat([]byte("1"), 200)...), "", ErrBadHeader},
+		{"v1 cut", []byte("PROXY TCP4 203.0.113.7"), "", io.EOF},
+		{"v2 IPv4", v2(1, 1, v4Block("203.0.113.7", "10.0.0.1", 51000, 4510)), "203.0.113.7:51000", nil},
+		{"v2 IPv6", v2(1, 2, v6Block("2001:db8::7", "2001:db8::1", 51000, 4510)), "[2001:db8::7]:51000", nil},
+		{"v2 IPv4 with TLVs", v2(1, 1, append(v4Block("203.0.113.7", "10.0.0.1", 51000, 4510), 0x04, 0x00, 0x01, 0x00)), "203.0.113.7:51000", nil},
+		{"v2 LOCAL", v2(0, 0, nil), "", nil},
+		{"v2 LOCAL with addresses", v2(0, 1, v4Block("10.0.0.9", "10.0.0.1", 1, 2)), "", nil},
+		{"v2 short IPv4 block", v2(1, 1, []byte{203, 0, 113, 7}), "", ErrBadHeader},
+		{"v2 bad command", v2(2, 1, v4Block("203.0.113.7", "10.0.0.1", 51000, 4510)), "", ErrBadHeader},
+		{"v2 truncated header", v2(1, 1, nil)[:14], "", io.ErrUnexpectedEOF},
+		{"v2 truncated addresses", v2(1, 1, v4Block("203.0.113.7", "10.0.0.1", 51000, 4510))[:20], "", io.ErrUnexpectedEOF},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			stream := test.stream
+			if test.err == nil {
+				stream = append(append([]byte{}, stream...), game...)
+			}
+			r := bufio.NewReader(bytes.NewReader(stream))
+			addr, err := ReadHeader(r)
+			if !errors.Is(err, test.err) {
+				t.Fatalf("err %v, want %v", err, test.err)
+			}
+			if got := ""; addr != nil {
+				got = addr.String()
+				if got != test.addr {
+					t.Errorf("addr %s, want %s", got, test.addr)
+				}
+			} else if test.addr != "" {
+				t.Errorf("no addr, want %s", test.addr)
+			}
+			if test.err != nil {
+				return
+			}
+			if rest, _ := io.ReadAll(r); !bytes.Equal(rest, game) {
+				t.Errorf("left % X after the header, want the game packet", rest)
+			}
+		})
+	}
+}
+
+func TestReadHeaderPassesThrough(t *testing.T) {
+	for _, stream := range [][]byte{
+		game,
+		[]byte("PROXZ TCP4 203.0.113.7 10.0.0.1 51000 4510\r\n"),
+		append(append([]byte{}, v2Signature[:5]...), 0xFF),
+	} {
+		r := bufio.NewReader(bytes.NewReader(stream))
+		if addr, err := ReadHeader(r); addr != nil || err != nil {
+			t.Errorf("% X: addr %v, err %v, want neither", stream, addr, err)
+		}
+		if rest, _ := io.ReadAll(r); !bytes.Equal(rest, stream) {
+			t.Errorf("% X: read % X, want it unchanged", stream, rest)
+		}
+	}
+}
+
+// accept connects to a listener trusting cidrs, sends stream and returns
+// the accepted end.
+func accept(t *testing.T, cidrs []string, stream []byte) net.Conn {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+	pl, err := NewListener(l, cidrs, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	client, err := net.Dial("tcp", l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { client.Close() })
+	if _, err := client.Write(stream); err != nil {
+		t.Fatal(err)
+	}
+
+	conn, err := pl.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return conn
+}
+
+func readAtLeast(t *testing.T, conn net.Conn, n int) []byte {
+	t.Helper()
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	buf := make([]byte, n)
+	if _, err := io.ReadFull(conn, buf); err != nil {
+		t.Fatal(err)
+	}
+	return buf
+}
+
+func TestListener(t *testing.T) {
+	header := []byte("PROXY TCP4 203.0.113.7 10.0.0.1 51000 4510\r\n")
+	stream := append(append([]byte{}, header...), game...)
+
+	t.Run("trusted", func(t *testing.T) {
+		conn := accept(t, []string{"127.0.0.0/8"}, stream)
+		if got := conn.RemoteAddr().String(); got != "203.0.113.7:51000" {
+			t.Errorf("remote %s, want the client of the header", got)
+		}
+		if got := readAtLeast(t, conn, len(game)); !bytes.Equal(got, game) {
+			t.Errorf("read % X, want the game packet", got)
+		}
+	})
+
+	t.Run("untrusted", func(t *testing.T) {
+		conn := accept(t, []string{"10.0.0.0/8", "2001:db8::/32"}, stream)
+		if _, ok := conn.(*Conn); ok {
+			t.Error("connection from outside the trusted networks wrapped")
+		}
+		if ip := conn.RemoteAddr().(*net.TCPAddr).IP; !ip.IsLoopback() {
+			t.Errorf("remote %s, want the real peer", ip)
+		}
+		if got := readAtLeast(t, conn, len(stream)); !bytes.Equal(got, stream) {
+			t.Errorf("read % X, want the header left in the stream", got)
+		}
+	})
+
+	t.Run("trusted without header", func(t *testing.T) {
+		conn := accept(t, []string{"127.0.0.0/8"}, game)
+		if got := readAtLeast(t, conn, len(game)); !bytes.Equal(got, game) {
+			t.Errorf("read % X, want the game packet", got)
+		}
+		if ip := conn.RemoteAddr().(*net.TCPAddr).IP; !ip.IsLoopback() {
+			t.Errorf("remote %s, want the proxy", ip)
+		}
+	})
+
+	t.Run("bad header", func(t *testing.T) {
+		conn := accept(t, []string{"127.0.0.0/8"}, []byte("PROXY TCP9 1 2 3 4\r\n"))
+		if _, err := conn.Read(make([]byte, 8)); !errors.Is(err, ErrBadHeader) {
+			t.Errorf("err %v, want %v", err, ErrBadHeader)
+		}
+	})
+
+	if _, err := NewListener(nil, []string{"10.0.0.0"}, 0); err == nil {
+		t.Error("NewListener took a CIDR without a mask")
+	}
+}
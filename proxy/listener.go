@@ -0,0 +1,107 @@
+package proxy
+
+import (
+	"bufio"
+	"fmt"
+	"log"
+	"net"
+	"sync"
+	"time"
+)
+
+// Listener accepts connections that may start with a PROXY protocol v1 or v2
+// header. Headers are only honoured from the trusted networks; connections
+// from anywhere else are returned untouched, so a player can not spoof its
+// address by sending a header of its own.
+type Listener struct {
+	net.Listener
+	Trusted       []*net.IPNet
+	HeaderTimeout time.Duration
+}
+
+// NewListener wraps l, trusting headers sent from the given CIDRs.
+func NewListener(l net.Listener, cidrs []string, timeout time.Duration) (*Listener, error) {
+	trusted := make([]*net.IPNet, 0, len(cidrs))
+	for _, cidr := range cidrs {
+		_, ipnet, err := net.ParseCIDR(cidr)
+		if err != nil {
+			return nil, fmt.Errorf("proxy: %s", err)
+		}
+		trusted = append(trusted, ipnet)
+	}
+	return &Listener{Listener: l, Trusted: trusted, HeaderTimeout: timeout}, nil
+}
+
+func (l *Listener) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	if !l.isTrusted(conn.RemoteAddr()) {
+		return conn, nil
+	}
+	return &Conn{Conn: conn, reader: bufio.NewReader(conn), timeout: l.HeaderTimeout}, nil
+}
+
+func (l *Listener) isTrusted(addr net.Addr) bool {
+	tcp, ok := addr.(*net.TCPAddr)
+	if !ok {
+		return false
+	}
+	for _, ipnet := range l.Trusted {
+		if ipnet.Contains(tcp.IP) {
+			return true
+		}
+	}
+	return false
+}
+
+// Conn is a connection from a trusted proxy. The header is read the first
+// time the connection is read from or asked for its remote address, so a slow
+// proxy does not hold up the accept loop.
+type Conn struct {
+	net.Conn
+	reader  *bufio.Reader
+	timeout time.Duration
+
+	once   sync.Once
+	remote net.Addr
+	err    error
+}
+
+func (c *Conn) readHeader() {
+	c.once.Do(func() {
+		if c.timeout > 0 {
+			c.Conn.SetReadDeadline(time.Now().Add(c.timeout))
+			defer c.Conn.SetReadDeadline(time.Time{})
+		}
+
+		c.remote, c.err = ReadHeader(c.reader)
+		if c.err != nil {
+			log.Printf("PROXY header from %s rejected: %s", c.Conn.RemoteAddr(), c.err)
+		}
+	})
+}
+
+func (c *Conn) Read(b []byte) (int, error) {
+	c.readHeader()
+	if c.err != nil {
+		return 0, c.err
+	}
+	return c.reader.Read(b)
+}
+
+// RemoteAddr returns the client address from the header, or the address of
+// the proxy when the header did not carry one.
+func (c *Conn) RemoteAddr() net.Addr {
+	c.readHeader()
+	if c.remote != nil {
+		return c.remote
+	}
+	return c.Conn.RemoteAddr()
+}
+
+// ProxyAddr returns the address of the proxy the connection came through.
+func (c *Conn) ProxyAddr() net.Addr {
+	return c.Conn.RemoteAddr()
+}
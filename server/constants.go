@@ -1,7 +1,6 @@
 package server
 
 import (
-	cmap "github.com/orcaman/concurrent-map"
 	"github.com/thoas/go-funk"
 	"github.com/twodragon/kore-server/database"
 )
@@ -15,10 +14,6 @@ const (
 	HGM_USER
 )
 
-var (
-	MutedPlayers = cmap.New()
-)
-
 func init() {
 	accUpgrades := []byte{}
 	armorUpgrades := []byte{}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/nats-io/nats-server/v2/server"
+	gonats "github.com/nats-io/nats.go"
+	"github.com/twodragon/kore-server/config"
+	"github.com/twodragon/kore-server/database"
+)
+
+// waitForShutdown blocks until SIGINT or SIGTERM, then drains the server:
+// no new connections or logins, a countdown for the players, wars and events
+// finished, everyone saved and kicked. A second signal skips the countdown.
+// It returns the exit code of the process.
+func waitForShutdown(listen net.Listener, ns *server.Server, nc *gonats.Conn) int {
+	sig := make(chan os.Signal, 2)
+	signal.Notify(sig, syscall.SIGINT, syscall.SIGTERM)
+
+	log.Printf("Received %s, shutting down...", <-sig)
+	database.Drain()
+	listen.Close()
+
+	countdown(config.Default.Server.ShutdownCountdown, sig)
+
+	database.EndEvents()
+
+	report := database.SaveAll()
+	log.Printf("Saved %d online characters", report.Characters)
+
+	for _, s := range database.AllSockets() {
+		s.SetCloseReason(database.SESSION_SHUTDOWN)
+		s.OnClose()
+	}
+
+	nc.Close()
+	ns.Shutdown()
+
+	if len(report.Failed) > 0 {
+		log.Printf("Shutdown finished with %d save errors:", len(report.Failed))
+		for _, f := range report.Failed {
+			log.Print(f)
+		}
+		return 1
+	}
+
+	log.Print("Shutdown finished")
+	return 0
+}
+
+func countdown(seconds int, sig <-chan os.Signal) {
+	for seconds > 0 {
+		database.Announce(fmt.Sprintf("Server will shut down for maintenance in %d seconds.", seconds))
+
+		step := 10
+		if seconds <= 10 {
+			step = 5
+		}
+		if seconds <= 5 {
+			step = 1
+		}
+		if step > seconds {
+			step = seconds
+		}
+
+		select {
+		case s := <-sig:
+			log.Printf("Received %s, skipping countdown", s)
+			return
+		case <-time.After(time.Duration(step) * time.Second):
+		}
+		seconds -= step
+	}
+}